@@ -47,6 +47,10 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	go application.StartStoragePurge(purgeCtx)
+
 	go func() {
 		pkgLogger.Infow(nil, "[main] Starting server on %s\n", config.HostPort)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -56,6 +60,7 @@ func main() {
 
 	<-quit
 	pkgLogger.Infow(nil, "[main] Shutting down server...\n")
+	stopPurge()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
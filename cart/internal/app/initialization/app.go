@@ -2,6 +2,8 @@ package initialization
 
 import (
 	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	redisCli "github.com/redis/go-redis/v9"
 	"go.opentelemetry.io/otel"
@@ -19,12 +21,15 @@ import (
 	"route256/cart/internal/metrics"
 	"route256/cart/internal/pkg/service/lomsservice"
 	"route256/cart/internal/tracing"
+	"route256/libs/health"
 	"time"
 
 	_ "net/http/pprof"
 	"route256/cart/internal/app/server"
 	"route256/cart/internal/http/client"
 	"route256/cart/internal/pkg/repository"
+	"route256/cart/internal/pkg/repository/pgstorage"
+	"route256/cart/internal/pkg/repository/redisstorage"
 	"route256/cart/internal/pkg/service/cartservice"
 	"route256/cart/internal/pkg/service/productservice"
 )
@@ -35,15 +40,15 @@ type App struct {
 	productService *productservice.ProductService
 	cartService    *cartservice.CartService
 	router         http.Handler
+	healthChecker  *health.Checker
 	Logger         *logger.Logger
+	pgStorage      *pgstorage.Storage
+	storageConf    *StorageConf
 }
 
 func New(config *Config, pkgLoger *logger.Logger) (*App, error) {
 	log.Println("[cart] Starting application initialization")
 
-	// Инициализация репозитория корзины
-	cartRepository := repository.NewRepository(repository.NewStorage())
-
 	// Создание HTTP клиента с ретраями
 	httpClient := client.NewHttpClientWithRetryWithDefaultTransport(
 		config.MaxRetries,
@@ -52,10 +57,10 @@ func New(config *Config, pkgLoger *logger.Logger) (*App, error) {
 
 	limitTripper := client.NewLimiterRoundTripper(httpClient.Transport, config.RequestsPerSecond)
 	metricTripper := client.NewMetricTripper(limitTripper)
-	grpcClient := newGRPCClient(config)
+	lomsConn := newGRPCClient(config)
 
 	// Инициализация сервиса заказов
-	lomsService := lomsservice.NewLomsService(grpcClient)
+	lomsService := lomsservice.NewLomsService(loms.NewLomsClient(lomsConn))
 
 	// Инициализация сервиса продуктов
 	productService := productservice.NewProductService(
@@ -64,7 +69,15 @@ func New(config *Config, pkgLoger *logger.Logger) (*App, error) {
 		config.ProductServiceURL,
 		config.ProductServicePath,
 	)
-	redisClient := MustInitRedisClient(config.RedisClient)
+	redisClient := InitRedisClient(config.RedisClient)
+
+	// Инициализация репозитория корзины
+	storage, pgStorage, err := newCartStorage(config.Storage, redisClient)
+	if err != nil {
+		return nil, err
+	}
+	cartRepository := repository.NewRepository(storage)
+
 	redisCasher := redis.NewRedisCacher[productservice.SKUWrapper, *productservice.ProductWrapper](redisClient, config.RedisClient.KeyTtl)
 
 	cacheProductService := productservice.NewCacheProductService(config.CacheCapacity, productService,
@@ -78,10 +91,13 @@ func New(config *Config, pkgLoger *logger.Logger) (*App, error) {
 		httpClient:     httpClient,
 		productService: productService,
 		cartService:    cartService,
+		healthChecker:  newHealthChecker(redisClient, lomsConn, pgStorage),
 		Logger:         pkgLoger,
+		pgStorage:      pgStorage,
+		storageConf:    config.Storage,
 	}
 
-	_, err := tracing.InitTracerProvider("CART")
+	_, err = tracing.InitTracerProvider("CART")
 	if err != nil {
 		return nil, err
 	}
@@ -91,7 +107,50 @@ func New(config *Config, pkgLoger *logger.Logger) (*App, error) {
 	return app, nil
 }
 
-func MustInitRedisClient(redisConf *RedisConf) *redisCli.Client {
+// newCartStorage выбирает хранилище корзин по конфигурации.
+// Для postgres дополнительно возвращается само хранилище: ему нужны readiness и удаление просроченных корзин
+func newCartStorage(conf *StorageConf, redisClient *redisCli.Client) (repository.AbstractStorage, *pgstorage.Storage, error) {
+	switch conf.Type {
+	case StorageRedis:
+		return redisstorage.NewStorage(redisClient, conf.CartTtl), nil, nil
+	case StoragePostgres:
+		pool, err := initDbPool(conf.DB)
+		if err != nil {
+			return nil, nil, err
+		}
+		storage := pgstorage.NewStorage(pool, conf.CartTtl)
+		return storage, storage, nil
+	default:
+		return repository.NewStorage(), nil, nil
+	}
+}
+
+func initDbPool(dbConf *DBConf) (*pgxpool.Pool, error) {
+	dsn := fmt.Sprintf("postgres://%s:%s@%s/%s",
+		dbConf.DBUser, dbConf.DBPassword, dbConf.DBHostPort, dbConf.DBName)
+	poolConfig, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse configuration for db (host:port %s): %w", dbConf.DBHostPort, err)
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create pool with host:port %s: %w", dbConf.DBHostPort, err)
+	}
+	return pool, nil
+}
+
+// StartStoragePurge удаляет просроченные корзины из postgres до отмены ctx.
+// Redis удаляет их сам по TTL ключа, корзины в памяти не истекают
+func (app *App) StartStoragePurge(ctx context.Context) {
+	if app.pgStorage == nil {
+		return
+	}
+	app.pgStorage.StartPurge(ctx, app.storageConf.PurgeInterval)
+}
+
+// InitRedisClient создает клиента Redis. Недоступность Redis при старте не останавливает сервис:
+// клиент переподключается сам, а до тех пор сервис не готов по /readyz
+func InitRedisClient(redisConf *RedisConf) *redisCli.Client {
 	rdb := redisCli.NewClient(&redisCli.Options{
 		Addr:     redisConf.Addr,     // Адрес Redis-сервера
 		Password: redisConf.Password, // Пароль (если есть)
@@ -101,13 +160,26 @@ func MustInitRedisClient(redisConf *RedisConf) *redisCli.Client {
 	// Проверяем соединение
 	pong, err := rdb.Ping(context.Background()).Result()
 	if err != nil {
-		log.Fatalf("Не удалось подключиться к Redis: %v", err)
+		log.Printf("Не удалось подключиться к Redis: %v", err)
+		return rdb
 	}
 
 	logger.Debugw(nil, pong)
 
 	return rdb
+}
 
+// newHealthChecker проверяет Redis, базу корзин, если они хранятся в postgres, и готовность LOMS через его grpc.health.v1
+func newHealthChecker(redisClient *redisCli.Client, lomsConn *grpc.ClientConn, pgStorage *pgstorage.Storage) *health.Checker {
+	checker := health.NewChecker(health.DefaultTimeout)
+	checker.Add("redis", func(ctx context.Context) error {
+		return redisClient.Ping(ctx).Err()
+	})
+	if pgStorage != nil {
+		checker.Add("postgres", pgStorage.Ping)
+	}
+	checker.Add("loms", health.GRPCCheck(lomsConn, ""))
+	return checker
 }
 
 type metadataCarrier metadata.MD
@@ -132,7 +204,7 @@ func (mc metadataCarrier) Set(key string, value string) {
 	metadata.MD(mc).Append(key, value)
 }
 
-func newGRPCClient(config *Config) loms.LomsClient {
+func newGRPCClient(config *Config) *grpc.ClientConn {
 	unaryInterceptor := func(
 		ctx context.Context,
 		method string,
@@ -168,7 +240,7 @@ func newGRPCClient(config *Config) loms.LomsClient {
 		return nil
 	}
 
-	return loms.NewLomsClient(conn)
+	return conn
 }
 
 func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -179,16 +251,21 @@ func (app *App) setupRoutes() {
 	controller := server.New(app.cartService)
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /user/{user_id}/cart/{sku_id}", controller.PostItemHandleFunc)
+	mux.HandleFunc("PUT /user/{user_id}/cart/{sku_id}", controller.PutItemHandleFunc)
+	mux.HandleFunc("PATCH /user/{user_id}/cart", controller.PatchCartHandleFunc)
 	mux.HandleFunc("DELETE /user/{user_id}/cart", controller.DeleteCartByUserIdHandleFunc)
 	mux.HandleFunc("DELETE /user/{user_id}/cart/{sku_id}", controller.DeleteItemBySkuHandleFunc)
 	mux.HandleFunc("GET /user/{user_id}/cart", controller.GetCartContentHandleFunc)
 	mux.HandleFunc("POST /cart/checkout", controller.CheckoutHandleFunc)
+	mux.HandleFunc("GET /user/{user_id}/orders", controller.GetUserOrdersHandleFunc)
 
 	buisnessMux := middleware.NewLogMux(middleware.NewTraceMux(middleware.NewMetricMux(mux)))
 
 	mux = http.NewServeMux()
 	mux.Handle("/", buisnessMux)
 	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /healthz", health.LiveHandler)
+	mux.HandleFunc("GET /readyz", app.healthChecker.ReadyHandler)
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
 	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
 	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
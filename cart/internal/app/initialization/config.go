@@ -21,6 +21,34 @@ type Config struct {
 	RequestsPerSecond  uint
 	CacheCapacity      int
 	RedisClient        *RedisConf
+	Storage            *StorageConf
+}
+
+// Типы хранилища корзин
+const (
+	StorageMemory   = "memory"
+	StorageRedis    = "redis"
+	StoragePostgres = "postgres"
+)
+
+const (
+	defaultCartTtl       = 7 * 24 * time.Hour
+	defaultPurgeInterval = 10 * time.Minute
+)
+
+// StorageConf хранилище корзин. Redis берется из RedisConf, для postgres нужна база DB
+type StorageConf struct {
+	Type          string
+	CartTtl       time.Duration
+	PurgeInterval time.Duration
+	DB            *DBConf
+}
+
+type DBConf struct {
+	DBUser     string
+	DBPassword string
+	DBHostPort string
+	DBName     string
 }
 
 type RedisConf struct {
@@ -91,6 +119,11 @@ func LoadConfig(pathToEnv string) (*Config, error) {
 
 	redisConf, err := initRedisClient()
 
+	if err != nil {
+		return nil, err
+	}
+
+	storageConf, err := initStorage()
 	if err != nil {
 		return nil, err
 	}
@@ -105,9 +138,50 @@ func LoadConfig(pathToEnv string) (*Config, error) {
 		RequestsPerSecond:  uint(requestsPerSecond),
 		CacheCapacity:      cacheCapacityInt,
 		RedisClient:        redisConf,
+		Storage:            storageConf,
 	}, nil
 }
 
+func initStorage() (*StorageConf, error) {
+	storageType := os.Getenv("CART_STORAGE")
+	if storageType == "" {
+		storageType = StorageMemory
+	}
+
+	conf := &StorageConf{
+		Type:          storageType,
+		CartTtl:       loadDurationEnv("CART_TTL", defaultCartTtl),
+		PurgeInterval: loadDurationEnv("CART_PURGE_INTERVAL", defaultPurgeInterval),
+	}
+	switch storageType {
+	case StorageMemory, StorageRedis:
+	case StoragePostgres:
+		conf.DB = &DBConf{
+			DBUser:     os.Getenv("DATABASE_USER"),
+			DBPassword: os.Getenv("DATABASE_PASSWORD"),
+			DBHostPort: os.Getenv("DATABASE_HOST_PORT"),
+			DBName:     os.Getenv("DATABASE_NAME"),
+		}
+		if conf.DB.DBHostPort == "" || conf.DB.DBName == "" {
+			return nil, fmt.Errorf("DATABASE_HOST_PORT and DATABASE_NAME must be set for postgres storage")
+		}
+	default:
+		return nil, fmt.Errorf("unknown CART_STORAGE %q, expected memory, redis or postgres", storageType)
+	}
+	return conf, nil
+}
+
+// loadDurationEnv читает длительность в наносекундах из переменной окружения envName,
+// при отсутствии или некорректном значении возвращает defaultValue
+func loadDurationEnv(envName string, defaultValue time.Duration) time.Duration {
+	value, err := strconv.ParseInt(os.Getenv(envName), 0, 64)
+	if err != nil || value <= 0 {
+		logger.Warnw(nil, fmt.Sprintf("failed to parse %s: %v, will be using default: %v", envName, err, defaultValue))
+		return defaultValue
+	}
+	return time.Duration(value)
+}
+
 func initRedisClient() (*RedisConf, error) {
 	dbIndex := 0
 	err := error(nil)
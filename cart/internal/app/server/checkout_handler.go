@@ -2,6 +2,8 @@ package server
 
 import (
 	"encoding/json"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"io"
 	"net/http"
 )
@@ -23,7 +25,12 @@ func (s *Server) CheckoutHandleFunc(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	orderId, err := s.cartInterface.Checkout(r.Context(), postCheckoutRq.UserId)
+	orderId, err := s.cartInterface.Checkout(r.Context(), postCheckoutRq.UserId, r.Header.Get(IdempotencyKeyHeader))
+	// ключ идемпотентности уже использован для заказа с другим составом
+	if status.Code(err) == codes.AlreadyExists {
+		respondWithError(w, http.StatusConflict, err.Error(), methodUrl)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error(), methodUrl)
 		return
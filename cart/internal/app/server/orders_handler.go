@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"route256/cart/internal/pkg/model"
+	"strconv"
+)
+
+func (s *Server) GetUserOrdersHandleFunc(w http.ResponseWriter, r *http.Request) {
+	var methodUrl = "GET /user/<user_id>/orders"
+
+	userId, err := getParamFromReq(r, "user_id")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), methodUrl)
+		return
+	}
+
+	var pageSize int64
+	if rawPageSize := r.URL.Query().Get("page_size"); rawPageSize != "" {
+		pageSize, err = strconv.ParseInt(rawPageSize, 10, 32)
+		if err != nil || pageSize < 0 {
+			respondWithError(w, http.StatusBadRequest, "invalid page_size format", methodUrl)
+			return
+		}
+	}
+
+	userOrders, err := s.cartInterface.GetUserOrders(r.Context(), model.UserId(userId), int32(pageSize), r.URL.Query().Get("page_token"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), methodUrl)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(GetUserOrdersResponse{
+		userOrders,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to encode response", methodUrl)
+		return
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"route256/cart/internal/pkg/model"
+)
+
+// PatchCartHandleFunc применяет пакет операций к корзине: либо все, либо ни одной
+func (s *Server) PatchCartHandleFunc(w http.ResponseWriter, r *http.Request) {
+	userId, err := getParamFromReq(r, "user_id")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), "PATCH /user/<user_id>/cart")
+		return
+	}
+
+	var patchCartRq PatchCartRequest
+
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil || len(body) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty or invalid request body", "PATCH /user/<user_id>/cart")
+		return
+	}
+
+	err = json.Unmarshal(body, &patchCartRq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), "PATCH /user/<user_id>/cart")
+		return
+	}
+
+	operations := make([]model.CartOperation, 0, len(patchCartRq.Operations))
+	for _, operation := range patchCartRq.Operations {
+		operations = append(operations, model.CartOperation{
+			Type:  operation.Type,
+			SKU:   operation.SKU,
+			Count: operation.Count,
+		})
+	}
+
+	err = s.cartInterface.UpdateCart(r.Context(), model.UserId(userId), operations)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), "PATCH /user/<user_id>/cart")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
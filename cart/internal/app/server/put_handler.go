@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"route256/cart/internal/pkg/model"
+)
+
+// PutItemHandleFunc устанавливает количество товара в корзине, нулевое количество удаляет товар
+func (s *Server) PutItemHandleFunc(w http.ResponseWriter, r *http.Request) {
+	userId, err := getParamFromReq(r, "user_id")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), "PUT /user/<user_id>/cart/<sku_id>")
+		return
+	}
+
+	skuId, err := getParamFromReq(r, "sku_id")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), "PUT /user/<user_id>/cart/<sku_id>")
+		return
+	}
+
+	var putItemRq PutItemRequest
+
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil || len(body) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Empty or invalid request body", "PUT /user/<user_id>/cart/<sku_id>")
+		return
+	}
+
+	err = json.Unmarshal(body, &putItemRq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), "PUT /user/<user_id>/cart/<sku_id>")
+		return
+	}
+	if putItemRq.Count == nil {
+		respondWithError(w, http.StatusBadRequest, "missing count", "PUT /user/<user_id>/cart/<sku_id>")
+		return
+	}
+
+	err = s.cartInterface.SetCartItem(r.Context(), model.CartItem{
+		SKU:    model.SKU(skuId),
+		UserId: model.UserId(userId),
+		Count:  *putItemRq.Count,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), "PUT /user/<user_id>/cart/<sku_id>")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
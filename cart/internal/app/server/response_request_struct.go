@@ -5,14 +5,36 @@ import (
 	"route256/cart/internal/pkg/service/cartservice"
 )
 
+// IdempotencyKeyHeader заголовок, по которому повторный checkout вернет уже созданный заказ
+const IdempotencyKeyHeader = "Idempotency-Key"
+
 type PostItemRequest struct {
 	Count uint16 `json:"count"`
 }
 
+type PutItemRequest struct {
+	Count *uint16 `json:"count"`
+}
+
+// CartOperationRequest операция пакетного изменения корзины: add, set или remove
+type CartOperationRequest struct {
+	Type  model.CartOperationType `json:"type"`
+	SKU   model.SKU               `json:"sku_id"`
+	Count uint16                  `json:"count"`
+}
+
+type PatchCartRequest struct {
+	Operations []CartOperationRequest `json:"operations"`
+}
+
 type GetCartContentResponse struct {
 	*cartservice.CartContent
 }
 
+type GetUserOrdersResponse struct {
+	*cartservice.UserOrders
+}
+
 type PostCheckoutRq struct {
 	UserId model.UserId `json:"user"`
 }
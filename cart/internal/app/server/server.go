@@ -13,10 +13,13 @@ import (
 
 type CartInterface interface {
 	AddCartItem(ctx context.Context, cartItem model.CartItem) (*model.CartItem, error)
+	SetCartItem(ctx context.Context, cartItem model.CartItem) error
+	UpdateCart(ctx context.Context, userId model.UserId, operations []model.CartOperation) error
 	DeleteCartItem(ctx context.Context, userId model.UserId, sku model.SKU) error
 	CleanUpCart(ctx context.Context, userId model.UserId) error
 	GetCartItem(ctx context.Context, userId model.UserId) (*cartservice.CartContent, error)
-	Checkout(ctx context.Context, userId model.UserId) (orderId int64, err error)
+	Checkout(ctx context.Context, userId model.UserId, idempotencyKey string) (orderId int64, err error)
+	GetUserOrders(ctx context.Context, userId model.UserId, pageSize int32, pageToken string) (*cartservice.UserOrders, error)
 }
 
 type Server struct {
@@ -5,4 +5,6 @@ import "errors"
 var (
 	ErrUserNotFound = errors.New("user not found")
 	ErrCartNotFound = errors.New("cart not found")
+	// ErrTooManyItems количество товара в корзине больше, чем вмещает model.CartItem.Count
+	ErrTooManyItems = errors.New("too many items in cart")
 )
@@ -0,0 +1,39 @@
+package model
+
+import "fmt"
+
+// CartOperationType тип изменения товара в корзине
+type CartOperationType string
+
+const (
+	// CartOperationAdd добавляет Count к количеству товара
+	CartOperationAdd CartOperationType = "add"
+	// CartOperationSet устанавливает количество товара, нулевое количество удаляет товар
+	CartOperationSet CartOperationType = "set"
+	// CartOperationRemove удаляет товар, если он есть в корзине
+	CartOperationRemove CartOperationType = "remove"
+)
+
+// CartOperation изменение одного товара в корзине
+type CartOperation struct {
+	Type  CartOperationType
+	SKU   SKU
+	Count uint16
+}
+
+// Validate проверяет, что операция корректна
+func (op *CartOperation) Validate() error {
+	if op.SKU < 1 {
+		return fmt.Errorf("SKU must be positive")
+	}
+	switch op.Type {
+	case CartOperationAdd:
+		if op.Count < 1 {
+			return fmt.Errorf("count must be positive for %s operation", op.Type)
+		}
+	case CartOperationSet, CartOperationRemove:
+	default:
+		return fmt.Errorf("unknown operation type %q", op.Type)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+-- name: AddCartItem :execrows
+INSERT INTO cart_items (user_id, sku, count)
+VALUES (@user_id, @sku, @count)
+ON CONFLICT (user_id, sku) DO UPDATE SET count = cart_items.count + EXCLUDED.count
+WHERE cart_items.count + EXCLUDED.count <= sqlc.arg(max_count)::integer;
+
+-- name: DeleteCart :execrows
+DELETE
+FROM carts
+WHERE user_id = @user_id
+  AND updated_at >= now() - sqlc.arg(ttl)::interval;
+
+-- name: DeleteCartIfEmpty :execrows
+DELETE
+FROM carts
+WHERE user_id = $1
+  AND NOT EXISTS (SELECT 1 FROM cart_items WHERE cart_items.user_id = $1);
+
+-- name: DeleteCartItem :execrows
+DELETE
+FROM cart_items
+WHERE user_id = $1
+  AND sku = $2;
+
+-- name: DeleteCartItems :exec
+DELETE
+FROM cart_items
+WHERE user_id = $1;
+
+-- name: DeleteExpiredCart :exec
+DELETE
+FROM carts
+WHERE user_id = @user_id
+  AND updated_at < now() - sqlc.arg(ttl)::interval;
+
+-- name: DeleteExpiredCarts :execrows
+DELETE
+FROM carts
+WHERE user_id IN (SELECT expired.user_id
+                  FROM carts expired
+                  WHERE expired.updated_at < now() - sqlc.arg(ttl)::interval
+                  LIMIT @max_count);
+
+-- name: GetCartItems :many
+SELECT cart_items.sku, cart_items.count
+FROM cart_items
+       JOIN carts ON carts.user_id = cart_items.user_id
+WHERE carts.user_id = @user_id
+  AND carts.updated_at >= now() - sqlc.arg(ttl)::interval
+ORDER BY cart_items.sku;
+
+-- name: LockCart :one
+SELECT user_id
+FROM carts
+WHERE user_id = @user_id
+  AND updated_at >= now() - sqlc.arg(ttl)::interval
+FOR UPDATE;
+
+-- name: TouchCart :exec
+INSERT INTO carts (user_id)
+VALUES ($1)
+ON CONFLICT (user_id) DO UPDATE SET updated_at = now();
@@ -0,0 +1,243 @@
+package pgstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log"
+	"math"
+	"route256/cart/internal/logger"
+	"route256/cart/internal/pkg/apperrors"
+	"route256/cart/internal/pkg/model"
+	"time"
+)
+
+// purgeBatchSize число просроченных корзин, удаляемых одним запросом
+const purgeBatchSize = 1000
+
+// Storage хранит корзины в таблицах carts и cart_items.
+// Корзина, которую не меняли дольше ttl, считается удаленной: ее не видно в запросах,
+// при следующем добавлении товара она очищается, а остальные удаляет PurgeExpired
+type Storage struct {
+	pool *pgxpool.Pool
+	ttl  pgtype.Interval
+}
+
+func NewStorage(pool *pgxpool.Pool, ttl time.Duration) *Storage {
+	return &Storage{
+		pool: pool,
+		ttl:  pgtype.Interval{Microseconds: ttl.Microseconds(), Valid: true},
+	}
+}
+
+func (s *Storage) AddItem(ctx context.Context, userID model.UserId, item model.CartItem) error {
+	err := s.inTx(ctx, func(queries *Queries) error {
+		err := queries.DeleteExpiredCart(ctx, &DeleteExpiredCartParams{UserID: int64(userID), Ttl: s.ttl})
+		if err != nil {
+			return err
+		}
+		// строка корзины блокируется до конца транзакции, поэтому изменения одной корзины не пересекаются
+		if err = queries.TouchCart(ctx, int64(userID)); err != nil {
+			return err
+		}
+		added, err := queries.AddCartItem(ctx, &AddCartItemParams{
+			UserID:   int64(userID),
+			Sku:      int64(item.SKU),
+			Count:    int32(item.Count),
+			MaxCount: math.MaxUint16,
+		})
+		if err != nil {
+			return err
+		}
+		// запрос не увеличивает количество больше MaxCount, ошибка откатывает и продление корзины
+		if added == 0 {
+			return apperrors.ErrTooManyItems
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to add item %d to cart of user %d: %w", item.SKU, userID, err)
+	}
+	return nil
+}
+
+func (s *Storage) RemoveItem(ctx context.Context, userID model.UserId, sku model.SKU) error {
+	return s.inTx(ctx, func(queries *Queries) error {
+		_, err := queries.LockCart(ctx, &LockCartParams{UserID: int64(userID), Ttl: s.ttl})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return apperrors.ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("unable to lock cart of user %d: %w", userID, err)
+		}
+
+		deleted, err := queries.DeleteCartItem(ctx, &DeleteCartItemParams{UserID: int64(userID), Sku: int64(sku)})
+		if err != nil {
+			return fmt.Errorf("unable to remove item %d from cart of user %d: %w", sku, userID, err)
+		}
+		if deleted == 0 {
+			return apperrors.ErrCartNotFound
+		}
+		// пустая корзина удаляется, как и в памяти, а TTL продлевается только у непустой
+		removed, err := queries.DeleteCartIfEmpty(ctx, int64(userID))
+		if err != nil {
+			return fmt.Errorf("unable to remove empty cart of user %d: %w", userID, err)
+		}
+		if removed > 0 {
+			return nil
+		}
+		return queries.TouchCart(ctx, int64(userID))
+	})
+}
+
+func (s *Storage) RemoveByUserId(ctx context.Context, userID model.UserId) error {
+	deleted, err := New(s.pool).DeleteCart(ctx, &DeleteCartParams{UserID: int64(userID), Ttl: s.ttl})
+	if err != nil {
+		return fmt.Errorf("unable to remove cart of user %d: %w", userID, err)
+	}
+	if deleted == 0 {
+		return apperrors.ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *Storage) GetCart(ctx context.Context, userID model.UserId) (map[model.SKU]model.CartItem, error) {
+	rows, err := New(s.pool).GetCartItems(ctx, &GetCartItemsParams{UserID: int64(userID), Ttl: s.ttl})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get cart of user %d: %w", userID, err)
+	}
+	if len(rows) == 0 {
+		return nil, apperrors.ErrCartNotFound
+	}
+
+	return cartFromRows(userID, rows)
+}
+
+func (s *Storage) UpdateCart(ctx context.Context, userID model.UserId, update func(cart map[model.SKU]model.CartItem) error) error {
+	return s.inTx(ctx, func(queries *Queries) error {
+		err := queries.DeleteExpiredCart(ctx, &DeleteExpiredCartParams{UserID: int64(userID), Ttl: s.ttl})
+		if err != nil {
+			return fmt.Errorf("unable to remove expired cart of user %d: %w", userID, err)
+		}
+		// строка корзины создается или блокируется до конца транзакции
+		if err = queries.TouchCart(ctx, int64(userID)); err != nil {
+			return fmt.Errorf("unable to lock cart of user %d: %w", userID, err)
+		}
+		rows, err := queries.GetCartItems(ctx, &GetCartItemsParams{UserID: int64(userID), Ttl: s.ttl})
+		if err != nil {
+			return fmt.Errorf("unable to get cart of user %d: %w", userID, err)
+		}
+		cart, err := cartFromRows(userID, rows)
+		if err != nil {
+			return err
+		}
+		if err = update(cart); err != nil {
+			return err
+		}
+
+		if err = queries.DeleteCartItems(ctx, int64(userID)); err != nil {
+			return fmt.Errorf("unable to clear cart of user %d: %w", userID, err)
+		}
+		for sku, item := range cart {
+			if item.Count == 0 {
+				continue
+			}
+			_, err = queries.AddCartItem(ctx, &AddCartItemParams{
+				UserID:   int64(userID),
+				Sku:      int64(sku),
+				Count:    int32(item.Count),
+				MaxCount: math.MaxUint16,
+			})
+			if err != nil {
+				return fmt.Errorf("unable to save item %d to cart of user %d: %w", sku, userID, err)
+			}
+		}
+		if _, err = queries.DeleteCartIfEmpty(ctx, int64(userID)); err != nil {
+			return fmt.Errorf("unable to remove empty cart of user %d: %w", userID, err)
+		}
+		return nil
+	})
+}
+
+// cartFromRows собирает корзину из строк cart_items. Количество больше uint16 не обрезается,
+// а возвращается ошибкой: такую строку запретила бы проверка в cart_items
+func cartFromRows(userID model.UserId, rows []*GetCartItemsRow) (map[model.SKU]model.CartItem, error) {
+	cart := make(map[model.SKU]model.CartItem, len(rows))
+	for _, row := range rows {
+		if row.Count <= 0 || row.Count > math.MaxUint16 {
+			return nil, fmt.Errorf("invalid count %d of sku %d in cart of user %d", row.Count, row.Sku, userID)
+		}
+		cart[model.SKU(row.Sku)] = model.CartItem{
+			SKU:    model.SKU(row.Sku),
+			UserId: userID,
+			Count:  uint16(row.Count),
+		}
+	}
+	return cart, nil
+}
+
+// PurgeExpired удаляет просроченные корзины пачками и возвращает их число
+func (s *Storage) PurgeExpired(ctx context.Context) (int64, error) {
+	var purged int64
+	for {
+		deleted, err := New(s.pool).DeleteExpiredCarts(ctx, &DeleteExpiredCartsParams{
+			Ttl:      s.ttl,
+			MaxCount: purgeBatchSize,
+		})
+		if err != nil {
+			return purged, fmt.Errorf("unable to purge expired carts: %w", err)
+		}
+		purged += deleted
+		if deleted < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// StartPurge периодически удаляет просроченные корзины
+func (s *Storage) StartPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping cart purge")
+			return
+		case <-ticker.C:
+			purged, err := s.PurgeExpired(ctx)
+			if err != nil {
+				logger.Errorw(ctx, "Failed to purge expired carts", "error", err)
+				continue
+			}
+			if purged > 0 {
+				logger.Infow(ctx, "Purged expired carts", "count", purged)
+			}
+		}
+	}
+}
+
+// Ping для readiness
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.pool.Ping(ctx)
+}
+
+func (s *Storage) inTx(ctx context.Context, fn func(queries *Queries) error) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	// после Commit откат ничего не делает
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err = fn(New(tx)); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("unable to commit transaction: %w", err)
+	}
+	return nil
+}
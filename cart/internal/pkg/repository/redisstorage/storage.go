@@ -0,0 +1,176 @@
+package redisstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"route256/cart/internal/pkg/apperrors"
+	"route256/cart/internal/pkg/model"
+	"strconv"
+	"time"
+)
+
+// maxUpdateAttempts число попыток изменить корзину, если ее одновременно меняют другие запросы
+const maxUpdateAttempts = 10
+
+// removeItemScript удаляет товар из корзины атомарно, чтобы отличить отсутствие корзины от отсутствия товара.
+// Возвращает 0, если корзины нет, 1, если нет товара, 2, если товар удален
+var removeItemScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  return 0
+end
+if redis.call('HDEL', KEYS[1], ARGV[1]) == 0 then
+  return 1
+end
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 2
+`)
+
+// addItemScript увеличивает количество товара и продлевает корзину, если новое количество не больше ARGV[3].
+// Иначе корзина не меняется. Возвращает 0, если количество превышено, 1, если товар добавлен
+var addItemScript = redis.NewScript(`
+local count = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0') + tonumber(ARGV[2])
+if count > tonumber(ARGV[3]) then
+  return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], count)
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return 1
+`)
+
+// Storage хранит корзину пользователя в хеше cart:{userId}: поле - SKU, значение - количество.
+// Каждая операция меняет ключ одной командой или скриптом, поэтому блокировки не нужны.
+// Корзина удаляется, если ее не меняли дольше ttl
+type Storage struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewStorage(client *redis.Client, ttl time.Duration) *Storage {
+	return &Storage{
+		client: client,
+		ttl:    ttl,
+	}
+}
+
+func cartKey(userID model.UserId) string {
+	return fmt.Sprintf("cart:%d", userID)
+}
+
+func (s *Storage) AddItem(ctx context.Context, userID model.UserId, item model.CartItem) error {
+	// HINCRBY не ограничивает значение, а количество больше uint16 не разобрала бы parseCart
+	added, err := addItemScript.Run(ctx, s.client, []string{cartKey(userID)},
+		strconv.FormatInt(int64(item.SKU), 10), int64(item.Count), math.MaxUint16, s.ttl.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("unable to add item %d to cart of user %d: %w", item.SKU, userID, err)
+	}
+	if added == 0 {
+		return fmt.Errorf("unable to add item %d to cart of user %d: %w", item.SKU, userID, apperrors.ErrTooManyItems)
+	}
+	return nil
+}
+
+func (s *Storage) RemoveItem(ctx context.Context, userID model.UserId, sku model.SKU) error {
+	result, err := removeItemScript.Run(ctx, s.client, []string{cartKey(userID)},
+		strconv.FormatInt(int64(sku), 10), s.ttl.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("unable to remove item %d from cart of user %d: %w", sku, userID, err)
+	}
+	switch result {
+	case 0:
+		return apperrors.ErrUserNotFound
+	case 1:
+		return apperrors.ErrCartNotFound
+	}
+	return nil
+}
+
+func (s *Storage) RemoveByUserId(ctx context.Context, userID model.UserId) error {
+	deleted, err := s.client.Del(ctx, cartKey(userID)).Result()
+	if err != nil {
+		return fmt.Errorf("unable to remove cart of user %d: %w", userID, err)
+	}
+	if deleted == 0 {
+		return apperrors.ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *Storage) GetCart(ctx context.Context, userID model.UserId) (map[model.SKU]model.CartItem, error) {
+	fields, err := s.client.HGetAll(ctx, cartKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get cart of user %d: %w", userID, err)
+	}
+	if len(fields) == 0 {
+		return nil, apperrors.ErrCartNotFound
+	}
+
+	return parseCart(userID, fields)
+}
+
+func (s *Storage) UpdateCart(ctx context.Context, userID model.UserId, update func(cart map[model.SKU]model.CartItem) error) error {
+	key := cartKey(userID)
+	for i := 0; i < maxUpdateAttempts; i++ {
+		// при изменении ключа другим клиентом транзакция не выполнится и будет повторена
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			fields, err := tx.HGetAll(ctx, key).Result()
+			if err != nil {
+				return fmt.Errorf("unable to get cart of user %d: %w", userID, err)
+			}
+			cart, err := parseCart(userID, fields)
+			if err != nil {
+				return err
+			}
+			if err = update(cart); err != nil {
+				return err
+			}
+
+			values := make([]interface{}, 0, 2*len(cart))
+			for sku, item := range cart {
+				if item.Count > 0 {
+					values = append(values, strconv.FormatInt(int64(sku), 10), int64(item.Count))
+				}
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Del(ctx, key)
+				if len(values) > 0 {
+					pipe.HSet(ctx, key, values...)
+					pipe.Expire(ctx, key, s.ttl)
+				}
+				return nil
+			})
+			if err != nil && !errors.Is(err, redis.TxFailedErr) {
+				return fmt.Errorf("unable to update cart of user %d: %w", userID, err)
+			}
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("unable to update cart of user %d: too many concurrent changes", userID)
+}
+
+func parseCart(userID model.UserId, fields map[string]string) (map[model.SKU]model.CartItem, error) {
+	cart := make(map[model.SKU]model.CartItem, len(fields))
+	for field, value := range fields {
+		sku, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sku %q in cart of user %d: %w", field, userID, err)
+		}
+		count, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid count of sku %d in cart of user %d: %w", sku, userID, err)
+		}
+		cart[model.SKU(sku)] = model.CartItem{
+			SKU:    model.SKU(sku),
+			UserId: userID,
+			Count:  uint16(count),
+		}
+	}
+	return cart, nil
+}
@@ -3,20 +3,24 @@ package repository
 import (
 	"context"
 	"route256/cart/internal/pkg/model"
-	"sync"
 )
 
+// AbstractStorage хранилище корзин. Каждая операция атомарна относительно корзины пользователя,
+// синхронизацию обеспечивает само хранилище
 type AbstractStorage interface {
-	AddItem(id model.UserId, item model.CartItem)
-	RemoveItem(id model.UserId, sku model.SKU) error
-	RemoveByUserId(id model.UserId) error
-	GetCart(id model.UserId) (map[model.SKU]model.CartItem, error)
+	AddItem(ctx context.Context, id model.UserId, item model.CartItem) error
+	RemoveItem(ctx context.Context, id model.UserId, sku model.SKU) error
+	RemoveByUserId(ctx context.Context, id model.UserId) error
+	GetCart(ctx context.Context, id model.UserId) (map[model.SKU]model.CartItem, error)
+	// UpdateCart передает update копию корзины, пустую, если корзины нет, и атомарно сохраняет результат.
+	// Товары с нулевым количеством удаляются, пустая корзина удаляется целиком.
+	// Если update вернул ошибку, корзина не меняется
+	UpdateCart(ctx context.Context, id model.UserId, update func(cart map[model.SKU]model.CartItem) error) error
 }
 
 // Repository использует Storage для работы с корзинами
 type Repository struct {
 	storage AbstractStorage
-	rwMx    sync.RWMutex
 }
 
 // NewRepository создает новый репозиторий с хранилищем корзин
@@ -25,30 +29,29 @@ func NewRepository(storage AbstractStorage) *Repository {
 }
 
 // InsertItem добавляет или обновляет элемент в корзине пользователя
-func (r *Repository) InsertItem(_ context.Context, cartItem model.CartItem) (*model.CartItem, error) {
-	r.rwMx.Lock()
-	defer r.rwMx.Unlock()
-	r.storage.AddItem(cartItem.UserId, cartItem)
+func (r *Repository) InsertItem(ctx context.Context, cartItem model.CartItem) (*model.CartItem, error) {
+	if err := r.storage.AddItem(ctx, cartItem.UserId, cartItem); err != nil {
+		return nil, err
+	}
 	return &cartItem, nil
 }
 
 // RemoveItem удаляет товар из корзины пользователя
-func (r *Repository) RemoveItem(_ context.Context, userId model.UserId, sku model.SKU) error {
-	r.rwMx.Lock()
-	defer r.rwMx.Unlock()
-	return r.storage.RemoveItem(userId, sku)
+func (r *Repository) RemoveItem(ctx context.Context, userId model.UserId, sku model.SKU) error {
+	return r.storage.RemoveItem(ctx, userId, sku)
 }
 
 // RemoveByUserId удаляет корзину пользователя
-func (r *Repository) RemoveByUserId(_ context.Context, userId model.UserId) error {
-	r.rwMx.Lock()
-	defer r.rwMx.Unlock()
-	return r.storage.RemoveByUserId(userId)
+func (r *Repository) RemoveByUserId(ctx context.Context, userId model.UserId) error {
+	return r.storage.RemoveByUserId(ctx, userId)
 }
 
 // GetItem возвращает корзину пользователя
-func (r *Repository) GetCartByUserId(_ context.Context, userId model.UserId) (map[model.SKU]model.CartItem, error) {
-	r.rwMx.RLock()
-	defer r.rwMx.RUnlock()
-	return r.storage.GetCart(userId)
+func (r *Repository) GetCartByUserId(ctx context.Context, userId model.UserId) (map[model.SKU]model.CartItem, error) {
+	return r.storage.GetCart(ctx, userId)
+}
+
+// UpdateCart атомарно изменяет корзину пользователя
+func (r *Repository) UpdateCart(ctx context.Context, userId model.UserId, update func(cart map[model.SKU]model.CartItem) error) error {
+	return r.storage.UpdateCart(ctx, userId, update)
 }
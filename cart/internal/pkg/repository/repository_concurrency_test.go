@@ -12,8 +12,11 @@ import (
 
 func TestConcurrentInsert(t *testing.T) {
 	t.Parallel()
-	storage := NewStorage()
-	repo := NewRepository(storage)
+	testConcurrentInsert(t, newMemoryStorage)
+}
+
+func testConcurrentInsert(t *testing.T, newStorage storageFactory) {
+	repo := NewRepository(newStorage(t))
 
 	userId := model.UserId(1)
 	numGoroutines := 100
@@ -49,8 +52,11 @@ func TestConcurrentInsert(t *testing.T) {
 
 func TestConcurrentGetCart(t *testing.T) {
 	t.Parallel()
-	storage := NewStorage()
-	repo := NewRepository(storage)
+	testConcurrentGetCart(t, newMemoryStorage)
+}
+
+func testConcurrentGetCart(t *testing.T, newStorage storageFactory) {
+	repo := NewRepository(newStorage(t))
 
 	userId := model.UserId(2)
 	for i := 0; i < 50; i++ {
@@ -85,8 +91,11 @@ func TestConcurrentGetCart(t *testing.T) {
 
 func TestConcurrentInsertAndGet(t *testing.T) {
 	t.Parallel()
-	storage := NewStorage()
-	repo := NewRepository(storage)
+	testConcurrentInsertAndGet(t, newMemoryStorage)
+}
+
+func testConcurrentInsertAndGet(t *testing.T, newStorage storageFactory) {
+	repo := NewRepository(newStorage(t))
 
 	userId := model.UserId(3)
 	numInsertGoroutines := 50
@@ -134,8 +143,11 @@ func TestConcurrentInsertAndGet(t *testing.T) {
 
 func TestConcurrentInsertAndRemove(t *testing.T) {
 	t.Parallel()
-	storage := NewStorage()
-	repo := NewRepository(storage)
+	testConcurrentInsertAndRemove(t, newMemoryStorage)
+}
+
+func testConcurrentInsertAndRemove(t *testing.T, newStorage storageFactory) {
+	repo := NewRepository(newStorage(t))
 
 	userId := model.UserId(4)
 	numGoroutines := 100
@@ -177,6 +189,14 @@ func TestConcurrentInsertAndRemove(t *testing.T) {
 	assert.Equal(t, numGoroutines, len(cart)+int(removeSuccessCount.Load()))
 }
 
+// runConcurrencySuite проверяет одновременные изменения корзины в хранилище из newStorage
+func runConcurrencySuite(t *testing.T, newStorage storageFactory) {
+	t.Run("Insert", func(t *testing.T) { testConcurrentInsert(t, newStorage) })
+	t.Run("GetCart", func(t *testing.T) { testConcurrentGetCart(t, newStorage) })
+	t.Run("InsertAndGet", func(t *testing.T) { testConcurrentInsertAndGet(t, newStorage) })
+	t.Run("InsertAndRemove", func(t *testing.T) { testConcurrentInsertAndRemove(t, newStorage) })
+}
+
 func cartHasUserId(cart map[model.SKU]model.CartItem, userId model.UserId) bool {
 	for sku := range cart {
 		if cart[sku].UserId != userId {
@@ -2,13 +2,24 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"math"
 	"route256/cart/internal/pkg/apperrors"
 	"route256/cart/internal/pkg/model"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// storageFactory создает пустое хранилище корзин для теста. Хранилища в памяти, Redis и Postgres
+// проверяются одними тестами, внешние запускаются с тегом integration
+type storageFactory func(t *testing.T) AbstractStorage
+
+func newMemoryStorage(*testing.T) AbstractStorage {
+	return NewStorage()
+}
+
 type testStruct struct {
 	name     string
 	mockRepo func() *AbstractStorageMock
@@ -46,7 +57,7 @@ func TestRepository_RemoveItem(t *testing.T) {
 			"Remove item successfully",
 			func() *AbstractStorageMock {
 				storageMock := NewAbstractStorageMock(t)
-				storageMock.RemoveItemMock.Expect(model.UserId(1), model.SKU(101)).Return(nil)
+				storageMock.RemoveItemMock.Expect(context.Background(), model.UserId(1), model.SKU(101)).Return(nil)
 				return storageMock
 			},
 			model.UserId(1),
@@ -58,7 +69,7 @@ func TestRepository_RemoveItem(t *testing.T) {
 			"Remove item with error",
 			func() *AbstractStorageMock {
 				storageMock := NewAbstractStorageMock(t)
-				storageMock.RemoveItemMock.Expect(model.UserId(1), model.SKU(101)).Return(apperrors.ErrCartNotFound)
+				storageMock.RemoveItemMock.Expect(context.Background(), model.UserId(1), model.SKU(101)).Return(apperrors.ErrCartNotFound)
 				return storageMock
 			},
 			model.UserId(1),
@@ -91,7 +102,7 @@ func TestRepository_GetItem(t *testing.T) {
 			"Get item successfully",
 			func() *AbstractStorageMock {
 				storageMock := NewAbstractStorageMock(t)
-				storageMock.GetCartMock.Expect(model.UserId(1)).Return(map[model.SKU]model.CartItem{
+				storageMock.GetCartMock.Expect(context.Background(), model.UserId(1)).Return(map[model.SKU]model.CartItem{
 					model.SKU(101): {UserId: 1, SKU: 101, Count: 2},
 				}, nil)
 				return storageMock
@@ -105,7 +116,7 @@ func TestRepository_GetItem(t *testing.T) {
 			"Get item - user not found",
 			func() *AbstractStorageMock {
 				storageMock := NewAbstractStorageMock(t)
-				storageMock.GetCartMock.Expect(model.UserId(1)).Return(nil, apperrors.ErrCartNotFound)
+				storageMock.GetCartMock.Expect(context.Background(), model.UserId(1)).Return(nil, apperrors.ErrCartNotFound)
 				return storageMock
 			},
 			model.UserId(1),
@@ -137,7 +148,7 @@ func TestRepository_RemoveByUserId(t *testing.T) {
 			"Remove user by ID successfully",
 			func() *AbstractStorageMock {
 				storageMock := NewAbstractStorageMock(t)
-				storageMock.RemoveByUserIdMock.Expect(model.UserId(1)).Return(nil)
+				storageMock.RemoveByUserIdMock.Expect(context.Background(), model.UserId(1)).Return(nil)
 				return storageMock
 			},
 			model.UserId(1),
@@ -149,7 +160,7 @@ func TestRepository_RemoveByUserId(t *testing.T) {
 			"Remove user by ID - user not found",
 			func() *AbstractStorageMock {
 				storageMock := NewAbstractStorageMock(t)
-				storageMock.RemoveByUserIdMock.Expect(model.UserId(999)).Return(apperrors.ErrUserNotFound)
+				storageMock.RemoveByUserIdMock.Expect(context.Background(), model.UserId(999)).Return(apperrors.ErrUserNotFound)
 				return storageMock
 			},
 			model.UserId(999),
@@ -180,11 +191,11 @@ func TestRepository_InsertItem(t *testing.T) {
 			"Insert item successfully",
 			func() *AbstractStorageMock {
 				storageMock := NewAbstractStorageMock(t)
-				storageMock.AddItemMock.Expect(model.UserId(1), model.CartItem{
+				storageMock.AddItemMock.Expect(context.Background(), model.UserId(1), model.CartItem{
 					UserId: 1,
 					SKU:    101,
 					Count:  2,
-				}).Return()
+				}).Return(nil)
 				return storageMock
 			},
 			model.UserId(1),
@@ -213,3 +224,153 @@ func TestRepository_InsertItem(t *testing.T) {
 		})
 	}
 }
+
+func TestRepository_MemoryStorage(t *testing.T) {
+	runRepositorySuite(t, newMemoryStorage)
+}
+
+// runRepositorySuite проверяет поведение репозитория, одинаковое для всех хранилищ
+func runRepositorySuite(t *testing.T, newStorage storageFactory) {
+	t.Run("InsertItemAddsCount", func(t *testing.T) { testInsertItemAddsCount(t, newStorage) })
+	t.Run("InsertItemTooMany", func(t *testing.T) { testInsertItemTooMany(t, newStorage) })
+	t.Run("RemoveItem", func(t *testing.T) { testRemoveItem(t, newStorage) })
+	t.Run("RemoveByUserId", func(t *testing.T) { testRemoveByUserId(t, newStorage) })
+	t.Run("UpdateCart", func(t *testing.T) { testUpdateCart(t, newStorage) })
+}
+
+func testInsertItemAddsCount(t *testing.T, newStorage storageFactory) {
+	ctx := context.Background()
+	repo := NewRepository(newStorage(t))
+
+	_, err := repo.GetCartByUserId(ctx, 1)
+	assert.ErrorIs(t, err, apperrors.ErrCartNotFound)
+
+	_, err = repo.InsertItem(ctx, model.CartItem{UserId: 1, SKU: 101, Count: 2})
+	require.NoError(t, err)
+	_, err = repo.InsertItem(ctx, model.CartItem{UserId: 1, SKU: 101, Count: 3})
+	require.NoError(t, err)
+	_, err = repo.InsertItem(ctx, model.CartItem{UserId: 1, SKU: 102, Count: 1})
+	require.NoError(t, err)
+
+	cart, err := repo.GetCartByUserId(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, map[model.SKU]model.CartItem{
+		101: {UserId: 1, SKU: 101, Count: 5},
+		102: {UserId: 1, SKU: 102, Count: 1},
+	}, cart)
+}
+
+func testInsertItemTooMany(t *testing.T, newStorage storageFactory) {
+	ctx := context.Background()
+	repo := NewRepository(newStorage(t))
+
+	_, err := repo.InsertItem(ctx, model.CartItem{UserId: 1, SKU: 101, Count: math.MaxUint16 - 1})
+	require.NoError(t, err)
+	// количество до предела uint16 включительно допустимо
+	_, err = repo.InsertItem(ctx, model.CartItem{UserId: 1, SKU: 101, Count: 1})
+	require.NoError(t, err)
+
+	_, err = repo.InsertItem(ctx, model.CartItem{UserId: 1, SKU: 101, Count: 1})
+	assert.ErrorIs(t, err, apperrors.ErrTooManyItems)
+	_, err = repo.InsertItem(ctx, model.CartItem{UserId: 1, SKU: 101, Count: math.MaxUint16})
+	assert.ErrorIs(t, err, apperrors.ErrTooManyItems)
+
+	// отказ не меняет корзину, и она по-прежнему читается
+	cart, err := repo.GetCartByUserId(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, map[model.SKU]model.CartItem{101: {UserId: 1, SKU: 101, Count: math.MaxUint16}}, cart)
+}
+
+func testRemoveItem(t *testing.T, newStorage storageFactory) {
+	ctx := context.Background()
+	repo := NewRepository(newStorage(t))
+
+	assert.ErrorIs(t, repo.RemoveItem(ctx, 1, 101), apperrors.ErrUserNotFound)
+
+	_, err := repo.InsertItem(ctx, model.CartItem{UserId: 1, SKU: 101, Count: 2})
+	require.NoError(t, err)
+	_, err = repo.InsertItem(ctx, model.CartItem{UserId: 1, SKU: 102, Count: 1})
+	require.NoError(t, err)
+	assert.ErrorIs(t, repo.RemoveItem(ctx, 1, 103), apperrors.ErrCartNotFound)
+
+	require.NoError(t, repo.RemoveItem(ctx, 1, 101))
+	cart, err := repo.GetCartByUserId(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, map[model.SKU]model.CartItem{102: {UserId: 1, SKU: 102, Count: 1}}, cart)
+
+	// после удаления последнего товара корзины нет
+	require.NoError(t, repo.RemoveItem(ctx, 1, 102))
+	_, err = repo.GetCartByUserId(ctx, 1)
+	assert.ErrorIs(t, err, apperrors.ErrCartNotFound)
+	assert.ErrorIs(t, repo.RemoveItem(ctx, 1, 102), apperrors.ErrUserNotFound)
+}
+
+func testRemoveByUserId(t *testing.T, newStorage storageFactory) {
+	ctx := context.Background()
+	repo := NewRepository(newStorage(t))
+
+	assert.ErrorIs(t, repo.RemoveByUserId(ctx, 1), apperrors.ErrUserNotFound)
+
+	_, err := repo.InsertItem(ctx, model.CartItem{UserId: 1, SKU: 101, Count: 2})
+	require.NoError(t, err)
+	_, err = repo.InsertItem(ctx, model.CartItem{UserId: 2, SKU: 101, Count: 1})
+	require.NoError(t, err)
+
+	require.NoError(t, repo.RemoveByUserId(ctx, 1))
+	_, err = repo.GetCartByUserId(ctx, 1)
+	assert.ErrorIs(t, err, apperrors.ErrCartNotFound)
+	// корзины других пользователей не меняются
+	cart, err := repo.GetCartByUserId(ctx, 2)
+	require.NoError(t, err)
+	assert.Len(t, cart, 1)
+}
+
+func testUpdateCart(t *testing.T, newStorage storageFactory) {
+	ctx := context.Background()
+	repo := NewRepository(newStorage(t))
+
+	// корзины нет: update получает пустую корзину, и ее результат сохраняется
+	err := repo.UpdateCart(ctx, 1, func(cart map[model.SKU]model.CartItem) error {
+		assert.Empty(t, cart)
+		cart[101] = model.CartItem{UserId: 1, SKU: 101, Count: 2}
+		cart[102] = model.CartItem{UserId: 1, SKU: 102, Count: 3}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// ошибка update не меняет корзину
+	rejected := errors.New("rejected")
+	err = repo.UpdateCart(ctx, 1, func(cart map[model.SKU]model.CartItem) error {
+		delete(cart, 101)
+		cart[102] = model.CartItem{UserId: 1, SKU: 102, Count: 10}
+		return rejected
+	})
+	assert.ErrorIs(t, err, rejected)
+	cart, err := repo.GetCartByUserId(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, map[model.SKU]model.CartItem{
+		101: {UserId: 1, SKU: 101, Count: 2},
+		102: {UserId: 1, SKU: 102, Count: 3},
+	}, cart)
+
+	// нулевое количество удаляет товар
+	err = repo.UpdateCart(ctx, 1, func(cart map[model.SKU]model.CartItem) error {
+		assert.Equal(t, uint16(2), cart[101].Count)
+		cart[101] = model.CartItem{UserId: 1, SKU: 101}
+		cart[102] = model.CartItem{UserId: 1, SKU: 102, Count: math.MaxUint16}
+		return nil
+	})
+	require.NoError(t, err)
+	cart, err = repo.GetCartByUserId(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, map[model.SKU]model.CartItem{102: {UserId: 1, SKU: 102, Count: math.MaxUint16}}, cart)
+
+	// пустая корзина удаляется целиком
+	err = repo.UpdateCart(ctx, 1, func(cart map[model.SKU]model.CartItem) error {
+		delete(cart, 102)
+		return nil
+	})
+	require.NoError(t, err)
+	_, err = repo.GetCartByUserId(ctx, 1)
+	assert.ErrorIs(t, err, apperrors.ErrCartNotFound)
+}
@@ -0,0 +1,108 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"route256/cart/internal/pkg/repository/pgstorage"
+	"route256/cart/internal/pkg/repository/redisstorage"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/jackc/pgx/v5/pgxpool"
+	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// migrationsDir миграции базы корзин, которые применяет make apply-migrations
+const migrationsDir = "../../../migrations"
+
+const cartTTL = time.Hour
+
+func startContainer(t *testing.T, req testcontainers.ContainerRequest) (string, nat.Port) {
+	t.Helper()
+	ctx := context.Background()
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	require.NoError(t, err, "failed to start container %s", req.Image)
+	t.Cleanup(func() {
+		require.NoError(t, container.Terminate(context.Background()))
+	})
+
+	host, err := container.Host(ctx)
+	require.NoError(t, err)
+	port, err := container.MappedPort(ctx, nat.Port(req.ExposedPorts[0]))
+	require.NoError(t, err)
+	return host, port
+}
+
+func TestRedisStorage(t *testing.T) {
+	host, port := startContainer(t, testcontainers.ContainerRequest{
+		Image:        "redis:7",
+		ExposedPorts: []string{"6379/tcp"},
+		WaitingFor:   wait.ForLog("Ready to accept connections"),
+	})
+	client := redis.NewClient(&redis.Options{Addr: fmt.Sprintf("%s:%s", host, port.Port())})
+	t.Cleanup(func() { _ = client.Close() })
+
+	newStorage := func(t *testing.T) AbstractStorage {
+		require.NoError(t, client.FlushDB(context.Background()).Err())
+		return redisstorage.NewStorage(client, cartTTL)
+	}
+	t.Run("Repository", func(t *testing.T) { runRepositorySuite(t, newStorage) })
+	t.Run("Concurrency", func(t *testing.T) { runConcurrencySuite(t, newStorage) })
+}
+
+func TestPgStorage(t *testing.T) {
+	dsn := func(host string, port nat.Port) string {
+		return fmt.Sprintf("host=%s port=%s user=testuser password=testpassword dbname=testdb sslmode=disable", host, port.Port())
+	}
+	host, port := startContainer(t, testcontainers.ContainerRequest{
+		Image:        "postgres:16",
+		ExposedPorts: []string{"5432/tcp"},
+		Env: map[string]string{
+			"POSTGRES_PASSWORD": "testpassword",
+			"POSTGRES_USER":     "testuser",
+			"POSTGRES_DB":       "testdb",
+		},
+		WaitingFor: wait.ForSQL("5432/tcp", "postgres", dsn),
+	})
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn(host, port))
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	applyMigrations(t, pool)
+
+	newStorage := func(t *testing.T) AbstractStorage {
+		_, err := pool.Exec(context.Background(), "TRUNCATE carts, cart_items")
+		require.NoError(t, err)
+		return pgstorage.NewStorage(pool, cartTTL)
+	}
+	t.Run("Repository", func(t *testing.T) { runRepositorySuite(t, newStorage) })
+	t.Run("Concurrency", func(t *testing.T) { runConcurrencySuite(t, newStorage) })
+}
+
+// applyMigrations выполняет секции Up миграций goose по порядку имен файлов
+func applyMigrations(t *testing.T, pool *pgxpool.Pool) {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(migrationsDir, "*.sql"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		up, _, _ := strings.Cut(string(data), "-- +goose Down")
+		_, err = pool.Exec(context.Background(), up)
+		require.NoError(t, err, "failed to apply migration %s", file)
+	}
+}
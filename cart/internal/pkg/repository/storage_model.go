@@ -1,34 +1,67 @@
 package repository
 
 import (
+	"context"
+	"math"
 	"route256/cart/internal/pkg/apperrors"
 	"route256/cart/internal/pkg/model"
+	"sync"
 )
 
-type Storage struct {
+// shardsCount число шардов с отдельными блокировками, корзины разных пользователей
+// в разных шардах меняются параллельно
+const shardsCount = 32
+
+type shard struct {
+	mx   sync.RWMutex
 	data map[model.UserId]map[model.SKU]model.CartItem
 }
 
+// Storage хранит корзины в памяти, разбитыми по шардам по ID пользователя
+type Storage struct {
+	shards [shardsCount]*shard
+}
+
 func NewStorage() *Storage {
-	return &Storage{
-		data: make(map[model.UserId]map[model.SKU]model.CartItem),
+	s := &Storage{}
+	for i := range s.shards {
+		s.shards[i] = &shard{
+			data: make(map[model.UserId]map[model.SKU]model.CartItem),
+		}
 	}
+	return s
 }
 
-func (s *Storage) AddItem(userID model.UserId, item model.CartItem) {
-	if _, ok := s.data[userID]; !ok {
-		s.data[userID] = make(map[model.SKU]model.CartItem)
+func (s *Storage) shard(userID model.UserId) *shard {
+	return s.shards[uint64(userID)%shardsCount]
+}
+
+func (s *Storage) AddItem(_ context.Context, userID model.UserId, item model.CartItem) error {
+	sh := s.shard(userID)
+	sh.mx.Lock()
+	defer sh.mx.Unlock()
+
+	if _, ok := sh.data[userID]; !ok {
+		sh.data[userID] = make(map[model.SKU]model.CartItem)
 	}
-	if existingItem, ok := s.data[userID][item.SKU]; ok {
+	if existingItem, ok := sh.data[userID][item.SKU]; ok {
+		if uint32(existingItem.Count)+uint32(item.Count) > math.MaxUint16 {
+			return apperrors.ErrTooManyItems
+		}
 		existingItem.Count += item.Count
-		s.data[userID][item.SKU] = existingItem
+		sh.data[userID][item.SKU] = existingItem
 	} else {
-		s.data[userID][item.SKU] = item
+		sh.data[userID][item.SKU] = item
 	}
+	return nil
 }
 
-func (s *Storage) RemoveItem(userID model.UserId, sku model.SKU) error {
-	if items, ok := s.data[userID]; ok {
+func (s *Storage) RemoveItem(_ context.Context, userID model.UserId, sku model.SKU) error {
+	sh := s.shard(userID)
+	sh.mx.Lock()
+	defer sh.mx.Unlock()
+
+	if items, ok := sh.data[userID]; ok {
 		_, ok := items[sku]
 		//благодаря тестам на многопоточность здесь ошибку обнаружил, что не возвращается ошибка если sku не найден
 		if !ok {
@@ -36,16 +69,20 @@ func (s *Storage) RemoveItem(userID model.UserId, sku model.SKU) error {
 		}
 		delete(items, sku)
 		if len(items) == 0 {
-			delete(s.data, userID) // Удаляем пользователя, если его корзина пуста
+			delete(sh.data, userID) // Удаляем пользователя, если его корзина пуста
 		}
 		return nil
 	}
 	return apperrors.ErrUserNotFound
 }
 
-func (s *Storage) RemoveByUserId(userID model.UserId) error {
-	if _, ok := s.data[userID]; ok {
-		delete(s.data, userID)
+func (s *Storage) RemoveByUserId(_ context.Context, userID model.UserId) error {
+	sh := s.shard(userID)
+	sh.mx.Lock()
+	defer sh.mx.Unlock()
+
+	if _, ok := sh.data[userID]; ok {
+		delete(sh.data, userID)
 		return nil
 	}
 	return apperrors.ErrUserNotFound
@@ -54,8 +91,12 @@ func (s *Storage) RemoveByUserId(userID model.UserId) error {
 // тесты на многопоточность показали, что это косячный метод
 // мапа ссылочный тип, мы можем ее получить, но при этом в нее могут записываться данные
 // будем возращать копию данных
-func (s *Storage) GetCart(userID model.UserId) (map[model.SKU]model.CartItem, error) {
-	cart, ok := s.data[userID]
+func (s *Storage) GetCart(_ context.Context, userID model.UserId) (map[model.SKU]model.CartItem, error) {
+	sh := s.shard(userID)
+	sh.mx.RLock()
+	defer sh.mx.RUnlock()
+
+	cart, ok := sh.data[userID]
 	if !ok {
 		return nil, apperrors.ErrCartNotFound
 	}
@@ -66,3 +107,28 @@ func (s *Storage) GetCart(userID model.UserId) (map[model.SKU]model.CartItem, er
 	}
 	return copyMap, nil
 }
+
+func (s *Storage) UpdateCart(_ context.Context, userID model.UserId, update func(cart map[model.SKU]model.CartItem) error) error {
+	sh := s.shard(userID)
+	sh.mx.Lock()
+	defer sh.mx.Unlock()
+
+	cart := make(map[model.SKU]model.CartItem, len(sh.data[userID]))
+	for k, v := range sh.data[userID] {
+		cart[k] = v
+	}
+	if err := update(cart); err != nil {
+		return err
+	}
+	for sku, item := range cart {
+		if item.Count == 0 {
+			delete(cart, sku)
+		}
+	}
+	if len(cart) == 0 {
+		delete(sh.data, userID)
+		return nil
+	}
+	sh.data[userID] = cart
+	return nil
+}
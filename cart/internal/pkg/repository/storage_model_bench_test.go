@@ -1,12 +1,14 @@
 package repository
 
 import (
+	"context"
 	"testing"
 
 	"route256/cart/internal/pkg/model"
 )
 
 func BenchmarkStorage_AddItem(b *testing.B) {
+	ctx := context.Background()
 	s := NewStorage()
 
 	items := make([]model.CartItem, 100)
@@ -25,11 +27,12 @@ func BenchmarkStorage_AddItem(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		s.AddItem(uids[i%1000], items[i%100])
+		_ = s.AddItem(ctx, uids[i%1000], items[i%100])
 	}
 }
 
 func BenchmarkStorage_RemoveItem(b *testing.B) {
+	ctx := context.Background()
 	s := NewStorage()
 
 	userID := model.UserId(1)
@@ -46,12 +49,12 @@ func BenchmarkStorage_RemoveItem(b *testing.B) {
 	}
 
 	for i := 0; i < b.N; i++ {
-		s.AddItem(userID, items[i%100])
+		_ = s.AddItem(ctx, userID, items[i%100])
 	}
 
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_ = s.RemoveItem(userID, skus[i%100])
+		_ = s.RemoveItem(ctx, userID, skus[i%100])
 	}
 }
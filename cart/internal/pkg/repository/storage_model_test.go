@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"route256/cart/internal/pkg/apperrors"
 	"route256/cart/internal/pkg/model"
 	"testing"
@@ -17,62 +19,92 @@ var (
 )
 
 func TestStorage_AddItem_Native(t *testing.T) {
+	ctx := context.Background()
 	storage := NewStorage()
 
-	storage.AddItem(defaultItem.UserId, defaultItem)
+	storage.AddItem(ctx, defaultItem.UserId, defaultItem)
 
-	assert.Equal(t, 1, len(storage.data))
-	assert.Equal(t, 1, len(storage.data[defaultItem.UserId]))
-	assert.Equal(t, defaultItem, storage.data[defaultItem.UserId][defaultItem.SKU])
+	assert.Equal(t, 1, len(storage.shard(defaultItem.UserId).data))
+	assert.Equal(t, 1, len(storage.shard(defaultItem.UserId).data[defaultItem.UserId]))
+	assert.Equal(t, defaultItem, storage.shard(defaultItem.UserId).data[defaultItem.UserId][defaultItem.SKU])
 
-	storage.AddItem(defaultItem.UserId, model.CartItem{UserId: 1, SKU: 101, Count: 3})
+	storage.AddItem(ctx, defaultItem.UserId, model.CartItem{UserId: 1, SKU: 101, Count: 3})
 
-	assert.Equal(t, 1, len(storage.data))
-	assert.Equal(t, 1, len(storage.data[defaultItem.UserId]))
-	assert.Equal(t, uint16(5), storage.data[defaultItem.UserId][defaultItem.SKU].Count)
+	assert.Equal(t, 1, len(storage.shard(defaultItem.UserId).data))
+	assert.Equal(t, 1, len(storage.shard(defaultItem.UserId).data[defaultItem.UserId]))
+	assert.Equal(t, uint16(5), storage.shard(defaultItem.UserId).data[defaultItem.UserId][defaultItem.SKU].Count)
 }
 
 func TestStorage_RemoveItem_Native(t *testing.T) {
+	ctx := context.Background()
 	storage := NewStorage()
 
-	storage.AddItem(defaultItem.UserId, defaultItem)
+	storage.AddItem(ctx, defaultItem.UserId, defaultItem)
 
 	// Удаляем товар
-	err := storage.RemoveItem(defaultItem.UserId, defaultItem.SKU)
+	err := storage.RemoveItem(ctx, defaultItem.UserId, defaultItem.SKU)
 	assert.NoError(t, err)
 
 	// Проверяем внутреннее состояние
-	assert.Equal(t, 0, len(storage.data[defaultItem.UserId]))
+	assert.Equal(t, 0, len(storage.shard(defaultItem.UserId).data[defaultItem.UserId]))
 }
 
 func TestStorage_RemoveByUserId_Native(t *testing.T) {
+	ctx := context.Background()
 	storage := NewStorage()
 
 	// Добавляем товар
-	storage.AddItem(defaultItem.UserId, defaultItem)
+	storage.AddItem(ctx, defaultItem.UserId, defaultItem)
 
 	// Удаляем корзину пользователя
-	err := storage.RemoveByUserId(defaultItem.UserId)
+	err := storage.RemoveByUserId(ctx, defaultItem.UserId)
 	assert.NoError(t, err)
 
 	// Проверяем, что пользователь удален
-	assert.Equal(t, 0, len(storage.data))
+	assert.Equal(t, 0, len(storage.shard(defaultItem.UserId).data))
 }
 
 func TestStorage_GetCart_Native(t *testing.T) {
+	ctx := context.Background()
 	storage := NewStorage()
 
 	// Проверяем получение корзины для несуществующего пользователя
-	_, err := storage.GetCart(999)
+	_, err := storage.GetCart(ctx, 999)
 	assert.Error(t, err)
 	assert.Equal(t, apperrors.ErrCartNotFound, err)
 
 	// Добавляем товар
-	storage.AddItem(defaultItem.UserId, defaultItem)
+	storage.AddItem(ctx, defaultItem.UserId, defaultItem)
 
 	// Проверяем получение корзины для существующего пользователя
-	cart, err := storage.GetCart(defaultItem.UserId)
+	cart, err := storage.GetCart(ctx, defaultItem.UserId)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(cart))
 	assert.Equal(t, defaultItem, cart[defaultItem.SKU])
 }
+
+func TestStorage_UpdateCart_Native(t *testing.T) {
+	ctx := context.Background()
+	storage := NewStorage()
+
+	storage.AddItem(ctx, defaultItem.UserId, defaultItem)
+
+	// Ошибка update не меняет корзину
+	err := storage.UpdateCart(ctx, defaultItem.UserId, func(cart map[model.SKU]model.CartItem) error {
+		delete(cart, defaultItem.SKU)
+		return errors.New("rejected")
+	})
+	assert.Error(t, err)
+	cart, err := storage.GetCart(ctx, defaultItem.UserId)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultItem, cart[defaultItem.SKU])
+
+	// Нулевое количество удаляет товар, пустая корзина удаляется
+	err = storage.UpdateCart(ctx, defaultItem.UserId, func(cart map[model.SKU]model.CartItem) error {
+		cart[defaultItem.SKU] = model.CartItem{SKU: defaultItem.SKU, UserId: defaultItem.UserId}
+		return nil
+	})
+	assert.NoError(t, err)
+	_, err = storage.GetCart(ctx, defaultItem.UserId)
+	assert.Equal(t, apperrors.ErrCartNotFound, err)
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
 	"route256/cart/internal/infra/errgroup"
+	"route256/cart/internal/pkg/apperrors"
 	"route256/cart/internal/pkg/model"
+	"slices"
 	"sync"
 )
 
@@ -14,6 +17,7 @@ type CartRepository interface {
 	RemoveItem(_ context.Context, userId model.UserId, sku model.SKU) error
 	RemoveByUserId(_ context.Context, userId model.UserId) error
 	GetCartByUserId(_ context.Context, userId model.UserId) (map[model.SKU]model.CartItem, error)
+	UpdateCart(ctx context.Context, userId model.UserId, update func(cart map[model.SKU]model.CartItem) error) error
 }
 
 type ProductService interface {
@@ -24,8 +28,9 @@ type ProductService interface {
 }
 
 type LomsService interface {
-	CreateOrder(ctx context.Context, userId model.UserId, cart map[model.SKU]model.CartItem) (int64, error)
+	CreateOrder(ctx context.Context, userId model.UserId, cart map[model.SKU]model.CartItem, idempotencyKey string) (int64, error)
 	GetStockInfo(ctx context.Context, sku model.SKU) (availableCountStock uint64, err error)
+	GetUserOrders(ctx context.Context, userId model.UserId, pageSize int32, pageToken string) (*UserOrders, error)
 }
 
 type CartService struct {
@@ -73,6 +78,110 @@ func (s *CartService) AddCartItem(ctx context.Context, cartItem model.CartItem)
 	return item, nil
 }
 
+// SetCartItem устанавливает количество товара в корзине, нулевое количество удаляет товар
+func (s *CartService) SetCartItem(ctx context.Context, cartItem model.CartItem) error {
+	return s.UpdateCart(ctx, cartItem.UserId, []model.CartOperation{{
+		Type:  model.CartOperationSet,
+		SKU:   cartItem.SKU,
+		Count: cartItem.Count,
+	}})
+}
+
+// UpdateCart применяет операции к корзине по порядку: либо все, либо ни одной.
+// Наличие на складе проверяется для итогового количества каждого добавленного или измененного товара
+func (s *CartService) UpdateCart(ctx context.Context, userId model.UserId, operations []model.CartOperation) error {
+	if errUserId := checkFieldMustPositive(int64(userId), "user_id"); errUserId != nil {
+		log.Printf("[cartService] Failed to update cart: UserID validation failed for UserID %d", userId)
+		return errUserId
+	}
+	if len(operations) == 0 {
+		return fmt.Errorf("operations must not be empty")
+	}
+	var skus []model.SKU
+	for i, operation := range operations {
+		if err := operation.Validate(); err != nil {
+			return fmt.Errorf("errors during cartservice validate operation %d: %w", i, err)
+		}
+		if operation.Type != model.CartOperationRemove && operation.Count > 0 && !slices.Contains(skus, operation.SKU) {
+			skus = append(skus, operation.SKU)
+		}
+	}
+
+	availableCounts, err := s.getAvailableCounts(ctx, skus)
+	if err != nil {
+		log.Printf("[cartService] Failed to update cart for user %d: %v", userId, err)
+		return err
+	}
+
+	err = s.repository.UpdateCart(ctx, userId, func(cart map[model.SKU]model.CartItem) error {
+		for _, operation := range operations {
+			if err := applyCartOperation(cart, userId, operation); err != nil {
+				return err
+			}
+		}
+		for sku, availableCount := range availableCounts {
+			if uint64(cart[sku].Count) > availableCount {
+				return fmt.Errorf("not enough items in stock for SKU %d", sku)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[cartService] Failed to update cart for user %d: %v", userId, err)
+		return err
+	}
+
+	log.Printf("[cartService] Cart for user %d successfully updated with %d operations", userId, len(operations))
+
+	return nil
+}
+
+// getAvailableCounts проверяет, что товары существуют, и возвращает их доступное количество на складе
+func (s *CartService) getAvailableCounts(ctx context.Context, skus []model.SKU) (map[model.SKU]uint64, error) {
+	availableCounts := make(map[model.SKU]uint64, len(skus))
+	errGroup, cancelCtx := errgroup.NewErrGroup(ctx)
+	var mx sync.Mutex
+	for _, sku := range skus {
+		sku := sku
+		errGroup.Go(func() error {
+			if _, err := s.productService.GetProductInfo(cancelCtx, sku); err != nil {
+				log.Printf("[cartService] Product info for SKU %d not found", sku)
+				return err
+			}
+			availableCount, err := s.lomsService.GetStockInfo(cancelCtx, sku)
+			if err != nil {
+				log.Printf("[cartService] Failed get info from stock for SKU %d", sku)
+				return err
+			}
+			mx.Lock()
+			defer mx.Unlock()
+			availableCounts[sku] = availableCount
+			return nil
+		})
+	}
+
+	if err := errGroup.Wait(); err != nil {
+		return nil, err
+	}
+	return availableCounts, nil
+}
+
+func applyCartOperation(cart map[model.SKU]model.CartItem, userId model.UserId, operation model.CartOperation) error {
+	switch operation.Type {
+	case model.CartOperationAdd:
+		count := uint32(cart[operation.SKU].Count) + uint32(operation.Count)
+		if count > math.MaxUint16 {
+			return fmt.Errorf("%w: SKU %d", apperrors.ErrTooManyItems, operation.SKU)
+		}
+		cart[operation.SKU] = model.CartItem{SKU: operation.SKU, UserId: userId, Count: uint16(count)}
+	case model.CartOperationSet:
+		cart[operation.SKU] = model.CartItem{SKU: operation.SKU, UserId: userId, Count: operation.Count}
+	case model.CartOperationRemove:
+		delete(cart, operation.SKU)
+	}
+	return nil
+}
+
 func (s *CartService) DeleteCartItem(ctx context.Context, userId model.UserId, sku model.SKU) error {
 	// Валидация SKU и UserID
 	if errSku := checkFieldMustPositive(int64(sku), "sku"); errSku != nil {
@@ -156,7 +265,9 @@ func (s *CartService) GetCartItem(ctx context.Context, userId model.UserId) (*Ca
 	return &cartContent, nil
 }
 
-func (s *CartService) Checkout(ctx context.Context, userId model.UserId) (orderId int64, err error) {
+// Checkout оформляет заказ из корзины пользователя. idempotencyKey пробрасывается в LOMS, чтобы повтор
+// запроса после таймаута не создал второй заказ, если корзина не успела очиститься
+func (s *CartService) Checkout(ctx context.Context, userId model.UserId, idempotencyKey string) (orderId int64, err error) {
 	if errUserId := checkFieldMustPositive(int64(userId), "user_id"); errUserId != nil {
 		log.Printf("[cartService] Failed to retrieve cart: validation failed: for UserID %d", userId)
 		return 0, errUserId
@@ -167,7 +278,7 @@ func (s *CartService) Checkout(ctx context.Context, userId model.UserId) (orderI
 		return 0, err
 	}
 	log.Printf("[cartService] Retrieving cart successed %+v", userCart)
-	orderId, err = s.lomsService.CreateOrder(ctx, userId, userCart)
+	orderId, err = s.lomsService.CreateOrder(ctx, userId, userCart, idempotencyKey)
 	if err != nil {
 		log.Printf("[cartService] Failed to create order for user %d: %v", userId, err)
 		return 0, err
@@ -181,6 +292,20 @@ func (s *CartService) Checkout(ctx context.Context, userId model.UserId) (orderI
 	return orderId, nil
 }
 
+// GetUserOrders возвращает страницу истории заказов пользователя из LOMS
+func (s *CartService) GetUserOrders(ctx context.Context, userId model.UserId, pageSize int32, pageToken string) (*UserOrders, error) {
+	if errUserId := checkFieldMustPositive(int64(userId), "user_id"); errUserId != nil {
+		log.Printf("[cartService] Failed to retrieve orders: validation failed: for UserID %d", userId)
+		return nil, errUserId
+	}
+	orders, err := s.lomsService.GetUserOrders(ctx, userId, pageSize, pageToken)
+	if err != nil {
+		log.Printf("[cartService] Failed to retrieve orders for user %d: %v", userId, err)
+		return nil, err
+	}
+	return orders, nil
+}
+
 func checkFieldMustPositive(value int64, fieldName string) error {
 	if value < 1 {
 		return fmt.Errorf("field " + fieldName + " must be positive")
@@ -8,6 +8,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/goleak"
+	"math"
+	"route256/cart/internal/pkg/apperrors"
 	"route256/cart/internal/pkg/model"
 	"testing"
 )
@@ -620,3 +622,157 @@ func TestCartService_GetCartItem(t *testing.T) {
 		})
 	}
 }
+
+func TestCartService_GetUserOrders(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	t.Run("success - proxy to loms", func(t *testing.T) {
+		userOrders := &UserOrders{
+			Orders:        []OrderInfo{{OrderId: 1, Status: "PAYED", Items: []OrderItem{{SKU: 123, Count: 2}}}},
+			NextPageToken: "next",
+		}
+		lomsServiceMock := NewLomsServiceMock(mc)
+		lomsServiceMock.GetUserOrdersMock.Expect(ctx, model.UserId(1), int32(10), "token").Return(userOrders, nil)
+		service := NewService(NewCartRepositoryMock(mc), NewProductServiceMock(mc), lomsServiceMock)
+
+		got, err := service.GetUserOrders(ctx, 1, 10, "token")
+		assert.NoError(t, err)
+		assert.Equal(t, userOrders, got)
+	})
+
+	t.Run("error - invalid UserID", func(t *testing.T) {
+		lomsServiceMock := NewLomsServiceMock(mc)
+		service := NewService(NewCartRepositoryMock(mc), NewProductServiceMock(mc), lomsServiceMock)
+
+		_, err := service.GetUserOrders(ctx, 0, 10, "")
+		assert.Error(t, err)
+		assert.Equal(t, 0, len(lomsServiceMock.GetUserOrdersMock.Calls()))
+	})
+
+	t.Run("error - loms failure", func(t *testing.T) {
+		lomsServiceMock := NewLomsServiceMock(mc)
+		lomsServiceMock.GetUserOrdersMock.Return(nil, fmt.Errorf("loms error"))
+		service := NewService(NewCartRepositoryMock(mc), NewProductServiceMock(mc), lomsServiceMock)
+
+		_, err := service.GetUserOrders(ctx, 1, 0, "")
+		assert.Error(t, err)
+	})
+}
+
+func TestCartService_UpdateCart(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+	product := &model.Product{Name: "TestProduct", Price: 100}
+
+	// newRepoMock применяет update к копии cart и сохраняет результат в saved
+	newRepoMock := func(cart map[model.SKU]model.CartItem, saved *map[model.SKU]model.CartItem) *CartRepositoryMock {
+		repoMock := NewCartRepositoryMock(mc)
+		repoMock.UpdateCartMock.Set(func(_ context.Context, _ model.UserId, update func(cart map[model.SKU]model.CartItem) error) error {
+			updated := make(map[model.SKU]model.CartItem, len(cart))
+			for sku, item := range cart {
+				updated[sku] = item
+			}
+			if err := update(updated); err != nil {
+				return err
+			}
+			*saved = updated
+			return nil
+		})
+		return repoMock
+	}
+
+	t.Run("success - apply operations", func(t *testing.T) {
+		var saved map[model.SKU]model.CartItem
+		repoMock := newRepoMock(map[model.SKU]model.CartItem{
+			101: {SKU: 101, UserId: 1, Count: 5},
+			102: {SKU: 102, UserId: 1, Count: 1},
+		}, &saved)
+		productServiceMock := NewProductServiceMock(mc)
+		productServiceMock.GetProductInfoMock.Return(product, nil)
+		lomsServiceMock := NewLomsServiceMock(mc)
+		lomsServiceMock.GetStockInfoMock.Return(10, nil)
+		service := NewService(repoMock, productServiceMock, lomsServiceMock)
+
+		err := service.UpdateCart(ctx, 1, []model.CartOperation{
+			{Type: model.CartOperationAdd, SKU: 101, Count: 3},
+			{Type: model.CartOperationRemove, SKU: 102},
+			{Type: model.CartOperationSet, SKU: 103, Count: 2},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, map[model.SKU]model.CartItem{
+			101: {SKU: 101, UserId: 1, Count: 8},
+			103: {SKU: 103, UserId: 1, Count: 2},
+		}, saved)
+		assert.Equal(t, 2, len(lomsServiceMock.GetStockInfoMock.Calls()))
+	})
+
+	t.Run("error - final count exceeds stock", func(t *testing.T) {
+		var saved map[model.SKU]model.CartItem
+		repoMock := newRepoMock(map[model.SKU]model.CartItem{
+			101: {SKU: 101, UserId: 1, Count: 8},
+		}, &saved)
+		productServiceMock := NewProductServiceMock(mc)
+		productServiceMock.GetProductInfoMock.Return(product, nil)
+		lomsServiceMock := NewLomsServiceMock(mc)
+		lomsServiceMock.GetStockInfoMock.Return(10, nil)
+		service := NewService(repoMock, productServiceMock, lomsServiceMock)
+
+		err := service.UpdateCart(ctx, 1, []model.CartOperation{
+			{Type: model.CartOperationSet, SKU: 102, Count: 1},
+			{Type: model.CartOperationAdd, SKU: 101, Count: 3},
+		})
+		assert.Error(t, err)
+		assert.Nil(t, saved)
+	})
+
+	t.Run("error - count exceeds uint16", func(t *testing.T) {
+		var saved map[model.SKU]model.CartItem
+		repoMock := newRepoMock(map[model.SKU]model.CartItem{
+			101: {SKU: 101, UserId: 1, Count: math.MaxUint16 - 1},
+		}, &saved)
+		productServiceMock := NewProductServiceMock(mc)
+		productServiceMock.GetProductInfoMock.Return(product, nil)
+		lomsServiceMock := NewLomsServiceMock(mc)
+		lomsServiceMock.GetStockInfoMock.Return(math.MaxUint32, nil)
+		service := NewService(repoMock, productServiceMock, lomsServiceMock)
+
+		err := service.UpdateCart(ctx, 1, []model.CartOperation{{Type: model.CartOperationAdd, SKU: 101, Count: 2}})
+		assert.ErrorIs(t, err, apperrors.ErrTooManyItems)
+		assert.Nil(t, saved)
+	})
+
+	t.Run("success - set zero removes item without stock check", func(t *testing.T) {
+		var saved map[model.SKU]model.CartItem
+		repoMock := newRepoMock(map[model.SKU]model.CartItem{
+			101: {SKU: 101, UserId: 1, Count: 8},
+		}, &saved)
+		service := NewService(repoMock, NewProductServiceMock(mc), NewLomsServiceMock(mc))
+
+		err := service.SetCartItem(ctx, model.CartItem{SKU: 101, UserId: 1, Count: 0})
+		require.NoError(t, err)
+		assert.Equal(t, uint16(0), saved[101].Count)
+	})
+
+	t.Run("error - invalid operation", func(t *testing.T) {
+		repoMock := NewCartRepositoryMock(mc)
+		service := NewService(repoMock, NewProductServiceMock(mc), NewLomsServiceMock(mc))
+
+		err := service.UpdateCart(ctx, 1, []model.CartOperation{{Type: "multiply", SKU: 101, Count: 2}})
+		assert.Error(t, err)
+		assert.Equal(t, 0, len(repoMock.UpdateCartMock.Calls()))
+	})
+
+	t.Run("error - product not found", func(t *testing.T) {
+		repoMock := NewCartRepositoryMock(mc)
+		productServiceMock := NewProductServiceMock(mc)
+		productServiceMock.GetProductInfoMock.Return(nil, fmt.Errorf("product not found"))
+		service := NewService(repoMock, productServiceMock, NewLomsServiceMock(mc))
+
+		err := service.UpdateCart(ctx, 1, []model.CartOperation{{Type: model.CartOperationAdd, SKU: 101, Count: 2}})
+		assert.Error(t, err)
+		assert.Equal(t, 0, len(repoMock.UpdateCartMock.Calls()))
+	})
+}
@@ -14,6 +14,23 @@ type CartContent struct {
 	TotalPrice uint32             `json:"total_price"`
 }
 
+type OrderItem struct {
+	SKU   int64  `json:"sku_id"`
+	Count uint32 `json:"count"`
+}
+
+type OrderInfo struct {
+	OrderId int64       `json:"order_id"`
+	Status  string      `json:"status"`
+	Items   []OrderItem `json:"items"`
+}
+
+// UserOrders страница истории заказов пользователя, NextPageToken пустой на последней странице
+type UserOrders struct {
+	Orders        []OrderInfo `json:"orders"`
+	NextPageToken string      `json:"next_page_token,omitempty"`
+}
+
 func createEnrichedCartItemDTO(cartItem model.CartItem, product model.Product) EnrichedCartItem {
 	return EnrichedCartItem{
 		SKU:   int64(cartItem.SKU),
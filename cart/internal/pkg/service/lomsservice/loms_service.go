@@ -38,13 +38,16 @@ func NewLomsService(client loms.LomsClient) *LomsService {
 	return &LomsService{client: client}
 }
 
-func (s *LomsService) CreateOrder(ctx context.Context, userId model.UserId, cart map[model.SKU]model.CartItem) (orderId int64, err error) {
+func (s *LomsService) CreateOrder(ctx context.Context, userId model.UserId, cart map[model.SKU]model.CartItem, idempotencyKey string) (orderId int64, err error) {
 	orderInRq, err := NewOrderRequest(userId, cart)
 	if err != nil {
 		log.Printf("[orderservice] Error creating order request: %v", err)
 		return 0, err
 	}
-	orderIdRs, err := s.client.OrderCreate(ctx, &loms.OrderCreateRequest{Order: orderInRq})
+	orderIdRs, err := s.client.OrderCreate(ctx, &loms.OrderCreateRequest{
+		Order:          orderInRq,
+		IdempotencyKey: idempotencyKey,
+	})
 	if err != nil {
 		log.Printf("[orderservice] Error creating order: %v", err)
 		return 0, err
@@ -67,3 +70,38 @@ func (s *LomsService) GetStockInfo(ctx context.Context, sku model.SKU) (availabl
 	return availableCount.GetCount(), nil
 
 }
+
+func (s *LomsService) GetUserOrders(ctx context.Context, userId model.UserId, pageSize int32, pageToken string) (*cartservice.UserOrders, error) {
+	ordersRs, err := s.client.UserOrders(ctx, &loms.UserOrdersRequest{
+		UserId:    int64(userId),
+		PageSize:  pageSize,
+		PageToken: pageToken,
+	})
+	if err != nil {
+		log.Printf("[orderservice] Error getting orders of user %d: %v", userId, err)
+		return nil, err
+	}
+	return convertUserOrdersResponse(ordersRs), nil
+}
+
+func convertUserOrdersResponse(ordersRs *loms.UserOrdersResponse) *cartservice.UserOrders {
+	userOrders := &cartservice.UserOrders{
+		Orders:        make([]cartservice.OrderInfo, 0, len(ordersRs.GetOrders())),
+		NextPageToken: ordersRs.GetNextPageToken(),
+	}
+	for _, order := range ordersRs.GetOrders() {
+		orderInfo := cartservice.OrderInfo{
+			OrderId: order.GetId(),
+			Status:  order.GetStatus(),
+			Items:   make([]cartservice.OrderItem, 0, len(order.GetItems())),
+		}
+		for _, item := range order.GetItems() {
+			orderInfo.Items = append(orderInfo.Items, cartservice.OrderItem{
+				SKU:   int64(item.GetSku()),
+				Count: item.GetCount(),
+			})
+		}
+		userOrders.Orders = append(userOrders.Orders, orderInfo)
+	}
+	return userOrders
+}
@@ -14,6 +14,7 @@ import (
 	"google.golang.org/grpc/status"
 
 	"route256/cart/internal/pkg/model"
+	"route256/cart/internal/pkg/service/cartservice"
 )
 
 type LomsServiceSuite struct {
@@ -48,6 +49,7 @@ func (suite *LomsServiceSuite) TestCreateOrder_Success() {
 				{Sku: 1002, Count: 3},
 			},
 		},
+		IdempotencyKey: "checkout-123",
 	}
 
 	orderResponse := &loms.OrderCreateResponse{
@@ -60,10 +62,11 @@ func (suite *LomsServiceSuite) TestCreateOrder_Success() {
 			opts ...grpc.CallOption) (op1 *loms.OrderCreateResponse, err error) {
 			assert.ElementsMatch(suite.T(), orderRequest.Order.Items, in.Order.Items)
 			assert.Equal(suite.T(), orderRequest.Order.User, in.Order.User)
+			assert.Equal(suite.T(), orderRequest.IdempotencyKey, in.IdempotencyKey)
 			return orderResponse, nil
 		})
 
-	orderId, err := suite.lomsSvc.CreateOrder(context.Background(), userId, cart)
+	orderId, err := suite.lomsSvc.CreateOrder(context.Background(), userId, cart, "checkout-123")
 
 	assert.NoError(suite.T(), err)
 	assert.Equal(suite.T(), int64(1), orderId)
@@ -95,7 +98,7 @@ func (suite *LomsServiceSuite) TestCreateOrder_Error() {
 			return nil, createErr
 		})
 
-	orderId, err := suite.lomsSvc.CreateOrder(context.Background(), userId, cart)
+	orderId, err := suite.lomsSvc.CreateOrder(context.Background(), userId, cart, "")
 
 	assert.Error(suite.T(), err)
 	assert.Equal(suite.T(), int64(0), orderId)
@@ -163,3 +166,37 @@ func (suite *LomsServiceSuite) TestGetStockInfo_Error() {
 	assert.Equal(suite.T(), uint64(0), availableCount)
 	assert.Equal(suite.T(), internalErr, err)
 }
+
+func (suite *LomsServiceSuite) TestGetUserOrders_Success() {
+	userId := model.UserId(123)
+
+	suite.mockLomsClient.UserOrdersMock.
+		Expect(context.Background(), &loms.UserOrdersRequest{UserId: 123, PageSize: 10, PageToken: "token"}).
+		Return(&loms.UserOrdersResponse{
+			Orders: []*loms.Order{
+				{Id: 2, User: 123, Status: "PAYED", Items: []*loms.Item{{Sku: 1001, Count: 2}}},
+			},
+			NextPageToken: "next",
+		}, nil)
+
+	userOrders, err := suite.lomsSvc.GetUserOrders(context.Background(), userId, 10, "token")
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), &cartservice.UserOrders{
+		Orders: []cartservice.OrderInfo{
+			{OrderId: 2, Status: "PAYED", Items: []cartservice.OrderItem{{SKU: 1001, Count: 2}}},
+		},
+		NextPageToken: "next",
+	}, userOrders)
+}
+
+func (suite *LomsServiceSuite) TestGetUserOrders_Error() {
+	internalErr := errors.New("internal server error")
+
+	suite.mockLomsClient.UserOrdersMock.Return(nil, internalErr)
+
+	userOrders, err := suite.lomsSvc.GetUserOrders(context.Background(), model.UserId(123), 0, "")
+
+	assert.Nil(suite.T(), userOrders)
+	assert.Equal(suite.T(), internalErr, err)
+}
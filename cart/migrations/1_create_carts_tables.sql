@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE carts
+(
+  user_id    BIGINT PRIMARY KEY,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX carts_updated_at_idx ON carts (updated_at);
+
+CREATE TABLE cart_items
+(
+  user_id BIGINT  NOT NULL REFERENCES carts (user_id) ON DELETE CASCADE,
+  sku     BIGINT  NOT NULL,
+  count   INTEGER NOT NULL CHECK (count > 0),
+  PRIMARY KEY (user_id, sku)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS cart_items;
+DROP TABLE IF EXISTS carts;
+-- +goose StatementEnd
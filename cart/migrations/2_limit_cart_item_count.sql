@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- количество товара в корзине хранится в uint16
+ALTER TABLE cart_items
+  ADD CONSTRAINT cart_items_count_max CHECK (count <= 65535);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE cart_items
+  DROP CONSTRAINT IF EXISTS cart_items_count_max;
+-- +goose StatementEnd
@@ -0,0 +1,3 @@
+
+$d1aeda3a-bedb-43be-8303-3d50f0791d2f
+order.paid R*PAYED"A"F
\ No newline at end of file
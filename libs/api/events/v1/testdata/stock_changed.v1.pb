@@ -0,0 +1,3 @@
+
+$cb3c6fa7-5b3b-4e9f-a6a1-8e33a49c1a37stock.changed ZA
+ *RESERVE
\ No newline at end of file
@@ -0,0 +1,3 @@
+module route256/libs
+
+go 1.22
@@ -0,0 +1,160 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// DefaultTimeout время, за которое должна ответить каждая проверка
+const DefaultTimeout = 2 * time.Second
+
+// Check проверяет зависимость сервиса и возвращает ошибку, если она недоступна
+type Check func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   Check
+	// optional сбой проверки означает деградацию, а не неготовность сервиса
+	optional bool
+}
+
+// Checker собирает проверки зависимостей сервиса для /readyz и grpc.health.v1
+type Checker struct {
+	checks  []check
+	timeout time.Duration
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add добавляет проверку зависимости, без которой сервис не готов обслуживать запросы
+func (c *Checker) Add(name string, fn Check) {
+	c.checks = append(c.checks, check{name: name, fn: fn})
+}
+
+// AddOptional добавляет проверку зависимости, без которой сервис работает в деградированном режиме.
+// Ее сбой виден в ответе /readyz, но готовность не снимает
+func (c *Checker) AddOptional(name string, fn Check) {
+	c.checks = append(c.checks, check{name: name, fn: fn, optional: true})
+}
+
+// Result результат проверок: статус каждой зависимости, "ok" или текст ошибки
+type Result struct {
+	Ready  bool              `json:"-"`
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Run выполняет все проверки параллельно, каждую не дольше timeout
+func (c *Checker) Run(ctx context.Context) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	errs := make([]error, len(c.checks))
+	var wg sync.WaitGroup
+	for i, ch := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = ch.fn(ctx)
+		}()
+	}
+	wg.Wait()
+
+	result := Result{Ready: true, Status: "ok", Checks: make(map[string]string, len(c.checks))}
+	for i, ch := range c.checks {
+		if errs[i] == nil {
+			result.Checks[ch.name] = "ok"
+			continue
+		}
+		result.Checks[ch.name] = errs[i].Error()
+		if !ch.optional {
+			result.Ready = false
+			result.Status = "unavailable"
+		}
+	}
+	return result
+}
+
+// LiveHandler обработчик /healthz: процесс жив и обслуживает HTTP, зависимости не проверяются
+func LiveHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyHandler обработчик /readyz: 200, если все обязательные зависимости доступны, иначе 503
+func (c *Checker) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	result := c.Run(r.Context())
+	statusCode := http.StatusOK
+	if !result.Ready {
+		statusCode = http.StatusServiceUnavailable
+	}
+	writeJSON(w, statusCode, result)
+}
+
+// WatchGRPC раз в interval обновляет статус grpc.health.v1 для всего сервера и services по результату проверок.
+// При остановке все сервисы переводятся в NOT_SERVING
+func (c *Checker) WatchGRPC(ctx context.Context, server *health.Server, interval time.Duration, services ...string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ready := false
+	for {
+		result := c.Run(ctx)
+		if ctx.Err() != nil {
+			server.Shutdown()
+			return
+		}
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+		if result.Ready {
+			status = healthpb.HealthCheckResponse_SERVING
+		}
+		if result.Ready != ready {
+			log.Printf("[health] Serving status changed to %v: %v", status, result.Checks)
+			ready = result.Ready
+		}
+		server.SetServingStatus("", status)
+		for _, service := range services {
+			server.SetServingStatus(service, status)
+		}
+
+		select {
+		case <-ctx.Done():
+			server.Shutdown()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("[health] Response writing failed: %v", err)
+	}
+}
+
+// GRPCCheck проверяет сервис за соединением conn через его grpc.health.v1
+func GRPCCheck(conn grpc.ClientConnInterface, service string) Check {
+	client := healthpb.NewHealthClient(conn)
+	return func(ctx context.Context) error {
+		response, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return err
+		}
+		if response.Status != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("status %v", response.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,107 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"route256/libs/health"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	grpcHealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func ok(context.Context) error { return nil }
+
+func TestChecker_ReadyHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		master         health.Check
+		replica        health.Check
+		wantStatusCode int
+		wantChecks     map[string]string
+	}{
+		{
+			name:           "all dependencies are up",
+			master:         ok,
+			replica:        ok,
+			wantStatusCode: http.StatusOK,
+			wantChecks:     map[string]string{"master": "ok", "replica": "ok"},
+		},
+		{
+			name:           "replica is down",
+			master:         ok,
+			replica:        func(context.Context) error { return errors.New("connection refused") },
+			wantStatusCode: http.StatusOK,
+			wantChecks:     map[string]string{"master": "ok", "replica": "connection refused"},
+		},
+		{
+			name:           "master is down",
+			master:         func(context.Context) error { return errors.New("connection refused") },
+			replica:        ok,
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantChecks:     map[string]string{"master": "connection refused", "replica": "ok"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := health.NewChecker(time.Second)
+			checker.Add("master", tt.master)
+			checker.AddOptional("replica", tt.replica)
+
+			recorder := httptest.NewRecorder()
+			checker.ReadyHandler(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			assert.Equal(t, tt.wantStatusCode, recorder.Code)
+			var body health.Result
+			require.NoError(t, json.NewDecoder(recorder.Body).Decode(&body))
+			assert.Equal(t, tt.wantChecks, body.Checks)
+		})
+	}
+}
+
+func TestChecker_RunTimesOutSlowCheck(t *testing.T) {
+	checker := health.NewChecker(10 * time.Millisecond)
+	checker.Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	result := checker.Run(context.Background())
+	assert.False(t, result.Ready)
+	assert.Equal(t, context.DeadlineExceeded.Error(), result.Checks["slow"])
+}
+
+func TestChecker_WatchGRPC(t *testing.T) {
+	checker := health.NewChecker(time.Second)
+	checker.Add("master", func(context.Context) error { return errors.New("connection refused") })
+	server := grpcHealth.NewServer()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		checker.WatchGRPC(ctx, server, time.Hour, "Loms")
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		response, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "Loms"})
+		return err == nil && response.Status == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+func TestLiveHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	health.LiveHandler(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, recorder.Body.String())
+}
@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// BrokersProbe проверяет доступность брокеров Kafka для readiness.
+// Клиент создается при первой проверке и переиспользуется, поэтому сервис стартует и при недоступной Kafka
+type BrokersProbe struct {
+	brokers []string
+	mu      sync.Mutex
+	client  sarama.Client
+}
+
+func NewBrokersProbe(brokers []string) *BrokersProbe {
+	return &BrokersProbe{brokers: brokers}
+}
+
+// Check запрашивает метаданные кластера
+func (p *BrokersProbe) Check(ctx context.Context) error {
+	client, err := p.getClient()
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- client.RefreshMetadata()
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-done:
+		if err != nil {
+			return fmt.Errorf("kafka metadata: %w", err)
+		}
+		return nil
+	}
+}
+
+func (p *BrokersProbe) getClient() (sarama.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client != nil && !p.client.Closed() {
+		return p.client, nil
+	}
+	config := sarama.NewConfig()
+	config.Net.DialTimeout = time.Second
+	config.Metadata.Retry.Max = 0
+	client, err := sarama.NewClient(p.brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("kafka brokers %v: %w", p.brokers, err)
+	}
+	p.client = client
+	return client, nil
+}
+
+// Close закрывает клиента проверок
+func (p *BrokersProbe) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == nil {
+		return nil
+	}
+	return p.client.Close()
+}
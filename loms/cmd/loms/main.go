@@ -19,6 +19,22 @@ func main() {
 		log.Fatalf("[main] Failed to load configuration: %v", err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "reshard" {
+		if err = app.RunReshard(config, os.Args[2:]); err != nil {
+			log.Fatalf("[main] Resharding failed: %v", err)
+		}
+		log.Println("[main] Resharding completed")
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "restock" {
+		if err = app.RunRestock(config, os.Args[2:]); err != nil {
+			log.Fatalf("[main] Restock failed: %v", err)
+		}
+		log.Println("[main] Restock completed")
+		return
+	}
+
 	application, err := app.MustNew(config)
 	if err != nil {
 		log.Fatalf("[main] Failed to initialize application: %v", err)
@@ -2,17 +2,41 @@ package grpccontroller
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	appErr "route256/loms/internal/errors"
 	lomsGrpc "route256/loms/internal/generated/api/loms/v1"
+	"route256/loms/internal/infra/database"
 	"route256/loms/internal/model"
+	"strconv"
+	"unicode/utf8"
 )
 
+// IdempotencyKeyMetadata ключ метаданных, в который grpc-gateway кладет HTTP заголовок Idempotency-Key
+const IdempotencyKeyMetadata = "idempotency-key"
+
+// MaxIdempotencyKeyLen максимальная длина ключа идемпотентности, как max_len поля idempotency_key в proto
+const MaxIdempotencyKeyLen = 128
+
 func (o *LomsController) OrderCreate(ctx context.Context, createRq *lomsGrpc.OrderCreateRequest) (*lomsGrpc.OrderCreateResponse, error) {
-	orderId, err := o.orderService.Create(ctx, convertCreateRequestToOrder(createRq))
+	idempotencyKey, err := extractIdempotencyKey(ctx, createRq)
+	if err != nil {
+		return nil, mapErrorToGRPC(err)
+	}
+	ctx, commits := database.TrackCommits(ctx)
+	orderId, err := o.orderService.Create(ctx, convertCreateRequestToOrder(createRq), idempotencyKey)
 	if err != nil {
 		return nil, mapErrorToGRPC(err)
 	}
-	return &lomsGrpc.OrderCreateResponse{OrderId: orderId}, nil
+	response := &lomsGrpc.OrderCreateResponse{OrderId: orderId}
+	// повтор по ключу идемпотентности ничего не записывает, и токена у него нет
+	if token, ok := commits.Token(); ok {
+		response.ConsistencyToken = token.String()
+	}
+	return response, nil
 }
 
 func (o *LomsController) OrderPay(ctx context.Context, request *lomsGrpc.OrderPayRequest) (*emptypb.Empty, error) {
@@ -32,6 +56,13 @@ func (o *LomsController) OrderCancel(ctx context.Context, request *lomsGrpc.Orde
 }
 
 func (o *LomsController) OrderInfo(ctx context.Context, request *lomsGrpc.OrderInfoRequest) (*lomsGrpc.OrderInfoResponse, error) {
+	if request.ConsistencyToken != "" {
+		token, err := database.ParseConsistencyToken(request.ConsistencyToken)
+		if err != nil {
+			return nil, mapErrorToGRPC(fmt.Errorf("%w: %w", appErr.ErrInvalidInput, err))
+		}
+		ctx = database.WithConsistencyToken(ctx, token)
+	}
 	order, err := o.orderService.GetById(ctx, request.OrderId)
 	if err != nil {
 		return nil, mapErrorToGRPC(err)
@@ -39,8 +70,47 @@ func (o *LomsController) OrderInfo(ctx context.Context, request *lomsGrpc.OrderI
 	return &lomsGrpc.OrderInfoResponse{Order: convertOrderToResponse(order)}, nil
 }
 
-func (o *LomsController) OrdersAll(ctx context.Context, _ *emptypb.Empty) (*lomsGrpc.OrderAllResponse, error) {
-	orders, err := o.orderService.GetAllOrders(ctx)
+func (o *LomsController) OrderHistory(ctx context.Context, request *lomsGrpc.OrderHistoryRequest) (*lomsGrpc.OrderHistoryResponse, error) {
+	history, err := o.orderService.GetHistory(ctx, request.OrderId)
+	if err != nil {
+		return nil, mapErrorToGRPC(err)
+	}
+	return convertHistoryToResponse(history), nil
+}
+
+func (o *LomsController) OrdersAll(ctx context.Context, request *lomsGrpc.OrdersAllRequest) (*lomsGrpc.OrderAllResponse, error) {
+	beforeID, err := decodePageToken(request.PageToken)
+	if err != nil {
+		return nil, mapErrorToGRPC(err)
+	}
+	filter := model.OrdersFilter{
+		UserId:   request.UserId,
+		State:    model.StateType(request.Status),
+		BeforeID: beforeID,
+		Limit:    int(request.PageSize),
+	}
+	orders, nextBeforeID, err := o.orderService.ListOrders(ctx, filter)
+	if err != nil {
+		return nil, mapErrorToGRPC(err)
+	}
+	var ordersRs []*lomsGrpc.Order
+	for _, order := range orders {
+		ordersRs = append(ordersRs, convertOrderToResponse(order))
+	}
+	return &lomsGrpc.OrderAllResponse{Orders: ordersRs, NextPageToken: encodePageToken(nextBeforeID)}, nil
+}
+
+func (o *LomsController) UserOrders(ctx context.Context, request *lomsGrpc.UserOrdersRequest) (*lomsGrpc.UserOrdersResponse, error) {
+	beforeID, err := decodePageToken(request.PageToken)
+	if err != nil {
+		return nil, mapErrorToGRPC(err)
+	}
+	filter := model.OrdersFilter{
+		UserId:   request.UserId,
+		BeforeID: beforeID,
+		Limit:    int(request.PageSize),
+	}
+	orders, nextBeforeID, err := o.orderService.ListUserOrders(ctx, filter)
 	if err != nil {
 		return nil, mapErrorToGRPC(err)
 	}
@@ -48,7 +118,47 @@ func (o *LomsController) OrdersAll(ctx context.Context, _ *emptypb.Empty) (*loms
 	for _, order := range orders {
 		ordersRs = append(ordersRs, convertOrderToResponse(order))
 	}
-	return &lomsGrpc.OrderAllResponse{Orders: ordersRs}, nil
+	return &lomsGrpc.UserOrdersResponse{Orders: ordersRs, NextPageToken: encodePageToken(nextBeforeID)}, nil
+}
+
+// encodePageToken курсор страницы - ID последнего заказа предыдущей страницы, непрозрачный для клиента
+func encodePageToken(beforeID int64) string {
+	if beforeID == 0 {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(beforeID, 10)))
+}
+
+func decodePageToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, fmt.Errorf("page token %q: %w", token, appErr.ErrInvalidInput)
+	}
+	beforeID, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || beforeID <= 0 {
+		return 0, fmt.Errorf("page token %q: %w", token, appErr.ErrInvalidInput)
+	}
+	return beforeID, nil
+}
+
+// extractIdempotencyKey ключ из тела запроса приоритетнее ключа из метаданных.
+// Тело проверяет валидатор proto, ключ из метаданных проверяется здесь по тем же правилам
+func extractIdempotencyKey(ctx context.Context, createRq *lomsGrpc.OrderCreateRequest) (string, error) {
+	if key := createRq.GetIdempotencyKey(); key != "" {
+		return key, nil
+	}
+	values := metadata.ValueFromIncomingContext(ctx, IdempotencyKeyMetadata)
+	if len(values) == 0 {
+		return "", nil
+	}
+	key := values[0]
+	if key == "" || utf8.RuneCountInString(key) > MaxIdempotencyKeyLen {
+		return "", fmt.Errorf("idempotency key must be 1..%d characters long: %w", MaxIdempotencyKeyLen, appErr.ErrInvalidInput)
+	}
+	return key, nil
 }
 
 func convertOrderToResponse(order *model.Order) *lomsGrpc.Order {
@@ -68,6 +178,21 @@ func convertOrderToResponse(order *model.Order) *lomsGrpc.Order {
 	return orderRs
 }
 
+func convertHistoryToResponse(history *model.OrderHistory) *lomsGrpc.OrderHistoryResponse {
+	historyRs := &lomsGrpc.OrderHistoryResponse{
+		OrderId:   history.OrderID,
+		CreatedAt: timestamppb.New(history.CreatedAt),
+		UpdatedAt: timestamppb.New(history.UpdatedAt),
+	}
+	for _, change := range history.Changes {
+		historyRs.History = append(historyRs.History, &lomsGrpc.OrderStateChange{
+			Status:    string(change.State),
+			ChangedAt: timestamppb.New(change.ChangedAt),
+		})
+	}
+	return historyRs
+}
+
 func convertCreateRequestToOrder(createRq *lomsGrpc.OrderCreateRequest) *model.Order {
 	order := model.Order{
 		UserId: createRq.GetOrder().GetUser(),
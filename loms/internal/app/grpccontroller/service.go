@@ -13,11 +13,13 @@ import (
 var _ lomsGrpc.LomsServer = (*LomsController)(nil)
 
 type OrderService interface {
-	Create(ctx context.Context, order *model.Order) (orderID int64, err error)
+	Create(ctx context.Context, order *model.Order, idempotencyKey string) (orderID int64, err error)
 	GetById(ctx context.Context, orderID int64) (*model.Order, error)
 	OrderPay(ctx context.Context, orderID int64) error
 	OrderCancel(ctx context.Context, orderID int64) error
-	GetAllOrders(ctx context.Context) ([]*model.Order, error)
+	ListOrders(ctx context.Context, filter model.OrdersFilter) (orders []*model.Order, nextBeforeID int64, err error)
+	ListUserOrders(ctx context.Context, filter model.OrdersFilter) (orders []*model.Order, nextBeforeID int64, err error)
+	GetHistory(ctx context.Context, orderID int64) (*model.OrderHistory, error)
 }
 
 type StockService interface {
@@ -40,8 +42,15 @@ func mapErrorToGRPC(err error) error {
 	if errors.Is(err, appErr.ErrNotFound) || errors.Is(err, appErr.ErrOrderState) {
 		return status.Error(codes.NotFound, err.Error())
 	}
-	if errors.Is(err, appErr.ErrNegativeReserved) || errors.Is(err, appErr.ErrNegativeAvailable) {
+	if errors.Is(err, appErr.ErrNegativeReserved) || errors.Is(err, appErr.ErrNegativeAvailable) ||
+		errors.Is(err, appErr.ErrInvalidInput) {
 		return status.Error(codes.InvalidArgument, err.Error())
 	}
+	if errors.Is(err, appErr.ErrIdempotencyConflict) {
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	if errors.Is(err, appErr.ErrUnavailable) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
 	return status.Error(codes.Internal, "Internal server error")
 }
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"context"
+	"route256/loms/internal/app/grpccontroller"
+	lomsGrpc "route256/loms/internal/generated/api/loms/v1"
+	"route256/loms/internal/model"
+	"strings"
+	"testing"
+
+	"github.com/gojuno/minimock/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestLomsController_OrderCreate_IdempotencyKeyMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		header   []string
+		wantKey  string
+		wantCode codes.Code
+	}{
+		{
+			name:    "no key",
+			wantKey: "",
+		},
+		{
+			name:    "key from header",
+			header:  []string{"key"},
+			wantKey: "key",
+		},
+		{
+			name:    "body key takes precedence",
+			body:    "body-key",
+			header:  []string{strings.Repeat("k", grpccontroller.MaxIdempotencyKeyLen+1)},
+			wantKey: "body-key",
+		},
+		{
+			name:    "header key of max length",
+			header:  []string{strings.Repeat("k", grpccontroller.MaxIdempotencyKeyLen)},
+			wantKey: strings.Repeat("k", grpccontroller.MaxIdempotencyKeyLen),
+		},
+		{
+			name:     "error - header key too long",
+			header:   []string{strings.Repeat("k", grpccontroller.MaxIdempotencyKeyLen+1)},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "error - empty header key",
+			header:   []string{""},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := minimock.NewController(t)
+			orderService := NewOrderServiceMock(mc)
+			if tt.wantCode == codes.OK {
+				orderService.CreateMock.Set(func(_ context.Context, _ *model.Order, idempotencyKey string) (int64, error) {
+					assert.Equal(t, tt.wantKey, idempotencyKey)
+					return 1, nil
+				})
+			}
+			controller := grpccontroller.NewLomsController(orderService, nil)
+
+			ctx := context.Background()
+			if tt.header != nil {
+				md := metadata.MD{}
+				md.Append(grpccontroller.IdempotencyKeyMetadata, tt.header...)
+				ctx = metadata.NewIncomingContext(ctx, md)
+			}
+			rs, err := controller.OrderCreate(ctx, &lomsGrpc.OrderCreateRequest{
+				Order:          &lomsGrpc.Order{User: 1, Items: []*lomsGrpc.Item{{Sku: 1, Count: 1}}},
+				IdempotencyKey: tt.body,
+			})
+			if tt.wantCode != codes.OK {
+				assert.Equal(t, tt.wantCode, status.Code(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), rs.OrderId)
+		})
+	}
+}
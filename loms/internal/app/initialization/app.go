@@ -9,38 +9,54 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	grpcHealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"route256/libs/health"
+	"route256/libs/kafka"
 	"route256/loms/internal/app/grpccontroller"
 	"route256/loms/internal/generated/api/loms/v1"
 	"route256/loms/internal/infra/database"
 	"route256/loms/internal/infra/producer"
+	"route256/loms/internal/model"
 	grpcMW "route256/loms/internal/mw/grpc"
 	httpMW "route256/loms/internal/mw/http"
 	"route256/loms/internal/repository/orderrepository"
 	"route256/loms/internal/repository/outboxrepository"
 	"route256/loms/internal/repository/stockrepository"
 	"route256/loms/internal/service/orderservice"
+	"route256/loms/internal/service/processor/expiryprocessor"
 	"route256/loms/internal/service/processor/outboxprocessor"
+	"route256/loms/internal/service/processor/retentionprocessor"
+	"route256/loms/internal/service/processor/sagaprocessor"
 	"route256/loms/internal/service/stockservice"
 	transactionmanager "route256/loms/internal/service/transactionamanger"
 	"route256/loms/internal/tracing"
+	"strings"
 	"syscall"
 	"time"
 )
 
 type App struct {
-	orderRepository *orderrepository.Repository
-	stockRepository *stockrepository.Repository
-	stockService    *stockservice.Service
-	orderService    *orderservice.Service
-	GrpcServer      *grpc.Server
-	GwServer        *http.Server
-	OutboxProcessor *outboxprocessor.OutboxProcessor
+	dbRouter           *database.DBRouter
+	orderRepository    *orderrepository.Repository
+	stockRepository    *stockrepository.Repository
+	stockService       *stockservice.Service
+	orderService       *orderservice.Service
+	GrpcServer         *grpc.Server
+	GwServer           *http.Server
+	OutboxProcessor    *outboxprocessor.OutboxProcessor
+	SagaProcessor      *sagaprocessor.SagaProcessor
+	ExpiryProcessor    *expiryprocessor.ExpiryProcessor
+	RetentionProcessor *retentionprocessor.RetentionProcessor
+	healthChecker      *health.Checker
+	healthServer       *grpcHealth.Server
+	kafkaProbe         *kafka.BrokersProbe
 }
 
 func (application *App) Run(config *Config) {
@@ -79,6 +95,26 @@ func (application *App) Run(config *Config) {
 	// Запускаем OutboxProcessor
 	go application.OutboxProcessor.Start(ctx)
 
+	// Запускаем восстановление незавершенных саг
+	go application.SagaProcessor.Start(ctx)
+
+	// Запускаем отмену неоплаченных заказов
+	go application.ExpiryProcessor.Start(ctx)
+
+	// Запускаем удаление отправленных событий outbox старше срока хранения
+	go application.RetentionProcessor.Start(ctx)
+
+	// Следим за картой бакетов, ее меняет решардинг
+	if config.BucketMapPath != "" {
+		go application.dbRouter.WatchBucketMap(ctx, config.BucketMapPath, config.IntervalBucketMap)
+	}
+	// Реплики получают чтения, только пока их отставание измерено и не превышает допустимое
+	go application.dbRouter.WatchReplicaLag(ctx, config.IntervalReplicaLag, config.MaxReplicaLag)
+
+	// Статус grpc.health.v1 следует за проверками зависимостей
+	go application.healthChecker.WatchGRPC(ctx, application.healthServer, config.IntervalHealthCheck,
+		loms.Loms_ServiceDesc.ServiceName)
+
 	// Ожидаем завершения или ошибки
 	select {
 	case sig := <-quit:
@@ -90,6 +126,10 @@ func (application *App) Run(config *Config) {
 	// Отменяем все фоновые задачи
 	cancel()
 
+	if err := application.kafkaProbe.Close(); err != nil {
+		log.Printf("[main] Unable to close kafka health probe: %v", err)
+	}
+
 	// Завершаем работу gRPC сервера
 	go func() {
 		application.GrpcServer.GracefulStop()
@@ -111,27 +151,53 @@ func (application *App) Run(config *Config) {
 func MustNew(config *Config) (*App, error) {
 	log.Println("[cart] Starting application initialization")
 
-	dbRouter := database.NewDBRouter(initAllInstancesBd(config.DBConfigs))
+	shards := initAllInstancesBd(config.DBConfigs)
+	bucketMap, err := loadBucketMap(config.BucketMapPath, len(shards))
+	if err != nil {
+		log.Fatalf("Unable to load bucket map: %v", err)
+	}
+	dbRouter := database.NewDBRouter(shards, bucketMap)
 	outboxRepository := outboxrepository.NewRepository()
 	orderRepository := orderrepository.NewRepository(dbRouter, outboxRepository)
-	stockRepository := stockrepository.NewRepository(dbRouter)
-
-	stockService := stockservice.NewService(stockRepository)
-	orderService := orderservice.NewService(orderRepository, stockService)
+	stockRepository := stockrepository.NewRepository(dbRouter, outboxRepository)
 
 	tm := transactionmanager.NewTransactionManager(dbRouter)
 
+	stockService := stockservice.NewService(stockRepository)
+	orderService := orderservice.NewService(orderRepository, stockService, tm)
+
 	syncProducer, err := initKafkaSyncProducer(config.KafkaConfig)
 	if err != nil {
 		log.Fatalf("Unable to create kafka producer: %v", err)
 	}
-	processor := outboxprocessor.NewOutboxProcessor(outboxRepository, syncProducer, config.IntervalOutbox, tm, config.KafkaConfig.Topic)
+	processor := outboxprocessor.NewOutboxProcessor(outboxRepository, syncProducer, dbRouter,
+		map[model.OutboxStream]string{
+			model.OrderOutboxStream: config.KafkaConfig.Topic,
+			model.StockOutboxStream: config.KafkaConfig.StockTopic,
+		},
+		outboxprocessor.Options{
+			Interval:    config.IntervalOutbox,
+			BatchSize:   config.OutboxBatchSize,
+			Parallelism: config.OutboxParallelism,
+			Lease:       config.OutboxLease,
+			Retry: outboxrepository.RetryPolicy{
+				MaxAttempts: config.OutboxMaxAttempts,
+				BaseBackoff: config.OutboxRetryBackoff,
+				MaxBackoff:  config.OutboxMaxBackoff,
+			},
+		})
 	app := &App{
+		dbRouter:        dbRouter,
 		orderRepository: orderRepository,
 		stockRepository: stockRepository,
 		stockService:    stockService,
 		orderService:    orderService,
 		OutboxProcessor: processor,
+		SagaProcessor:   sagaprocessor.NewSagaProcessor(orderService, config.IntervalSaga),
+		ExpiryProcessor: expiryprocessor.NewExpiryProcessor(orderService, config.IntervalExpiry, config.ReservationTTL),
+		RetentionProcessor: retentionprocessor.NewRetentionProcessor(outboxRepository, dbRouter,
+			config.IntervalRetention, config.OutboxRetention),
+		kafkaProbe: kafka.NewBrokersProbe(config.KafkaConfig.Brokers),
 	}
 
 	httpMW.SwaggerUrlForCors = config.SwagerUrl
@@ -145,6 +211,11 @@ func MustNew(config *Config) (*App, error) {
 		),
 	)
 	reflection.Register(grpcServer)
+	app.healthChecker = newHealthChecker(shards, app.kafkaProbe)
+	app.healthServer = grpcHealth.NewServer()
+	// до первой проверки зависимостей сервис не готов
+	app.healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, app.healthServer)
 	lomsController := grpccontroller.NewLomsController(app.orderService, app.stockService)
 	loms.RegisterLomsServer(grpcServer, lomsController)
 
@@ -155,13 +226,15 @@ func MustNew(config *Config) (*App, error) {
 		log.Fatalln("Failed to deal:", err)
 	}
 
-	gwmux := runtime.NewServeMux()
+	gwmux := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher))
 
 	if err = loms.RegisterLomsHandler(context.Background(), gwmux, conn); err != nil {
 		log.Fatalln("Failed to register gateway:", err)
 	}
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("GET /healthz", health.LiveHandler)
+	mux.HandleFunc("GET /readyz", app.healthChecker.ReadyHandler)
 
 	mux.Handle("/", gwmux)
 	gwServer := &http.Server{
@@ -179,6 +252,37 @@ func MustNew(config *Config) (*App, error) {
 	return app, nil
 }
 
+// newHealthChecker проверяет мастер и реплику каждого шарда и брокеры Kafka.
+// Недоступная реплика не снимает готовность: чтения уходят на мастер
+func newHealthChecker(shards []*database.MasterAndReplica, kafkaProbe *kafka.BrokersProbe) *health.Checker {
+	checker := health.NewChecker(health.DefaultTimeout)
+	for i, shard := range shards {
+		checker.Add(fmt.Sprintf("postgres_shard_%d_master", i), shard[0].Ping)
+		if shard[1] != nil {
+			checker.AddOptional(fmt.Sprintf("postgres_shard_%d_replica", i), shard[1].Ping)
+		}
+	}
+	checker.Add("kafka", kafkaProbe.Check)
+	return checker
+}
+
+// incomingHeaderMatcher помимо стандартных заголовков пробрасывает в gRPC метаданные заголовок Idempotency-Key
+func incomingHeaderMatcher(key string) (string, bool) {
+	if strings.EqualFold(key, "Idempotency-Key") {
+		return grpccontroller.IdempotencyKeyMetadata, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// loadBucketMap без указанного файла бакеты раскладываются по шардам по остатку от деления
+func loadBucketMap(path string, countShard int) (*database.BucketMap, error) {
+	if path == "" {
+		log.Printf("[config] bucket map is not set, buckets will be spread across %d shards by modulo", countShard)
+		return database.NewModuloBucketMap(countShard), nil
+	}
+	return database.LoadBucketMap(path, countShard)
+}
+
 func initAllInstancesBd(dbConfigs []*DBConfigs) []*database.MasterAndReplica {
 	var mastersAndReplicas []*database.MasterAndReplica
 	for _, dbConfig := range dbConfigs {
@@ -11,13 +11,29 @@ import (
 )
 
 type Config struct {
-	StockFilePath  string
-	GgrpcHostPort  string
-	HttpPort       int
-	SwagerUrl      string
-	DBConfigs      []*DBConfigs
-	KafkaConfig    *KafkaConfig
-	IntervalOutbox time.Duration
+	StockFilePath       string
+	BucketMapPath       string
+	GgrpcHostPort       string
+	HttpPort            int
+	SwagerUrl           string
+	DBConfigs           []*DBConfigs
+	KafkaConfig         *KafkaConfig
+	IntervalOutbox      time.Duration
+	OutboxBatchSize     int
+	OutboxParallelism   int
+	OutboxLease         time.Duration
+	OutboxMaxAttempts   int
+	OutboxRetryBackoff  time.Duration
+	OutboxMaxBackoff    time.Duration
+	OutboxRetention     time.Duration
+	IntervalRetention   time.Duration
+	IntervalSaga        time.Duration
+	IntervalExpiry      time.Duration
+	ReservationTTL      time.Duration
+	IntervalBucketMap   time.Duration
+	IntervalReplicaLag  time.Duration
+	MaxReplicaLag       time.Duration
+	IntervalHealthCheck time.Duration
 }
 
 type DBConfigs struct {
@@ -33,13 +49,30 @@ type DBConfig struct {
 }
 
 type KafkaConfig struct {
-	Brokers  []string
-	RetryMax uint
-	Topic    string
+	Brokers    []string
+	RetryMax   uint
+	Topic      string
+	StockTopic string
 }
 
 const defaultHostPortGrpc = ":50051"
 const defaultHttpPort = 8081
+const defaultIntervalOutbox = time.Second
+const defaultOutboxBatchSize = 100
+const defaultOutboxParallelism = 1
+const defaultOutboxLease = 30 * time.Second
+const defaultOutboxMaxAttempts = 10
+const defaultOutboxRetryBackoff = time.Second
+const defaultOutboxMaxBackoff = 5 * time.Minute
+const defaultOutboxRetention = 7 * 24 * time.Hour
+const defaultIntervalRetention = time.Hour
+const defaultIntervalSaga = 10 * time.Second
+const defaultIntervalExpiry = time.Minute
+const defaultReservationTTL = 15 * time.Minute
+const defaultIntervalBucketMap = 5 * time.Second
+const defaultIntervalReplicaLag = time.Second
+const defaultMaxReplicaLag = 2 * time.Second
+const defaultIntervalHealthCheck = 5 * time.Second
 
 func LoadDefaultConfig() (*Config, error) {
 	return LoadConfig("./.env")
@@ -51,6 +84,7 @@ func LoadConfig(pathToEnv string) (*Config, error) {
 	}
 
 	stockFilePath := os.Getenv("STOCK_FILE_PATH")
+	bucketMapPath := os.Getenv("BUCKET_MAP_PATH")
 
 	grpcPort := os.Getenv("GRPC_HOST_PORT")
 	if grpcPort == "" {
@@ -77,21 +111,47 @@ func LoadConfig(pathToEnv string) (*Config, error) {
 		log.Printf("[config] replica configuration is incomplete, using only master")
 	}
 
-	intervalOutboxStr := os.Getenv("INTERVAL_OUTBOX")
-	intervalOutbox, err := strconv.ParseUint(intervalOutboxStr, 10, 64)
+	intervalOutbox := loadDurationEnv("INTERVAL_OUTBOX", defaultIntervalOutbox)
+	outboxBatchSize := loadIntEnv("OUTBOX_BATCH_SIZE", defaultOutboxBatchSize)
+	outboxParallelism := loadIntEnv("OUTBOX_PARALLELISM", defaultOutboxParallelism)
+	outboxLease := loadDurationEnv("OUTBOX_LEASE", defaultOutboxLease)
+	outboxMaxAttempts := loadIntEnv("OUTBOX_MAX_ATTEMPTS", defaultOutboxMaxAttempts)
+	outboxRetryBackoff := loadDurationEnv("OUTBOX_RETRY_BACKOFF", defaultOutboxRetryBackoff)
+	outboxMaxBackoff := loadDurationEnv("OUTBOX_MAX_RETRY_BACKOFF", defaultOutboxMaxBackoff)
+	outboxRetention := loadDurationEnv("OUTBOX_RETENTION", defaultOutboxRetention)
+	intervalRetention := loadDurationEnv("INTERVAL_OUTBOX_RETENTION", defaultIntervalRetention)
+	intervalSaga := loadDurationEnv("INTERVAL_SAGA_RECOVERY", defaultIntervalSaga)
+	intervalExpiry := loadDurationEnv("INTERVAL_RESERVATION_EXPIRY", defaultIntervalExpiry)
+	reservationTTL := loadDurationEnv("RESERVATION_TTL", defaultReservationTTL)
+	intervalBucketMap := loadDurationEnv("INTERVAL_BUCKET_MAP_RELOAD", defaultIntervalBucketMap)
+	intervalReplicaLag := loadDurationEnv("INTERVAL_REPLICA_LAG", defaultIntervalReplicaLag)
+	maxReplicaLag := loadDurationEnv("MAX_REPLICA_LAG", defaultMaxReplicaLag)
+	intervalHealthCheck := loadDurationEnv("INTERVAL_HEALTH_CHECK", defaultIntervalHealthCheck)
 
-	if err != nil || intervalOutbox > math.MaxInt64 {
-		log.Printf("[config] failed to parse INTERVAL_OUTBOX: %v", err)
-		intervalOutbox = 1_000_000_000
-	}
 	return &Config{
-		StockFilePath:  stockFilePath,
-		GgrpcHostPort:  grpcPort,
-		HttpPort:       httpPort,
-		SwagerUrl:      swaggerUrl,
-		DBConfigs:      dbConfigs,
-		KafkaConfig:    MustLoadKafkaConfig(),
-		IntervalOutbox: time.Duration(intervalOutbox),
+		StockFilePath:       stockFilePath,
+		BucketMapPath:       bucketMapPath,
+		GgrpcHostPort:       grpcPort,
+		HttpPort:            httpPort,
+		SwagerUrl:           swaggerUrl,
+		DBConfigs:           dbConfigs,
+		KafkaConfig:         MustLoadKafkaConfig(),
+		IntervalOutbox:      intervalOutbox,
+		OutboxBatchSize:     outboxBatchSize,
+		OutboxParallelism:   outboxParallelism,
+		OutboxLease:         outboxLease,
+		OutboxMaxAttempts:   outboxMaxAttempts,
+		OutboxRetryBackoff:  outboxRetryBackoff,
+		OutboxMaxBackoff:    outboxMaxBackoff,
+		OutboxRetention:     outboxRetention,
+		IntervalRetention:   intervalRetention,
+		IntervalSaga:        intervalSaga,
+		IntervalExpiry:      intervalExpiry,
+		ReservationTTL:      reservationTTL,
+		IntervalBucketMap:   intervalBucketMap,
+		IntervalReplicaLag:  intervalReplicaLag,
+		MaxReplicaLag:       maxReplicaLag,
+		IntervalHealthCheck: intervalHealthCheck,
 	}, nil
 }
 
@@ -101,7 +161,8 @@ func MustLoadDBConfig() ([]*DBConfigs, error) {
 	generateEnvNameWithIndex := func(envName string, index int) string {
 		return fmt.Sprintf("%s_%d", envName, index)
 	}
-	for i := 0; i < 2; i++ {
+	// шард 0 обязателен, остальные шарды читаются по порядку, пока задан DATABASE_MASTER_HOST_PORT_<i>
+	for i := 0; i == 0 || os.Getenv(generateEnvNameWithIndex("DATABASE_MASTER_HOST_PORT", i)) != ""; i++ {
 		masterConfig := &DBConfig{
 			DBUser:     os.Getenv(generateEnvNameWithIndex("DATABASE_MASTER_USER", i)),
 			DBPassword: os.Getenv(generateEnvNameWithIndex("DATABASE_MASTER_PASSWORD", i)),
@@ -155,9 +216,10 @@ func MustLoadKafkaConfig() (kafkaConfig *KafkaConfig) {
 	}
 
 	kafkaConfig = &KafkaConfig{
-		Brokers:  []string{os.Getenv("KAFKA_BROKER")},
-		RetryMax: uint(maxRetry),
-		Topic:    os.Getenv("KAFKA_TOPIC"),
+		Brokers:    []string{os.Getenv("KAFKA_BROKER")},
+		RetryMax:   uint(maxRetry),
+		Topic:      os.Getenv("KAFKA_TOPIC"),
+		StockTopic: os.Getenv("KAFKA_STOCK_TOPIC"),
 	}
 	if kafkaConfig.Brokers[0] == "" {
 		log.Fatalf("KAFKA_BROKER is not set")
@@ -165,9 +227,35 @@ func MustLoadKafkaConfig() (kafkaConfig *KafkaConfig) {
 	if kafkaConfig.Topic == "" {
 		log.Fatalf("KAFKA_TOPIC is not set")
 	}
+	if kafkaConfig.StockTopic == "" {
+		log.Fatalf("KAFKA_STOCK_TOPIC is not set")
+	}
 	return kafkaConfig
 }
 
+// loadDurationEnv читает длительность в наносекундах из переменной окружения envName,
+// при отсутствии или некорректном значении возвращает defaultValue
+func loadDurationEnv(envName string, defaultValue time.Duration) time.Duration {
+	valueStr := os.Getenv(envName)
+	value, err := strconv.ParseUint(valueStr, 10, 64)
+	if err != nil || value > math.MaxInt64 || value == 0 {
+		log.Printf("[config] failed to parse %s: %v, will be using default: %v", envName, err, defaultValue)
+		return defaultValue
+	}
+	return time.Duration(value)
+}
+
+// loadIntEnv читает положительное целое из переменной окружения envName,
+// при отсутствии или некорректном значении возвращает defaultValue
+func loadIntEnv(envName string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(envName))
+	if err != nil || value <= 0 {
+		log.Printf("[config] failed to parse %s: %v, will be using default: %d", envName, err, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
 func loadEnv(pathToEnv string) error {
 	if err := godotenv.Load(pathToEnv); err != nil {
 		return fmt.Errorf("failed to load %s: %w", pathToEnv, err)
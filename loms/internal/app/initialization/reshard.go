@@ -0,0 +1,93 @@
+package initialization
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os/signal"
+	"route256/loms/internal/app/reshard"
+	"route256/loms/internal/infra/database"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const defaultReshardBatchSize = 10
+const defaultReshardDrainTimeout = time.Minute
+
+// RunReshard выполняет команду `loms reshard -target <карта>`: переносит заказы и стоки так,
+// чтобы раскладка бакетов по шардам совпала с целевой картой. Текущая карта берется из BUCKET_MAP_PATH,
+// работающие сервисы должны читать тот же файл.
+// `loms reshard -stock-from <шард>` раскладывает стоки, лежащие на одном шарде, по текущей карте
+func RunReshard(config *Config, args []string) error {
+	flags := flag.NewFlagSet("reshard", flag.ContinueOnError)
+	targetPath := flags.String("target", "", "path to the target bucket map")
+	batchSize := flags.Int("batch", defaultReshardBatchSize, "buckets moved per pass")
+	grace := flags.Duration("grace", 3*config.IntervalBucketMap,
+		"time for services to reload the bucket map and finish running transactions")
+	drainTimeout := flags.Duration("drain-timeout", defaultReshardDrainTimeout,
+		"max wait for outbox events of a bucket to be sent")
+	stockFrom := flags.Int("stock-from", database.UnassignedShard,
+		"shard holding all stocks to spread over the current bucket map instead of moving to -target")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *targetPath == "" && *stockFrom == database.UnassignedShard {
+		return errors.New("-target or -stock-from is required")
+	}
+	if config.BucketMapPath == "" {
+		return errors.New("BUCKET_MAP_PATH is not set, nothing to reshard")
+	}
+	if *batchSize <= 0 {
+		return fmt.Errorf("-batch must be positive, got %d", *batchSize)
+	}
+	if *grace <= config.IntervalBucketMap {
+		return fmt.Errorf("-grace %s must exceed the bucket map reload interval %s", *grace, config.IntervalBucketMap)
+	}
+
+	shards := initAllInstancesBd(config.DBConfigs)
+	masters := make([]*pgxpool.Pool, 0, len(shards))
+	for _, shard := range shards {
+		masters = append(masters, shard[0])
+	}
+	defer func() {
+		for _, shard := range shards {
+			for _, pool := range shard {
+				if pool != nil {
+					pool.Close()
+				}
+			}
+		}
+	}()
+
+	if *stockFrom != database.UnassignedShard && (*stockFrom < 0 || *stockFrom >= len(shards)) {
+		return fmt.Errorf("-stock-from %d is out of range of %d shards", *stockFrom, len(shards))
+	}
+	var target *database.BucketMap
+	if *targetPath != "" {
+		var err error
+		if target, err = database.LoadBucketMap(*targetPath, len(shards)); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *stockFrom != database.UnassignedShard {
+		log.Printf("[reshard] Spreading stocks of shard %d over %d shards", *stockFrom, len(shards))
+	} else {
+		log.Printf("[reshard] Resharding %d shards to %s", len(shards), *targetPath)
+	}
+	return reshard.NewResharder(masters, reshard.Options{
+		BucketMapPath: config.BucketMapPath,
+		Target:        target,
+		BatchSize:     *batchSize,
+		Grace:         *grace,
+		DrainTimeout:  *drainTimeout,
+		StockFrom:     *stockFrom,
+	}).Run(ctx)
+}
@@ -0,0 +1,64 @@
+package initialization
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os/signal"
+	"route256/loms/internal/infra/database"
+	"route256/loms/internal/model"
+	"route256/loms/internal/repository/outboxrepository"
+	"route256/loms/internal/repository/stockrepository"
+	"route256/loms/internal/service/stockservice"
+	transactionmanager "route256/loms/internal/service/transactionamanger"
+	"syscall"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RunRestock выполняет команду `loms restock -sku <SKU> -count <количество>`: пополняет сток SKU поставкой
+// на шарде бакета SKU. Событие stock.changed пишется в outbox и отправляется работающим сервисом
+func RunRestock(config *Config, args []string) error {
+	flags := flag.NewFlagSet("restock", flag.ContinueOnError)
+	sku := flags.Uint("sku", 0, "SKU to restock")
+	count := flags.Uint("count", 0, "number of items added to the total count")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *sku == 0 || *count == 0 {
+		return errors.New("-sku and -count are required")
+	}
+	if *sku > math.MaxUint32 || *count > math.MaxUint32 {
+		return fmt.Errorf("-sku and -count must not exceed %d", uint32(math.MaxUint32))
+	}
+
+	shards := initAllInstancesBd(config.DBConfigs)
+	defer func() {
+		for _, shard := range shards {
+			for _, pool := range shard {
+				if pool != nil {
+					pool.Close()
+				}
+			}
+		}
+	}()
+	bucketMap, err := loadBucketMap(config.BucketMapPath, len(shards))
+	if err != nil {
+		return err
+	}
+	dbRouter := database.NewDBRouter(shards, bucketMap)
+	stockService := stockservice.NewService(stockrepository.NewRepository(dbRouter, outboxrepository.NewRepository()))
+	tm := transactionmanager.NewTransactionManager(dbRouter)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	items := []*model.Item{{SKU: model.SKUType(*sku), Count: uint32(*count)}}
+	log.Printf("[restock] Adding %d items of SKU %d", *count, *sku)
+	return tm.RunInStockShardTx(ctx, []model.SKUType{items[0].SKU}, func(tx pgx.Tx) error {
+		return stockService.Restock(ctx, tx, items)
+	})
+}
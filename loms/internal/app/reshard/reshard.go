@@ -0,0 +1,617 @@
+package reshard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"route256/loms/internal/infra/database"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Move перенос бакета со старого шарда на новый. From == UnassignedShard означает,
+// что бакет еще не закреплен за шардом, и данных для переноса нет.
+// StockOnly переносит бакет карты стоков, иначе переносится бакет карты заказов
+type Move struct {
+	Bucket    int
+	From      int
+	To        int
+	StockOnly bool
+}
+
+type Options struct {
+	// BucketMapPath файл текущей карты, который читают работающие сервисы
+	BucketMapPath string
+	// Target карта, к которой нужно прийти
+	Target *database.BucketMap
+	// BatchSize число бакетов, которые переносятся за один проход
+	BatchSize int
+	// Grace время, за которое все сервисы гарантированно перечитают карту и завершат начатые транзакции
+	Grace time.Duration
+	// DrainTimeout максимальное время ожидания отправки событий outbox переносимого бакета
+	DrainTimeout time.Duration
+	// StockFrom шард, с которого стоки раскладываются по текущей карте вместо переноса к Target.
+	// UnassignedShard означает обычный перенос
+	StockFrom int
+}
+
+// Resharder переносит заказы и стоки между шардами бакет за бакетом:
+// бакет помечается read-only, его строки копируются на новый шард в одной транзакции со сверкой количества,
+// карта переключается подменой файла, после чего бакет удаляется со старого шарда и строки сверяются еще раз
+type Resharder struct {
+	shards []*pgxpool.Pool
+	opts   Options
+	// legacyUsers пользователи заказов со старыми номерами по шардам и бакетам, собираются один раз за запуск
+	legacyUsers []map[int][]int64
+}
+
+func NewResharder(shards []*pgxpool.Pool, opts Options) *Resharder {
+	return &Resharder{shards: shards, opts: opts}
+}
+
+type column struct {
+	name string
+	// enum тип колонки, значения передаются между шардами текстом
+	enum string
+}
+
+type table struct {
+	name         string
+	bucketColumn string
+	// byOrderID строки относятся к бакету через ID заказа в bucketColumn, а не через остаток от деления
+	byOrderID bool
+	columns   []column
+}
+
+// key различает строки одной таблицы, которые относятся к бакету по разным колонкам, как события заказов и стоков в outbox
+func (t table) key() string {
+	return t.name + "." + t.bucketColumn
+}
+
+// bucketKeys ключи строк бакетов. Заказы относятся к бакету пользователя: номера заказов, выданные последовательностями
+// шардов до перехода на бакеты, попадают в бакеты 0 и 1, поэтому заказы бакетов перечисляются явно
+type bucketKeys struct {
+	buckets  []int
+	orderIDs []int64
+}
+
+// where возвращает условие отбора строк бакетов и его аргументы
+func (t table) where(keys bucketKeys) (string, []any) {
+	if t.byOrderID {
+		return fmt.Sprintf("%s = ANY($1)", t.bucketColumn), []any{keys.orderIDs}
+	}
+	return fmt.Sprintf("%s %% $1 = ANY($2)", t.bucketColumn), []any{int64(database.BucketsCount), keys.buckets}
+}
+
+// orderTables таблицы с заказами бакета, родительские таблицы раньше дочерних.
+// Суррогатные id истории статусов и outbox не переносятся, новый шард выдает свои
+var orderTables = []table{
+	{name: "orders", bucketColumn: "id", byOrderID: true, columns: []column{
+		{name: "id"}, {name: "state", enum: "order_status"}, {name: "user_id"}, {name: "created_at"}, {name: "updated_at"},
+	}},
+	{name: "items", bucketColumn: "order_id", byOrderID: true, columns: []column{
+		{name: "sku"}, {name: "order_id"}, {name: "count"},
+	}},
+	{name: "order_state_history", bucketColumn: "order_id", byOrderID: true, columns: []column{
+		{name: "order_id"}, {name: "state", enum: "order_status"}, {name: "changed_at"},
+	}},
+	{name: "order_saga", bucketColumn: "order_id", byOrderID: true, columns: []column{
+		{name: "order_id"}, {name: "user_id"}, {name: "operation", enum: "stock_operation_type"},
+		{name: "target_state", enum: "order_status"}, {name: "created_at"},
+	}},
+	{name: "idempotency_keys", bucketColumn: "order_id", byOrderID: true, columns: []column{
+		{name: "user_id"}, {name: "idempotency_key"}, {name: "order_id"}, {name: "created_at"}, {name: "request_hash"},
+	}},
+	{name: "outbox", bucketColumn: "order_id", byOrderID: true, columns: []column{
+		{name: "order_id"}, {name: "payload"}, {name: "created_at"}, {name: "processed"}, {name: "processed_at"},
+		{name: "attempts"}, {name: "last_error"}, {name: "next_attempt_at"}, {name: "dead_lettered_at"},
+	}},
+}
+
+// stockTables таблицы со стоками бакета, бакет SKU - остаток от деления SKU на число бакетов
+var stockTables = []table{
+	{name: "stock", bucketColumn: "sku", columns: []column{
+		{name: "sku"}, {name: "total_count"}, {name: "reserved_count"},
+	}},
+	{name: "stock_operations", bucketColumn: "sku", columns: []column{
+		{name: "order_id"}, {name: "operation", enum: "stock_operation_type"}, {name: "sku"}, {name: "created_at"},
+	}},
+	{name: "outbox", bucketColumn: "sku", columns: []column{
+		{name: "sku"}, {name: "payload"}, {name: "created_at"}, {name: "processed"}, {name: "processed_at"},
+		{name: "attempts"}, {name: "last_error"}, {name: "next_attempt_at"}, {name: "dead_lettered_at"},
+	}},
+}
+
+// tablesFor возвращает таблицы, строки которых переносит move
+func tablesFor(move Move) []table {
+	if move.StockOnly {
+		return stockTables
+	}
+	return orderTables
+}
+
+// Plan возвращает бакеты заказов, а затем бакеты стоков, шард которых отличается в текущей и целевой картах
+func Plan(current, target *database.BucketMap) ([]Move, error) {
+	moves, err := planMoves(current.ShardIndex, target.ShardIndex, false)
+	if err != nil {
+		return nil, err
+	}
+	stockMoves, err := planMoves(current.StockShardIndex, target.StockShardIndex, true)
+	if err != nil {
+		return nil, fmt.Errorf("stocks: %w", err)
+	}
+	return append(moves, stockMoves...), nil
+}
+
+func planMoves(current, target func(bucket int) (int, error), stockOnly bool) ([]Move, error) {
+	var moves []Move
+	for bucket := 0; bucket < database.BucketsCount; bucket++ {
+		from, err := current(bucket)
+		if err != nil {
+			if !errors.Is(err, database.ErrBucketUnassigned) {
+				return nil, err
+			}
+			from = database.UnassignedShard
+		}
+		to, err := target(bucket)
+		if err != nil {
+			if errors.Is(err, database.ErrBucketUnassigned) && from == database.UnassignedShard {
+				continue
+			}
+			return nil, fmt.Errorf("target map: %w", err)
+		}
+		if from != to {
+			moves = append(moves, Move{Bucket: bucket, From: from, To: to, StockOnly: stockOnly})
+		}
+	}
+	return moves, nil
+}
+
+// PlanStockRebalance возвращает переносы бакетов стоков, лежащих на шарде from, на шарды тех же бакетов в карте заказов.
+// Нужен один раз при переходе к шардированию стоков по SKU, когда все стоки лежат на одном шарде
+func PlanStockRebalance(current *database.BucketMap, from int) ([]Move, error) {
+	var moves []Move
+	for bucket := 0; bucket < database.BucketsCount; bucket++ {
+		stockShard, err := current.StockShardIndex(bucket)
+		if err != nil && !errors.Is(err, database.ErrBucketUnassigned) {
+			return nil, err
+		}
+		if err != nil || stockShard != from {
+			continue
+		}
+		to, err := current.ShardIndex(bucket)
+		if err != nil {
+			if errors.Is(err, database.ErrBucketUnassigned) {
+				continue
+			}
+			return nil, err
+		}
+		if to != from {
+			moves = append(moves, Move{Bucket: bucket, From: from, To: to, StockOnly: true})
+		}
+	}
+	return moves, nil
+}
+
+// Run удаляет строки, оставшиеся от прерванного запуска, и переносит все бакеты, шард которых меняется, пачками по BatchSize
+func (r *Resharder) Run(ctx context.Context) error {
+	current, err := database.LoadBucketMap(r.opts.BucketMapPath, len(r.shards))
+	if err != nil {
+		return err
+	}
+	r.legacyUsers = make([]map[int][]int64, len(r.shards))
+	if err = r.cleanup(ctx, current); err != nil {
+		return err
+	}
+	var moves []Move
+	if r.opts.StockFrom != database.UnassignedShard {
+		moves, err = PlanStockRebalance(current, r.opts.StockFrom)
+	} else {
+		moves, err = Plan(current, r.opts.Target)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("[reshard] %d buckets to move", len(moves))
+
+	for start := 0; start < len(moves); start += r.opts.BatchSize {
+		batch := moves[start:min(start+r.opts.BatchSize, len(moves))]
+		if err = r.moveBatch(ctx, current, batch); err != nil {
+			return err
+		}
+		log.Printf("[reshard] Moved %d/%d buckets", start+len(batch), len(moves))
+	}
+	return nil
+}
+
+// setReadOnly запрещает или разрешает запись в переносимый бакет карты заказов или стоков
+func setReadOnly(bucketMap *database.BucketMap, move Move, readOnly bool) {
+	if move.StockOnly {
+		bucketMap.SetStockReadOnly(move.Bucket, readOnly)
+	} else {
+		bucketMap.SetReadOnly(move.Bucket, readOnly)
+	}
+}
+
+// moveBatch переносит пачку бакетов. Бакет закрепляется за новым шардом в карте только после того,
+// как его строки скопированы, до этого он read-only на старом шарде
+func (r *Resharder) moveBatch(ctx context.Context, current *database.BucketMap, batch []Move) error {
+	for _, move := range batch {
+		setReadOnly(current, move, true)
+	}
+	if err := r.publish(ctx, current); err != nil {
+		return err
+	}
+
+	copied := make(map[int]*copiedBucket, len(batch))
+	for _, move := range batch {
+		if move.From == database.UnassignedShard {
+			continue
+		}
+		bucketCopy, err := r.copyBucket(ctx, move)
+		if err != nil {
+			// бакет остается на старом шарде, снимаем запрет на запись
+			for _, m := range batch {
+				setReadOnly(current, m, false)
+			}
+			return errors.Join(fmt.Errorf("bucket %d: %w", move.Bucket, err), current.Save(r.opts.BucketMapPath))
+		}
+		copied[move.Bucket] = bucketCopy
+	}
+
+	for _, move := range batch {
+		if move.StockOnly {
+			current.SetStockShard(move.Bucket, move.To)
+		} else {
+			current.SetShard(move.Bucket, move.To)
+		}
+		setReadOnly(current, move, false)
+	}
+	if err := r.publish(ctx, current); err != nil {
+		return err
+	}
+
+	for _, move := range batch {
+		if move.From == database.UnassignedShard {
+			continue
+		}
+		if err := r.deleteBucket(ctx, r.shards[move.From], move, copied[move.Bucket].keys); err != nil {
+			return fmt.Errorf("bucket %d: unable to clean up shard %d: %w", move.Bucket, move.From, err)
+		}
+		if err := r.verifyBucket(ctx, move, copied[move.Bucket]); err != nil {
+			return fmt.Errorf("bucket %d: %w", move.Bucket, err)
+		}
+	}
+	return nil
+}
+
+// publish сохраняет карту и ждет, пока ее перечитают все сервисы
+func (r *Resharder) publish(ctx context.Context, bucketMap *database.BucketMap) error {
+	if err := bucketMap.Save(r.opts.BucketMapPath); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(r.opts.Grace):
+		return nil
+	}
+}
+
+// copiedBucket ключи перенесенных строк бакета и их число по таблицам
+type copiedBucket struct {
+	keys   bucketKeys
+	counts map[string]int64
+}
+
+// copyBucket копирует строки бакета со старого шарда на новый в одной транзакции нового шарда.
+// Старые строки бакета на новом шарде, оставшиеся от прерванного запуска, удаляются,
+// поэтому перенос можно безопасно повторить
+func (r *Resharder) copyBucket(ctx context.Context, move Move) (*copiedBucket, error) {
+	src, dst := r.shards[move.From], r.shards[move.To]
+	// бакет уже read-only, поэтому новых заказов у его пользователей не появится
+	keys, err := r.bucketKeysOf(ctx, move)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.waitOutboxDrained(ctx, src, move, keys); err != nil {
+		return nil, err
+	}
+
+	srcTx, err := src.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, fmt.Errorf("unable to begin tx on shard %d: %w", move.From, err)
+	}
+	defer func() {
+		_ = srcTx.Rollback(ctx)
+	}()
+
+	moveTables := tablesFor(move)
+	counts := make(map[string]int64, len(moveTables))
+	err = pgx.BeginFunc(ctx, dst, func(dstTx pgx.Tx) error {
+		if err := r.deleteBucketInTx(ctx, dstTx, move, keys); err != nil {
+			return err
+		}
+		for _, t := range moveTables {
+			copiedRows, err := copyTable(ctx, srcTx, dstTx, t, keys)
+			if err != nil {
+				return err
+			}
+			srcRows, err := countRows(ctx, srcTx, t, keys)
+			if err != nil {
+				return err
+			}
+			if copiedRows != srcRows {
+				return fmt.Errorf("%s: copied %d rows, source has %d", t.name, copiedRows, srcRows)
+			}
+			counts[t.key()] = copiedRows
+		}
+		if move.StockOnly {
+			return nil
+		}
+		// номера заказов нового шарда не должны пересечься с перенесенными
+		_, err := dstTx.Exec(ctx, `SELECT setval('order_number_seq', GREATEST(
+			(SELECT last_value FROM order_number_seq),
+			COALESCE((SELECT max(id) / $1 FROM orders), 0) + 1))`, int64(database.BucketsCount))
+		if err != nil {
+			return fmt.Errorf("unable to advance order_number_seq: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to copy to shard %d: %w", move.To, err)
+	}
+	return &copiedBucket{keys: keys, counts: counts}, nil
+}
+
+// bucketKeysOf собирает ID заказов бакета на шарде move.From
+func (r *Resharder) bucketKeysOf(ctx context.Context, move Move) (bucketKeys, error) {
+	keys := bucketKeys{buckets: []int{move.Bucket}}
+	if move.StockOnly {
+		return keys, nil
+	}
+	// новые пользователи бакетов 0 и 1 получают номера заказов из тех же бакетов, что и старые заказы,
+	// поэтому их пользователи собираются заново уже после того, как бакет стал read-only
+	if move.Bucket < database.LegacyOrderBuckets {
+		r.legacyUsers[move.From] = nil
+	}
+	legacyUsers, err := r.legacyUsersOf(ctx, move.From)
+	if err != nil {
+		return keys, err
+	}
+	keys.orderIDs, err = orderIdsOf(ctx, r.shards[move.From], keys.buckets, legacyUsers[move.Bucket])
+	if err != nil {
+		return keys, fmt.Errorf("unable to read orders of bucket %d: %w", move.Bucket, err)
+	}
+	return keys, nil
+}
+
+// legacyUsersOf возвращает пользователей заказов шарда с номерами из бакетов 0 и 1 по бакетам пользователей.
+// Бакет пользователя считается хэшем user_id, поэтому пользователи раскладываются на стороне сервиса.
+// Старые номера больше не выдаются, и пользователи бакетов начиная с LegacyOrderBuckets здесь не меняются до конца запуска
+func (r *Resharder) legacyUsersOf(ctx context.Context, shard int) (map[int][]int64, error) {
+	if r.legacyUsers[shard] != nil {
+		return r.legacyUsers[shard], nil
+	}
+	rows, err := r.shards[shard].Query(ctx, "SELECT DISTINCT user_id FROM orders WHERE id % $1 < $2",
+		int64(database.BucketsCount), int64(database.LegacyOrderBuckets))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read users of shard %d: %w", shard, err)
+	}
+	userIds, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return nil, fmt.Errorf("unable to read users of shard %d: %w", shard, err)
+	}
+	users := make(map[int][]int64)
+	for _, userId := range userIds {
+		bucket := database.BucketFromUserId(userId)
+		users[bucket] = append(users[bucket], userId)
+	}
+	r.legacyUsers[shard] = users
+	return users, nil
+}
+
+// orderIdsOf возвращает ID заказов бакетов buckets: новые номера несут бакет в остатке от деления,
+// а заказы с номерами из бакетов 0 и 1 отбираются по пользователям бакетов userIds
+func orderIdsOf(ctx context.Context, q querier, buckets []int, userIds []int64) ([]int64, error) {
+	rows, err := q.Query(ctx, `SELECT id FROM orders
+		WHERE (id % $1 >= $2 AND id % $1 = ANY($3)) OR (id % $1 < $2 AND user_id = ANY($4))`,
+		int64(database.BucketsCount), int64(database.LegacyOrderBuckets), buckets, userIds)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int64])
+}
+
+// cleanup удаляет с каждого шарда строки бакетов, закрепленных в карте за другим шардом. Они остаются, если прерванный
+// запуск переключил карту, но не успел удалить бакет со старого шарда, или скопировал бакет, но не переключил карту.
+// Такие бакеты уже не попадут в план, поэтому очистка выполняется в начале каждого запуска и повторяется безопасно
+func (r *Resharder) cleanup(ctx context.Context, current *database.BucketMap) error {
+	for shard, pool := range r.shards {
+		orderBuckets, err := foreignBuckets(current.ShardIndex, shard)
+		if err != nil {
+			return err
+		}
+		stockBuckets, err := foreignBuckets(current.StockShardIndex, shard)
+		if err != nil {
+			return fmt.Errorf("stocks: %w", err)
+		}
+
+		legacyUsers, err := r.legacyUsersOf(ctx, shard)
+		if err != nil {
+			return err
+		}
+		var userIds []int64
+		for _, bucket := range orderBuckets {
+			userIds = append(userIds, legacyUsers[bucket]...)
+		}
+		orderIDs, err := orderIdsOf(ctx, pool, orderBuckets, userIds)
+		if err != nil {
+			return fmt.Errorf("unable to read stale orders of shard %d: %w", shard, err)
+		}
+
+		if len(orderIDs) > 0 {
+			if err = r.deleteBucket(ctx, pool, Move{}, bucketKeys{orderIDs: orderIDs}); err != nil {
+				return fmt.Errorf("unable to clean up shard %d: %w", shard, err)
+			}
+			log.Printf("[reshard] Removed %d orders of buckets mapped to other shards from shard %d", len(orderIDs), shard)
+		}
+		if len(stockBuckets) > 0 {
+			if err = r.deleteBucket(ctx, pool, Move{StockOnly: true}, bucketKeys{buckets: stockBuckets}); err != nil {
+				return fmt.Errorf("unable to clean up stocks of shard %d: %w", shard, err)
+			}
+		}
+	}
+	return nil
+}
+
+// foreignBuckets возвращает бакеты, закрепленные за шардом, отличным от shard
+func foreignBuckets(shardIndex func(bucket int) (int, error), shard int) ([]int, error) {
+	var buckets []int
+	for bucket := 0; bucket < database.BucketsCount; bucket++ {
+		bucketShard, err := shardIndex(bucket)
+		if err != nil {
+			if errors.Is(err, database.ErrBucketUnassigned) {
+				continue
+			}
+			return nil, err
+		}
+		if bucketShard != shard {
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets, nil
+}
+
+// waitOutboxDrained ждет, пока старый шард отправит события бакета: перенесенный outbox уже не будет отправлен повторно.
+// Недоставленные события переносятся как есть
+func (r *Resharder) waitOutboxDrained(ctx context.Context, src *pgxpool.Pool, move Move, keys bucketKeys) error {
+	deadline := time.Now().Add(r.opts.DrainTimeout)
+	for {
+		var pending int64
+		for _, t := range tablesFor(move) {
+			if t.name != "outbox" {
+				continue
+			}
+			var tablePending int64
+			where, args := t.where(keys)
+			query := "SELECT count(*) FROM outbox WHERE NOT processed AND dead_lettered_at IS NULL AND " + where
+			if err := src.QueryRow(ctx, query, args...).Scan(&tablePending); err != nil {
+				return fmt.Errorf("unable to count pending outbox: %w", err)
+			}
+			pending += tablePending
+		}
+		if pending == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d outbox events are still pending after %s", pending, r.opts.DrainTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (r *Resharder) deleteBucket(ctx context.Context, pool *pgxpool.Pool, move Move, keys bucketKeys) error {
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		return r.deleteBucketInTx(ctx, tx, move, keys)
+	})
+}
+
+func (r *Resharder) deleteBucketInTx(ctx context.Context, tx pgx.Tx, move Move, keys bucketKeys) error {
+	moveTables := tablesFor(move)
+	for i := len(moveTables) - 1; i >= 0; i-- {
+		t := moveTables[i]
+		where, args := t.where(keys)
+		if _, err := tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s", t.name, where), args...); err != nil {
+			return fmt.Errorf("unable to delete bucket rows from %s: %w", t.name, err)
+		}
+	}
+	return nil
+}
+
+// verifyBucket сверяет, что после переключения строки бакета остались только на новом шарде и их не стало меньше
+func (r *Resharder) verifyBucket(ctx context.Context, move Move, copied *copiedBucket) error {
+	for _, t := range tablesFor(move) {
+		srcRows, err := countRows(ctx, r.shards[move.From], t, copied.keys)
+		if err != nil {
+			return err
+		}
+		dstRows, err := countRows(ctx, r.shards[move.To], t, copied.keys)
+		if err != nil {
+			return err
+		}
+		if srcRows != 0 || dstRows < copied.counts[t.key()] {
+			return fmt.Errorf("%s: verification failed, %d rows left on shard %d, %d of %d copied rows on shard %d",
+				t.key(), srcRows, move.From, dstRows, copied.counts[t.key()], move.To)
+		}
+	}
+	log.Printf("[reshard] Bucket %d moved from shard %d to shard %d: %v", move.Bucket, move.From, move.To, copied.counts)
+	return nil
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func countRows(ctx context.Context, q querier, t table, keys bucketKeys) (int64, error) {
+	var count int64
+	where, args := t.where(keys)
+	if err := q.QueryRow(ctx, fmt.Sprintf("SELECT count(*) FROM %s WHERE %s", t.name, where), args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("unable to count rows of %s: %w", t.name, err)
+	}
+	return count, nil
+}
+
+func copyTable(ctx context.Context, srcTx, dstTx pgx.Tx, t table, keys bucketKeys) (int64, error) {
+	selectColumns := make([]string, 0, len(t.columns))
+	insertColumns := make([]string, 0, len(t.columns))
+	placeholders := make([]string, 0, len(t.columns))
+	for i, c := range t.columns {
+		insertColumns = append(insertColumns, c.name)
+		if c.enum != "" {
+			selectColumns = append(selectColumns, c.name+"::text")
+			placeholders = append(placeholders, fmt.Sprintf("$%d::text::%s", i+1, c.enum))
+		} else {
+			selectColumns = append(selectColumns, c.name)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
+		}
+	}
+	where, args := t.where(keys)
+	selectQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(selectColumns, ", "), t.name, where)
+	insertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		t.name, strings.Join(insertColumns, ", "), strings.Join(placeholders, ", "))
+
+	rows, err := srcTx.Query(ctx, selectQuery, args...)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read %s: %w", t.name, err)
+	}
+	batch := &pgx.Batch{}
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("unable to read %s: %w", t.name, err)
+		}
+		batch.Queue(insertQuery, values...)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("unable to read %s: %w", t.name, err)
+	}
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+	if err = dstTx.SendBatch(ctx, batch).Close(); err != nil {
+		return 0, fmt.Errorf("unable to write %s: %w", t.name, err)
+	}
+	return int64(batch.Len()), nil
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"route256/loms/internal/app/reshard"
+	"route256/loms/internal/infra/database"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan_AddShard(t *testing.T) {
+	current := database.NewModuloBucketMap(2)
+	target := database.NewModuloBucketMap(2)
+	target.SetShard(10, 2)
+	target.SetShard(11, 2)
+
+	moves, err := reshard.Plan(current, target)
+	require.NoError(t, err)
+	assert.Equal(t, []reshard.Move{
+		{Bucket: 10, From: 0, To: 2},
+		{Bucket: 11, From: 1, To: 2},
+	}, moves)
+}
+
+func TestPlan_AssignEmptyBucket(t *testing.T) {
+	current := database.NewModuloBucketMap(2)
+	current.SetShard(3, database.UnassignedShard)
+	current.SetShard(4, database.UnassignedShard)
+	target := database.NewModuloBucketMap(2)
+	target.SetShard(4, database.UnassignedShard)
+
+	moves, err := reshard.Plan(current, target)
+	require.NoError(t, err)
+	assert.Equal(t, []reshard.Move{{Bucket: 3, From: database.UnassignedShard, To: 1}}, moves)
+}
+
+func TestPlan_UnassignBucketWithData(t *testing.T) {
+	current := database.NewModuloBucketMap(2)
+	target := database.NewModuloBucketMap(2)
+	target.SetShard(7, database.UnassignedShard)
+
+	_, err := reshard.Plan(current, target)
+	assert.ErrorIs(t, err, database.ErrBucketUnassigned)
+}
+
+func TestPlan_SameMap(t *testing.T) {
+	moves, err := reshard.Plan(database.NewModuloBucketMap(3), database.NewModuloBucketMap(3))
+	require.NoError(t, err)
+	assert.Empty(t, moves)
+}
+
+func TestPlan_StockBuckets(t *testing.T) {
+	current := database.NewModuloBucketMap(2)
+	target := database.NewModuloBucketMap(2)
+	target.SetShard(10, 1)
+	target.SetStockShard(10, 1)
+	target.SetStockShard(11, 1)
+
+	moves, err := reshard.Plan(current, target)
+	require.NoError(t, err)
+	// бакеты заказов и стоков переносятся независимо, стоки по умолчанию лежат на шарде 0
+	assert.Equal(t, []reshard.Move{
+		{Bucket: 10, From: 0, To: 1},
+		{Bucket: 10, From: 0, To: 1, StockOnly: true},
+		{Bucket: 11, From: 0, To: 1, StockOnly: true},
+	}, moves)
+}
+
+func TestPlanStockRebalance(t *testing.T) {
+	current := database.NewModuloBucketMap(2)
+	current.SetShard(3, database.UnassignedShard)
+	// бакет 7 уже разложен прерванным запуском
+	current.SetStockShard(7, 1)
+
+	moves, err := reshard.PlanStockRebalance(current, 0)
+	require.NoError(t, err)
+	// бакеты шарда 0, незакрепленный бакет 3 и перенесенный бакет 7 остаются на месте
+	assert.Len(t, moves, database.BucketsCount/2-2)
+	assert.Equal(t, reshard.Move{Bucket: 1, From: 0, To: 1, StockOnly: true}, moves[0])
+	assert.Equal(t, reshard.Move{Bucket: 5, From: 0, To: 1, StockOnly: true}, moves[1])
+	assert.Equal(t, reshard.Move{Bucket: 9, From: 0, To: 1, StockOnly: true}, moves[2])
+}
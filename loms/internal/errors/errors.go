@@ -3,11 +3,14 @@ package apperrors
 import "errors"
 
 var (
-	ErrNotFound          = errors.New("item not found")
-	ErrStockInsufficient = errors.New("insufficient stock")
-	ErrNegativeReserved  = errors.New("reserved quantity cannot be negative")
-	ErrNegativeAvailable = errors.New("available quantity cannot be negative")
-	ErrInvalidInput      = errors.New("invalid input")
-	ErrInternal          = errors.New("internal error")
-	ErrOrderState        = errors.New("invalid order state")
+	ErrNotFound            = errors.New("item not found")
+	ErrStockInsufficient   = errors.New("insufficient stock")
+	ErrNegativeReserved    = errors.New("reserved quantity cannot be negative")
+	ErrNegativeAvailable   = errors.New("available quantity cannot be negative")
+	ErrInvalidInput        = errors.New("invalid input")
+	ErrInternal            = errors.New("internal error")
+	ErrOrderState          = errors.New("invalid order state")
+	ErrIdempotencyKey      = errors.New("idempotency key already used")
+	ErrIdempotencyConflict = errors.New("idempotency key reused with different request")
+	ErrUnavailable         = errors.New("temporarily unavailable")
 )
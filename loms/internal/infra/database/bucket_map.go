@@ -0,0 +1,237 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	appErr "route256/loms/internal/errors"
+	"strconv"
+)
+
+// BucketsCount число виртуальных бакетов. Пользователь попадает в бакет по хэшу user_id,
+// а ID заказа несет бакет пользователя в остатке от деления на BucketsCount,
+// поэтому маршрутизация по пользователю и по заказу всегда приводит на один шард.
+// Сток попадает в бакет по остатку от деления SKU на BucketsCount, бакеты стоков раскладываются по шардам своей картой
+const BucketsCount = 1000
+
+// UnassignedShard значение в карте бакетов для бакета, за которым не закреплен шард
+const UnassignedShard = -1
+
+// ErrBucketUnassigned бакет ключа не закреплен ни за одним шардом
+var ErrBucketUnassigned = errors.New("bucket is not assigned to any shard")
+
+// ErrSkusSpanShards стоки набора SKU лежат на разных шардах и не могут быть изменены в одной транзакции
+var ErrSkusSpanShards = errors.New("skus are stored on different shards")
+
+// ErrBucketReadOnly бакет переносится на другой шард, запись в него временно запрещена
+var ErrBucketReadOnly = fmt.Errorf("bucket is read-only while it moves between shards: %w", appErr.ErrUnavailable)
+
+// BucketMap явная карта бакет -> индекс шарда для заказов и отдельная карта для стоков. Бакеты, которые
+// переносятся между шардами, помечаются read-only: читать их можно со старого шарда, а писать нельзя до переключения карты
+type BucketMap struct {
+	shards        [BucketsCount]int
+	readOnly      [BucketsCount]bool
+	stockShards   [BucketsCount]int
+	stockReadOnly [BucketsCount]bool
+}
+
+// bucketMapFile формат файла карты: i-й элемент shards - шард бакета i, UnassignedShard - бакет без шарда.
+// Без stock_shards все стоки лежат на шарде по умолчанию, как до шардирования стоков
+type bucketMapFile struct {
+	Shards        []int `json:"shards"`
+	ReadOnly      []int `json:"read_only,omitempty"`
+	StockShards   []int `json:"stock_shards,omitempty"`
+	StockReadOnly []int `json:"stock_read_only,omitempty"`
+}
+
+// NewModuloBucketMap раскладывает бакеты заказов по шардам по остатку от деления, так бакет 0 попадает на шард 0,
+// бакет 1 - на шард 1 и т.д. Совпадает с раскладкой, по которой шардировались заказы до появления карты.
+// Стоки остаются на шарде по умолчанию, пока их не разложит `loms reshard -stock-from`
+func NewModuloBucketMap(countShard int) *BucketMap {
+	var bucketMap BucketMap
+	for bucket := range bucketMap.shards {
+		bucketMap.shards[bucket] = bucket % countShard
+		bucketMap.stockShards[bucket] = DefaultShardIndex
+	}
+	return &bucketMap
+}
+
+// LoadBucketMap читает карту из json файла и проверяет, что она ссылается только на countShard шардов
+func LoadBucketMap(path string, countShard int) (*BucketMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read bucket map %s: %w", path, err)
+	}
+	var file bucketMapFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("unable to parse bucket map %s: %w", path, err)
+	}
+	if len(file.Shards) != BucketsCount {
+		return nil, fmt.Errorf("bucket map %s: got %d buckets, want %d", path, len(file.Shards), BucketsCount)
+	}
+	if file.StockShards != nil && len(file.StockShards) != BucketsCount {
+		return nil, fmt.Errorf("bucket map %s: got %d stock buckets, want %d", path, len(file.StockShards), BucketsCount)
+	}
+	var bucketMap BucketMap
+	if err = loadShards(&bucketMap.shards, file.Shards, countShard); err != nil {
+		return nil, fmt.Errorf("bucket map %s: %w", path, err)
+	}
+	if file.StockShards != nil {
+		if err = loadShards(&bucketMap.stockShards, file.StockShards, countShard); err != nil {
+			return nil, fmt.Errorf("bucket map %s, stocks: %w", path, err)
+		}
+	}
+	if err = loadReadOnly(&bucketMap.readOnly, file.ReadOnly); err != nil {
+		return nil, fmt.Errorf("bucket map %s: %w", path, err)
+	}
+	if err = loadReadOnly(&bucketMap.stockReadOnly, file.StockReadOnly); err != nil {
+		return nil, fmt.Errorf("bucket map %s, stocks: %w", path, err)
+	}
+	return &bucketMap, nil
+}
+
+func loadShards(dst *[BucketsCount]int, shards []int, countShard int) error {
+	for bucket, shard := range shards {
+		if shard != UnassignedShard && (shard < 0 || shard >= countShard) {
+			return fmt.Errorf("bucket %d refers to shard %d, only %d shards configured", bucket, shard, countShard)
+		}
+		dst[bucket] = shard
+	}
+	return nil
+}
+
+func loadReadOnly(dst *[BucketsCount]bool, buckets []int) error {
+	for _, bucket := range buckets {
+		if bucket < 0 || bucket >= BucketsCount {
+			return fmt.Errorf("read-only bucket %d out of range", bucket)
+		}
+		dst[bucket] = true
+	}
+	return nil
+}
+
+func readOnlyBuckets(readOnly *[BucketsCount]bool) []int {
+	var buckets []int
+	for bucket, isReadOnly := range readOnly {
+		if isReadOnly {
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets
+}
+
+// Save записывает карту в json файл в формате LoadBucketMap. Файл подменяется через rename,
+// поэтому читатели видят либо старую, либо новую карту целиком
+func (m *BucketMap) Save(path string) error {
+	file := bucketMapFile{
+		Shards:        m.shards[:],
+		ReadOnly:      readOnlyBuckets(&m.readOnly),
+		StockShards:   m.stockShards[:],
+		StockReadOnly: readOnlyBuckets(&m.stockReadOnly),
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("unable to encode bucket map: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create temp file for bucket map %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("unable to write bucket map %s: %w", path, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write bucket map %s: %w", path, err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("unable to replace bucket map %s: %w", path, err)
+	}
+	return nil
+}
+
+// Clone возвращает независимую копию карты
+func (m *BucketMap) Clone() *BucketMap {
+	clone := *m
+	return &clone
+}
+
+// ShardIndex возвращает шард бакета заказов или ErrBucketUnassigned
+func (m *BucketMap) ShardIndex(bucket int) (int, error) {
+	return shardIndex(&m.shards, bucket)
+}
+
+// StockShardIndex возвращает шард бакета стоков или ErrBucketUnassigned
+func (m *BucketMap) StockShardIndex(bucket int) (int, error) {
+	return shardIndex(&m.stockShards, bucket)
+}
+
+func shardIndex(shards *[BucketsCount]int, bucket int) (int, error) {
+	if bucket < 0 || bucket >= BucketsCount {
+		return 0, fmt.Errorf("bucket %d out of range [0, %d)", bucket, BucketsCount)
+	}
+	shard := shards[bucket]
+	if shard == UnassignedShard {
+		return 0, fmt.Errorf("bucket %d: %w", bucket, ErrBucketUnassigned)
+	}
+	return shard, nil
+}
+
+// SetShard закрепляет бакет за шардом, UnassignedShard снимает закрепление
+func (m *BucketMap) SetShard(bucket int, shard int) {
+	m.shards[bucket] = shard
+}
+
+// SetStockShard закрепляет бакет стоков за шардом
+func (m *BucketMap) SetStockShard(bucket int, shard int) {
+	m.stockShards[bucket] = shard
+}
+
+// IsReadOnly сообщает, запрещена ли запись в бакет заказов
+func (m *BucketMap) IsReadOnly(bucket int) bool {
+	return m.readOnly[bucket]
+}
+
+// SetReadOnly запрещает или разрешает запись в бакет заказов
+func (m *BucketMap) SetReadOnly(bucket int, readOnly bool) {
+	m.readOnly[bucket] = readOnly
+}
+
+// IsStockReadOnly сообщает, запрещена ли запись в бакет стоков
+func (m *BucketMap) IsStockReadOnly(bucket int) bool {
+	return m.stockReadOnly[bucket]
+}
+
+// SetStockReadOnly запрещает или разрешает запись в бакет стоков
+func (m *BucketMap) SetStockReadOnly(bucket int, readOnly bool) {
+	m.stockReadOnly[bucket] = readOnly
+}
+
+// BucketFromUserId возвращает бакет пользователя
+func BucketFromUserId(userId int64) int {
+	return int(hashCode(strconv.FormatInt(userId, 10)) % BucketsCount)
+}
+
+// BucketFromSku возвращает бакет стока SKU
+func BucketFromSku(sku int64) int {
+	return int(sku % BucketsCount)
+}
+
+// BucketFromOrderId возвращает бакет заказа, совпадающий с бакетом пользователя, оформившего заказ
+func BucketFromOrderId(orderID int64) int {
+	return int(orderID % BucketsCount)
+}
+
+// LegacyOrderBuckets число первых бакетов, в которые попадают номера заказов, выданные до перехода на бакеты:
+// последовательности шардов 0 и 1 выдавали ID с шагом 1000, начиная с 1000 и 1001
+const LegacyOrderBuckets = 2
+
+// MayBeLegacyOrderId сообщает, мог ли ID заказа быть выдан до перехода на бакеты. Такой заказ лежит на шарде бакета
+// пользователя, а не бакета из ID, так как решардинг переносит заказы вместе с пользователем
+func MayBeLegacyOrderId(orderID int64) bool {
+	return BucketFromOrderId(orderID) < LegacyOrderBuckets
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitBreaker выводит реплику из ротации после threshold сбоев подряд.
+// По истечении cooldown реплика снова получает запросы: первый успех возвращает ее в ротацию,
+// первый сбой выводит еще на cooldown
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow сообщает, можно ли отправить запрос на реплику
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !time.Now().Before(b.openUntil)
+}
+
+// Success сбрасывает счетчик сбоев. Возвращает true, если реплика была выведена из ротации
+func (b *CircuitBreaker) Success() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ejected := b.failures >= b.threshold
+	b.failures = 0
+	b.openUntil = time.Time{}
+	return ejected
+}
+
+// Failure учитывает сбой реплики. Возвращает true, если реплика только что выведена из ротации:
+// после threshold сбоев подряд или после неудачной пробы по истечении cooldown
+func (b *CircuitBreaker) Failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures < b.threshold {
+		return false
+	}
+	now := time.Now()
+	// сбой запроса, начатого до вывода из ротации, продлевает cooldown, но повторно не выводит
+	alreadyOpen := now.Before(b.openUntil)
+	b.openUntil = now.Add(b.cooldown)
+	return !alreadyOpen
+}
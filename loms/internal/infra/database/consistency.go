@@ -0,0 +1,235 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"route256/loms/internal/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInvalidConsistencyToken токен согласованности не удалось разобрать
+var ErrInvalidConsistencyToken = errors.New("invalid consistency token")
+
+// ConsistencyToken позиция WAL шарда после коммита записи. Чтение с таким токеном уходит на реплику,
+// только если она уже воспроизвела WAL до этой позиции, иначе на мастер
+type ConsistencyToken struct {
+	Shard int
+	LSN   uint64
+}
+
+// String кодирует токен в виде "<шард>:<LSN в формате pg_lsn>"
+func (t ConsistencyToken) String() string {
+	return fmt.Sprintf("%d:%s", t.Shard, FormatLSN(t.LSN))
+}
+
+func ParseConsistencyToken(token string) (ConsistencyToken, error) {
+	var shard int
+	var hi, lo uint32
+	var tail string
+	n, _ := fmt.Sscanf(token, "%d:%X/%X%s", &shard, &hi, &lo, &tail)
+	if n != 3 || shard < 0 {
+		return ConsistencyToken{}, fmt.Errorf("%q: %w", token, ErrInvalidConsistencyToken)
+	}
+	return ConsistencyToken{Shard: shard, LSN: uint64(hi)<<32 | uint64(lo)}, nil
+}
+
+// ParseLSN разбирает позицию WAL в текстовом формате pg_lsn, например 16/B374D848
+func ParseLSN(lsn string) (uint64, error) {
+	var hi, lo uint32
+	if _, err := fmt.Sscanf(lsn, "%X/%X", &hi, &lo); err != nil {
+		return 0, fmt.Errorf("lsn %q: %w", lsn, err)
+	}
+	return uint64(hi)<<32 | uint64(lo), nil
+}
+
+func FormatLSN(lsn uint64) string {
+	return fmt.Sprintf("%X/%X", uint32(lsn>>32), uint32(lsn))
+}
+
+type consistencyTokenKey struct{}
+
+// WithConsistencyToken требует, чтобы чтения в ctx видели запись, после которой выдан token
+func WithConsistencyToken(ctx context.Context, token ConsistencyToken) context.Context {
+	return context.WithValue(ctx, consistencyTokenKey{}, token)
+}
+
+func consistencyTokenFromContext(ctx context.Context) (ConsistencyToken, bool) {
+	token, ok := ctx.Value(consistencyTokenKey{}).(ConsistencyToken)
+	return token, ok
+}
+
+// CommitTracker запоминает позицию WAL после последнего коммита на шарде пользователя в рамках запроса
+type CommitTracker struct {
+	mu    sync.Mutex
+	token ConsistencyToken
+	set   bool
+}
+
+// Token возвращает токен последнего отслеженного коммита
+func (t *CommitTracker) Token() (ConsistencyToken, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.token, t.set
+}
+
+func (t *CommitTracker) record(token ConsistencyToken) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = token
+	t.set = true
+}
+
+type commitTrackerKey struct{}
+
+// TrackCommits возвращает контекст, в котором коммиты через TrackCommit запоминаются в трекере
+func TrackCommits(ctx context.Context) (context.Context, *CommitTracker) {
+	tracker := &CommitTracker{}
+	return context.WithValue(ctx, commitTrackerKey{}, tracker), tracker
+}
+
+// TrackCommit запоминает текущую позицию WAL мастера после коммита, если в ctx есть трекер.
+// Позиция не меньше позиции записи коммита, поэтому реплика, дошедшая до нее, видит закоммиченные данные
+func (fc *FallbackConnection) TrackCommit(ctx context.Context) {
+	tracker, ok := ctx.Value(commitTrackerKey{}).(*CommitTracker)
+	if !ok || fc.currentConn == nil {
+		return
+	}
+	var lsn string
+	if err := fc.currentConn.QueryRow(ctx, "SELECT pg_current_wal_lsn()::text").Scan(&lsn); err != nil {
+		log.Printf("[infra] Unable to read commit LSN of shard %d: %v", fc.shard, err)
+		return
+	}
+	parsed, err := ParseLSN(lsn)
+	if err != nil {
+		log.Printf("[infra] Unable to read commit LSN of shard %d: %v", fc.shard, err)
+		return
+	}
+	tracker.record(ConsistencyToken{Shard: fc.shard, LSN: parsed})
+}
+
+// replicaLag последнее измеренное отставание реплики шарда
+type replicaLag struct {
+	// lag отставание в наносекундах, отрицательное значение - отставание неизвестно
+	lag       atomic.Int64
+	replayLSN atomic.Uint64
+}
+
+func newReplicaLag() *replicaLag {
+	l := &replicaLag{}
+	l.lag.Store(-1)
+	return l
+}
+
+// fresh сообщает, можно ли читать с реплики при допустимом отставании maxLag и токене согласованности
+func (l *replicaLag) fresh(maxLag time.Duration, token ConsistencyToken, hasToken bool) bool {
+	lag := l.lag.Load()
+	if lag < 0 || time.Duration(lag) > maxLag {
+		return false
+	}
+	return !hasToken || l.replayLSN.Load() >= token.LSN
+}
+
+// ReplicaLag отставание реплики: ноль, если она воспроизвела WAL до позиции мастера primaryLSN,
+// иначе время с момента коммита последней воспроизведенной транзакции
+func ReplicaLag(primaryLSN, replayLSN uint64, sinceLastReplay time.Duration) time.Duration {
+	if replayLSN >= primaryLSN {
+		return 0
+	}
+	return sinceLastReplay
+}
+
+// SetMaxReplicaLag задает допустимое отставание реплик, с которым они получают чтения
+func (db *DBRouter) SetMaxReplicaLag(maxLag time.Duration) {
+	db.maxReplicaLag.Store(int64(maxLag))
+}
+
+// RecordReplicaLag запоминает измеренные позицию воспроизведения и отставание реплики шарда.
+// Отрицательное lag означает, что отставание неизвестно, и реплика не получает чтений
+func (db *DBRouter) RecordReplicaLag(shardIndex int, replayLSN uint64, lag time.Duration) {
+	state := db.replicaLags[shardIndex]
+	if lag < 0 {
+		state.lag.Store(-1)
+		return
+	}
+	state.replayLSN.Store(replayLSN)
+	state.lag.Store(int64(lag))
+	metrics.RecordReplicaLag(shardIndex, lag)
+}
+
+// WatchReplicaLag раз в interval измеряет отставание реплик от мастеров. Реплика, отстающая больше maxLag
+// или с неизвестным отставанием, не получает чтений, пока не догонит мастер
+func (db *DBRouter) WatchReplicaLag(ctx context.Context, interval, maxLag time.Duration) {
+	db.SetMaxReplicaLag(maxLag)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for shardIndex := range db.shards {
+			db.measureReplicaLag(ctx, shardIndex)
+		}
+		select {
+		case <-ctx.Done():
+			log.Println("[infra] Stopping replica lag watcher")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// measureReplicaLag сравнивает позицию воспроизведения реплики с позицией WAL мастера, прочитанной раньше нее.
+// Реплика, которая перестала получать WAL, отстает от мастера, даже если воспроизвела все полученное
+func (db *DBRouter) measureReplicaLag(ctx context.Context, shardIndex int) {
+	master, replica := db.shards[shardIndex][0], db.shards[shardIndex][1]
+	if replica == nil {
+		return
+	}
+
+	var primaryLSN string
+	if err := master.QueryRow(ctx, "SELECT pg_current_wal_lsn()::text").Scan(&primaryLSN); err != nil {
+		db.replicaLagUnknown(ctx, shardIndex, fmt.Errorf("unable to read master WAL position: %w", err))
+		return
+	}
+	var replayLSN *string
+	var sinceLastReplay *float64
+	err := replica.QueryRow(ctx, `SELECT pg_last_wal_replay_lsn()::text,
+		EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())::float8`).Scan(&replayLSN, &sinceLastReplay)
+	if err != nil {
+		db.replicaLagUnknown(ctx, shardIndex, err)
+		return
+	}
+	if replayLSN == nil {
+		db.replicaLagUnknown(ctx, shardIndex, errors.New("server is not in recovery"))
+		return
+	}
+
+	primary, err := ParseLSN(primaryLSN)
+	if err != nil {
+		db.replicaLagUnknown(ctx, shardIndex, err)
+		return
+	}
+	replayed, err := ParseLSN(*replayLSN)
+	if err != nil {
+		db.replicaLagUnknown(ctx, shardIndex, err)
+		return
+	}
+	if replayed < primary && sinceLastReplay == nil {
+		db.replicaLagUnknown(ctx, shardIndex, errors.New("replica has not replayed any transaction yet"))
+		return
+	}
+	var since time.Duration
+	if sinceLastReplay != nil {
+		since = time.Duration(*sinceLastReplay * float64(time.Second))
+	}
+	db.RecordReplicaLag(shardIndex, replayed, ReplicaLag(primary, replayed, since))
+}
+
+func (db *DBRouter) replicaLagUnknown(ctx context.Context, shardIndex int, err error) {
+	if ctx.Err() == nil {
+		log.Printf("[infra] Unable to measure lag of replica of shard %d: %v", shardIndex, err)
+	}
+	db.RecordReplicaLag(shardIndex, 0, -1)
+}
@@ -2,127 +2,156 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/spaolacci/murmur3"
 	"log"
-	"strconv"
+	"sync/atomic"
+	"time"
 )
 
-type FallbackConnection struct {
-	currentConn  *pgxpool.Conn
-	fallBackConn *pgxpool.Conn
+/*
+Структура которая может поддерживать работу с двумя базами данных, реализует метод для извлечения пула а так же
+методы для выполнения запросов к базе данных, то что нужно моему приложению в будущем можно расширить либо переходит на
+внешнюю балансировку
+*/
+type DBRouter struct {
+	shards     []*MasterAndReplica
+	countShard int
+	bucketMap  atomic.Pointer[BucketMap]
+	// replicaBreakers предохранители реплик по индексу шарда
+	replicaBreakers []*CircuitBreaker
+	// replicaLags отставание реплик по индексу шарда, измеряется WatchReplicaLag
+	replicaLags   []*replicaLag
+	maxReplicaLag atomic.Int64
 }
 
-func (fc *FallbackConnection) Begin(ctx context.Context) (pgx.Tx, error) {
-	return fc.currentConn.Begin(ctx)
-}
+type MasterAndReplica [2]*pgxpool.Pool
 
-func (fc *FallbackConnection) Release() {
-	if fc.currentConn != nil {
-		fc.currentConn.Release()
-	}
-	if fc.fallBackConn != nil {
-		fc.fallBackConn.Release()
-	}
-}
+// DefaultShardIndex шард по умолчанию для данных, не распределенных по бакетам
+const DefaultShardIndex = 0
 
-/*
-также хочу реализовать примитивно fallback поведение
-при ошибке на реплике, попробуем выполнить запрос на мастере
-проблема в том что любая ошибка будет расценена как ошибка на реплике и запроску к master
-может быть стоить различать ошибки бд и ошибки соединения, но пока хочу сделать по-простому
-*/
+const (
+	// ReplicaFailureThreshold число сбоев реплики подряд, после которого она выводится из ротации
+	ReplicaFailureThreshold = 5
+	// ReplicaEjectionPeriod время, на которое реплика выводится из ротации
+	ReplicaEjectionPeriod = 30 * time.Second
+)
 
-func (fc *FallbackConnection) Exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
-	tag, err := fc.currentConn.Exec(ctx, query, args...)
-	if err != nil {
-		fmt.Println("[infra] Detected connection error")
-		// Выполнить фоллбэк, если ошибка подключения
-		if fc.fallBackConn != nil {
-			return fc.fallBackConn.Exec(ctx, query, args...)
+// NewDBRouter masterDB mustn't nil, and replicaDB can be nil.
+// Шард пользователя и заказа выбирается через bucketMap, обе маршрутизации проходят через одну карту
+func NewDBRouter(shards []*MasterAndReplica, bucketMap *BucketMap) *DBRouter {
+	db := &DBRouter{shards: shards, countShard: len(shards)}
+	db.replicaBreakers = make([]*CircuitBreaker, len(shards))
+	db.replicaLags = make([]*replicaLag, len(shards))
+	for i := range shards {
+		db.replicaBreakers[i] = NewCircuitBreaker(ReplicaFailureThreshold, ReplicaEjectionPeriod)
+		db.replicaLags[i] = newReplicaLag()
+	}
+	db.bucketMap.Store(bucketMap)
+	return db
+}
+
+// SetBucketMap атомарно подменяет карту бакетов, уже выданные соединения остаются на прежних шардах
+func (db *DBRouter) SetBucketMap(bucketMap *BucketMap) {
+	db.bucketMap.Store(bucketMap)
+}
+
+// WatchBucketMap раз в interval перечитывает карту бакетов из файла и подменяет ее при изменении.
+// Так работающий сервис видит read-only бакеты и переключение карты при решардинге
+func (db *DBRouter) WatchBucketMap(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("[infra] Stopping bucket map watcher")
+			return
+		case <-ticker.C:
+			bucketMap, err := LoadBucketMap(path, db.countShard)
+			if err != nil {
+				log.Printf("[infra] Failed to reload bucket map, keeping the current one: %v", err)
+				continue
+			}
+			if *bucketMap != *db.bucketMap.Load() {
+				db.SetBucketMap(bucketMap)
+				log.Printf("[infra] Bucket map reloaded from %s", path)
+			}
 		}
 	}
-	return tag, err
 }
 
-func (fc *FallbackConnection) Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error) {
-	rows, err := fc.currentConn.Query(ctx, query, args...)
+// PickConnFromUserId выбирает шард пользователя. Соединение на запись в бакет,
+// который переносится на другой шард, не выдается - возвращается ErrBucketReadOnly
+func (db *DBRouter) PickConnFromUserId(ctx context.Context, userId int64, readOnlyOperation bool) (*FallbackConnection, error) {
+	bucketMap := db.bucketMap.Load()
+	bucket := BucketFromUserId(userId)
+	if !readOnlyOperation && bucketMap.IsReadOnly(bucket) {
+		return &FallbackConnection{}, fmt.Errorf("user %d, bucket %d: %w", userId, bucket, ErrBucketReadOnly)
+	}
+	shardIndex, err := bucketMap.ShardIndex(bucket)
 	if err != nil {
-		fmt.Println("[infra] Detected connection error")
-		if fc.fallBackConn != nil {
-			return fc.fallBackConn.Query(ctx, query, args...)
-		}
+		return &FallbackConnection{}, fmt.Errorf("user %d: %w", userId, err)
 	}
-	return rows, err
+	return db.pickConnectionFromShards(ctx, shardIndex, readOnlyOperation)
 }
 
-func (fc *FallbackConnection) QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row {
-	return &fallbackRow{
-		currentRow:   fc.currentConn.QueryRow(ctx, query, args...),
-		fallBackConn: fc.fallBackConn,
-		ctx:          ctx,
-		query:        query,
-		args:         args,
+// ShardIndexFromUserId возвращает индекс шарда, на котором лежат заказы пользователя
+func (db *DBRouter) ShardIndexFromUserId(userId int64) (int, error) {
+	shardIndex, err := db.bucketMap.Load().ShardIndex(BucketFromUserId(userId))
+	if err != nil {
+		return 0, fmt.Errorf("user %d: %w", userId, err)
 	}
+	return shardIndex, nil
 }
 
-// fallbackRow структура обертки для Row с fallback
-type fallbackRow struct {
-	currentRow   pgx.Row
-	fallBackConn *pgxpool.Conn
-	ctx          context.Context
-	query        string
-	args         []interface{}
-}
-
-// Scan метод для сканирования данных с fallback
-func (f *fallbackRow) Scan(dest ...interface{}) error {
-	// Выполняем первичное сканирование
-	err := f.currentRow.Scan(dest...)
+func (db *DBRouter) PickConnFromOrderId(ctx context.Context, orderID int64, readOnlyOperation bool) (*FallbackConnection, error) {
+	shardIndex, err := db.bucketMap.Load().ShardIndex(BucketFromOrderId(orderID))
 	if err != nil {
-		if f.fallBackConn != nil {
-			fmt.Println("[infra] Fallback to master database")
-			masterRow := f.fallBackConn.QueryRow(f.ctx, f.query, f.args...)
-			return masterRow.Scan(dest...)
-		}
+		return &FallbackConnection{}, fmt.Errorf("order %d: %w", orderID, err)
 	}
-	return err
-}
-
-/*
-Структура которая может поддерживать работу с двумя базами данных, реализует метод для извлечения пула а так же
-методы для выполнения запросов к базе данных, то что нужно моему приложению в будущем можно расширить либо переходит на
-внешнюю балансировку
-*/
-type DBRouter struct {
-	shards     []*MasterAndReplica
-	countShard int
-}
-
-type MasterAndReplica [2]*pgxpool.Pool
-
-// NewDBRouter masterDB mustn't nil, and replicaDB can be nil
-func NewDBRouter(shards []*MasterAndReplica) *DBRouter {
-	return &DBRouter{shards: shards, countShard: len(shards)}
+	return db.pickConnectionFromShards(ctx, shardIndex, readOnlyOperation)
 }
 
-func (db *DBRouter) PickConnFromUserId(ctx context.Context, userId int64, readOnlyOperation bool) (*FallbackConnection, error) {
-	shardKey := strconv.FormatInt(userId, 10)
-	hash := hashCode(shardKey)
-	shardIndex := int(hash) % db.countShard
+// PickConnFromSkus выбирает шард стоков набора SKU. Все SKU должны лежать на одном шарде,
+// иначе возвращается ErrSkusSpanShards
+func (db *DBRouter) PickConnFromSkus(ctx context.Context, skus []int64, readOnlyOperation bool) (*FallbackConnection, error) {
+	if len(skus) == 0 {
+		return &FallbackConnection{}, errors.New("no skus to pick a shard for")
+	}
+	shardIndex := UnassignedShard
+	for _, sku := range skus {
+		skuShardIndex, err := db.ShardIndexFromSku(sku, readOnlyOperation)
+		if err != nil {
+			return &FallbackConnection{}, err
+		}
+		if shardIndex != UnassignedShard && skuShardIndex != shardIndex {
+			return &FallbackConnection{}, fmt.Errorf("skus %v: %w", skus, ErrSkusSpanShards)
+		}
+		shardIndex = skuShardIndex
+	}
 	return db.pickConnectionFromShards(ctx, shardIndex, readOnlyOperation)
 }
 
-func (db *DBRouter) PickConnFromOrderId(ctx context.Context, orderID int64, readOnlyOperation bool) (*FallbackConnection, error) {
-	shardIndex := orderID % 1000
-	return db.pickConnectionFromShards(ctx, int(shardIndex), readOnlyOperation)
+// ShardIndexFromSku возвращает индекс шарда, на котором лежит сток SKU, по карте стоков.
+// Для записи в бакет, который переносится на другой шард, возвращается ErrBucketReadOnly
+func (db *DBRouter) ShardIndexFromSku(sku int64, readOnlyOperation bool) (int, error) {
+	bucketMap := db.bucketMap.Load()
+	bucket := BucketFromSku(sku)
+	if !readOnlyOperation && bucketMap.IsStockReadOnly(bucket) {
+		return 0, fmt.Errorf("sku %d, bucket %d: %w", sku, bucket, ErrBucketReadOnly)
+	}
+	shardIndex, err := bucketMap.StockShardIndex(bucket)
+	if err != nil {
+		return 0, fmt.Errorf("sku %d: %w", sku, err)
+	}
+	return shardIndex, nil
 }
 
 func (db *DBRouter) PickDefaultShard(ctx context.Context, readOnlyOperation bool) (*FallbackConnection, error) {
-	return db.pickConnectionFromShards(ctx, 0, readOnlyOperation)
+	return db.pickConnectionFromShards(ctx, DefaultShardIndex, readOnlyOperation)
 }
 
 func (db *DBRouter) PickAllShards(ctx context.Context, readOnlyOperation bool) ([]*FallbackConnection, error) {
@@ -137,23 +166,53 @@ func (db *DBRouter) PickAllShards(ctx context.Context, readOnlyOperation bool) (
 	return connections, nil
 }
 
+// CountShards возвращает число шардов
+func (db *DBRouter) CountShards() int {
+	return db.countShard
+}
+
+// PickShard выдает соединение с шардом по его индексу
+func (db *DBRouter) PickShard(ctx context.Context, shardIndex int, readOnlyOperation bool) (*FallbackConnection, error) {
+	if shardIndex < 0 || shardIndex >= db.countShard {
+		return &FallbackConnection{}, fmt.Errorf("shard %d is out of range [0, %d)", shardIndex, db.countShard)
+	}
+	return db.pickConnectionFromShards(ctx, shardIndex, readOnlyOperation)
+}
+
+// pickConnectionFromShards выдает соединение с мастером шарда, а для чтения - с репликой и мастером в запасе.
+// Реплика пропускается, если она выведена из ротации предохранителем, отстает от мастера больше допустимого
+// или еще не дошла до токена согласованности из ctx
 func (db *DBRouter) pickConnectionFromShards(ctx context.Context, shardIndex int, readOnlyOperation bool) (*FallbackConnection, error) {
 	masterConn, err := db.shards[shardIndex][0].Acquire(ctx)
 	if err != nil {
 		return &FallbackConnection{}, err
 	}
-	var replicaConn *pgxpool.Conn
-	if readOnlyOperation && db.shards[shardIndex][1] != nil {
-		replicaConn, err = db.shards[shardIndex][1].Acquire(ctx)
-		if err != nil {
-			log.Println(err)
-			return &FallbackConnection{currentConn: masterConn}, nil
+	replica := db.shards[shardIndex][1]
+	breaker := db.replicaBreakers[shardIndex]
+	if !readOnlyOperation || replica == nil || !breaker.Allow() || !db.ReplicaFresh(ctx, shardIndex) {
+		return &FallbackConnection{currentConn: masterConn, shard: shardIndex}, nil
+	}
+
+	replicaConn, err := replica.Acquire(ctx)
+	if err != nil {
+		log.Printf("[infra] Unable to acquire replica of shard %d: %v", shardIndex, err)
+		fc := &FallbackConnection{currentConn: masterConn, breaker: breaker, shard: shardIndex}
+		if ctx.Err() == nil {
+			fc.recordFailure(err)
 		}
-	} else {
-		return &FallbackConnection{currentConn: masterConn}, nil
+		return fc, nil
 	}
+	return NewReplicaConnection(replicaConn, masterConn, breaker, shardIndex), nil
+}
 
-	return &FallbackConnection{currentConn: replicaConn, fallBackConn: masterConn}, nil
+// ReplicaFresh сообщает, достаточно ли свежи данные реплики шарда для чтения в ctx.
+// Токен другого шарда, например выданный до переноса бакета, свежесть реплики не подтверждает
+func (db *DBRouter) ReplicaFresh(ctx context.Context, shardIndex int) bool {
+	token, hasToken := consistencyTokenFromContext(ctx)
+	if hasToken && token.Shard != shardIndex {
+		return false
+	}
+	return db.replicaLags[shardIndex].fresh(time.Duration(db.maxReplicaLag.Load()), token, hasToken)
 }
 
 func hashCode(key string) uint32 {
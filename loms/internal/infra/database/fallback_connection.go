@@ -0,0 +1,235 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"route256/loms/internal/metrics"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Conn соединение с одним сервером шарда, его реализует *pgxpool.Conn
+type Conn interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row
+	Release()
+}
+
+var _ Conn = (*pgxpool.Conn)(nil)
+
+// FallbackConnection соединение с шардом. Для чтения с реплики хранит соединение с мастером,
+// на который запрос повторяется, если отказала сама реплика. Ошибки SQL и отмена контекста на мастер не повторяются
+type FallbackConnection struct {
+	currentConn  Conn
+	fallBackConn Conn
+	// breaker предохранитель реплики, задан вместе с fallBackConn
+	breaker *CircuitBreaker
+	shard   int
+}
+
+// NewReplicaConnection соединение для чтения с реплики шарда shard с повтором запроса на мастере при отказе реплики
+func NewReplicaConnection(replica, master Conn, breaker *CircuitBreaker, shard int) *FallbackConnection {
+	return &FallbackConnection{currentConn: replica, fallBackConn: master, breaker: breaker, shard: shard}
+}
+
+func (fc *FallbackConnection) Begin(ctx context.Context) (pgx.Tx, error) {
+	return fc.currentConn.Begin(ctx)
+}
+
+func (fc *FallbackConnection) Release() {
+	if fc.currentConn != nil {
+		fc.currentConn.Release()
+	}
+	if fc.fallBackConn != nil {
+		fc.fallBackConn.Release()
+	}
+}
+
+func (fc *FallbackConnection) Exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
+	tag, err := fc.currentConn.Exec(ctx, query, args...)
+	if !fc.shouldFallback(ctx, err) {
+		return tag, err
+	}
+	return fc.fallBackConn.Exec(ctx, query, args...)
+}
+
+// Query выполняет запрос на текущем соединении. Сбой реплики до первой прочитанной строки
+// незаметно для вызывающего повторяет запрос на мастере, сбой посреди чтения возвращается через rows.Err()
+func (fc *FallbackConnection) Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error) {
+	rows, err := fc.currentConn.Query(ctx, query, args...)
+	if err != nil {
+		if !fc.shouldFallback(ctx, err) {
+			return rows, err
+		}
+		if rows != nil {
+			rows.Close()
+		}
+		return fc.fallBackConn.Query(ctx, query, args...)
+	}
+	if fc.fallBackConn == nil {
+		return rows, nil
+	}
+	return &fallbackRows{Rows: rows, fc: fc, ctx: ctx, query: query, args: args}, nil
+}
+
+func (fc *FallbackConnection) QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row {
+	return &fallbackRow{
+		currentRow: fc.currentConn.QueryRow(ctx, query, args...),
+		fc:         fc,
+		ctx:        ctx,
+		query:      query,
+		args:       args,
+	}
+}
+
+// shouldFallback учитывает результат запроса к реплике в ее предохранителе
+// и сообщает, нужно ли повторить запрос на мастере
+func (fc *FallbackConnection) shouldFallback(ctx context.Context, err error) bool {
+	if fc.fallBackConn == nil {
+		return false
+	}
+	if err == nil {
+		fc.recordSuccess()
+		return false
+	}
+	// срок запроса истек или его отменили: повтор на мастере тоже не успеет
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if !IsReplicaFailure(err) {
+		// ошибка SQL повторится и на мастере, а реплика при этом исправна
+		fc.recordSuccess()
+		return false
+	}
+	fc.recordFailure(err)
+	log.Printf("[infra] Replica of shard %d failed, falling back to master: %v", fc.shard, err)
+	return true
+}
+
+func (fc *FallbackConnection) recordSuccess() {
+	if fc.breaker.Success() {
+		log.Printf("[infra] Replica of shard %d is back in rotation", fc.shard)
+		metrics.RecordReplicaEjection(fc.shard, false)
+	}
+}
+
+func (fc *FallbackConnection) recordFailure(err error) {
+	metrics.RecordReplicaFailure(fc.shard)
+	if fc.breaker.Failure() {
+		log.Printf("[infra] Replica of shard %d is ejected from rotation for %s: %v", fc.shard, fc.breaker.cooldown, err)
+		metrics.RecordReplicaEjection(fc.shard, true)
+	}
+}
+
+// IsReplicaFailure отличает отказ реплики (сеть, соединение, остановка или перегрузка сервера,
+// конфликт с восстановлением на standby) от ошибки самого запроса, которая повторится на мастере
+func IsReplicaFailure(err error) bool {
+	if err == nil || errors.Is(err, pgx.ErrNoRows) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case strings.HasPrefix(pgErr.Code, "08"), // connection_exception
+			strings.HasPrefix(pgErr.Code, "53"),  // insufficient_resources
+			strings.HasPrefix(pgErr.Code, "57P"), // admin_shutdown, crash_shutdown, cannot_connect_now
+			pgErr.Code == "40001":                // на реплике - отмена запроса из-за конфликта с восстановлением
+			return true
+		}
+		return false
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		pgconn.Timeout(err) ||
+		pgconn.SafeToRetry(err)
+}
+
+// fallbackRows обертка над строками реплики, которая проверяет rows.Err() по окончании чтения
+type fallbackRows struct {
+	pgx.Rows
+	fc    *FallbackConnection
+	ctx   context.Context
+	query string
+	args  []interface{}
+	// read вызывающий уже получил строки, повтор на мастере вернул бы их еще раз
+	read bool
+	// onMaster запрос уже повторен на мастере
+	onMaster bool
+	err      error
+}
+
+func (r *fallbackRows) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	if r.Rows.Next() {
+		r.read = true
+		return true
+	}
+	if r.onMaster {
+		return false
+	}
+
+	err := r.Rows.Err()
+	if r.read {
+		if err != nil && IsReplicaFailure(err) && r.ctx.Err() == nil {
+			r.fc.recordFailure(err)
+		} else if err == nil {
+			r.fc.recordSuccess()
+		}
+		return false
+	}
+	if !r.fc.shouldFallback(r.ctx, err) {
+		return false
+	}
+
+	r.Rows.Close()
+	r.onMaster = true
+	rows, err := r.fc.fallBackConn.Query(r.ctx, r.query, r.args...)
+	if err != nil {
+		if rows != nil {
+			rows.Close()
+		}
+		r.err = err
+		return false
+	}
+	r.Rows = rows
+	return r.Next()
+}
+
+func (r *fallbackRows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.Rows.Err()
+}
+
+// fallbackRow структура обертки для Row с fallback
+type fallbackRow struct {
+	currentRow pgx.Row
+	fc         *FallbackConnection
+	ctx        context.Context
+	query      string
+	args       []interface{}
+}
+
+// Scan метод для сканирования данных с fallback
+func (f *fallbackRow) Scan(dest ...interface{}) error {
+	err := f.currentRow.Scan(dest...)
+	if !f.fc.shouldFallback(f.ctx, err) {
+		return err
+	}
+	return f.fc.fallBackConn.QueryRow(f.ctx, f.query, f.args...).Scan(dest...)
+}
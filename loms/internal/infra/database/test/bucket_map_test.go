@@ -0,0 +1,154 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	appErr "route256/loms/internal/errors"
+	"route256/loms/internal/infra/database"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketMap_OrderAndUserShareShard(t *testing.T) {
+	bucketMap := database.NewModuloBucketMap(2)
+
+	for userId := int64(1); userId < 1000; userId++ {
+		bucket := database.BucketFromUserId(userId)
+		// так ID заказа формирует запрос SaveOrder
+		orderID := 42*database.BucketsCount + int64(bucket)
+
+		userShard, err := bucketMap.ShardIndex(bucket)
+		require.NoError(t, err)
+		orderShard, err := bucketMap.ShardIndex(database.BucketFromOrderId(orderID))
+		require.NoError(t, err)
+		assert.Equal(t, userShard, orderShard)
+	}
+}
+
+func TestBucketMap_UnassignedBucket(t *testing.T) {
+	bucketMap := database.NewModuloBucketMap(2)
+	bucketMap.SetShard(7, database.UnassignedShard)
+
+	_, err := bucketMap.ShardIndex(database.BucketFromOrderId(3007))
+	assert.ErrorIs(t, err, database.ErrBucketUnassigned)
+}
+
+func TestBucketMap_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bucket-map.json")
+	bucketMap := database.NewModuloBucketMap(3)
+	bucketMap.SetShard(999, database.UnassignedShard)
+	bucketMap.SetReadOnly(5, true)
+	bucketMap.SetStockShard(10, 2)
+	bucketMap.SetStockReadOnly(11, true)
+
+	require.NoError(t, bucketMap.Save(path))
+	loaded, err := database.LoadBucketMap(path, 3)
+	require.NoError(t, err)
+	assert.Equal(t, bucketMap, loaded)
+}
+
+func TestBucketMap_LoadInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "not enough buckets", content: `{"shards": [0, 1]}`},
+		{name: "not json", content: "0-999:0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "bucket-map.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o644))
+			_, err := database.LoadBucketMap(path, 2)
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("unknown shard", func(t *testing.T) {
+		path := filepath.Join(dir, "bucket-map.json")
+		bucketMap := database.NewModuloBucketMap(3)
+		require.NoError(t, bucketMap.Save(path))
+		_, err := database.LoadBucketMap(path, 2)
+		assert.Error(t, err)
+	})
+}
+
+func TestDBRouter_ReadOnlyBucket(t *testing.T) {
+	const userId = 42
+	bucketMap := database.NewModuloBucketMap(2)
+	bucketMap.SetReadOnly(database.BucketFromUserId(userId), true)
+	// до выбора соединения дело не доходит, поэтому пулы не нужны
+	router := database.NewDBRouter([]*database.MasterAndReplica{{}, {}}, bucketMap)
+
+	_, err := router.PickConnFromUserId(context.Background(), userId, false)
+	assert.ErrorIs(t, err, database.ErrBucketReadOnly)
+	assert.ErrorIs(t, err, appErr.ErrUnavailable)
+
+	router.SetBucketMap(database.NewModuloBucketMap(2))
+	shardIndex, err := router.ShardIndexFromUserId(userId)
+	assert.NoError(t, err)
+	assert.Equal(t, database.BucketFromUserId(userId)%2, shardIndex)
+}
+
+func TestDBRouter_StockOnDefaultShard(t *testing.T) {
+	router := database.NewDBRouter([]*database.MasterAndReplica{{}, {}}, database.NewModuloBucketMap(2))
+
+	// пока стоки не разложены по шардам, они лежат на шарде 0 независимо от карты заказов
+	for _, sku := range []int64{1, 1002, 1003, 773297411} {
+		shardIndex, err := router.ShardIndexFromSku(sku, false)
+		assert.NoError(t, err)
+		assert.Equal(t, database.DefaultShardIndex, shardIndex)
+	}
+}
+
+func TestBucketMap_LoadWithoutStockShards(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bucket-map.json")
+	shards := make([]string, database.BucketsCount)
+	for bucket := range shards {
+		shards[bucket] = strconv.Itoa(bucket % 2)
+	}
+	content := `{"shards": [` + strings.Join(shards, ",") + `], "read_only": [3]}`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	bucketMap, err := database.LoadBucketMap(path, 2)
+	require.NoError(t, err)
+	shardIndex, err := bucketMap.StockShardIndex(3)
+	require.NoError(t, err)
+	assert.Equal(t, database.DefaultShardIndex, shardIndex)
+	assert.True(t, bucketMap.IsReadOnly(3))
+	assert.False(t, bucketMap.IsStockReadOnly(3))
+}
+
+func TestDBRouter_StockReadOnlyBucket(t *testing.T) {
+	bucketMap := database.NewModuloBucketMap(2)
+	bucketMap.SetStockReadOnly(database.BucketFromSku(1003), true)
+	router := database.NewDBRouter([]*database.MasterAndReplica{{}, {}}, bucketMap)
+
+	_, err := router.ShardIndexFromSku(1003, false)
+	assert.ErrorIs(t, err, database.ErrBucketReadOnly)
+	_, err = router.ShardIndexFromSku(1003, true)
+	assert.NoError(t, err)
+	// бакет заказов с тем же номером доступен на запись
+	_, err = router.ShardIndexFromUserId(3)
+	assert.NoError(t, err)
+}
+
+func TestDBRouter_SkusSpanShards(t *testing.T) {
+	bucketMap := database.NewModuloBucketMap(2)
+	bucketMap.SetStockShard(database.BucketFromSku(1003), 1)
+	router := database.NewDBRouter([]*database.MasterAndReplica{{}, {}}, bucketMap)
+
+	shardIndex, err := router.ShardIndexFromSku(1003, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, shardIndex)
+
+	_, err = router.PickConnFromSkus(context.Background(), []int64{1002, 1003}, false)
+	assert.ErrorIs(t, err, database.ErrSkusSpanShards)
+}
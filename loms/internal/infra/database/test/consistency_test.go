@@ -0,0 +1,89 @@
+package test
+
+import (
+	"context"
+	"route256/loms/internal/infra/database"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsistencyToken_RoundTrip(t *testing.T) {
+	token := database.ConsistencyToken{Shard: 1, LSN: 0x16_B374D848}
+
+	assert.Equal(t, "1:16/B374D848", token.String())
+
+	parsed, err := database.ParseConsistencyToken(token.String())
+	require.NoError(t, err)
+	assert.Equal(t, token, parsed)
+}
+
+func TestParseConsistencyToken_Invalid(t *testing.T) {
+	for _, raw := range []string{"", "1", "1:16", "x:0/1", "-1:0/1", "1:0/1garbage"} {
+		_, err := database.ParseConsistencyToken(raw)
+		assert.ErrorIs(t, err, database.ErrInvalidConsistencyToken, raw)
+	}
+}
+
+func TestParseLSN(t *testing.T) {
+	lsn, err := database.ParseLSN("0/3000060")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0x3000060), lsn)
+	assert.Equal(t, "0/3000060", database.FormatLSN(lsn))
+}
+
+func TestReplicaLag(t *testing.T) {
+	tests := []struct {
+		name            string
+		primaryLSN      uint64
+		replayLSN       uint64
+		sinceLastReplay time.Duration
+		want            time.Duration
+	}{
+		{name: "caught up with idle master", primaryLSN: 100, replayLSN: 100, sinceLastReplay: time.Hour, want: 0},
+		{name: "replayed past measured position", primaryLSN: 100, replayLSN: 120, sinceLastReplay: time.Hour, want: 0},
+		{name: "stale replica that stopped receiving WAL", primaryLSN: 200, replayLSN: 100, sinceLastReplay: time.Hour, want: time.Hour},
+		{name: "replica behind an active master", primaryLSN: 200, replayLSN: 190, sinceLastReplay: 50 * time.Millisecond, want: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, database.ReplicaLag(tt.primaryLSN, tt.replayLSN, tt.sinceLastReplay))
+		})
+	}
+}
+
+func TestDBRouter_ReplicaFresh(t *testing.T) {
+	router := database.NewDBRouter([]*database.MasterAndReplica{{}, {}}, database.NewModuloBucketMap(2))
+	router.SetMaxReplicaLag(time.Second)
+	ctx := context.Background()
+
+	// пока отставание не измерено, реплика не получает чтений
+	assert.False(t, router.ReplicaFresh(ctx, 0))
+
+	router.RecordReplicaLag(0, 0x500, database.ReplicaLag(0x500, 0x500, time.Hour))
+	assert.True(t, router.ReplicaFresh(ctx, 0))
+
+	tests := []struct {
+		name  string
+		token database.ConsistencyToken
+		want  bool
+	}{
+		{name: "token replayed", token: database.ConsistencyToken{Shard: 0, LSN: 0x400}, want: true},
+		{name: "token ahead of replica", token: database.ConsistencyToken{Shard: 0, LSN: 0x600}, want: false},
+		{name: "token of another shard", token: database.ConsistencyToken{Shard: 1, LSN: 0x100}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, router.ReplicaFresh(database.WithConsistencyToken(ctx, tt.token), 0))
+		})
+	}
+
+	// реплика, переставшая получать WAL, отстает от мастера и выводится из чтений
+	router.RecordReplicaLag(0, 0x500, database.ReplicaLag(0x900, 0x500, time.Minute))
+	assert.False(t, router.ReplicaFresh(ctx, 0))
+
+	router.RecordReplicaLag(0, 0x900, -1)
+	assert.False(t, router.ReplicaFresh(ctx, 0))
+}
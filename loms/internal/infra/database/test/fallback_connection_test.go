@@ -0,0 +1,203 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"route256/loms/internal/infra/database"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsReplicaFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "no rows", err: pgx.ErrNoRows, want: false},
+		{name: "unique violation", err: &pgconn.PgError{Code: "23505"}, want: false},
+		{name: "syntax error", err: fmt.Errorf("query: %w", &pgconn.PgError{Code: "42601"}), want: false},
+		{name: "context canceled", err: context.Canceled, want: false},
+		{name: "admin shutdown", err: &pgconn.PgError{Code: "57P01"}, want: true},
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}, want: true},
+		{name: "too many connections", err: &pgconn.PgError{Code: "53300"}, want: true},
+		{name: "recovery conflict", err: &pgconn.PgError{Code: "40001"}, want: true},
+		{name: "unexpected eof", err: fmt.Errorf("read: %w", io.ErrUnexpectedEOF), want: true},
+		{name: "plain error", err: errors.New("cannot scan"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, database.IsReplicaFailure(tt.err))
+		})
+	}
+}
+
+func TestCircuitBreaker_EjectsAfterThreshold(t *testing.T) {
+	breaker := database.NewCircuitBreaker(3, time.Hour)
+
+	assert.False(t, breaker.Failure())
+	assert.False(t, breaker.Failure())
+	assert.True(t, breaker.Allow())
+	assert.True(t, breaker.Failure())
+	assert.False(t, breaker.Allow())
+
+	assert.True(t, breaker.Success())
+	assert.True(t, breaker.Allow())
+}
+
+func TestCircuitBreaker_SuccessResetsFailures(t *testing.T) {
+	breaker := database.NewCircuitBreaker(2, time.Hour)
+
+	breaker.Failure()
+	assert.False(t, breaker.Success())
+	assert.False(t, breaker.Failure())
+	assert.True(t, breaker.Allow())
+}
+
+func TestCircuitBreaker_ProbeAfterCooldown(t *testing.T) {
+	breaker := database.NewCircuitBreaker(1, 10*time.Millisecond)
+
+	assert.True(t, breaker.Failure())
+	assert.False(t, breaker.Allow())
+
+	time.Sleep(20 * time.Millisecond)
+	assert.True(t, breaker.Allow())
+	// неудачная проба снова выводит реплику из ротации
+	breaker.Failure()
+	assert.False(t, breaker.Allow())
+}
+
+func TestCircuitBreaker_ReejectsAfterFailedProbe(t *testing.T) {
+	breaker := database.NewCircuitBreaker(2, 10*time.Millisecond)
+
+	assert.False(t, breaker.Failure())
+	assert.True(t, breaker.Failure())
+	// сбой запроса, отправленного до вывода из ротации, не выводит реплику повторно
+	assert.False(t, breaker.Failure())
+
+	for i := 0; i < 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+		assert.True(t, breaker.Allow())
+		assert.True(t, breaker.Failure(), "failed probe %d must eject the replica again", i+1)
+		assert.False(t, breaker.Allow())
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	assert.True(t, breaker.Success())
+	assert.False(t, breaker.Failure())
+	assert.True(t, breaker.Allow())
+}
+
+// fakeRows строки одного запроса, err возвращается из Err() после того, как строки закончились
+type fakeRows struct {
+	pgx.Rows
+	values []int64
+	err    error
+	pos    int
+	closed bool
+}
+
+func (r *fakeRows) Next() bool {
+	if r.closed || r.pos >= len(r.values) {
+		r.closed = true
+		return false
+	}
+	r.pos++
+	return true
+}
+
+func (r *fakeRows) Scan(dest ...any) error {
+	*dest[0].(*int64) = r.values[r.pos-1]
+	return nil
+}
+
+func (r *fakeRows) Err() error {
+	if r.closed {
+		return r.err
+	}
+	return nil
+}
+
+func (r *fakeRows) Close() {
+	r.closed = true
+}
+
+// fakeConn соединение, которое на Query отдает заданные строки и считает запросы
+type fakeConn struct {
+	database.Conn
+	rows    *fakeRows
+	queries int
+}
+
+func (c *fakeConn) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	c.queries++
+	return c.rows, nil
+}
+
+func readAll(t *testing.T, rows pgx.Rows) ([]int64, error) {
+	t.Helper()
+	var values []int64
+	for rows.Next() {
+		var value int64
+		assert.NoError(t, rows.Scan(&value))
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+func TestFallbackRows_ReplicaFailsBeforeFirstRow(t *testing.T) {
+	replica := &fakeConn{rows: &fakeRows{err: io.ErrUnexpectedEOF}}
+	master := &fakeConn{rows: &fakeRows{values: []int64{1, 2, 3}}}
+	breaker := database.NewCircuitBreaker(1, time.Hour)
+	conn := database.NewReplicaConnection(replica, master, breaker, 0)
+
+	rows, err := conn.Query(context.Background(), "SELECT id FROM orders")
+	assert.NoError(t, err)
+	values, err := readAll(t, rows)
+
+	// обрыв реплики посреди запроса незаметен: строки читаются с мастера
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 2, 3}, values)
+	assert.Equal(t, 1, master.queries)
+	assert.False(t, breaker.Allow(), "Отказ реплики не учтен предохранителем")
+}
+
+func TestFallbackRows_ReplicaFailsAfterRowsRead(t *testing.T) {
+	replica := &fakeConn{rows: &fakeRows{values: []int64{1, 2}, err: io.ErrUnexpectedEOF}}
+	master := &fakeConn{rows: &fakeRows{values: []int64{1, 2, 3}}}
+	breaker := database.NewCircuitBreaker(1, time.Hour)
+	conn := database.NewReplicaConnection(replica, master, breaker, 0)
+
+	rows, err := conn.Query(context.Background(), "SELECT id FROM orders")
+	assert.NoError(t, err)
+	values, err := readAll(t, rows)
+
+	// повтор на мастере вернул бы прочитанные строки второй раз, поэтому ошибка возвращается вызывающему
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, []int64{1, 2}, values)
+	assert.Zero(t, master.queries)
+	assert.False(t, breaker.Allow(), "Отказ реплики не учтен предохранителем")
+}
+
+func TestFallbackRows_QueryErrorNotRetried(t *testing.T) {
+	sqlErr := &pgconn.PgError{Code: "42P01"}
+	replica := &fakeConn{rows: &fakeRows{err: sqlErr}}
+	master := &fakeConn{rows: &fakeRows{values: []int64{1}}}
+	breaker := database.NewCircuitBreaker(1, time.Hour)
+	conn := database.NewReplicaConnection(replica, master, breaker, 0)
+
+	rows, err := conn.Query(context.Background(), "SELECT id FROM missing")
+	assert.NoError(t, err)
+	values, err := readAll(t, rows)
+
+	assert.ErrorIs(t, err, sqlErr)
+	assert.Empty(t, values)
+	assert.Zero(t, master.queries)
+	assert.True(t, breaker.Allow())
+}
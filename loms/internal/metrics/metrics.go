@@ -3,6 +3,7 @@ package metrics
 import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"strconv"
 	"time"
 )
 
@@ -38,6 +39,53 @@ var (
 		},
 		[]string{"category", "status"},
 	)
+	ExpiredOrders = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "expired_orders_total",
+			Help: "Количество заказов, отмененных по истечении времени ожидания оплаты.",
+		},
+	)
+	ReplicaFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_replica_failures_total",
+			Help: "Количество сбоев реплик по шардам, после которых запрос повторялся на мастере.",
+		},
+		[]string{"shard"},
+	)
+	ReplicaEjected = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "db_replica_ejected",
+			Help: "Выведена ли реплика шарда из ротации предохранителем (1 - выведена).",
+		},
+		[]string{"shard"},
+	)
+	ReplicaLag = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "db_replica_lag_seconds",
+			Help: "Последнее измеренное отставание реплики шарда от мастера.",
+		},
+		[]string{"shard"},
+	)
+	OutboxEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outbox_events_total",
+			Help: "Количество попыток отправки событий outbox по результату (sent, retried, dead_lettered).",
+		},
+		[]string{"status"},
+	)
+	OutboxPurged = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "outbox_purged_events_total",
+			Help: "Количество отправленных событий outbox, удаленных по истечении срока хранения.",
+		},
+	)
+	ExpiryRuns = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "order_expiry_runs_total",
+			Help: "Количество проходов отмены просроченных заказов по статусу.",
+		},
+		[]string{"status"},
+	)
 )
 
 // RecordRequest записывает метрики для запросов к API
@@ -51,3 +99,44 @@ func RecordDBRequest(category, status string, duration time.Duration) {
 	DBRequests.WithLabelValues(category).Inc()
 	DBRequestDuration.WithLabelValues(category, status).Observe(duration.Seconds())
 }
+
+// RecordReplicaFailure записывает сбой реплики шарда
+func RecordReplicaFailure(shard int) {
+	ReplicaFailures.WithLabelValues(strconv.Itoa(shard)).Inc()
+}
+
+// RecordReplicaEjection записывает вывод реплики шарда из ротации и возврат в нее
+func RecordReplicaEjection(shard int, ejected bool) {
+	value := 0.0
+	if ejected {
+		value = 1
+	}
+	ReplicaEjected.WithLabelValues(strconv.Itoa(shard)).Set(value)
+}
+
+// RecordReplicaLag записывает отставание реплики шарда
+func RecordReplicaLag(shard int, lag time.Duration) {
+	ReplicaLag.WithLabelValues(strconv.Itoa(shard)).Set(lag.Seconds())
+}
+
+// RecordExpiryRun записывает метрики прохода отмены просроченных заказов
+func RecordExpiryRun(cancelled int, err error) {
+	if err != nil {
+		ExpiryRuns.WithLabelValues("error").Inc()
+		return
+	}
+	ExpiryRuns.WithLabelValues("ok").Inc()
+	ExpiredOrders.Add(float64(cancelled))
+}
+
+// RecordOutboxBatch записывает результат отправки пачки событий outbox
+func RecordOutboxBatch(sent, retried, deadLettered int) {
+	OutboxEvents.WithLabelValues("sent").Add(float64(sent))
+	OutboxEvents.WithLabelValues("retried").Add(float64(retried))
+	OutboxEvents.WithLabelValues("dead_lettered").Add(float64(deadLettered))
+}
+
+// RecordOutboxPurge записывает число удаленных событий outbox
+func RecordOutboxPurge(deleted int64) {
+	OutboxPurged.Add(float64(deleted))
+}
@@ -1,7 +1,11 @@
 package model
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	apperrors "route256/loms/internal/errors"
+	"slices"
 	"strings"
 )
 
@@ -9,15 +13,18 @@ type StateType string
 
 const (
 	NEW              StateType = "NEW"
-	AWAITING_PAYMENT           = "AWAITING_PAYMENT"
-	FAILED                     = "FAILED"
-	PAYED                      = "PAYED"
-	CANCELLED                  = "CANCELLED"
+	AWAITING_PAYMENT StateType = "AWAITING_PAYMENT"
+	FAILED           StateType = "FAILED"
+	PAYED            StateType = "PAYED"
+	CANCELLED        StateType = "CANCELLED"
 )
 
-var availableStates = map[StateType]struct{}{
-	NEW:              {},
-	AWAITING_PAYMENT: {},
+// transitions допустимые переходы между статусами заказа. Пустой статус у еще не сохраненного заказа,
+// статусы без исходящих переходов конечные
+var transitions = map[StateType][]StateType{
+	"":               {NEW},
+	NEW:              {AWAITING_PAYMENT, FAILED},
+	AWAITING_PAYMENT: {PAYED, CANCELLED},
 	FAILED:           {},
 	PAYED:            {},
 	CANCELLED:        {},
@@ -30,11 +37,80 @@ type Order struct {
 	UserId int64
 }
 
+// TransitionError недопустимый переход заказа из статуса From в статус To
+type TransitionError struct {
+	From StateType
+	To   StateType
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%v: transition from %q to %q is not allowed", apperrors.ErrOrderState, e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return apperrors.ErrOrderState
+}
+
+// ParseState проверяет, что state один из известных статусов заказа
+func ParseState(state string) (StateType, error) {
+	parsed := StateType(strings.ToUpper(state))
+	if _, ok := transitions[parsed]; !ok || parsed == "" {
+		return "", fmt.Errorf("invalid State: %s", state)
+	}
+	return parsed, nil
+}
+
+// CanTransition сообщает, разрешен ли переход из статуса from в статус to
+func CanTransition(from, to StateType) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceStates возвращает статусы, из которых разрешен переход в статус to
+func SourceStates(to StateType) []StateType {
+	sources := make([]StateType, 0)
+	for from := range transitions {
+		if from != "" && CanTransition(from, to) {
+			sources = append(sources, from)
+		}
+	}
+	slices.Sort(sources)
+	return sources
+}
+
+// CheckTransition возвращает TransitionError, если заказ нельзя перевести в статус state
+func (order *Order) CheckTransition(state StateType) error {
+	if !CanTransition(order.State, state) {
+		return &TransitionError{From: order.State, To: state}
+	}
+	return nil
+}
+
+// SetState переводит заказ в статус state, если переход разрешен таблицей переходов
 func (order *Order) SetState(state StateType) error {
-	state = StateType(strings.ToUpper(string(state)))
-	if _, ok := availableStates[state]; !ok {
-		return fmt.Errorf("invalid State: %s", state)
+	parsed, err := ParseState(string(state))
+	if err != nil {
+		return err
+	}
+	if err = order.CheckTransition(parsed); err != nil {
+		return err
 	}
-	order.State = state
+	order.State = parsed
 	return nil
 }
+
+// RequestHash хеш состава заказа для проверки повторов по ключу идемпотентности.
+// Не зависит от порядка товаров в запросе
+func (order *Order) RequestHash() string {
+	items := make([]string, 0, len(order.Items))
+	for _, item := range order.Items {
+		items = append(items, fmt.Sprintf("%d:%d", item.SKU, item.Count))
+	}
+	slices.Sort(items)
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%s", order.UserId, strings.Join(items, ","))))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// OrderStateChange переход заказа в статус State в момент ChangedAt
+type OrderStateChange struct {
+	State     StateType
+	ChangedAt time.Time
+}
+
+// OrderHistory время создания, последнего изменения и история статусов заказа в хронологическом порядке
+type OrderHistory struct {
+	OrderID   int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Changes   []*OrderStateChange
+}
@@ -0,0 +1,9 @@
+package model
+
+// OrdersFilter параметры выборки страницы заказов. Заказы выбираются по убыванию ID
+type OrdersFilter struct {
+	UserId   int64     // Только заказы пользователя, 0 - всех пользователей
+	State    StateType // Только заказы в статусе, пустой - в любом статусе
+	BeforeID int64     // Ключ курсора: только заказы с меньшим ID, 0 - с первой страницы
+	Limit    int       // Максимальное число заказов
+}
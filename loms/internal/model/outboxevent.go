@@ -2,11 +2,30 @@ package model
 
 import "time"
 
-// OutboxEvent представляет событие, связанное с заказом, для обработки через механизм outbox.
+// OutboxStream поток событий outbox. У каждого потока свой топик Kafka и ключ сообщения.
+type OutboxStream string
+
+const (
+	OrderOutboxStream OutboxStream = "order" // События заказа, ключ сообщения - ID заказа
+	StockOutboxStream OutboxStream = "stock" // События стока, ключ сообщения - SKU
+)
+
+// OutboxEvent представляет событие заказа или стока для обработки через механизм outbox.
 type OutboxEvent struct {
-	ID        int32     // Уникальный идентификатор события в таблице outbox
-	OrderID   int64     // Идентификатор заказа, с которым связано событие
-	Payload   string    // Дополнительные данные события в формате JSON
-	CreatedAt time.Time // Временная метка создания события
-	Processed bool      // Флаг обработки, указывает, было ли отправлено событие
+	ID           int32        // Уникальный идентификатор события в таблице outbox
+	Stream       OutboxStream // Поток события
+	OrderID      int64        // Идентификатор заказа, с которым связано событие заказа
+	SKU          SKUType      // SKU, с которым связано событие стока
+	Payload      []byte       // Конверт события EventEnvelope в формате protobuf
+	CreatedAt    time.Time    // Временная метка создания события
+	Processed    bool         // Флаг обработки, указывает, было ли отправлено событие
+	Attempts     int32        // Число неудачных попыток отправки
+	DeadLettered bool         // Событие исчерпало попытки или не может быть отправлено и больше не отправляется
+}
+
+// OutboxFailure неудачная попытка отправить событие outbox.
+type OutboxFailure struct {
+	EventID   int32 // Идентификатор события в таблице outbox
+	Err       error // Ошибка отправки
+	Permanent bool  // Ошибка не исправится при повторе, например сообщение больше допустимого брокером
 }
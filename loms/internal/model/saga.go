@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+type StockOperation string
+
+const (
+	RESERVE        StockOperation = "RESERVE"
+	RESERVE_REMOVE StockOperation = "RESERVE_REMOVE"
+	RESERVE_CANCEL StockOperation = "RESERVE_CANCEL"
+	// RESTOCK пополнение стока поставкой, не связано с заказом
+	RESTOCK StockOperation = "RESTOCK"
+)
+
+// SagaStep незавершенная операция над заказом, когда заказ и сток лежат на разных шардах.
+// Шаг записывается в журнал на шарде заказа до изменения стока и удаляется вместе со сменой статуса заказа
+type SagaStep struct {
+	OrderID     int64          // Идентификатор заказа
+	UserId      int64          // Пользователь заказа, по нему выбирается шард
+	Operation   StockOperation // Операция над стоком
+	TargetState StateType      // Статус заказа после успешного изменения стока
+	CreatedAt   time.Time      // Время записи шага в журнал
+}
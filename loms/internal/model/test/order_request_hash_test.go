@@ -0,0 +1,21 @@
+package test
+
+import (
+	"route256/loms/internal/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrder_RequestHash(t *testing.T) {
+	order := &model.Order{UserId: 1, Items: []*model.Item{{SKU: 1, Count: 2}, {SKU: 3, Count: 4}}}
+
+	reordered := &model.Order{UserId: 1, Items: []*model.Item{{SKU: 3, Count: 4}, {SKU: 1, Count: 2}}}
+	assert.Equal(t, order.RequestHash(), reordered.RequestHash())
+
+	otherCount := &model.Order{UserId: 1, Items: []*model.Item{{SKU: 1, Count: 2}, {SKU: 3, Count: 5}}}
+	assert.NotEqual(t, order.RequestHash(), otherCount.RequestHash())
+
+	otherUser := &model.Order{UserId: 2, Items: order.Items}
+	assert.NotEqual(t, order.RequestHash(), otherUser.RequestHash())
+}
@@ -0,0 +1,61 @@
+package test
+
+import (
+	appErrors "route256/loms/internal/errors"
+	"route256/loms/internal/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrder_SetState_Transitions(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    model.StateType
+		to      model.StateType
+		allowed bool
+	}{
+		{name: "create", from: "", to: model.NEW, allowed: true},
+		{name: "reserved", from: model.NEW, to: model.AWAITING_PAYMENT, allowed: true},
+		{name: "reserve failed", from: model.NEW, to: model.FAILED, allowed: true},
+		{name: "paid", from: model.AWAITING_PAYMENT, to: model.PAYED, allowed: true},
+		{name: "cancelled", from: model.AWAITING_PAYMENT, to: model.CANCELLED, allowed: true},
+		{name: "pay new order", from: model.NEW, to: model.PAYED, allowed: false},
+		{name: "cancel paid order", from: model.PAYED, to: model.CANCELLED, allowed: false},
+		{name: "pay cancelled order", from: model.CANCELLED, to: model.PAYED, allowed: false},
+		{name: "same state", from: model.AWAITING_PAYMENT, to: model.AWAITING_PAYMENT, allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := &model.Order{State: tt.from}
+
+			err := order.SetState(tt.to)
+			if tt.allowed {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.to, order.State)
+				return
+			}
+			assert.ErrorIs(t, err, appErrors.ErrOrderState)
+			var transitionErr *model.TransitionError
+			assert.ErrorAs(t, err, &transitionErr)
+			assert.Equal(t, tt.from, transitionErr.From)
+			assert.Equal(t, tt.to, transitionErr.To)
+			assert.Equal(t, tt.from, order.State)
+		})
+	}
+}
+
+func TestOrder_SetState_UnknownState(t *testing.T) {
+	order := &model.Order{State: model.NEW}
+
+	err := order.SetState("DELIVERED")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, appErrors.ErrOrderState)
+	assert.Equal(t, model.NEW, order.State)
+}
+
+func TestSourceStates(t *testing.T) {
+	assert.Equal(t, []model.StateType{model.AWAITING_PAYMENT}, model.SourceStates(model.CANCELLED))
+	assert.Equal(t, []model.StateType{model.NEW}, model.SourceStates(model.FAILED))
+	assert.Empty(t, model.SourceStates(model.NEW))
+}
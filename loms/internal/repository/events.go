@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	events "route256/libs/generated/api/events/v1"
+	"route256/loms/internal/model"
+	"time"
+)
+
+// EventVersion версия схемы событий, которые LOMS пишет в outbox.
+// Версия 0 - события до введения конверта: заказ, сериализованный в JSON
+const EventVersion = 1
+
+// orderEventTypes тип события по статусу, в который перешел заказ
+var orderEventTypes = map[model.StateType]string{
+	model.NEW:              "order.created",
+	model.AWAITING_PAYMENT: "order.awaiting_payment",
+	model.FAILED:           "order.failed",
+	model.PAYED:            "order.paid",
+	model.CANCELLED:        "order.cancelled",
+}
+
+// MarshalOrderEvent сериализует конверт события об изменении статуса заказа
+func MarshalOrderEvent(order *model.Order, occurredAt time.Time) ([]byte, error) {
+	eventType, ok := orderEventTypes[order.State]
+	if !ok {
+		return nil, fmt.Errorf("no event type for order state %q", order.State)
+	}
+	orderEvent := &events.OrderEvent{
+		OrderId: order.ID,
+		UserId:  order.UserId,
+		State:   string(order.State),
+		Items:   make([]*events.Item, 0, len(order.Items)),
+	}
+	for _, item := range order.Items {
+		orderEvent.Items = append(orderEvent.Items, &events.Item{Sku: uint32(item.SKU), Count: item.Count})
+	}
+	return marshalEnvelope(eventType, occurredAt, func(envelope *events.EventEnvelope) {
+		envelope.Data = &events.EventEnvelope_Order{Order: orderEvent}
+	})
+}
+
+// StockChangedEventType тип события об изменении стока
+const StockChangedEventType = "stock.changed"
+
+// MarshalStockEvent сериализует конверт события об изменении стока операцией над заказом
+func MarshalStockEvent(stock *model.Stock, orderID int64, operation model.StockOperation, occurredAt time.Time) ([]byte, error) {
+	stockEvent := &events.StockEvent{
+		Sku:           int64(stock.SKU),
+		TotalCount:    stock.TotalCount,
+		ReservedCount: stock.ReservedCount,
+		OrderId:       orderID,
+		Operation:     string(operation),
+	}
+	return marshalEnvelope(StockChangedEventType, occurredAt, func(envelope *events.EventEnvelope) {
+		envelope.Data = &events.EventEnvelope_Stock{Stock: stockEvent}
+	})
+}
+
+func marshalEnvelope(eventType string, occurredAt time.Time, setData func(envelope *events.EventEnvelope)) ([]byte, error) {
+	envelope := &events.EventEnvelope{
+		EventId:    uuid.NewString(),
+		Type:       eventType,
+		OccurredAt: timestamppb.New(occurredAt),
+		Version:    EventVersion,
+	}
+	setData(envelope)
+	payload, err := proto.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal %s event: %w", eventType, err)
+	}
+	return payload, nil
+}
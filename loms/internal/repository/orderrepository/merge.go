@@ -0,0 +1,130 @@
+package orderrepository
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"route256/loms/internal/infra/database"
+	"route256/loms/internal/model"
+)
+
+// OrderStream поток заказов одного шарда по убыванию ID
+type OrderStream interface {
+	// Next возвращает следующий заказ, false - если заказы закончились или чтение завершилось ошибкой
+	Next() (*model.Order, bool, error)
+}
+
+// orderStream построчно читает заказы одного шарда, упорядоченные по убыванию ID.
+// err выставляется до закрытия канала, поэтому читать его можно после того, как канал закрыт
+type orderStream struct {
+	shardID int
+	orders  chan *model.Order
+	err     error
+}
+
+func newOrderStream(shardID int) *orderStream {
+	return &orderStream{
+		shardID: shardID,
+		orders:  make(chan *model.Order),
+	}
+}
+
+func (s *orderStream) run(ctx context.Context, conn *database.FallbackConnection, params *ListOrdersParams) {
+	defer close(s.orders)
+
+	rows, err := conn.Query(ctx, listOrders, params.BeforeID, params.UserID, params.State, params.MaxCount)
+	if err != nil {
+		s.err = fmt.Errorf("unable to list orders from shard id %d: %w", s.shardID, err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row ListOrdersRow
+		if err = rows.Scan(&row.ID, &row.State, &row.UserID, &row.Skus, &row.Counts); err != nil {
+			s.err = fmt.Errorf("unable to scan order from shard id %d: %w", s.shardID, err)
+			return
+		}
+		order, err := repackListOrdersRowToOrder(&row)
+		if err != nil {
+			s.err = fmt.Errorf("unable to repack order from DB for shard id %d: %w", s.shardID, err)
+			return
+		}
+		select {
+		case s.orders <- order:
+		case <-ctx.Done():
+			return
+		}
+	}
+	if err = rows.Err(); err != nil {
+		s.err = fmt.Errorf("unable to list orders from shard id %d: %w", s.shardID, err)
+	}
+}
+
+func (s *orderStream) Next() (*model.Order, bool, error) {
+	order, ok := <-s.orders
+	if !ok {
+		return nil, false, s.err
+	}
+	return order, true, nil
+}
+
+type streamHead struct {
+	order  *model.Order
+	stream OrderStream
+}
+
+// ordersHeap куча текущих заказов шардов, на вершине заказ с наибольшим ID
+type ordersHeap []*streamHead
+
+func (h ordersHeap) Len() int           { return len(h) }
+func (h ordersHeap) Less(i, j int) bool { return h[i].order.ID > h[j].order.ID }
+func (h ordersHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *ordersHeap) Push(x any) {
+	*h = append(*h, x.(*streamHead))
+}
+
+func (h *ordersHeap) Pop() any {
+	old := *h
+	n := len(old)
+	head := old[n-1]
+	*h = old[:n-1]
+	return head
+}
+
+// MergeOrderStreams сливает упорядоченные потоки шардов в один по убыванию ID и останавливается на limit заказах
+func MergeOrderStreams(streams []OrderStream, limit int) ([]*model.Order, error) {
+	h := make(ordersHeap, 0, len(streams))
+	for _, stream := range streams {
+		order, ok, err := stream.Next()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			h = append(h, &streamHead{order: order, stream: stream})
+		}
+	}
+	heap.Init(&h)
+
+	orders := make([]*model.Order, 0, limit)
+	for h.Len() > 0 && len(orders) < limit {
+		head := h[0]
+		// пока решардинг не удалил перенесенный бакет со старого шарда, заказ может прийти с двух шардов
+		if len(orders) == 0 || orders[len(orders)-1].ID != head.order.ID {
+			orders = append(orders, head.order)
+		}
+
+		order, ok, err := head.stream.Next()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			head.order = order
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return orders, nil
+}
@@ -7,6 +7,8 @@ package orderrepository
 import (
 	"database/sql/driver"
 	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type OrderStatus string
@@ -53,3 +55,54 @@ func (ns NullOrderStatus) Value() (driver.Value, error) {
 	}
 	return string(ns.OrderStatus), nil
 }
+
+type StockOperationType string
+
+const (
+	StockOperationTypeRESERVE       StockOperationType = "RESERVE"
+	StockOperationTypeRESERVEREMOVE StockOperationType = "RESERVE_REMOVE"
+	StockOperationTypeRESERVECANCEL StockOperationType = "RESERVE_CANCEL"
+)
+
+func (e *StockOperationType) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = StockOperationType(s)
+	case string:
+		*e = StockOperationType(s)
+	default:
+		return fmt.Errorf("unsupported scan type for StockOperationType: %T", src)
+	}
+	return nil
+}
+
+type NullStockOperationType struct {
+	StockOperationType StockOperationType
+	Valid              bool // Valid is true if StockOperationType is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullStockOperationType) Scan(value interface{}) error {
+	if value == nil {
+		ns.StockOperationType, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.StockOperationType.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullStockOperationType) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.StockOperationType), nil
+}
+
+type OrderSaga struct {
+	OrderID     int64
+	UserID      int64
+	Operation   StockOperationType
+	TargetState OrderStatus
+	CreatedAt   pgtype.Timestamptz
+}
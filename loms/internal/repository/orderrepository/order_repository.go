@@ -2,17 +2,18 @@ package orderrepository
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"go.uber.org/multierr"
 	appErr "route256/loms/internal/errors"
 	"route256/loms/internal/infra/database"
 	"route256/loms/internal/model"
 	"route256/loms/internal/repository"
 	"route256/loms/internal/repository/outboxrepository"
-	"slices"
+	"sync"
+	"time"
 )
 
 type Repository struct {
@@ -33,90 +34,322 @@ func NewRepository(pool ConnectionPooler, oR *outboxrepository.Repository) *Repo
 	}
 }
 
-func (r *Repository) SaveOrder(ctx context.Context, order *model.Order) (*model.Order, error) {
-	conn, err := r.pool.PickConnFromUserId(ctx, order.UserId, false)
+// SaveOrder сохраняет заказ в транзакции tx, а при непустом idempotencyKey закрепляет ключ за заказом.
+// Если ключ уже был использован пользователем, возвращается ошибка ErrIdempotencyKey и транзакцию нужно откатить.
+// ID заказа несет бакет пользователя, чтобы заказ находился по ID на том же шарде, что и по пользователю
+func (r *Repository) SaveOrder(ctx context.Context, tx pgx.Tx, order *model.Order, idempotencyKey string) (*model.Order, error) {
+	repTx := New(tx)
+	orderID, err := repTx.SaveOrder(ctx, &SaveOrderParams{
+		BucketsCount: database.BucketsCount,
+		Bucket:       int64(database.BucketFromUserId(order.UserId)),
+		State:        OrderStatus(order.State),
+		UserID:       order.UserId,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to acquire a connection: %w", err)
+		return nil, fmt.Errorf("unable to save order: %w", err)
 	}
-	defer conn.Release()
-
-	payload, err := json.Marshal(order)
+	err = repTx.SaveItems(ctx, repackItemsToSaveItemParams(order.Items, orderID))
 	if err != nil {
-		return nil, fmt.Errorf("unable to marshal order: %w", err)
+		return nil, fmt.Errorf("unable to save order items: %w", err)
 	}
-	err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
-		repTx := New(tx)
-		orderID, err := repTx.SaveOrder(ctx, &SaveOrderParams{
-			State:  OrderStatus(order.State),
-			UserID: order.UserId,
+	order.ID = orderID
+
+	if idempotencyKey != "" {
+		inserted, err := repTx.SaveIdempotencyKey(ctx, &SaveIdempotencyKeyParams{
+			UserID:         order.UserId,
+			IdempotencyKey: idempotencyKey,
+			OrderID:        orderID,
+			RequestHash:    order.RequestHash(),
 		})
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("unable to save idempotency key: %w", err)
 		}
-		err = repTx.SaveItems(ctx, repackItemsToSaveItemParams(order.Items, orderID))
-		if err != nil {
-			return err
+		if inserted == 0 {
+			return nil, fmt.Errorf("user %d, key %q: %w", order.UserId, idempotencyKey, appErr.ErrIdempotencyKey)
 		}
-		order.ID = orderID
+	}
 
-		err = r.outboxRepository.SaveOutboxEvent(ctx, tx, &model.OutboxEvent{
-			OrderID: order.ID,
-			Payload: string(payload),
-		})
-		if err != nil {
-			return err
-		}
-		return nil
+	if err = r.saveStateChange(ctx, tx, order); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// UpdateOrder сохраняет новый статус заказа в транзакции tx вместе с записью в историю статусов и событием в outbox.
+// Статус меняется через compare-and-set: строка обновляется, только если текущий статус в базе
+// допускает переход в новый по таблице переходов модели, иначе возвращается ErrOrderState
+func (r *Repository) UpdateOrder(ctx context.Context, tx pgx.Tx, order *model.Order) error {
+	sourceStates := model.SourceStates(order.State)
+	expectedStates := make([]string, 0, len(sourceStates))
+	for _, state := range sourceStates {
+		expectedStates = append(expectedStates, string(state))
+	}
+	updated, err := New(tx).UpdateOrder(ctx, &UpdateOrderParams{
+		State:          OrderStatus(order.State),
+		OrderID:        order.ID,
+		ExpectedStates: expectedStates,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to save order: %w", err)
+		return fmt.Errorf("unable to update order: %w", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("order with ID %v not found in any of states %v: %w", order.ID, expectedStates, appErr.ErrOrderState)
+	}
+	return r.saveStateChange(ctx, tx, order)
+}
+
+// GetByIdForUpdate читает заказ в транзакции tx и блокирует строку заказа до конца транзакции
+func (r *Repository) GetByIdForUpdate(ctx context.Context, tx pgx.Tx, orderID int64) (*model.Order, error) {
+	rows, err := New(tx).GetOrderByIdForUpdate(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get order by ID for update: %w", err)
+	}
+	orderFromDB := make([]*GetOrderByIdRow, 0, len(rows))
+	for _, row := range rows {
+		orderFromDB = append(orderFromDB, (*GetOrderByIdRow)(row))
+	}
+	order, err := repackOrderFromDBToOrder(orderFromDB)
+	if err != nil {
+		return nil, fmt.Errorf("order with ID %v: %w", orderID, err)
 	}
 	return order, nil
 }
 
-func (r *Repository) UpdateOrder(ctx context.Context, order *model.Order) error {
-	conn, err := r.pool.PickConnFromUserId(ctx, order.UserId, false)
+// SaveSagaStep записывает в журнал шаг саги. Для заказа допускается только один незавершенный шаг,
+// поэтому повторная запись означает, что над заказом уже выполняется другая операция
+func (r *Repository) SaveSagaStep(ctx context.Context, tx pgx.Tx, step *model.SagaStep) error {
+	inserted, err := New(tx).SaveSagaStep(ctx, &SaveSagaStepParams{
+		OrderID:     step.OrderID,
+		UserID:      step.UserId,
+		Operation:   StockOperationType(step.Operation),
+		TargetState: OrderStatus(step.TargetState),
+	})
 	if err != nil {
-		return fmt.Errorf("unable to acquire a connection: %w", err)
+		return fmt.Errorf("unable to save saga step: %w", err)
 	}
-	defer conn.Release()
+	if inserted == 0 {
+		return fmt.Errorf("order %d already has pending operation: %w", step.OrderID, appErr.ErrOrderState)
+	}
+	return nil
+}
 
-	payload, err := json.Marshal(order)
+// DeleteSagaStep удаляет шаг саги из журнала. Возвращает false, если шага уже нет,
+// то есть сагу завершил другой обработчик
+func (r *Repository) DeleteSagaStep(ctx context.Context, tx pgx.Tx, orderID int64) (bool, error) {
+	deleted, err := New(tx).DeleteSagaStep(ctx, orderID)
 	if err != nil {
-		return fmt.Errorf("unable to marshal order: %w", err)
+		return false, fmt.Errorf("unable to delete saga step: %w", err)
 	}
+	return deleted > 0, nil
+}
 
-	err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
-		repTx := New(tx)
-		_, err = repTx.UpdateOrder(ctx, &UpdateOrderParams{
-			UserID:  order.UserId,
-			State:   OrderStatus(order.State),
-			OrderID: order.ID,
+// GetPendingSagaSteps возвращает со всех шардов шаги саг, записанные раньше чем olderThan назад
+func (r *Repository) GetPendingSagaSteps(ctx context.Context, olderThan time.Duration, limit int32) ([]*model.SagaStep, error) {
+	connections, err := r.pool.PickAllShards(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("error pick all shards: %w", err)
+	}
+	defer func() {
+		for _, conn := range connections {
+			conn.Release()
+		}
+	}()
+
+	createdBefore := pgtype.Timestamptz{Time: time.Now().Add(-olderThan), Valid: true}
+	steps := make([]*model.SagaStep, 0)
+	for id, conn := range connections {
+		stepsFromDB, err := New(conn).GetPendingSagaSteps(ctx, &GetPendingSagaStepsParams{
+			CreatedBefore: createdBefore,
+			MaxCount:      limit,
 		})
 		if err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				return fmt.Errorf("order with ID %v: %w", order.ID, appErr.ErrNotFound)
-			}
-			return fmt.Errorf("unable to update order: %w", err)
-		}
-		err = r.outboxRepository.SaveOutboxEvent(ctx, tx, &model.OutboxEvent{
-			OrderID: order.ID,
-			Payload: string(payload),
+			return nil, fmt.Errorf("unable to get saga steps from shard id %d: %w", id, err)
+		}
+		for _, step := range stepsFromDB {
+			steps = append(steps, &model.SagaStep{
+				OrderID:     step.OrderID,
+				UserId:      step.UserID,
+				Operation:   model.StockOperation(step.Operation),
+				TargetState: model.StateType(step.TargetState),
+				CreatedAt:   step.CreatedAt.Time,
+			})
+		}
+	}
+	return steps, nil
+}
+
+// GetExpiredOrderIds возвращает со всех шардов ID заказов, ожидающих оплаты дольше ttl
+func (r *Repository) GetExpiredOrderIds(ctx context.Context, ttl time.Duration, limit int32) ([]int64, error) {
+	connections, err := r.pool.PickAllShards(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("error pick all shards: %w", err)
+	}
+	defer func() {
+		for _, conn := range connections {
+			conn.Release()
+		}
+	}()
+
+	updatedBefore := pgtype.Timestamptz{Time: time.Now().Add(-ttl), Valid: true}
+	orderIDs := make([]int64, 0)
+	for id, conn := range connections {
+		ids, err := New(conn).GetExpiredOrderIds(ctx, &GetExpiredOrderIdsParams{
+			UpdatedBefore: updatedBefore,
+			MaxCount:      limit,
 		})
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("unable to get expired orders from shard id %d: %w", id, err)
+		}
+		orderIDs = append(orderIDs, ids...)
+	}
+	return orderIDs, nil
+}
+
+// GetStateHistory возвращает время создания заказа и историю смены его статусов
+func (r *Repository) GetStateHistory(ctx context.Context, orderID int64) (*model.OrderHistory, error) {
+	var (
+		timestamps    *GetOrderTimestampsRow
+		changesFromDB []*GetOrderStateHistoryRow
+	)
+	err := r.queryOrder(ctx, orderID, true, func(conn *database.FallbackConnection) error {
+		repConn := New(conn)
+		var err error
+		timestamps, err = repConn.GetOrderTimestamps(ctx, orderID)
+		if err != nil {
+			return fmt.Errorf("unable to get order timestamps: %w", err)
+		}
+		changesFromDB, err = repConn.GetOrderStateHistory(ctx, orderID)
+		if err != nil {
+			return fmt.Errorf("unable to get order state history: %w", err)
 		}
 		return nil
 	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("order with ID %v: %w", orderID, appErr.ErrNotFound)
+		}
+		return nil, err
+	}
+
+	history := &model.OrderHistory{
+		OrderID:   orderID,
+		CreatedAt: timestamps.CreatedAt.Time,
+		UpdatedAt: timestamps.UpdatedAt.Time,
+		Changes:   make([]*model.OrderStateChange, 0, len(changesFromDB)),
+	}
+	for _, change := range changesFromDB {
+		history.Changes = append(history.Changes, &model.OrderStateChange{
+			State:     model.StateType(change.State),
+			ChangedAt: change.ChangedAt.Time,
+		})
+	}
+	return history, nil
+}
 
+// queryOrder выполняет query на шарде бакета из ID заказа. Заказ с ID, выданным до перехода на бакеты,
+// мог переехать вместе с бакетом пользователя, поэтому, если его нет на шарде бакета из ID, он ищется на всех шардах
+func (r *Repository) queryOrder(
+	ctx context.Context,
+	orderID int64,
+	readOnlyOperation bool,
+	query func(conn *database.FallbackConnection) error,
+) error {
+	conn, err := r.pool.PickConnFromOrderId(ctx, orderID, readOnlyOperation)
+	if err != nil {
+		return fmt.Errorf("unable to acquire a connection: %w", err)
+	}
+	err = query(conn)
+	conn.Release()
+	if !errors.Is(err, pgx.ErrNoRows) || !database.MayBeLegacyOrderId(orderID) {
+		return err
+	}
+
+	connections, pickErr := r.pool.PickAllShards(ctx, readOnlyOperation)
+	defer func() {
+		for _, conn := range connections {
+			conn.Release()
+		}
+	}()
+	if pickErr != nil {
+		return fmt.Errorf("error pick all shards: %w", pickErr)
+	}
+	for _, conn := range connections {
+		if err = query(conn); !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+	}
 	return err
 }
 
-func (r *Repository) GetById(ctx context.Context, orderID int64) (*model.Order, error) {
-	conn, err := r.pool.PickConnFromOrderId(ctx, orderID, false)
-	orderFromDB, err := New(conn).GetOrderById(ctx, orderID)
+// saveStateChange фиксирует текущий статус заказа в истории статусов и в outbox
+func (r *Repository) saveStateChange(ctx context.Context, tx pgx.Tx, order *model.Order) error {
+	err := New(tx).SaveOrderStateHistory(ctx, &SaveOrderStateHistoryParams{
+		OrderID: order.ID,
+		State:   OrderStatus(order.State),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to get order by ID: %w", err)
+		return fmt.Errorf("unable to save order state history: %w", err)
+	}
+
+	payload, err := repository.MarshalOrderEvent(order, time.Now())
+	if err != nil {
+		return err
+	}
+	return r.outboxRepository.SaveOutboxEvent(ctx, tx, &model.OutboxEvent{
+		Stream:  model.OrderOutboxStream,
+		OrderID: order.ID,
+		Payload: payload,
+	})
+}
+
+// GetOrderIdByIdempotencyKey возвращает ID заказа, ранее созданного пользователем с этим ключом идемпотентности,
+// и хеш запроса, с которым он создан. Читаем с мастера, так как повтор запроса обычно приходит сразу после записи
+func (r *Repository) GetOrderIdByIdempotencyKey(ctx context.Context, userId int64, idempotencyKey string) (int64, string, error) {
+	conn, err := r.pool.PickConnFromUserId(ctx, userId, false)
+	if err != nil {
+		return 0, "", fmt.Errorf("unable to acquire a connection: %w", err)
+	}
+	defer conn.Release()
+
+	row, err := New(conn).GetOrderIdByIdempotencyKey(ctx, &GetOrderIdByIdempotencyKeyParams{
+		UserID:         userId,
+		IdempotencyKey: idempotencyKey,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, "", fmt.Errorf("idempotency key %q: %w", idempotencyKey, appErr.ErrNotFound)
+		}
+		return 0, "", fmt.Errorf("unable to get order by idempotency key: %w", err)
+	}
+	return row.OrderID, row.RequestHash, nil
+}
+
+// GetById читает заказ с мастера, для проверок перед изменением заказа
+func (r *Repository) GetById(ctx context.Context, orderID int64) (*model.Order, error) {
+	return r.getById(ctx, orderID, false)
+}
+
+// GetByIdFromReplica читает заказ с реплики, если она не отстает больше допустимого
+// и дошла до токена согласованности из ctx, иначе с мастера
+func (r *Repository) GetByIdFromReplica(ctx context.Context, orderID int64) (*model.Order, error) {
+	return r.getById(ctx, orderID, true)
+}
+
+func (r *Repository) getById(ctx context.Context, orderID int64, readOnlyOperation bool) (*model.Order, error) {
+	var orderFromDB []*GetOrderByIdRow
+	err := r.queryOrder(ctx, orderID, readOnlyOperation, func(conn *database.FallbackConnection) error {
+		var err error
+		orderFromDB, err = New(conn).GetOrderById(ctx, orderID)
+		if err != nil {
+			return fmt.Errorf("unable to get order by ID: %w", err)
+		}
+		if len(orderFromDB) == 0 {
+			return pgx.ErrNoRows
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
 	}
 	order, err := repackOrderFromDBToOrder(orderFromDB)
 	if err != nil {
@@ -125,53 +358,94 @@ func (r *Repository) GetById(ctx context.Context, orderID int64) (*model.Order,
 	return order, nil
 }
 
-func (r *Repository) GetAllOrders(ctx context.Context) ([]*model.Order, error) {
+// ListOrders возвращает со всех шардов не больше filter.Limit заказов по убыванию ID.
+// Шарды читаются параллельно, и их упорядоченные выборки сливаются k-way слиянием по мере чтения строк,
+// так что чтение прекращается, как только набрана страница
+func (r *Repository) ListOrders(ctx context.Context, filter *model.OrdersFilter) ([]*model.Order, error) {
 	connections, err := r.pool.PickAllShards(ctx, true)
+	defer func() {
+		for _, conn := range connections {
+			conn.Release()
+		}
+	}()
 	if err != nil {
 		return nil, fmt.Errorf("error pick all shards: %w", err)
 	}
-	orders := make([]*model.Order, 0)
-	for id, conn := range connections {
-		ordersFromDb, err := New(conn).GetAllOrders(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("unable to get orders from shard id %d: %w", id, err)
-		}
-		toOrders, err := repackOrdersFromDBToOrders(ordersFromDb)
-		if err != nil {
-			return nil, fmt.Errorf("unable to repack order from DB for shard id %d: %w", id, err)
-		}
-		orders = append(orders, toOrders...)
+
+	params := &ListOrdersParams{
+		BeforeID: filter.BeforeID,
+		UserID:   filter.UserId,
+		State:    string(filter.State),
+		MaxCount: int32(filter.Limit),
 	}
 
-	slices.SortFunc(orders, func(i, j *model.Order) int {
-		return -int(i.ID - j.ID)
-	})
+	ctx, cancel := context.WithCancel(ctx)
+	wg := sync.WaitGroup{}
+	// сначала отменяем чтение оставшихся строк, затем дожидаемся горутин шардов, чтобы вернуть соединения
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
 
-	return orders, nil
+	streams := make([]OrderStream, 0, len(connections))
+	for id, conn := range connections {
+		stream := newOrderStream(id)
+		streams = append(streams, stream)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stream.run(ctx, conn, params)
+		}()
+	}
+
+	return MergeOrderStreams(streams, filter.Limit)
 }
 
-func repackOrdersFromDBToOrders(ordersFromDB []*GetAllOrdersRow) ([]*model.Order, error) {
-	ordersRes := make([]*model.Order, 0)
-	orders := make(map[int64][]*GetOrderByIdRow)
-	for _, orderFromDB := range ordersFromDB {
-		orders[orderFromDB.ID] = append(orders[orderFromDB.ID], &GetOrderByIdRow{
-			ID:     orderFromDB.ID,
-			State:  orderFromDB.State,
-			UserID: orderFromDB.UserID,
-			Sku:    orderFromDB.Sku,
-			Count:  orderFromDB.Count,
-		})
+// ListUserOrders возвращает не больше filter.Limit заказов пользователя filter.UserId по убыванию ID.
+// Заказы пользователя лежат на одном шарде, поэтому читается только он, по возможности с реплики
+func (r *Repository) ListUserOrders(ctx context.Context, filter *model.OrdersFilter) ([]*model.Order, error) {
+	conn, err := r.pool.PickConnFromUserId(ctx, filter.UserId, true)
+	defer conn.Release()
+	if err != nil {
+		return nil, fmt.Errorf("error pick shard for user %d: %w", filter.UserId, err)
 	}
 
-	for key := range orders {
-		order, err := repackOrderFromDBToOrder(orders[key])
+	rows, err := New(conn).ListOrders(ctx, &ListOrdersParams{
+		BeforeID: filter.BeforeID,
+		UserID:   filter.UserId,
+		State:    string(filter.State),
+		MaxCount: int32(filter.Limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list orders of user %d: %w", filter.UserId, err)
+	}
+
+	orders := make([]*model.Order, 0, len(rows))
+	for _, row := range rows {
+		order, err := repackListOrdersRowToOrder(row)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("unable to repack order from DB: %w", err)
 		}
-		ordersRes = append(ordersRes, order)
+		orders = append(orders, order)
 	}
+	return orders, nil
+}
 
-	return ordersRes, nil
+func repackListOrdersRowToOrder(row *ListOrdersRow) (*model.Order, error) {
+	if len(row.Skus) != len(row.Counts) {
+		return nil, fmt.Errorf("order %d: got %d skus and %d counts", row.ID, len(row.Skus), len(row.Counts))
+	}
+	orderFromDB := make([]*GetOrderByIdRow, 0, len(row.Skus))
+	for i := range row.Skus {
+		orderFromDB = append(orderFromDB, &GetOrderByIdRow{
+			ID:     row.ID,
+			State:  row.State,
+			UserID: row.UserID,
+			Sku:    row.Skus[i],
+			Count:  row.Counts[i],
+		})
+	}
+	return repackOrderFromDBToOrder(orderFromDB)
 }
 
 func repackOrderFromDBToOrder(orderFromDB []*GetOrderByIdRow) (*model.Order, error) {
@@ -179,13 +453,14 @@ func repackOrderFromDBToOrder(orderFromDB []*GetOrderByIdRow) (*model.Order, err
 		return nil, appErr.ErrNotFound
 	}
 
+	state, err := model.ParseState(string(orderFromDB[0].State))
+	if err != nil {
+		return nil, err
+	}
 	order := &model.Order{
 		ID:     orderFromDB[0].ID,
 		UserId: orderFromDB[0].UserID,
-	}
-	err := order.SetState(model.StateType(orderFromDB[0].State))
-	if err != nil {
-		return nil, err
+		State:  state,
 	}
 
 	items := make([]*model.Item, 0, len(orderFromDB))
@@ -9,10 +9,20 @@ import (
 )
 
 type Querier interface {
-	GetAllOrders(ctx context.Context) ([]*GetAllOrdersRow, error)
+	DeleteSagaStep(ctx context.Context, orderID int64) (int64, error)
+	GetExpiredOrderIds(ctx context.Context, arg *GetExpiredOrderIdsParams) ([]int64, error)
 	GetOrderById(ctx context.Context, orderID int64) ([]*GetOrderByIdRow, error)
+	GetOrderByIdForUpdate(ctx context.Context, orderID int64) ([]*GetOrderByIdForUpdateRow, error)
+	GetOrderIdByIdempotencyKey(ctx context.Context, arg *GetOrderIdByIdempotencyKeyParams) (*GetOrderIdByIdempotencyKeyRow, error)
+	GetOrderStateHistory(ctx context.Context, orderID int64) ([]*GetOrderStateHistoryRow, error)
+	GetOrderTimestamps(ctx context.Context, orderID int64) (*GetOrderTimestampsRow, error)
+	GetPendingSagaSteps(ctx context.Context, arg *GetPendingSagaStepsParams) ([]*OrderSaga, error)
+	ListOrders(ctx context.Context, arg *ListOrdersParams) ([]*ListOrdersRow, error)
+	SaveIdempotencyKey(ctx context.Context, arg *SaveIdempotencyKeyParams) (int64, error)
 	SaveItems(ctx context.Context, arg *SaveItemsParams) error
 	SaveOrder(ctx context.Context, arg *SaveOrderParams) (int64, error)
+	SaveOrderStateHistory(ctx context.Context, arg *SaveOrderStateHistoryParams) error
+	SaveSagaStep(ctx context.Context, arg *SaveSagaStepParams) (int64, error)
 	UpdateOrder(ctx context.Context, arg *UpdateOrderParams) (int64, error)
 }
 
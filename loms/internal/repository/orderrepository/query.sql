@@ -1,18 +1,18 @@
 -- name: SaveOrder :one
-INSERT INTO orders (state, user_id)
-VALUES (@state, @user_id)
+INSERT INTO orders (id, state, user_id)
+VALUES (nextval('order_number_seq') * sqlc.arg(buckets_count)::bigint + sqlc.arg(bucket)::bigint, @state, @user_id)
 RETURNING id;
 
 -- name: SaveItems :exec
 INSERT INTO items (sku, count, order_id)
 SELECT unnest(@skus::bigint[]), unnest(@counts::bigint[]), @order_id;
 
--- name: UpdateOrder :one
+-- name: UpdateOrder :execrows
 UPDATE orders
-SET state   = @state,
-    user_id = @user_id
+SET state      = @state,
+    updated_at = NOW()
 WHERE id = @order_id
-RETURNING id;
+  AND state::text = ANY (@expected_states::text[]);
 
 -- name: GetOrderById :many
 SELECT orders.id,
@@ -24,12 +24,78 @@ FROM orders
 JOIN items i on orders.id = i.order_id
 WHERE orders.id = @order_id;
 
--- name: GetAllOrders :many
+-- name: ListOrders :many
+SELECT orders.id,
+       orders.state,
+       orders.user_id,
+       array_agg(i.sku ORDER BY i.sku)::bigint[]   AS skus,
+       array_agg(i.count ORDER BY i.sku)::bigint[] AS counts
+FROM orders
+JOIN items i on orders.id = i.order_id
+WHERE (@before_id::bigint = 0 OR orders.id < @before_id::bigint)
+  AND (@user_id::bigint = 0 OR orders.user_id = @user_id::bigint)
+  AND (@state::text = '' OR orders.state::text = @state::text)
+GROUP BY orders.id
+ORDER BY orders.id DESC
+LIMIT @max_count;
+
+-- name: SaveIdempotencyKey :execrows
+INSERT INTO idempotency_keys (user_id, idempotency_key, order_id, request_hash)
+VALUES (@user_id, @idempotency_key, @order_id, @request_hash)
+ON CONFLICT (user_id, idempotency_key) DO NOTHING;
+
+-- name: GetOrderIdByIdempotencyKey :one
+SELECT order_id, request_hash
+FROM idempotency_keys
+WHERE user_id = @user_id
+  AND idempotency_key = @idempotency_key;
+
+-- name: GetOrderByIdForUpdate :many
 SELECT orders.id,
        orders.state,
        orders.user_id,
        i.sku,
        i.count
 FROM orders
-JOIN items i on orders.id = i.order_id;
+JOIN items i on orders.id = i.order_id
+WHERE orders.id = @order_id
+FOR UPDATE OF orders;
+
+-- name: SaveSagaStep :execrows
+INSERT INTO order_saga (order_id, user_id, operation, target_state)
+VALUES (@order_id, @user_id, @operation, @target_state)
+ON CONFLICT (order_id) DO NOTHING;
+
+-- name: DeleteSagaStep :execrows
+DELETE FROM order_saga
+WHERE order_id = @order_id;
+
+-- name: GetPendingSagaSteps :many
+SELECT order_id, user_id, operation, target_state, created_at
+FROM order_saga
+WHERE created_at < @created_before
+ORDER BY created_at
+LIMIT @max_count;
+
+-- name: GetExpiredOrderIds :many
+SELECT id
+FROM orders
+WHERE state = 'AWAITING_PAYMENT'
+  AND updated_at < @updated_before
+ORDER BY updated_at
+LIMIT @max_count;
+
+-- name: SaveOrderStateHistory :exec
+INSERT INTO order_state_history (order_id, state)
+VALUES (@order_id, @state);
+
+-- name: GetOrderTimestamps :one
+SELECT created_at, updated_at
+FROM orders
+WHERE id = @order_id;
 
+-- name: GetOrderStateHistory :many
+SELECT state, changed_at
+FROM order_state_history
+WHERE order_id = @order_id
+ORDER BY changed_at, id;
@@ -7,9 +7,58 @@ package orderrepository
 
 import (
 	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
-const getAllOrders = `-- name: GetAllOrders :many
+const deleteSagaStep = `-- name: DeleteSagaStep :execrows
+DELETE FROM order_saga
+WHERE order_id = $1
+`
+
+func (q *Queries) DeleteSagaStep(ctx context.Context, orderID int64) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteSagaStep, orderID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getExpiredOrderIds = `-- name: GetExpiredOrderIds :many
+SELECT id
+FROM orders
+WHERE state = 'AWAITING_PAYMENT'
+  AND updated_at < $1
+ORDER BY updated_at
+LIMIT $2
+`
+
+type GetExpiredOrderIdsParams struct {
+	UpdatedBefore pgtype.Timestamptz
+	MaxCount      int32
+}
+
+func (q *Queries) GetExpiredOrderIds(ctx context.Context, arg *GetExpiredOrderIdsParams) ([]int64, error) {
+	rows, err := q.db.Query(ctx, getExpiredOrderIds, arg.UpdatedBefore, arg.MaxCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOrderById = `-- name: GetOrderById :many
 SELECT orders.id,
        orders.state,
        orders.user_id,
@@ -17,9 +66,10 @@ SELECT orders.id,
        i.count
 FROM orders
 JOIN items i on orders.id = i.order_id
+WHERE orders.id = $1
 `
 
-type GetAllOrdersRow struct {
+type GetOrderByIdRow struct {
 	ID     int64
 	State  OrderStatus
 	UserID int64
@@ -27,15 +77,15 @@ type GetAllOrdersRow struct {
 	Count  int64
 }
 
-func (q *Queries) GetAllOrders(ctx context.Context) ([]*GetAllOrdersRow, error) {
-	rows, err := q.db.Query(ctx, getAllOrders)
+func (q *Queries) GetOrderById(ctx context.Context, orderID int64) ([]*GetOrderByIdRow, error) {
+	rows, err := q.db.Query(ctx, getOrderById, orderID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []*GetAllOrdersRow
+	var items []*GetOrderByIdRow
 	for rows.Next() {
-		var i GetAllOrdersRow
+		var i GetOrderByIdRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.State,
@@ -53,7 +103,7 @@ func (q *Queries) GetAllOrders(ctx context.Context) ([]*GetAllOrdersRow, error)
 	return items, nil
 }
 
-const getOrderById = `-- name: GetOrderById :many
+const getOrderByIdForUpdate = `-- name: GetOrderByIdForUpdate :many
 SELECT orders.id,
        orders.state,
        orders.user_id,
@@ -62,9 +112,10 @@ SELECT orders.id,
 FROM orders
 JOIN items i on orders.id = i.order_id
 WHERE orders.id = $1
+FOR UPDATE OF orders
 `
 
-type GetOrderByIdRow struct {
+type GetOrderByIdForUpdateRow struct {
 	ID     int64
 	State  OrderStatus
 	UserID int64
@@ -72,15 +123,15 @@ type GetOrderByIdRow struct {
 	Count  int64
 }
 
-func (q *Queries) GetOrderById(ctx context.Context, orderID int64) ([]*GetOrderByIdRow, error) {
-	rows, err := q.db.Query(ctx, getOrderById, orderID)
+func (q *Queries) GetOrderByIdForUpdate(ctx context.Context, orderID int64) ([]*GetOrderByIdForUpdateRow, error) {
+	rows, err := q.db.Query(ctx, getOrderByIdForUpdate, orderID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []*GetOrderByIdRow
+	var items []*GetOrderByIdForUpdateRow
 	for rows.Next() {
-		var i GetOrderByIdRow
+		var i GetOrderByIdForUpdateRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.State,
@@ -98,6 +149,207 @@ func (q *Queries) GetOrderById(ctx context.Context, orderID int64) ([]*GetOrderB
 	return items, nil
 }
 
+const getOrderIdByIdempotencyKey = `-- name: GetOrderIdByIdempotencyKey :one
+SELECT order_id, request_hash
+FROM idempotency_keys
+WHERE user_id = $1
+  AND idempotency_key = $2
+`
+
+type GetOrderIdByIdempotencyKeyParams struct {
+	UserID         int64
+	IdempotencyKey string
+}
+
+type GetOrderIdByIdempotencyKeyRow struct {
+	OrderID     int64
+	RequestHash string
+}
+
+func (q *Queries) GetOrderIdByIdempotencyKey(ctx context.Context, arg *GetOrderIdByIdempotencyKeyParams) (*GetOrderIdByIdempotencyKeyRow, error) {
+	row := q.db.QueryRow(ctx, getOrderIdByIdempotencyKey, arg.UserID, arg.IdempotencyKey)
+	var i GetOrderIdByIdempotencyKeyRow
+	err := row.Scan(&i.OrderID, &i.RequestHash)
+	return &i, err
+}
+
+const getOrderStateHistory = `-- name: GetOrderStateHistory :many
+SELECT state, changed_at
+FROM order_state_history
+WHERE order_id = $1
+ORDER BY changed_at, id
+`
+
+type GetOrderStateHistoryRow struct {
+	State     OrderStatus
+	ChangedAt pgtype.Timestamptz
+}
+
+func (q *Queries) GetOrderStateHistory(ctx context.Context, orderID int64) ([]*GetOrderStateHistoryRow, error) {
+	rows, err := q.db.Query(ctx, getOrderStateHistory, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*GetOrderStateHistoryRow
+	for rows.Next() {
+		var i GetOrderStateHistoryRow
+		if err := rows.Scan(&i.State, &i.ChangedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOrderTimestamps = `-- name: GetOrderTimestamps :one
+SELECT created_at, updated_at
+FROM orders
+WHERE id = $1
+`
+
+type GetOrderTimestampsRow struct {
+	CreatedAt pgtype.Timestamptz
+	UpdatedAt pgtype.Timestamptz
+}
+
+func (q *Queries) GetOrderTimestamps(ctx context.Context, orderID int64) (*GetOrderTimestampsRow, error) {
+	row := q.db.QueryRow(ctx, getOrderTimestamps, orderID)
+	var i GetOrderTimestampsRow
+	err := row.Scan(&i.CreatedAt, &i.UpdatedAt)
+	return &i, err
+}
+
+const getPendingSagaSteps = `-- name: GetPendingSagaSteps :many
+SELECT order_id, user_id, operation, target_state, created_at
+FROM order_saga
+WHERE created_at < $1
+ORDER BY created_at
+LIMIT $2
+`
+
+type GetPendingSagaStepsParams struct {
+	CreatedBefore pgtype.Timestamptz
+	MaxCount      int32
+}
+
+func (q *Queries) GetPendingSagaSteps(ctx context.Context, arg *GetPendingSagaStepsParams) ([]*OrderSaga, error) {
+	rows, err := q.db.Query(ctx, getPendingSagaSteps, arg.CreatedBefore, arg.MaxCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*OrderSaga
+	for rows.Next() {
+		var i OrderSaga
+		if err := rows.Scan(
+			&i.OrderID,
+			&i.UserID,
+			&i.Operation,
+			&i.TargetState,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrders = `-- name: ListOrders :many
+SELECT orders.id,
+       orders.state,
+       orders.user_id,
+       array_agg(i.sku ORDER BY i.sku)::bigint[]   AS skus,
+       array_agg(i.count ORDER BY i.sku)::bigint[] AS counts
+FROM orders
+JOIN items i on orders.id = i.order_id
+WHERE ($1::bigint = 0 OR orders.id < $1::bigint)
+  AND ($2::bigint = 0 OR orders.user_id = $2::bigint)
+  AND ($3::text = '' OR orders.state::text = $3::text)
+GROUP BY orders.id
+ORDER BY orders.id DESC
+LIMIT $4
+`
+
+type ListOrdersParams struct {
+	BeforeID int64
+	UserID   int64
+	State    string
+	MaxCount int32
+}
+
+type ListOrdersRow struct {
+	ID     int64
+	State  OrderStatus
+	UserID int64
+	Skus   []int64
+	Counts []int64
+}
+
+func (q *Queries) ListOrders(ctx context.Context, arg *ListOrdersParams) ([]*ListOrdersRow, error) {
+	rows, err := q.db.Query(ctx, listOrders,
+		arg.BeforeID,
+		arg.UserID,
+		arg.State,
+		arg.MaxCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*ListOrdersRow
+	for rows.Next() {
+		var i ListOrdersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.State,
+			&i.UserID,
+			&i.Skus,
+			&i.Counts,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const saveIdempotencyKey = `-- name: SaveIdempotencyKey :execrows
+INSERT INTO idempotency_keys (user_id, idempotency_key, order_id, request_hash)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id, idempotency_key) DO NOTHING
+`
+
+type SaveIdempotencyKeyParams struct {
+	UserID         int64
+	IdempotencyKey string
+	OrderID        int64
+	RequestHash    string
+}
+
+func (q *Queries) SaveIdempotencyKey(ctx context.Context, arg *SaveIdempotencyKeyParams) (int64, error) {
+	result, err := q.db.Exec(ctx, saveIdempotencyKey,
+		arg.UserID,
+		arg.IdempotencyKey,
+		arg.OrderID,
+		arg.RequestHash,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const saveItems = `-- name: SaveItems :exec
 INSERT INTO items (sku, count, order_id)
 SELECT unnest($1::bigint[]), unnest($2::bigint[]), $3
@@ -115,40 +367,89 @@ func (q *Queries) SaveItems(ctx context.Context, arg *SaveItemsParams) error {
 }
 
 const saveOrder = `-- name: SaveOrder :one
-INSERT INTO orders (state, user_id)
-VALUES ($1, $2)
+INSERT INTO orders (id, state, user_id)
+VALUES (nextval('order_number_seq') * $1::bigint + $2::bigint, $3, $4)
 RETURNING id
 `
 
 type SaveOrderParams struct {
-	State  OrderStatus
-	UserID int64
+	BucketsCount int64
+	Bucket       int64
+	State        OrderStatus
+	UserID       int64
 }
 
 func (q *Queries) SaveOrder(ctx context.Context, arg *SaveOrderParams) (int64, error) {
-	row := q.db.QueryRow(ctx, saveOrder, arg.State, arg.UserID)
+	row := q.db.QueryRow(ctx, saveOrder,
+		arg.BucketsCount,
+		arg.Bucket,
+		arg.State,
+		arg.UserID,
+	)
 	var id int64
 	err := row.Scan(&id)
 	return id, err
 }
 
-const updateOrder = `-- name: UpdateOrder :one
+const saveOrderStateHistory = `-- name: SaveOrderStateHistory :exec
+INSERT INTO order_state_history (order_id, state)
+VALUES ($1, $2)
+`
+
+type SaveOrderStateHistoryParams struct {
+	OrderID int64
+	State   OrderStatus
+}
+
+func (q *Queries) SaveOrderStateHistory(ctx context.Context, arg *SaveOrderStateHistoryParams) error {
+	_, err := q.db.Exec(ctx, saveOrderStateHistory, arg.OrderID, arg.State)
+	return err
+}
+
+const saveSagaStep = `-- name: SaveSagaStep :execrows
+INSERT INTO order_saga (order_id, user_id, operation, target_state)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (order_id) DO NOTHING
+`
+
+type SaveSagaStepParams struct {
+	OrderID     int64
+	UserID      int64
+	Operation   StockOperationType
+	TargetState OrderStatus
+}
+
+func (q *Queries) SaveSagaStep(ctx context.Context, arg *SaveSagaStepParams) (int64, error) {
+	result, err := q.db.Exec(ctx, saveSagaStep,
+		arg.OrderID,
+		arg.UserID,
+		arg.Operation,
+		arg.TargetState,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateOrder = `-- name: UpdateOrder :execrows
 UPDATE orders
-SET state   = $1,
-    user_id = $2
-WHERE id = $3
-RETURNING id
+SET state      = $1,
+    updated_at = NOW()
+WHERE id = $2
+  AND state::text = ANY ($3::text[])
 `
 
 type UpdateOrderParams struct {
-	State   OrderStatus
-	UserID  int64
-	OrderID int64
+	State          OrderStatus
+	OrderID        int64
+	ExpectedStates []string
 }
 
 func (q *Queries) UpdateOrder(ctx context.Context, arg *UpdateOrderParams) (int64, error) {
-	row := q.db.QueryRow(ctx, updateOrder, arg.State, arg.UserID, arg.OrderID)
-	var id int64
-	err := row.Scan(&id)
-	return id, err
+	result, err := q.db.Exec(ctx, updateOrder, arg.State, arg.OrderID, arg.ExpectedStates)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
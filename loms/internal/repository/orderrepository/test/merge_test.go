@@ -0,0 +1,112 @@
+package test
+
+import (
+	"errors"
+	"route256/loms/internal/model"
+	"route256/loms/internal/repository/orderrepository"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sliceStream поток заказов шарда поверх среза, err возвращается после того, как заказы закончились
+type sliceStream struct {
+	orders []*model.Order
+	err    error
+}
+
+func (s *sliceStream) Next() (*model.Order, bool, error) {
+	if len(s.orders) == 0 {
+		return nil, false, s.err
+	}
+	order := s.orders[0]
+	s.orders = s.orders[1:]
+	return order, true, nil
+}
+
+func newStream(ids ...int64) *sliceStream {
+	orders := make([]*model.Order, 0, len(ids))
+	for _, id := range ids {
+		orders = append(orders, &model.Order{ID: id})
+	}
+	return &sliceStream{orders: orders}
+}
+
+func orderIDs(orders []*model.Order) []int64 {
+	ids := make([]int64, 0, len(orders))
+	for _, order := range orders {
+		ids = append(ids, order.ID)
+	}
+	return ids
+}
+
+func TestMergeOrderStreams(t *testing.T) {
+	tests := []struct {
+		name    string
+		streams [][]int64
+		limit   int
+		want    []int64
+	}{
+		{
+			name:    "unequal stream lengths",
+			streams: [][]int64{{90, 20}, {80, 70, 60, 50, 10}, {30}},
+			limit:   10,
+			want:    []int64{90, 80, 70, 60, 50, 30, 20, 10},
+		},
+		{
+			name:    "limit hit mid-stream",
+			streams: [][]int64{{90, 60, 30}, {80, 50, 20}, {70, 40, 10}},
+			limit:   4,
+			want:    []int64{90, 80, 70, 60},
+		},
+		{
+			name:    "empty shard",
+			streams: [][]int64{{}, {50, 30}, {40}},
+			limit:   10,
+			want:    []int64{50, 40, 30},
+		},
+		{
+			name:    "all shards empty",
+			streams: [][]int64{{}, {}},
+			limit:   10,
+			want:    []int64{},
+		},
+		{
+			name:    "order on two shards during resharding",
+			streams: [][]int64{{50, 40}, {40, 30}},
+			limit:   10,
+			want:    []int64{50, 40, 30},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			streams := make([]orderrepository.OrderStream, 0, len(tt.streams))
+			for _, ids := range tt.streams {
+				streams = append(streams, newStream(ids...))
+			}
+
+			orders, err := orderrepository.MergeOrderStreams(streams, tt.limit)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, orderIDs(orders))
+		})
+	}
+}
+
+func TestMergeOrderStreams_StreamError(t *testing.T) {
+	shardErr := errors.New("shard unavailable")
+	failing := newStream(70)
+	failing.err = shardErr
+
+	_, err := orderrepository.MergeOrderStreams([]orderrepository.OrderStream{newStream(90, 60, 50), failing}, 10)
+	assert.ErrorIs(t, err, shardErr)
+}
+
+func TestMergeOrderStreams_StreamErrorAfterLimit(t *testing.T) {
+	failing := newStream(70)
+	failing.err = errors.New("shard unavailable")
+
+	orders, err := orderrepository.MergeOrderStreams([]orderrepository.OrderStream{newStream(90, 80), failing}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{90, 80}, orderIDs(orders))
+}
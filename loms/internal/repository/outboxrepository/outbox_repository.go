@@ -1,12 +1,27 @@
 package outboxrepository
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"route256/loms/internal/model"
+	"route256/loms/internal/repository"
+	"slices"
+	"time"
 )
 
+// RetryPolicy ограничивает повторные отправки события outbox
+type RetryPolicy struct {
+	// MaxAttempts число попыток, после которого событие переводится в dead letter
+	MaxAttempts int
+	// BaseBackoff задержка после первой неудачи, каждая следующая удваивается
+	BaseBackoff time.Duration
+	// MaxBackoff предел задержки между попытками
+	MaxBackoff time.Duration
+}
+
 type Repository struct {
 }
 
@@ -17,19 +32,29 @@ func NewRepository() *Repository {
 
 // SaveOutboxEvent сохраняет событие в таблицу outbox в рамках переданной транзакции
 func (r *Repository) SaveOutboxEvent(ctx context.Context, tx pgx.Tx, event *model.OutboxEvent) error {
-	repTx := New(tx)
-	return repTx.SaveOutboxEvent(ctx, &SaveOutboxEventParams{
-		OrderID: event.OrderID,
-		Payload: event.Payload,
-	})
+	params := &SaveOutboxEventParams{Payload: event.Payload}
+	switch event.Stream {
+	case model.OrderOutboxStream:
+		params.OrderID = &event.OrderID
+	case model.StockOutboxStream:
+		sku := int64(event.SKU)
+		params.Sku = &sku
+	default:
+		return fmt.Errorf("unknown outbox stream %q", event.Stream)
+	}
+	return New(tx).SaveOutboxEvent(ctx, params)
 }
 
-// GetPendingOutboxEvents получает неотправленные события из таблицы outbox в рамках транзакции
-func (r *Repository) GetPendingOutboxEvents(ctx context.Context, tx pgx.Tx, limit int) ([]*model.OutboxEvent, error) {
-	repTx := New(tx)
-	eventsFromDB, err := repTx.GetPendingOutboxEvents(ctx, int32(limit))
+// ClaimOutboxEvents захватывает до limit неотправленных событий на время lease и возвращает их.
+// События, захваченные другим обработчиком, пропускаются, а событие заказа не захватывается,
+// пока не отправлены его предыдущие события. Запрос выполняется сам по себе, без транзакции вызывающего
+func (r *Repository) ClaimOutboxEvents(ctx context.Context, db DBTX, lease time.Duration, limit int) ([]*model.OutboxEvent, error) {
+	eventsFromDB, err := New(db).ClaimOutboxEvents(ctx, &ClaimOutboxEventsParams{
+		Lease:    pgtype.Interval{Microseconds: lease.Microseconds(), Valid: true},
+		MaxCount: int32(limit),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to get pending outbox events: %w", err)
+		return nil, fmt.Errorf("unable to claim outbox events: %w", err)
 	}
 
 	// Преобразуем результат в нужный формат
@@ -37,16 +62,103 @@ func (r *Repository) GetPendingOutboxEvents(ctx context.Context, tx pgx.Tx, limi
 	for i, event := range eventsFromDB {
 		events[i] = &model.OutboxEvent{
 			ID:        event.ID,
-			OrderID:   event.OrderID,
 			Payload:   event.Payload,
 			CreatedAt: event.CreatedAt.Time,
+			Attempts:  event.Attempts,
+		}
+		if err = setStreamKey(events[i], event.OrderID, event.Sku); err != nil {
+			return nil, err
 		}
 	}
+	// UPDATE ... RETURNING не сохраняет порядок подзапроса
+	slices.SortFunc(events, func(a, b *model.OutboxEvent) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
 	return events, nil
 }
 
-// MarkOutboxEventProcessed помечает событие как обработанное в рамках транзакции
-func (r *Repository) MarkOutboxEventProcessed(ctx context.Context, tx pgx.Tx, eventID int32) error {
-	repTx := New(tx)
-	return repTx.MarkOutboxEventProcessed(ctx, eventID)
+// MarkOutboxEventsProcessed помечает события отправленными и снимает с них аренду
+func (r *Repository) MarkOutboxEventsProcessed(ctx context.Context, db DBTX, eventIDs []int32) error {
+	if len(eventIDs) == 0 {
+		return nil
+	}
+	if err := New(db).MarkOutboxEventsProcessed(ctx, eventIDs); err != nil {
+		return fmt.Errorf("unable to mark outbox events processed: %w", err)
+	}
+	return nil
+}
+
+// RecordOutboxFailures учитывает неудачные попытки отправки событий и снимает с них аренду.
+// Следующая попытка откладывается по экспоненте от policy.BaseBackoff до policy.MaxBackoff.
+// События, исчерпавшие policy.MaxAttempts или с неисправимой ошибкой, переводятся в dead letter и возвращаются
+func (r *Repository) RecordOutboxFailures(ctx context.Context, db DBTX, failures []*model.OutboxFailure, policy RetryPolicy) ([]*model.OutboxEvent, error) {
+	if len(failures) == 0 {
+		return nil, nil
+	}
+	params := &RecordOutboxFailuresParams{
+		BaseBackoff: pgtype.Interval{Microseconds: policy.BaseBackoff.Microseconds(), Valid: true},
+		MaxBackoff:  pgtype.Interval{Microseconds: policy.MaxBackoff.Microseconds(), Valid: true},
+		MaxAttempts: int32(policy.MaxAttempts),
+		Ids:         make([]int32, len(failures)),
+		Errors:      make([]string, len(failures)),
+		Permanent:   make([]bool, len(failures)),
+	}
+	for i, failure := range failures {
+		params.Ids[i] = failure.EventID
+		params.Errors[i] = failure.Err.Error()
+		params.Permanent[i] = failure.Permanent
+	}
+	rows, err := New(db).RecordOutboxFailures(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("unable to record outbox failures: %w", err)
+	}
+
+	var deadLettered []*model.OutboxEvent
+	for _, row := range rows {
+		if !row.DeadLettered {
+			continue
+		}
+		event := &model.OutboxEvent{
+			ID:           row.ID,
+			Attempts:     row.Attempts,
+			DeadLettered: true,
+		}
+		if err = setStreamKey(event, row.OrderID, row.Sku); err != nil {
+			return nil, err
+		}
+		deadLettered = append(deadLettered, event)
+	}
+	return deadLettered, nil
+}
+
+// DeleteProcessedOutboxEvents удаляет до limit событий, отправленных раньше чем retention назад, и возвращает их число.
+// Недоставленные события не удаляются
+func (r *Repository) DeleteProcessedOutboxEvents(ctx context.Context, db DBTX, retention time.Duration, limit int) (int64, error) {
+	deleted, err := New(db).DeleteProcessedOutboxEvents(ctx, &DeleteProcessedOutboxEventsParams{
+		Retention: pgtype.Interval{Microseconds: retention.Microseconds(), Valid: true},
+		MaxCount:  int32(limit),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete processed outbox events: %w", err)
+	}
+	return deleted, nil
+}
+
+// setStreamKey определяет поток события по тому, задан у него заказ или SKU
+func setStreamKey(event *model.OutboxEvent, orderID *int64, sku *int64) error {
+	switch {
+	case orderID != nil:
+		event.Stream = model.OrderOutboxStream
+		event.OrderID = *orderID
+	case sku != nil:
+		safeSku, err := repository.SafeInt64ToUint32(*sku)
+		if err != nil {
+			return fmt.Errorf("outbox event %d: %w", event.ID, err)
+		}
+		event.Stream = model.StockOutboxStream
+		event.SKU = model.SKUType(safeSku)
+	default:
+		return fmt.Errorf("outbox event %d has neither order nor sku", event.ID)
+	}
+	return nil
 }
@@ -9,8 +9,10 @@ import (
 )
 
 type Querier interface {
-	GetPendingOutboxEvents(ctx context.Context, limit int32) ([]*GetPendingOutboxEventsRow, error)
-	MarkOutboxEventProcessed(ctx context.Context, id int32) error
+	ClaimOutboxEvents(ctx context.Context, arg *ClaimOutboxEventsParams) ([]*ClaimOutboxEventsRow, error)
+	DeleteProcessedOutboxEvents(ctx context.Context, arg *DeleteProcessedOutboxEventsParams) (int64, error)
+	MarkOutboxEventsProcessed(ctx context.Context, ids []int32) error
+	RecordOutboxFailures(ctx context.Context, arg *RecordOutboxFailuresParams) ([]*RecordOutboxFailuresRow, error)
 	SaveOutboxEvent(ctx context.Context, arg *SaveOutboxEventParams) error
 }
 
@@ -1,15 +1,53 @@
 -- name: SaveOutboxEvent :exec
-INSERT INTO outbox (order_id, payload)
-VALUES ($1, $2);
+INSERT INTO outbox (order_id, sku, payload)
+VALUES ($1, $2, $3);
 
--- name: GetPendingOutboxEvents :many
-SELECT id, order_id, payload, created_at
-FROM outbox
-WHERE processed = FALSE
-ORDER BY created_at
-LIMIT $1;
+-- name: ClaimOutboxEvents :many
+UPDATE outbox
+SET locked_until = now() + sqlc.arg(lease)::interval
+WHERE outbox.id IN (SELECT pending.id
+                    FROM outbox pending
+                    WHERE pending.processed = FALSE
+                      AND pending.dead_lettered_at IS NULL
+                      AND pending.next_attempt_at <= now()
+                      AND (pending.locked_until IS NULL OR pending.locked_until < now())
+                      AND NOT EXISTS (SELECT 1
+                                      FROM outbox earlier
+                                      WHERE (earlier.order_id = pending.order_id OR earlier.sku = pending.sku)
+                                        AND earlier.processed = FALSE
+                                        AND earlier.dead_lettered_at IS NULL
+                                        AND earlier.id < pending.id)
+                    ORDER BY pending.id
+                    LIMIT @max_count FOR UPDATE SKIP LOCKED)
+RETURNING outbox.id, outbox.order_id, outbox.sku, outbox.payload, outbox.created_at, outbox.attempts;
+
+-- name: MarkOutboxEventsProcessed :exec
+UPDATE outbox
+SET processed    = TRUE,
+    processed_at = now(),
+    locked_until = NULL
+WHERE id = ANY (@ids::int[]);
 
--- name: MarkOutboxEventProcessed :exec
+-- name: RecordOutboxFailures :many
 UPDATE outbox
-SET processed = TRUE
-WHERE id = $1;
+SET attempts         = outbox.attempts + 1,
+    last_error       = failures.error,
+    locked_until     = NULL,
+    next_attempt_at  = now() + LEAST(sqlc.arg(base_backoff)::interval * power(2, LEAST(outbox.attempts, 30)),
+                                     sqlc.arg(max_backoff)::interval),
+    dead_lettered_at = CASE
+                           WHEN failures.permanent OR outbox.attempts + 1 >= sqlc.arg(max_attempts)::int THEN now()
+                           END
+FROM unnest(@ids::int[], @errors::text[], @permanent::boolean[]) AS failures(id, error, permanent)
+WHERE outbox.id = failures.id
+  AND outbox.processed = FALSE
+RETURNING outbox.id, outbox.order_id, outbox.sku, outbox.attempts, outbox.dead_lettered_at IS NOT NULL AS dead_lettered;
+
+-- name: DeleteProcessedOutboxEvents :execrows
+DELETE
+FROM outbox
+WHERE id IN (SELECT processed_event.id
+             FROM outbox processed_event
+             WHERE processed_event.processed = TRUE
+               AND processed_event.processed_at < now() - sqlc.arg(retention)::interval
+             LIMIT @max_count);
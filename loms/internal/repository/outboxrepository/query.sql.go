@@ -11,35 +11,56 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-const getPendingOutboxEvents = `-- name: GetPendingOutboxEvents :many
-SELECT id, order_id, payload, created_at
-FROM outbox
-WHERE processed = FALSE
-ORDER BY created_at
-LIMIT $1
+const claimOutboxEvents = `-- name: ClaimOutboxEvents :many
+UPDATE outbox
+SET locked_until = now() + $1::interval
+WHERE outbox.id IN (SELECT pending.id
+                    FROM outbox pending
+                    WHERE pending.processed = FALSE
+                      AND pending.dead_lettered_at IS NULL
+                      AND pending.next_attempt_at <= now()
+                      AND (pending.locked_until IS NULL OR pending.locked_until < now())
+                      AND NOT EXISTS (SELECT 1
+                                      FROM outbox earlier
+                                      WHERE (earlier.order_id = pending.order_id OR earlier.sku = pending.sku)
+                                        AND earlier.processed = FALSE
+                                        AND earlier.dead_lettered_at IS NULL
+                                        AND earlier.id < pending.id)
+                    ORDER BY pending.id
+                    LIMIT $2 FOR UPDATE SKIP LOCKED)
+RETURNING outbox.id, outbox.order_id, outbox.sku, outbox.payload, outbox.created_at, outbox.attempts
 `
 
-type GetPendingOutboxEventsRow struct {
+type ClaimOutboxEventsParams struct {
+	Lease    pgtype.Interval
+	MaxCount int32
+}
+
+type ClaimOutboxEventsRow struct {
 	ID        int32
-	OrderID   int64
-	Payload   string
+	OrderID   *int64
+	Sku       *int64
+	Payload   []byte
 	CreatedAt pgtype.Timestamptz
+	Attempts  int32
 }
 
-func (q *Queries) GetPendingOutboxEvents(ctx context.Context, limit int32) ([]*GetPendingOutboxEventsRow, error) {
-	rows, err := q.db.Query(ctx, getPendingOutboxEvents, limit)
+func (q *Queries) ClaimOutboxEvents(ctx context.Context, arg *ClaimOutboxEventsParams) ([]*ClaimOutboxEventsRow, error) {
+	rows, err := q.db.Query(ctx, claimOutboxEvents, arg.Lease, arg.MaxCount)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []*GetPendingOutboxEventsRow
+	var items []*ClaimOutboxEventsRow
 	for rows.Next() {
-		var i GetPendingOutboxEventsRow
+		var i ClaimOutboxEventsRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.OrderID,
+			&i.Sku,
 			&i.Payload,
 			&i.CreatedAt,
+			&i.Attempts,
 		); err != nil {
 			return nil, err
 		}
@@ -51,28 +72,120 @@ func (q *Queries) GetPendingOutboxEvents(ctx context.Context, limit int32) ([]*G
 	return items, nil
 }
 
-const markOutboxEventProcessed = `-- name: MarkOutboxEventProcessed :exec
+const deleteProcessedOutboxEvents = `-- name: DeleteProcessedOutboxEvents :execrows
+DELETE
+FROM outbox
+WHERE id IN (SELECT processed_event.id
+             FROM outbox processed_event
+             WHERE processed_event.processed = TRUE
+               AND processed_event.processed_at < now() - $1::interval
+             LIMIT $2)
+`
+
+type DeleteProcessedOutboxEventsParams struct {
+	Retention pgtype.Interval
+	MaxCount  int32
+}
+
+func (q *Queries) DeleteProcessedOutboxEvents(ctx context.Context, arg *DeleteProcessedOutboxEventsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteProcessedOutboxEvents, arg.Retention, arg.MaxCount)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const markOutboxEventsProcessed = `-- name: MarkOutboxEventsProcessed :exec
 UPDATE outbox
-SET processed = TRUE
-WHERE id = $1
+SET processed    = TRUE,
+    processed_at = now(),
+    locked_until = NULL
+WHERE id = ANY ($1::int[])
 `
 
-func (q *Queries) MarkOutboxEventProcessed(ctx context.Context, id int32) error {
-	_, err := q.db.Exec(ctx, markOutboxEventProcessed, id)
+func (q *Queries) MarkOutboxEventsProcessed(ctx context.Context, ids []int32) error {
+	_, err := q.db.Exec(ctx, markOutboxEventsProcessed, ids)
 	return err
 }
 
+const recordOutboxFailures = `-- name: RecordOutboxFailures :many
+UPDATE outbox
+SET attempts         = outbox.attempts + 1,
+    last_error       = failures.error,
+    locked_until     = NULL,
+    next_attempt_at  = now() + LEAST($1::interval * power(2, LEAST(outbox.attempts, 30)),
+                                     $2::interval),
+    dead_lettered_at = CASE
+                           WHEN failures.permanent OR outbox.attempts + 1 >= $3::int THEN now()
+                           END
+FROM unnest($4::int[], $5::text[], $6::boolean[]) AS failures(id, error, permanent)
+WHERE outbox.id = failures.id
+  AND outbox.processed = FALSE
+RETURNING outbox.id, outbox.order_id, outbox.sku, outbox.attempts, outbox.dead_lettered_at IS NOT NULL AS dead_lettered
+`
+
+type RecordOutboxFailuresParams struct {
+	BaseBackoff pgtype.Interval
+	MaxBackoff  pgtype.Interval
+	MaxAttempts int32
+	Ids         []int32
+	Errors      []string
+	Permanent   []bool
+}
+
+type RecordOutboxFailuresRow struct {
+	ID           int32
+	OrderID      *int64
+	Sku          *int64
+	Attempts     int32
+	DeadLettered bool
+}
+
+func (q *Queries) RecordOutboxFailures(ctx context.Context, arg *RecordOutboxFailuresParams) ([]*RecordOutboxFailuresRow, error) {
+	rows, err := q.db.Query(ctx, recordOutboxFailures,
+		arg.BaseBackoff,
+		arg.MaxBackoff,
+		arg.MaxAttempts,
+		arg.Ids,
+		arg.Errors,
+		arg.Permanent,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*RecordOutboxFailuresRow
+	for rows.Next() {
+		var i RecordOutboxFailuresRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.Sku,
+			&i.Attempts,
+			&i.DeadLettered,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const saveOutboxEvent = `-- name: SaveOutboxEvent :exec
-INSERT INTO outbox (order_id, payload)
-VALUES ($1, $2)
+INSERT INTO outbox (order_id, sku, payload)
+VALUES ($1, $2, $3)
 `
 
 type SaveOutboxEventParams struct {
-	OrderID int64
-	Payload string
+	OrderID *int64
+	Sku     *int64
+	Payload []byte
 }
 
 func (q *Queries) SaveOutboxEvent(ctx context.Context, arg *SaveOutboxEventParams) error {
-	_, err := q.db.Exec(ctx, saveOutboxEvent, arg.OrderID, arg.Payload)
+	_, err := q.db.Exec(ctx, saveOutboxEvent, arg.OrderID, arg.Sku, arg.Payload)
 	return err
 }
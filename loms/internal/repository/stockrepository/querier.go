@@ -10,6 +10,9 @@ import (
 
 type Querier interface {
 	GetStockBySkus(ctx context.Context, skus []int64) ([]*Stock, error)
+	GetStockBySkusForUpdate(ctx context.Context, skus []int64) ([]*Stock, error)
+	IsStockOperationApplied(ctx context.Context, arg *IsStockOperationAppliedParams) (bool, error)
+	SaveStockOperation(ctx context.Context, arg *SaveStockOperationParams) error
 	UpdateStockInfo(ctx context.Context, arg *UpdateStockInfoParams) error
 }
 
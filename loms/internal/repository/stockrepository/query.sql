@@ -13,3 +13,22 @@ SELECT sku,
        reserved_count
 FROM stock
 WHERE sku = ANY(@skus :: bigint[]);
+
+-- name: IsStockOperationApplied :one
+SELECT EXISTS(SELECT 1
+              FROM stock_operations
+              WHERE order_id = @order_id
+                AND operation = @operation);
+
+-- name: SaveStockOperation :exec
+INSERT INTO stock_operations (order_id, operation, sku)
+SELECT @order_id, @operation, unnest(@skus::bigint[]);
+
+-- name: GetStockBySkusForUpdate :many
+SELECT sku,
+       total_count,
+       reserved_count
+FROM stock
+WHERE sku = ANY(@skus :: bigint[])
+ORDER BY sku
+FOR UPDATE;
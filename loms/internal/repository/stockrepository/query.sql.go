@@ -37,6 +37,71 @@ func (q *Queries) GetStockBySkus(ctx context.Context, skus []int64) ([]*Stock, e
 	return items, nil
 }
 
+const getStockBySkusForUpdate = `-- name: GetStockBySkusForUpdate :many
+SELECT sku,
+       total_count,
+       reserved_count
+FROM stock
+WHERE sku = ANY($1 :: bigint[])
+ORDER BY sku
+FOR UPDATE
+`
+
+func (q *Queries) GetStockBySkusForUpdate(ctx context.Context, skus []int64) ([]*Stock, error) {
+	rows, err := q.db.Query(ctx, getStockBySkusForUpdate, skus)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*Stock
+	for rows.Next() {
+		var i Stock
+		if err := rows.Scan(&i.Sku, &i.TotalCount, &i.ReservedCount); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const isStockOperationApplied = `-- name: IsStockOperationApplied :one
+SELECT EXISTS(SELECT 1
+              FROM stock_operations
+              WHERE order_id = $1
+                AND operation = $2)
+`
+
+type IsStockOperationAppliedParams struct {
+	OrderID   int64
+	Operation StockOperationType
+}
+
+func (q *Queries) IsStockOperationApplied(ctx context.Context, arg *IsStockOperationAppliedParams) (bool, error) {
+	row := q.db.QueryRow(ctx, isStockOperationApplied, arg.OrderID, arg.Operation)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const saveStockOperation = `-- name: SaveStockOperation :exec
+INSERT INTO stock_operations (order_id, operation, sku)
+SELECT $1, $2, unnest($3::bigint[])
+`
+
+type SaveStockOperationParams struct {
+	OrderID   int64
+	Operation StockOperationType
+	Skus      []int64
+}
+
+func (q *Queries) SaveStockOperation(ctx context.Context, arg *SaveStockOperationParams) error {
+	_, err := q.db.Exec(ctx, saveStockOperation, arg.OrderID, arg.Operation, arg.Skus)
+	return err
+}
+
 const updateStockInfo = `-- name: UpdateStockInfo :exec
 UPDATE stock
 SET total_count    = data.total_count,
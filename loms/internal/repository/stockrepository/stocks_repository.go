@@ -9,32 +9,129 @@ import (
 	"route256/loms/internal/infra/database"
 	"route256/loms/internal/model"
 	"route256/loms/internal/repository"
+	"route256/loms/internal/repository/outboxrepository"
+	"slices"
+	"time"
 )
 
 type Repository struct {
-	pool ConnectionPooler
+	pool             ConnectionPooler
+	outboxRepository *outboxrepository.Repository
 }
 
 type ConnectionPooler interface {
-	PickDefaultShard(ctx context.Context, readOnly bool) (*database.FallbackConnection, error)
+	PickConnFromSkus(ctx context.Context, skus []int64, readOnly bool) (*database.FallbackConnection, error)
 }
 
-func NewRepository(pool ConnectionPooler) *Repository {
+func NewRepository(pool ConnectionPooler, oR *outboxrepository.Repository) *Repository {
 	return &Repository{
-		pool: pool,
+		pool:             pool,
+		outboxRepository: oR,
 	}
 }
 
+// GetStocks читает стоки с реплики шарда, на котором они лежат. Все SKU должны лежать на одном шарде
 func (r *Repository) GetStocks(ctx context.Context, sku []model.SKUType) (stocks []*model.Stock, err error) {
 	intSku := make([]int64, 0, len(sku))
 	for _, s := range sku {
 		intSku = append(intSku, int64(s))
 	}
-	conn, err := r.pool.PickDefaultShard(ctx, true)
+	conn, err := r.pool.PickConnFromSkus(ctx, intSku, true)
 	if err != nil {
 		return nil, fmt.Errorf("unable to acquire a connection: %w", err)
 	}
-	repositoryStocks, err := New(conn).GetStockBySkus(ctx, intSku)
+	defer conn.Release()
+	return getStocks(ctx, sku, New(conn).GetStockBySkus)
+}
+
+// GetStocksForUpdate читает стоки на мастере в транзакции tx и блокирует их строки до конца транзакции,
+// поэтому параллельные изменения одного SKU выполняются последовательно.
+// Строки блокируются в порядке возрастания SKU, чтобы заказы с пересекающимися товарами не ловили дедлок
+func (r *Repository) GetStocksForUpdate(ctx context.Context, tx pgx.Tx, sku []model.SKUType) ([]*model.Stock, error) {
+	return getStocks(ctx, sku, New(tx).GetStockBySkusForUpdate)
+}
+
+// UpdateStock сохраняет новые значения стоков, измененных операцией над заказом, в транзакции tx
+// вместе с событием stock.changed в outbox по каждому SKU
+func (r *Repository) UpdateStock(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation,
+	stocks map[model.SKUType]*model.Stock) error {
+	updateParamStock := repackStocksMapToUpdateStockParam(stocks)
+	result, err := tx.Exec(ctx, updateStockInfo, updateParamStock.Skus,
+		updateParamStock.TotalCounts,
+		updateParamStock.ReservedCounts)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() != int64(len(stocks)) {
+		return fmt.Errorf("expected %v rows affected, got %v", len(stocks), result.RowsAffected())
+	}
+	return r.saveStockChanges(ctx, tx, orderID, operation, stocks)
+}
+
+// saveStockChanges пишет в outbox события об изменении стоков в порядке возрастания SKU
+func (r *Repository) saveStockChanges(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation,
+	stocks map[model.SKUType]*model.Stock) error {
+	skus := make([]model.SKUType, 0, len(stocks))
+	for sku := range stocks {
+		skus = append(skus, sku)
+	}
+	slices.Sort(skus)
+
+	occurredAt := time.Now()
+	for _, sku := range skus {
+		payload, err := repository.MarshalStockEvent(stocks[sku], orderID, operation, occurredAt)
+		if err != nil {
+			return err
+		}
+		err = r.outboxRepository.SaveOutboxEvent(ctx, tx, &model.OutboxEvent{
+			Stream:  model.StockOutboxStream,
+			SKU:     sku,
+			Payload: payload,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to save stock event: %w", err)
+		}
+	}
+	return nil
+}
+
+// IsStockOperationApplied проверяет, была ли операция над стоком для заказа уже выполнена на шарде транзакции tx
+func (r *Repository) IsStockOperationApplied(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation) (bool, error) {
+	applied, err := New(tx).IsStockOperationApplied(ctx, &IsStockOperationAppliedParams{
+		OrderID:   orderID,
+		Operation: StockOperationType(operation),
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to check stock operation: %w", err)
+	}
+	return applied, nil
+}
+
+// SaveStockOperation отмечает операцию над стоком для заказа как выполненную. Отметка хранится по каждому SKU,
+// чтобы при решардинге она переезжала вместе со стоком
+func (r *Repository) SaveStockOperation(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation, skus []model.SKUType) error {
+	intSkus := make([]int64, 0, len(skus))
+	for _, sku := range skus {
+		intSkus = append(intSkus, int64(sku))
+	}
+	err := New(tx).SaveStockOperation(ctx, &SaveStockOperationParams{
+		OrderID:   orderID,
+		Operation: StockOperationType(operation),
+		Skus:      intSkus,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to save stock operation: %w", err)
+	}
+	return nil
+}
+
+func getStocks(ctx context.Context, sku []model.SKUType,
+	query func(ctx context.Context, skus []int64) ([]*Stock, error)) ([]*model.Stock, error) {
+	intSku := make([]int64, 0, len(sku))
+	for _, s := range sku {
+		intSku = append(intSku, int64(s))
+	}
+	repositoryStocks, err := query(ctx, intSku)
 	if err != nil {
 		return nil, err
 	}
@@ -52,36 +149,9 @@ func (r *Repository) GetStocks(ctx context.Context, sku []model.SKUType) (stocks
 				unfindedSku = append(unfindedSku, s)
 			}
 		}
-		return nil, fmt.Errorf("sku %v in database", apperrors.ErrNotFound)
+		return nil, fmt.Errorf("sku %v in database: %w", unfindedSku, apperrors.ErrNotFound)
 	}
-	stocks, err = repackRepositoryStockToModelStock(repositoryStocks)
-	if err != nil {
-		return nil, err
-	}
-	return stocks, nil
-}
-
-func (r *Repository) UpdateStock(ctx context.Context, stocks map[model.SKUType]*model.Stock) error {
-	conn, err := r.pool.PickDefaultShard(ctx, false)
-	if err != nil {
-		return fmt.Errorf("unable to acquire a connection: %w", err)
-	}
-	defer conn.Release()
-	err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) (err error) {
-		repTx := New(tx)
-		updateParamStock := repackStocksMapToUpdateStockParam(stocks)
-		result, err := repTx.db.Exec(ctx, updateStockInfo, updateParamStock.Skus,
-			updateParamStock.TotalCounts,
-			updateParamStock.ReservedCounts)
-		if err != nil {
-			return err
-		}
-		if result.RowsAffected() != int64(len(stocks)) {
-			return fmt.Errorf("expected %v rows affected, got %v", len(stocks), result.RowsAffected())
-		}
-		return nil
-	})
-	return err
+	return repackRepositoryStockToModelStock(repositoryStocks)
 }
 
 func repackStocksMapToUpdateStockParam(stocks map[model.SKUType]*model.Stock) *UpdateStockInfoParams {
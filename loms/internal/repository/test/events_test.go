@@ -0,0 +1,88 @@
+package test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	events "route256/libs/generated/api/events/v1"
+	"route256/loms/internal/model"
+	"route256/loms/internal/repository"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// update перезаписывает эталонные события, которые разбирает notifier
+var update = flag.Bool("update", false, "rewrite event fixtures in libs/api/events/v1/testdata")
+
+// fixturesDir эталонные события рядом с общей схемой: по ним notifier проверяет, что разбирает то, что пишет LOMS
+const fixturesDir = "../../../../libs/api/events/v1/testdata"
+
+var occurredAt = time.Date(2024, 10, 1, 12, 30, 0, 0, time.UTC)
+
+// assertFixture сравнивает событие с эталоном без учета идентификатора, который генерируется заново
+func assertFixture(t *testing.T, name string, payload []byte) {
+	t.Helper()
+	path := filepath.Join(fixturesDir, name)
+	if *update {
+		require.NoError(t, os.WriteFile(path, payload, 0o644))
+	}
+
+	var got, want events.EventEnvelope
+	require.NoError(t, proto.Unmarshal(payload, &got))
+	fixture, err := os.ReadFile(path)
+	require.NoError(t, err, "Нет эталона, запустите тест с -update")
+	require.NoError(t, proto.Unmarshal(fixture, &want))
+
+	_, err = uuid.Parse(got.GetEventId())
+	assert.NoError(t, err, "Идентификатор события не UUID")
+	got.EventId, want.EventId = "", ""
+	assert.True(t, proto.Equal(&want, &got), "Событие расходится с эталоном %s:\nwant %v\ngot  %v", name, &want, &got)
+}
+
+func TestMarshalOrderEvent(t *testing.T) {
+	order := &model.Order{
+		ID:     1005,
+		State:  model.PAYED,
+		UserId: 42,
+		Items:  []*model.Item{{SKU: 1076963, Count: 2}, {SKU: 1148162, Count: 1}},
+	}
+
+	payload, err := repository.MarshalOrderEvent(order, occurredAt)
+	require.NoError(t, err)
+
+	var envelope events.EventEnvelope
+	require.NoError(t, proto.Unmarshal(payload, &envelope))
+	assert.Equal(t, "order.paid", envelope.GetType())
+	assert.Equal(t, uint32(repository.EventVersion), envelope.GetVersion())
+	assert.Equal(t, occurredAt, envelope.GetOccurredAt().AsTime())
+	assert.Equal(t, int64(1005), envelope.GetOrder().GetOrderId())
+	assert.Equal(t, "PAYED", envelope.GetOrder().GetState())
+	assert.Len(t, envelope.GetOrder().GetItems(), 2)
+	assertFixture(t, "order_paid.v1.pb", payload)
+}
+
+func TestMarshalOrderEvent_UnknownState(t *testing.T) {
+	_, err := repository.MarshalOrderEvent(&model.Order{ID: 1, State: "UNKNOWN"}, occurredAt)
+	assert.Error(t, err)
+}
+
+func TestMarshalStockEvent(t *testing.T) {
+	stock := &model.Stock{SKU: 1076963, TotalCount: 10, ReservedCount: 4}
+
+	payload, err := repository.MarshalStockEvent(stock, 1005, model.RESERVE, occurredAt)
+	require.NoError(t, err)
+
+	var envelope events.EventEnvelope
+	require.NoError(t, proto.Unmarshal(payload, &envelope))
+	assert.Equal(t, repository.StockChangedEventType, envelope.GetType())
+	assert.Nil(t, envelope.GetOrder())
+	assert.Equal(t, int64(1076963), envelope.GetStock().GetSku())
+	assert.Equal(t, uint32(4), envelope.GetStock().GetReservedCount())
+	assert.Equal(t, "RESERVE", envelope.GetStock().GetOperation())
+	assertFixture(t, "stock_changed.v1.pb", payload)
+}
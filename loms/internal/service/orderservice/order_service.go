@@ -2,68 +2,110 @@ package orderservice
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
 	"log"
 	appErr "route256/loms/internal/errors"
 	"route256/loms/internal/model"
 	"route256/loms/internal/repository/orderrepository"
+	transactionmanager "route256/loms/internal/service/transactionamanger"
+	"slices"
+	"time"
 )
 
 var _ Repository = (*orderrepository.Repository)(nil)
+var _ TransactionManager = (*transactionmanager.TransactionManager)(nil)
+
+// recoverBatchSize максимальное число шагов саги, загружаемых с одного шарда за один проход восстановления
+const recoverBatchSize = 100
+
+// expireBatchSize максимальное число просроченных заказов, загружаемых с одного шарда за один проход
+const expireBatchSize = 100
+
+// DefaultPageSize размер страницы заказов, если он не указан в запросе
+const DefaultPageSize = 100
 
 type Repository interface {
-	SaveOrder(ctx context.Context, order *model.Order) (*model.Order, error)
-	UpdateOrder(ctx context.Context, order *model.Order) error
+	SaveOrder(ctx context.Context, tx pgx.Tx, order *model.Order, idempotencyKey string) (*model.Order, error)
+	GetOrderIdByIdempotencyKey(ctx context.Context, userId int64, idempotencyKey string) (orderID int64, requestHash string, err error)
+	UpdateOrder(ctx context.Context, tx pgx.Tx, order *model.Order) error
 	GetById(ctx context.Context, orderID int64) (*model.Order, error)
-	GetAllOrders(ctx context.Context) ([]*model.Order, error)
+	GetByIdFromReplica(ctx context.Context, orderID int64) (*model.Order, error)
+	GetByIdForUpdate(ctx context.Context, tx pgx.Tx, orderID int64) (*model.Order, error)
+	ListOrders(ctx context.Context, filter *model.OrdersFilter) ([]*model.Order, error)
+	ListUserOrders(ctx context.Context, filter *model.OrdersFilter) ([]*model.Order, error)
+	SaveSagaStep(ctx context.Context, tx pgx.Tx, step *model.SagaStep) error
+	DeleteSagaStep(ctx context.Context, tx pgx.Tx, orderID int64) (bool, error)
+	GetPendingSagaSteps(ctx context.Context, olderThan time.Duration, limit int32) ([]*model.SagaStep, error)
+	GetExpiredOrderIds(ctx context.Context, ttl time.Duration, limit int32) ([]int64, error)
+	GetStateHistory(ctx context.Context, orderID int64) (*model.OrderHistory, error)
 }
 
 type StockService interface {
-	Reserve(ctx context.Context, items []*model.Item) error
-	ReserveRemove(ctx context.Context, items []*model.Item) error
-	ReserveCancel(ctx context.Context, items []*model.Item) error
+	Reserve(ctx context.Context, tx pgx.Tx, orderID int64, items []*model.Item) error
+	ReserveRemove(ctx context.Context, tx pgx.Tx, orderID int64, items []*model.Item) error
+	ReserveCancel(ctx context.Context, tx pgx.Tx, orderID int64, items []*model.Item) error
+	CompensateReserve(ctx context.Context, tx pgx.Tx, orderID int64, items []*model.Item) error
+}
+
+type TransactionManager interface {
+	RunInUserShardTx(ctx context.Context, userId int64, fn func(tx pgx.Tx) error) error
+	RunInStockShardTx(ctx context.Context, skus []model.SKUType, fn func(tx pgx.Tx) error) error
+	StockShardIndex(sku model.SKUType) (int, error)
+	UserShardHoldsStock(userId int64, skus []model.SKUType) bool
 }
 
 type Service struct {
 	repository   Repository
 	stockService StockService
+	tm           TransactionManager
 }
 
-func NewService(repository Repository, stockService StockService) *Service {
-	return &Service{repository: repository, stockService: stockService}
+func NewService(repository Repository, stockService StockService, tm TransactionManager) *Service {
+	return &Service{repository: repository, stockService: stockService, tm: tm}
 }
 
-func (s *Service) Create(ctx context.Context, order *model.Order) (orderID int64, err error) {
-	_ = order.SetState(model.NEW)
-
-	order, err = s.repository.SaveOrder(ctx, order)
-	if err != nil {
-		log.Printf("[order_service] Error saving order: %v", err)
-		return 0, err
+// Create создает заказ и резервирует под него сток. При непустом idempotencyKey повторный вызов
+// с тем же ключом от того же пользователя вернет ID ранее созданного заказа без повторного резервирования
+// или ErrStockInsufficient, если заказ был отклонен, а повтор ключа с другим составом заказа вернет ErrIdempotencyConflict.
+// Если все стоки заказа лежат на шарде пользователя, заказ и резерв сохраняются в одной транзакции,
+// иначе резерв выполняется через журнал саги
+func (s *Service) Create(ctx context.Context, order *model.Order, idempotencyKey string) (orderID int64, err error) {
+	if idempotencyKey != "" {
+		orderID, err = s.findOrderByIdempotencyKey(ctx, order, idempotencyKey)
+		if err == nil {
+			log.Printf("[order_service] Replay of order %d by idempotency key %q", orderID, idempotencyKey)
+			return orderID, nil
+		}
+		if !errors.Is(err, appErr.ErrNotFound) {
+			return 0, err
+		}
 	}
 
-	err = s.stockService.Reserve(ctx, order.Items)
-	if err != nil {
-		_ = order.SetState(model.FAILED)
-		if updateErr := s.repository.UpdateOrder(ctx, order); updateErr != nil {
-			log.Printf("[order_service] Error updating order state: %v", updateErr)
-			return 0, updateErr
-		}
-		log.Printf("[order_service] Error reserving stock: %v", err)
+	if err = order.SetState(model.NEW); err != nil {
 		return 0, err
 	}
 
-	_ = order.SetState(model.AWAITING_PAYMENT)
-	err = s.repository.UpdateOrder(ctx, order)
+	userId := order.UserId
+	if s.tm.UserShardHoldsStock(userId, itemSkus(order.Items)) {
+		orderID, err = s.createInSingleTx(ctx, order, idempotencyKey)
+	} else {
+		orderID, err = s.createWithSaga(ctx, order, idempotencyKey)
+	}
+	// параллельный запрос с тем же ключом успел создать заказ раньше нас
+	if errors.Is(err, appErr.ErrIdempotencyKey) {
+		return s.findOrderByIdempotencyKey(ctx, order, idempotencyKey)
+	}
 	if err != nil {
-		log.Printf("[order_service] Error updating order state: %v", err)
 		return 0, err
 	}
-
-	return order.ID, nil
+	return orderID, nil
 }
 
+// GetById читает заказ для клиента, чтение может уйти на реплику
 func (s *Service) GetById(ctx context.Context, orderID int64) (*model.Order, error) {
-	order, err := s.repository.GetById(ctx, orderID)
+	order, err := s.repository.GetByIdFromReplica(ctx, orderID)
 	if err != nil {
 		log.Printf("[order_service] Error getting order: %v", err)
 		return nil, err
@@ -71,67 +113,400 @@ func (s *Service) GetById(ctx context.Context, orderID int64) (*model.Order, err
 	return order, nil
 }
 
+// GetHistory возвращает историю смены статусов заказа
+func (s *Service) GetHistory(ctx context.Context, orderID int64) (*model.OrderHistory, error) {
+	history, err := s.repository.GetStateHistory(ctx, orderID)
+	if err != nil {
+		log.Printf("[order_service] Error getting order history: %v", err)
+		return nil, err
+	}
+	return history, nil
+}
+
 func (s *Service) OrderPay(ctx context.Context, orderID int64) error {
-	order, err := s.repository.GetById(ctx, orderID)
+	return s.changeReservedOrderState(ctx, orderID, model.RESERVE_REMOVE, model.PAYED)
+}
+
+func (s *Service) OrderCancel(ctx context.Context, orderID int64) error {
+	return s.changeReservedOrderState(ctx, orderID, model.RESERVE_CANCEL, model.CANCELLED)
+}
+
+// ListOrders возвращает страницу заказов всех шардов по убыванию ID и ключ курсора следующей страницы.
+// Нулевой nextBeforeID означает, что страница последняя
+func (s *Service) ListOrders(ctx context.Context, filter model.OrdersFilter) (orders []*model.Order, nextBeforeID int64, err error) {
+	return s.listPage(ctx, filter, s.repository.ListOrders)
+}
+
+// ListUserOrders возвращает страницу заказов пользователя filter.UserId, читая только его шард
+func (s *Service) ListUserOrders(ctx context.Context, filter model.OrdersFilter) (orders []*model.Order, nextBeforeID int64, err error) {
+	if filter.UserId <= 0 {
+		return nil, 0, fmt.Errorf("user id %d: %w", filter.UserId, appErr.ErrInvalidInput)
+	}
+	return s.listPage(ctx, filter, s.repository.ListUserOrders)
+}
+
+func (s *Service) listPage(
+	ctx context.Context,
+	filter model.OrdersFilter,
+	list func(ctx context.Context, filter *model.OrdersFilter) ([]*model.Order, error),
+) (orders []*model.Order, nextBeforeID int64, err error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultPageSize
+	}
+	pageSize := filter.Limit
+	// запрашиваем на один заказ больше, чтобы узнать, есть ли следующая страница
+	filter.Limit++
+
+	orders, err = list(ctx, &filter)
 	if err != nil {
-		log.Printf("[order_service] Error getting order: %v", err)
-		return err
+		log.Printf("[order_service] Error getting orders: %v", err)
+		return nil, 0, err
+	}
+	if len(orders) > pageSize {
+		orders = orders[:pageSize]
+		nextBeforeID = orders[pageSize-1].ID
 	}
+	return orders, nextBeforeID, nil
+}
 
-	if order.State != model.AWAITING_PAYMENT {
-		log.Printf("[order_service] Invalid order state: %v", order.State)
-		return appErr.ErrOrderState
+// findOrderByIdempotencyKey возвращает заказ, созданный по ключу, если он создан с тем же составом.
+// Пустой хеш у ключей, сохраненных до появления проверки, совпадает с любым составом.
+// Для заказа в статусе FAILED возвращается ErrStockInsufficient, как при первом вызове
+func (s *Service) findOrderByIdempotencyKey(ctx context.Context, order *model.Order, idempotencyKey string) (int64, error) {
+	orderID, requestHash, err := s.repository.GetOrderIdByIdempotencyKey(ctx, order.UserId, idempotencyKey)
+	if err != nil {
+		if !errors.Is(err, appErr.ErrNotFound) {
+			log.Printf("[order_service] Error getting order by idempotency key: %v", err)
+		}
+		return 0, err
+	}
+	if requestHash != "" && requestHash != order.RequestHash() {
+		return 0, fmt.Errorf("user %d, key %q: %w", order.UserId, idempotencyKey, appErr.ErrIdempotencyConflict)
 	}
 
-	err = s.stockService.ReserveRemove(ctx, order.Items)
+	// заказ, отклоненный из-за стока, сохранен в FAILED вместе с ключом, повтор должен получить ту же ошибку
+	savedOrder, err := s.repository.GetById(ctx, orderID)
 	if err != nil {
-		log.Printf("[order_service] Error removing stock reservation: %v", err)
-		return err
+		log.Printf("[order_service] Error getting order by idempotency key: %v", err)
+		return 0, err
+	}
+	if savedOrder.State == model.FAILED {
+		return 0, fmt.Errorf("order %d: %w", orderID, appErr.ErrStockInsufficient)
 	}
+	return orderID, nil
+}
 
-	_ = order.SetState(model.PAYED)
-	err = s.repository.UpdateOrder(ctx, order)
+// RecoverSagas завершает шаги саг, записанные в журнал раньше чем olderThan назад.
+// Такие шаги остаются после сбоя между изменением стока и сменой статуса заказа
+func (s *Service) RecoverSagas(ctx context.Context, olderThan time.Duration) error {
+	steps, err := s.repository.GetPendingSagaSteps(ctx, olderThan, recoverBatchSize)
 	if err != nil {
-		log.Printf("[order_service] Error updating order state: %v", err)
+		log.Printf("[order_service] Error getting pending saga steps: %v", err)
 		return err
 	}
-
+	for _, step := range steps {
+		order, err := s.repository.GetById(ctx, step.OrderID)
+		if err != nil {
+			log.Printf("[order_service] Error getting order %d for saga recovery: %v", step.OrderID, err)
+			continue
+		}
+		err = s.completeSaga(ctx, step, order.Items)
+		if err != nil && !isStockRejection(err) {
+			log.Printf("[order_service] Error recovering saga for order %d: %v", step.OrderID, err)
+			continue
+		}
+		log.Printf("[order_service] Saga %v for order %d recovered", step.Operation, step.OrderID)
+	}
 	return nil
 }
 
-func (s *Service) OrderCancel(ctx context.Context, orderID int64) error {
+// CancelExpired отменяет заказы, ожидающие оплаты дольше ttl, и возвращает число отмененных.
+// Заказ отменяется так же, как через OrderCancel: резерв снимается, в outbox пишется событие
+func (s *Service) CancelExpired(ctx context.Context, ttl time.Duration) (int, error) {
+	orderIDs, err := s.repository.GetExpiredOrderIds(ctx, ttl, expireBatchSize)
+	if err != nil {
+		log.Printf("[order_service] Error getting expired orders: %v", err)
+		return 0, err
+	}
+	cancelled := 0
+	for _, orderID := range orderIDs {
+		err = s.OrderCancel(ctx, orderID)
+		if err != nil {
+			// заказ успели оплатить или отменить после выборки
+			if !errors.Is(err, appErr.ErrOrderState) {
+				log.Printf("[order_service] Error cancelling expired order %d: %v", orderID, err)
+			}
+			continue
+		}
+		log.Printf("[order_service] Order %d cancelled by reservation expiry", orderID)
+		cancelled++
+	}
+	return cancelled, nil
+}
+
+// createInSingleTx сохраняет заказ и резервирует сток в одной транзакции.
+// При нехватке стока заказ сохраняется в статусе FAILED, а ошибка резерва возвращается после коммита
+func (s *Service) createInSingleTx(ctx context.Context, order *model.Order, idempotencyKey string) (int64, error) {
+	var reserveErr error
+	err := s.tm.RunInUserShardTx(ctx, order.UserId, func(tx pgx.Tx) error {
+		savedOrder, err := s.repository.SaveOrder(ctx, tx, order, idempotencyKey)
+		if err != nil {
+			return err
+		}
+		order = savedOrder
+
+		targetState := model.AWAITING_PAYMENT
+		reserveErr = s.stockService.Reserve(ctx, tx, order.ID, order.Items)
+		if reserveErr != nil {
+			if !isStockRejection(reserveErr) {
+				return reserveErr
+			}
+			targetState = model.FAILED
+		}
+		if err = order.SetState(targetState); err != nil {
+			return err
+		}
+		return s.repository.UpdateOrder(ctx, tx, order)
+	})
+	if err != nil {
+		log.Printf("[order_service] Error creating order: %v", err)
+		return 0, err
+	}
+	if reserveErr != nil {
+		log.Printf("[order_service] Error reserving stock: %v", reserveErr)
+		return 0, reserveErr
+	}
+	return order.ID, nil
+}
+
+// createWithSaga сохраняет заказ вместе с шагом саги на шарде пользователя, после чего резервирует сток
+func (s *Service) createWithSaga(ctx context.Context, order *model.Order, idempotencyKey string) (int64, error) {
+	var step *model.SagaStep
+	err := s.tm.RunInUserShardTx(ctx, order.UserId, func(tx pgx.Tx) error {
+		savedOrder, err := s.repository.SaveOrder(ctx, tx, order, idempotencyKey)
+		if err != nil {
+			return err
+		}
+		order = savedOrder
+
+		step = &model.SagaStep{
+			OrderID:     order.ID,
+			UserId:      order.UserId,
+			Operation:   model.RESERVE,
+			TargetState: model.AWAITING_PAYMENT,
+		}
+		return s.repository.SaveSagaStep(ctx, tx, step)
+	})
+	if err != nil {
+		log.Printf("[order_service] Error saving order: %v", err)
+		return 0, err
+	}
+
+	err = s.completeSaga(ctx, step, order.Items)
+	if err != nil {
+		return 0, err
+	}
+	return order.ID, nil
+}
+
+// changeReservedOrderState выполняет операцию над резервом заказа и переводит его в targetState,
+// если переход разрешен таблицей переходов модели
+func (s *Service) changeReservedOrderState(ctx context.Context, orderID int64, operation model.StockOperation, targetState model.StateType) error {
 	order, err := s.repository.GetById(ctx, orderID)
 	if err != nil {
 		log.Printf("[order_service] Error getting order: %v", err)
 		return err
 	}
+	if err = order.CheckTransition(targetState); err != nil {
+		log.Printf("[order_service] Invalid order state: %v", err)
+		return err
+	}
+
+	if s.tm.UserShardHoldsStock(order.UserId, itemSkus(order.Items)) {
+		err = s.tm.RunInUserShardTx(ctx, order.UserId, func(tx pgx.Tx) error {
+			lockedOrder, err := s.lockOrderForTransition(ctx, tx, orderID, targetState)
+			if err != nil {
+				return err
+			}
+			err = s.applyStockOperation(ctx, tx, operation, lockedOrder.ID, lockedOrder.Items)
+			if err != nil {
+				return err
+			}
+			if err = lockedOrder.SetState(targetState); err != nil {
+				return err
+			}
+			return s.repository.UpdateOrder(ctx, tx, lockedOrder)
+		})
+		if err != nil {
+			log.Printf("[order_service] Error applying %v to order %d: %v", operation, orderID, err)
+			return err
+		}
+		return nil
+	}
+
+	step := &model.SagaStep{
+		OrderID:     order.ID,
+		UserId:      order.UserId,
+		Operation:   operation,
+		TargetState: targetState,
+	}
+	err = s.tm.RunInUserShardTx(ctx, order.UserId, func(tx pgx.Tx) error {
+		if _, err := s.lockOrderForTransition(ctx, tx, orderID, targetState); err != nil {
+			return err
+		}
+		return s.repository.SaveSagaStep(ctx, tx, step)
+	})
+	if err != nil {
+		log.Printf("[order_service] Error saving saga step for order %d: %v", orderID, err)
+		return err
+	}
+	return s.completeSaga(ctx, step, order.Items)
+}
+
+// lockOrderForTransition блокирует заказ и проверяет, что его все еще можно перевести в targetState
+func (s *Service) lockOrderForTransition(ctx context.Context, tx pgx.Tx, orderID int64, targetState model.StateType) (*model.Order, error) {
+	order, err := s.repository.GetByIdForUpdate(ctx, tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if err = order.CheckTransition(targetState); err != nil {
+		return nil, fmt.Errorf("order %d: %w", orderID, err)
+	}
+	return order, nil
+}
 
-	if order.State != model.AWAITING_PAYMENT {
-		log.Printf("[order_service] Invalid order state: %v", order.State)
-		return appErr.ErrOrderState
+// completeSaga выполняет операцию шага саги над стоком и завершает шаг на шарде заказа.
+// При инфраструктурной ошибке шаг остается в журнале и будет завершен при восстановлении.
+// Если сток отклонил операцию, резерв переводит заказ в FAILED, а оплата и отмена оставляют статус без изменений
+func (s *Service) completeSaga(ctx context.Context, step *model.SagaStep, items []*model.Item) error {
+	stockErr := s.applyStockOperationOnShards(ctx, step.Operation, step.OrderID, items)
+	if stockErr != nil && !isStockRejection(stockErr) {
+		log.Printf("[order_service] Error applying %v to order %d, saga step left for recovery: %v", step.Operation, step.OrderID, stockErr)
+		return stockErr
+	}
+
+	targetState := step.TargetState
+	if stockErr != nil {
+		log.Printf("[order_service] Stock rejected %v for order %d: %v", step.Operation, step.OrderID, stockErr)
+		targetState = ""
+		if step.Operation == model.RESERVE {
+			targetState = model.FAILED
+		}
 	}
 
-	err = s.stockService.ReserveCancel(ctx, order.Items)
+	err := s.tm.RunInUserShardTx(ctx, step.UserId, func(tx pgx.Tx) error {
+		deleted, err := s.repository.DeleteSagaStep(ctx, tx, step.OrderID)
+		if err != nil {
+			return err
+		}
+		// шаг уже завершен параллельным обработчиком
+		if !deleted || targetState == "" {
+			return nil
+		}
+		order, err := s.repository.GetByIdForUpdate(ctx, tx, step.OrderID)
+		if err != nil {
+			return err
+		}
+		if err = order.SetState(targetState); err != nil {
+			return err
+		}
+		return s.repository.UpdateOrder(ctx, tx, order)
+	})
 	if err != nil {
-		log.Printf("[order_service] Error cancelling stock reservation: %v", err)
+		log.Printf("[order_service] Error completing saga step for order %d: %v", step.OrderID, err)
 		return err
 	}
+	return stockErr
+}
 
-	_ = order.SetState(model.CANCELLED)
-	err = s.repository.UpdateOrder(ctx, order)
+// applyStockOperationOnShards выполняет операцию над стоком отдельной транзакцией на каждом шарде стоков заказа.
+// Операции на шарде идемпотентны, поэтому при инфраструктурной ошибке шаг саги можно безопасно повторить.
+// Если хотя бы один шард отклонил резерв, резерв откатывается на всех шардах заказа, чтобы заказ не держал сток частично
+func (s *Service) applyStockOperationOnShards(ctx context.Context, operation model.StockOperation, orderID int64, items []*model.Item) error {
+	groups, err := s.groupItemsByStockShard(items)
 	if err != nil {
-		log.Printf("[order_service] Error updating order state: %v", err)
 		return err
 	}
 
+	for _, group := range groups {
+		stockErr := s.tm.RunInStockShardTx(ctx, itemSkus(group), func(tx pgx.Tx) error {
+			return s.applyStockOperation(ctx, tx, operation, orderID, group)
+		})
+		if stockErr == nil {
+			continue
+		}
+		if operation == model.RESERVE && isStockRejection(stockErr) {
+			if err = s.compensateReserve(ctx, orderID, groups); err != nil {
+				return err
+			}
+		}
+		return stockErr
+	}
 	return nil
 }
 
-func (s *Service) GetAllOrders(ctx context.Context) ([]*model.Order, error) {
-	orders, err := s.repository.GetAllOrders(ctx)
-	if err != nil {
-		log.Printf("[order_service] Error getting orders: %v", err)
-		return nil, err
+// compensateReserve откатывает резерв заказа на всех шардах стоков.
+// При ошибке шаг саги остается в журнале, и при восстановлении резерв будет отклонен и откачен повторно
+func (s *Service) compensateReserve(ctx context.Context, orderID int64, groups [][]*model.Item) error {
+	for _, group := range groups {
+		err := s.tm.RunInStockShardTx(ctx, itemSkus(group), func(tx pgx.Tx) error {
+			return s.stockService.CompensateReserve(ctx, tx, orderID, group)
+		})
+		if err != nil {
+			log.Printf("[order_service] Error compensating reserve of order %d: %v", orderID, err)
+			return err
+		}
 	}
-	return orders, nil
+	return nil
+}
+
+// groupItemsByStockShard группирует позиции заказа по шардам стоков в порядке возрастания индекса шарда
+func (s *Service) groupItemsByStockShard(items []*model.Item) ([][]*model.Item, error) {
+	byShard := make(map[int][]*model.Item)
+	for _, item := range items {
+		shardIndex, err := s.tm.StockShardIndex(item.SKU)
+		if err != nil {
+			return nil, err
+		}
+		byShard[shardIndex] = append(byShard[shardIndex], item)
+	}
+
+	shardIndexes := make([]int, 0, len(byShard))
+	for shardIndex := range byShard {
+		shardIndexes = append(shardIndexes, shardIndex)
+	}
+	slices.Sort(shardIndexes)
+
+	groups := make([][]*model.Item, 0, len(shardIndexes))
+	for _, shardIndex := range shardIndexes {
+		groups = append(groups, byShard[shardIndex])
+	}
+	return groups, nil
+}
+
+func (s *Service) applyStockOperation(ctx context.Context, tx pgx.Tx, operation model.StockOperation, orderID int64, items []*model.Item) error {
+	switch operation {
+	case model.RESERVE:
+		return s.stockService.Reserve(ctx, tx, orderID, items)
+	case model.RESERVE_REMOVE:
+		return s.stockService.ReserveRemove(ctx, tx, orderID, items)
+	case model.RESERVE_CANCEL:
+		return s.stockService.ReserveCancel(ctx, tx, orderID, items)
+	}
+	return fmt.Errorf("unknown stock operation %v", operation)
+}
+
+func itemSkus(items []*model.Item) []model.SKUType {
+	skus := make([]model.SKUType, 0, len(items))
+	for _, item := range items {
+		skus = append(skus, item.SKU)
+	}
+	return skus
+}
+
+// isStockRejection отличает отказ стока по бизнес-правилам от инфраструктурной ошибки
+func isStockRejection(err error) bool {
+	return errors.Is(err, appErr.ErrStockInsufficient) ||
+		errors.Is(err, appErr.ErrNegativeReserved) ||
+		errors.Is(err, appErr.ErrNotFound)
 }
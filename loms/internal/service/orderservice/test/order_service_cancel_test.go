@@ -22,20 +22,21 @@ func TestService_OrderCancel_Success(t *testing.T) {
 		ID:    1,
 		Items: []*model.Item{{SKU: 1, Count: 10}},
 	}
-	_ = order.SetState(model.AWAITING_PAYMENT)
+	order.State = model.AWAITING_PAYMENT
 
 	repoMock.GetByIdMock.Expect(ctx, order.ID).Return(order, nil)
-	stockServiceMock.ReserveCancelMock.Expect(ctx, order.Items).Return(nil)
+	repoMock.GetByIdForUpdateMock.Expect(ctx, nil, order.ID).Return(copyOrder(order), nil)
+	stockServiceMock.ReserveCancelMock.Expect(ctx, nil, order.ID, order.Items).Return(nil)
 
 	orderForUpdate := &model.Order{
 		ID:    order.ID,
 		Items: order.Items,
 	}
-	_ = orderForUpdate.SetState(model.CANCELLED)
+	orderForUpdate.State = model.CANCELLED
 
-	repoMock.UpdateOrderMock.Expect(ctx, orderForUpdate).Return(nil)
+	repoMock.UpdateOrderMock.Expect(ctx, nil, orderForUpdate).Return(nil)
 
-	service := orderservice.NewService(repoMock, stockServiceMock)
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
 
 	err := service.OrderCancel(ctx, order.ID)
 	assert.NoError(t, err)
@@ -52,7 +53,7 @@ func TestService_OrderCancel_GetByIdError(t *testing.T) {
 
 	repoMock.GetByIdMock.Expect(ctx, orderID).Return(nil, errors.New("database error"))
 
-	service := orderservice.NewService(repoMock, stockServiceMock)
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
 
 	err := service.OrderCancel(ctx, orderID)
 	assert.Error(t, err)
@@ -70,12 +71,13 @@ func TestService_OrderCancel_ReserveCancelError(t *testing.T) {
 		ID:    1,
 		Items: []*model.Item{{SKU: 1, Count: 10}},
 	}
-	_ = order.SetState(model.AWAITING_PAYMENT)
+	order.State = model.AWAITING_PAYMENT
 
 	repoMock.GetByIdMock.Expect(ctx, order.ID).Return(order, nil)
-	stockServiceMock.ReserveCancelMock.Expect(ctx, order.Items).Return(errors.New("reserve cancel error"))
+	repoMock.GetByIdForUpdateMock.Expect(ctx, nil, order.ID).Return(copyOrder(order), nil)
+	stockServiceMock.ReserveCancelMock.Expect(ctx, nil, order.ID, order.Items).Return(errors.New("reserve cancel error"))
 
-	service := orderservice.NewService(repoMock, stockServiceMock)
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
 
 	err := service.OrderCancel(ctx, order.ID)
 	assert.Error(t, err)
@@ -93,18 +95,19 @@ func TestService_OrderCancel_UpdateOrderError(t *testing.T) {
 		ID:    1,
 		Items: []*model.Item{{SKU: 1, Count: 10}},
 	}
-	_ = order.SetState(model.AWAITING_PAYMENT)
+	order.State = model.AWAITING_PAYMENT
 
 	repoMock.GetByIdMock.Expect(ctx, order.ID).Return(order, nil)
-	stockServiceMock.ReserveCancelMock.Expect(ctx, order.Items).Return(nil)
+	repoMock.GetByIdForUpdateMock.Expect(ctx, nil, order.ID).Return(copyOrder(order), nil)
+	stockServiceMock.ReserveCancelMock.Expect(ctx, nil, order.ID, order.Items).Return(nil)
 	orderForUpdate := &model.Order{
 		ID:    order.ID,
 		Items: order.Items,
 	}
-	_ = orderForUpdate.SetState(model.CANCELLED)
-	repoMock.UpdateOrderMock.Expect(ctx, orderForUpdate).Return(errors.New("update error"))
+	orderForUpdate.State = model.CANCELLED
+	repoMock.UpdateOrderMock.Expect(ctx, nil, orderForUpdate).Return(errors.New("update error"))
 
-	service := orderservice.NewService(repoMock, stockServiceMock)
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
 
 	err := service.OrderCancel(ctx, order.ID)
 	assert.Error(t, err)
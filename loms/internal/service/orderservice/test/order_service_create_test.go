@@ -3,11 +3,13 @@ package test
 import (
 	"context"
 	"errors"
+	appErrors "route256/loms/internal/errors"
 	"route256/loms/internal/model"
 	"route256/loms/internal/service/orderservice"
 	"testing"
 
 	"github.com/gojuno/minimock/v3"
+	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,15 +26,17 @@ func TestService_Create_Success(t *testing.T) {
 	savedOrder := &model.Order{
 		Items: order.Items,
 		ID:    1,
+		State: model.NEW,
 	}
-	repoMock.SaveOrderMock.Expect(ctx, order).Return(savedOrder, nil)
-	stockServiceMock.ReserveMock.Expect(ctx, order.Items).Return(nil)
-	_ = savedOrder.SetState(model.AWAITING_PAYMENT)
-	repoMock.UpdateOrderMock.Expect(ctx, savedOrder).Return(nil)
+	repoMock.SaveOrderMock.Expect(ctx, nil, order, "").Return(savedOrder, nil)
+	stockServiceMock.ReserveMock.Expect(ctx, nil, savedOrder.ID, order.Items).Return(nil)
+	orderForUpdate := copyOrder(savedOrder)
+	orderForUpdate.State = model.AWAITING_PAYMENT
+	repoMock.UpdateOrderMock.Expect(ctx, nil, orderForUpdate).Return(nil)
 
-	service := orderservice.NewService(repoMock, stockServiceMock)
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
 
-	orderID, err := service.Create(ctx, order)
+	orderID, err := service.Create(ctx, order, "")
 	assert.NoError(t, err)
 	assert.Equal(t, savedOrder.ID, orderID)
 }
@@ -49,11 +53,11 @@ func TestService_Create_SaveOrderError(t *testing.T) {
 		Items: []*model.Item{{SKU: 1, Count: 10}},
 	}
 
-	repoMock.SaveOrderMock.Expect(ctx, order).Return(nil, errors.New("save error"))
+	repoMock.SaveOrderMock.Expect(ctx, nil, order, "").Return(nil, errors.New("save error"))
 
-	service := orderservice.NewService(repoMock, stockServiceMock)
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
 
-	orderID, err := service.Create(ctx, order)
+	orderID, err := service.Create(ctx, order, "")
 	assert.Error(t, err)
 	assert.Equal(t, int64(0), orderID)
 }
@@ -69,22 +73,52 @@ func TestService_Create_ReserveError(t *testing.T) {
 	savedOrder := &model.Order{
 		Items: order.Items,
 		ID:    1,
+		State: model.NEW,
 	}
 
 	repoMock := NewRepositoryMock(mc)
 	stockServiceMock := NewStockServiceMock(mc)
 
-	repoMock.SaveOrderMock.Expect(ctx, order).Return(savedOrder, nil)
-	stockServiceMock.ReserveMock.Expect(ctx, order.Items).Return(errors.New("reserve error"))
-	_ = savedOrder.SetState(model.FAILED)
+	repoMock.SaveOrderMock.Expect(ctx, nil, order, "").Return(savedOrder, nil)
+	stockServiceMock.ReserveMock.Expect(ctx, nil, savedOrder.ID, order.Items).Return(appErrors.ErrStockInsufficient)
+	orderForUpdate := copyOrder(savedOrder)
+	orderForUpdate.State = model.FAILED
 
-	repoMock.UpdateOrderMock.Expect(ctx, savedOrder).Return(nil)
+	repoMock.UpdateOrderMock.Expect(ctx, nil, orderForUpdate).Return(nil)
 
-	service := orderservice.NewService(repoMock, stockServiceMock)
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
 
-	orderID, err := service.Create(ctx, order)
-	assert.Error(t, err)
+	orderID, err := service.Create(ctx, order, "")
+	assert.ErrorIs(t, err, appErrors.ErrStockInsufficient)
+	assert.Equal(t, int64(0), orderID)
+}
+
+func TestService_Create_ReserveInfraErrorRollsBack(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	order := &model.Order{
+		Items: []*model.Item{{SKU: 1, Count: 10}},
+	}
+	savedOrder := &model.Order{
+		Items: order.Items,
+		ID:    1,
+		State: model.NEW,
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+
+	repoMock.SaveOrderMock.Expect(ctx, nil, order, "").Return(savedOrder, nil)
+	stockServiceMock.ReserveMock.Expect(ctx, nil, savedOrder.ID, order.Items).Return(errors.New("reserve error"))
+
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
+
+	orderID, err := service.Create(ctx, order, "")
+	assert.EqualError(t, err, "reserve error")
 	assert.Equal(t, int64(0), orderID)
+	assert.Equal(t, uint64(0), repoMock.UpdateOrderAfterCounter())
 }
 
 func TestService_Create_UpdateOrderError(t *testing.T) {
@@ -99,20 +133,386 @@ func TestService_Create_UpdateOrderError(t *testing.T) {
 	savedOrder := &model.Order{
 		Items: order.Items,
 		ID:    1,
+		State: model.NEW,
 	}
 
 	repoMock := NewRepositoryMock(mc)
 	stockServiceMock := NewStockServiceMock(mc)
 
-	repoMock.SaveOrderMock.Expect(ctx, order).Return(savedOrder, nil)
-	stockServiceMock.ReserveMock.Expect(ctx, order.Items).Return(nil)
-	_ = savedOrder.SetState(model.AWAITING_PAYMENT)
+	repoMock.SaveOrderMock.Expect(ctx, nil, order, "").Return(savedOrder, nil)
+	stockServiceMock.ReserveMock.Expect(ctx, nil, savedOrder.ID, order.Items).Return(nil)
+	orderForUpdate := copyOrder(savedOrder)
+	orderForUpdate.State = model.AWAITING_PAYMENT
 
-	repoMock.UpdateOrderMock.Expect(ctx, savedOrder).Return(errors.New("update error"))
+	repoMock.UpdateOrderMock.Expect(ctx, nil, orderForUpdate).Return(errors.New("update error"))
 
-	service := orderservice.NewService(repoMock, stockServiceMock)
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
 
-	orderID, err := service.Create(ctx, order)
+	orderID, err := service.Create(ctx, order, "")
 	assert.Error(t, err)
 	assert.Equal(t, int64(0), orderID)
 }
+
+func TestService_Create_SagaSuccess(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	order := &model.Order{
+		UserId: 2,
+		Items:  []*model.Item{{SKU: 1, Count: 10}},
+	}
+	savedOrder := &model.Order{
+		UserId: order.UserId,
+		Items:  order.Items,
+		ID:     1,
+		State:  model.NEW,
+	}
+	step := &model.SagaStep{
+		OrderID:     savedOrder.ID,
+		UserId:      order.UserId,
+		Operation:   model.RESERVE,
+		TargetState: model.AWAITING_PAYMENT,
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+
+	repoMock.SaveOrderMock.Expect(ctx, nil, order, "").Return(savedOrder, nil)
+	repoMock.SaveSagaStepMock.Expect(ctx, nil, step).Return(nil)
+	stockServiceMock.ReserveMock.Expect(ctx, nil, savedOrder.ID, order.Items).Return(nil)
+	repoMock.DeleteSagaStepMock.Expect(ctx, nil, savedOrder.ID).Return(true, nil)
+	lockedOrder := &model.Order{
+		UserId: order.UserId,
+		Items:  order.Items,
+		ID:     savedOrder.ID,
+		State:  model.NEW,
+	}
+	repoMock.GetByIdForUpdateMock.Expect(ctx, nil, savedOrder.ID).Return(lockedOrder, nil)
+	repoMock.UpdateOrderMock.Set(func(_ context.Context, _ pgx.Tx, order *model.Order) error {
+		assert.Equal(t, model.AWAITING_PAYMENT, order.State)
+		return nil
+	})
+
+	service := orderservice.NewService(repoMock, stockServiceMock, newCrossShardTM(mc))
+
+	orderID, err := service.Create(ctx, order, "")
+	assert.NoError(t, err)
+	assert.Equal(t, savedOrder.ID, orderID)
+}
+
+func TestService_Create_SagaStockUnavailableLeavesStep(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	order := &model.Order{
+		UserId: 2,
+		Items:  []*model.Item{{SKU: 1, Count: 10}},
+	}
+	savedOrder := &model.Order{
+		UserId: order.UserId,
+		Items:  order.Items,
+		ID:     1,
+		State:  model.NEW,
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+
+	repoMock.SaveOrderMock.Expect(ctx, nil, order, "").Return(savedOrder, nil)
+	repoMock.SaveSagaStepMock.Return(nil)
+	stockServiceMock.ReserveMock.Expect(ctx, nil, savedOrder.ID, order.Items).Return(errors.New("connection refused"))
+
+	service := orderservice.NewService(repoMock, stockServiceMock, newCrossShardTM(mc))
+
+	orderID, err := service.Create(ctx, order, "")
+	assert.EqualError(t, err, "connection refused")
+	assert.Equal(t, int64(0), orderID)
+	// шаг остается в журнале для восстановления
+	assert.Equal(t, uint64(0), repoMock.DeleteSagaStepAfterCounter())
+	assert.Equal(t, uint64(0), repoMock.UpdateOrderAfterCounter())
+}
+
+func TestService_Create_SagaStockRejectedFailsOrder(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	order := &model.Order{
+		UserId: 2,
+		Items:  []*model.Item{{SKU: 1, Count: 10}},
+	}
+	savedOrder := &model.Order{
+		UserId: order.UserId,
+		Items:  order.Items,
+		ID:     1,
+		State:  model.NEW,
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+
+	repoMock.SaveOrderMock.Expect(ctx, nil, order, "").Return(savedOrder, nil)
+	repoMock.SaveSagaStepMock.Return(nil)
+	stockServiceMock.ReserveMock.Expect(ctx, nil, savedOrder.ID, order.Items).Return(appErrors.ErrStockInsufficient)
+	stockServiceMock.CompensateReserveMock.Expect(ctx, nil, savedOrder.ID, order.Items).Return(nil)
+	repoMock.DeleteSagaStepMock.Expect(ctx, nil, savedOrder.ID).Return(true, nil)
+	repoMock.GetByIdForUpdateMock.Expect(ctx, nil, savedOrder.ID).Return(&model.Order{ID: savedOrder.ID, State: model.NEW}, nil)
+	repoMock.UpdateOrderMock.Set(func(_ context.Context, _ pgx.Tx, order *model.Order) error {
+		assert.Equal(t, model.FAILED, order.State)
+		return nil
+	})
+
+	service := orderservice.NewService(repoMock, stockServiceMock, newCrossShardTM(mc))
+
+	orderID, err := service.Create(ctx, order, "")
+	assert.ErrorIs(t, err, appErrors.ErrStockInsufficient)
+	assert.Equal(t, int64(0), orderID)
+}
+
+func TestService_Create_CrossShardStockRejectedCompensatesAllShards(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	items := []*model.Item{{SKU: 1, Count: 10}, {SKU: 2, Count: 5}}
+	order := &model.Order{UserId: 2, Items: items}
+	savedOrder := &model.Order{UserId: order.UserId, Items: items, ID: 1, State: model.NEW}
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+	tmMock := NewTransactionManagerMock(mc)
+
+	tmMock.UserShardHoldsStockMock.Return(false)
+	tmMock.RunInUserShardTxMock.Set(func(_ context.Context, _ int64, fn func(tx pgx.Tx) error) error {
+		return fn(nil)
+	})
+	// SKU 1 лежит на шарде 1, SKU 2 на шарде 0
+	tmMock.StockShardIndexMock.Set(func(sku model.SKUType) (int, error) {
+		return int(sku % 2), nil
+	})
+	var stockTxSkus [][]model.SKUType
+	tmMock.RunInStockShardTxMock.Set(func(_ context.Context, skus []model.SKUType, fn func(tx pgx.Tx) error) error {
+		stockTxSkus = append(stockTxSkus, skus)
+		return fn(nil)
+	})
+
+	repoMock.SaveOrderMock.Return(savedOrder, nil)
+	repoMock.SaveSagaStepMock.Return(nil)
+	// шард 0 резервирует, шард 1 отклоняет резерв
+	stockServiceMock.ReserveMock.Set(func(_ context.Context, _ pgx.Tx, _ int64, group []*model.Item) error {
+		if group[0].SKU == 1 {
+			return appErrors.ErrStockInsufficient
+		}
+		return nil
+	})
+	var compensated []model.SKUType
+	stockServiceMock.CompensateReserveMock.Set(func(_ context.Context, _ pgx.Tx, orderID int64, group []*model.Item) error {
+		assert.Equal(t, savedOrder.ID, orderID)
+		for _, item := range group {
+			compensated = append(compensated, item.SKU)
+		}
+		return nil
+	})
+	repoMock.DeleteSagaStepMock.Return(true, nil)
+	repoMock.GetByIdForUpdateMock.Return(&model.Order{ID: savedOrder.ID, State: model.NEW}, nil)
+	repoMock.UpdateOrderMock.Set(func(_ context.Context, _ pgx.Tx, order *model.Order) error {
+		assert.Equal(t, model.FAILED, order.State)
+		return nil
+	})
+
+	service := orderservice.NewService(repoMock, stockServiceMock, tmMock)
+
+	orderID, err := service.Create(ctx, order, "")
+	assert.ErrorIs(t, err, appErrors.ErrStockInsufficient)
+	assert.Equal(t, int64(0), orderID)
+	assert.Equal(t, [][]model.SKUType{{2}, {1}, {2}, {1}}, stockTxSkus)
+	assert.Equal(t, []model.SKUType{2, 1}, compensated)
+}
+
+func TestService_Create_IdempotencyKeyReplay(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	order := &model.Order{
+		UserId: 1,
+		Items:  []*model.Item{{SKU: 1, Count: 10}},
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+
+	repoMock.GetOrderIdByIdempotencyKeyMock.Expect(ctx, order.UserId, "key").Return(42, order.RequestHash(), nil)
+	repoMock.GetByIdMock.Expect(ctx, 42).Return(&model.Order{ID: 42, UserId: order.UserId, State: model.AWAITING_PAYMENT}, nil)
+
+	service := orderservice.NewService(repoMock, stockServiceMock, NewTransactionManagerMock(mc))
+
+	orderID, err := service.Create(ctx, order, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), orderID)
+	assert.Equal(t, uint64(0), repoMock.SaveOrderAfterCounter())
+	assert.Equal(t, uint64(0), stockServiceMock.ReserveAfterCounter())
+}
+
+func TestService_Create_IdempotencyKeyReplayRejected(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	order := &model.Order{
+		UserId: 1,
+		Items:  []*model.Item{{SKU: 1, Count: 10}},
+	}
+	savedOrder := &model.Order{
+		UserId: order.UserId,
+		Items:  order.Items,
+		ID:     42,
+		State:  model.NEW,
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+
+	// первый вызов не находит ключ и сохраняет заказ в FAILED, повтор находит ключ и этот заказ
+	repoMock.GetOrderIdByIdempotencyKeyMock.Set(func(_ context.Context, _ int64, _ string) (int64, string, error) {
+		if repoMock.SaveOrderAfterCounter() == 0 {
+			return 0, "", appErrors.ErrNotFound
+		}
+		return savedOrder.ID, order.RequestHash(), nil
+	})
+	repoMock.SaveOrderMock.Expect(ctx, nil, order, "key").Return(savedOrder, nil)
+	stockServiceMock.ReserveMock.Expect(ctx, nil, savedOrder.ID, order.Items).Return(appErrors.ErrStockInsufficient)
+	failedOrder := copyOrder(savedOrder)
+	failedOrder.State = model.FAILED
+	repoMock.UpdateOrderMock.Expect(ctx, nil, failedOrder).Return(nil)
+	repoMock.GetByIdMock.Expect(ctx, savedOrder.ID).Return(failedOrder, nil)
+
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
+
+	orderID, err := service.Create(ctx, order, "key")
+	assert.ErrorIs(t, err, appErrors.ErrStockInsufficient)
+	assert.Equal(t, int64(0), orderID)
+
+	orderID, err = service.Create(ctx, order, "key")
+	assert.ErrorIs(t, err, appErrors.ErrStockInsufficient)
+	assert.Equal(t, int64(0), orderID)
+	assert.Equal(t, uint64(1), repoMock.SaveOrderAfterCounter())
+	assert.Equal(t, uint64(1), stockServiceMock.ReserveAfterCounter())
+}
+
+func TestService_Create_IdempotencyKeyDifferentRequest(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	order := &model.Order{
+		UserId: 1,
+		Items:  []*model.Item{{SKU: 1, Count: 10}},
+	}
+	firstOrder := &model.Order{
+		UserId: 1,
+		Items:  []*model.Item{{SKU: 1, Count: 5}},
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+
+	repoMock.GetOrderIdByIdempotencyKeyMock.Expect(ctx, order.UserId, "key").Return(42, firstOrder.RequestHash(), nil)
+
+	service := orderservice.NewService(repoMock, stockServiceMock, NewTransactionManagerMock(mc))
+
+	_, err := service.Create(ctx, order, "key")
+	assert.ErrorIs(t, err, appErrors.ErrIdempotencyConflict)
+	assert.Equal(t, uint64(0), repoMock.SaveOrderAfterCounter())
+	assert.Equal(t, uint64(0), stockServiceMock.ReserveAfterCounter())
+}
+
+func TestService_Create_IdempotencyKeyLegacyRecord(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	order := &model.Order{
+		UserId: 1,
+		Items:  []*model.Item{{SKU: 1, Count: 10}},
+	}
+
+	repoMock := NewRepositoryMock(mc)
+
+	// ключ сохранен до появления хеша запроса
+	repoMock.GetOrderIdByIdempotencyKeyMock.Expect(ctx, order.UserId, "key").Return(42, "", nil)
+	repoMock.GetByIdMock.Expect(ctx, 42).Return(&model.Order{ID: 42, UserId: order.UserId, State: model.AWAITING_PAYMENT}, nil)
+
+	service := orderservice.NewService(repoMock, NewStockServiceMock(mc), NewTransactionManagerMock(mc))
+
+	orderID, err := service.Create(ctx, order, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), orderID)
+}
+
+func TestService_Create_IdempotencyKeyFirstCall(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	order := &model.Order{
+		UserId: 1,
+		Items:  []*model.Item{{SKU: 1, Count: 10}},
+	}
+	savedOrder := &model.Order{
+		UserId: order.UserId,
+		Items:  order.Items,
+		ID:     1,
+		State:  model.NEW,
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+
+	repoMock.GetOrderIdByIdempotencyKeyMock.Expect(ctx, order.UserId, "key").Return(0, "", appErrors.ErrNotFound)
+	repoMock.SaveOrderMock.Expect(ctx, nil, order, "key").Return(savedOrder, nil)
+	stockServiceMock.ReserveMock.Expect(ctx, nil, savedOrder.ID, order.Items).Return(nil)
+	orderForUpdate := copyOrder(savedOrder)
+	orderForUpdate.State = model.AWAITING_PAYMENT
+	repoMock.UpdateOrderMock.Expect(ctx, nil, orderForUpdate).Return(nil)
+
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
+
+	orderID, err := service.Create(ctx, order, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, savedOrder.ID, orderID)
+}
+
+func TestService_Create_IdempotencyKeyConcurrentConflict(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	order := &model.Order{
+		UserId: 1,
+		Items:  []*model.Item{{SKU: 1, Count: 10}},
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+
+	repoMock.SaveOrderMock.Expect(ctx, nil, order, "key").Return(nil, appErrors.ErrIdempotencyKey)
+
+	// первая проверка ключа вернет ErrNotFound, повторная после конфликта должна найти заказ
+	repoMock.GetOrderIdByIdempotencyKeyMock.Set(func(_ context.Context, _ int64, _ string) (int64, string, error) {
+		if repoMock.SaveOrderAfterCounter() == 0 {
+			return 0, "", appErrors.ErrNotFound
+		}
+		return 7, order.RequestHash(), nil
+	})
+	repoMock.GetByIdMock.Expect(ctx, 7).Return(&model.Order{ID: 7, UserId: order.UserId, State: model.AWAITING_PAYMENT}, nil)
+
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
+
+	orderID, err := service.Create(ctx, order, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), orderID)
+	assert.Equal(t, uint64(0), stockServiceMock.ReserveAfterCounter())
+}
@@ -0,0 +1,79 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"route256/loms/internal/model"
+	"route256/loms/internal/service/orderservice"
+	"testing"
+	"time"
+
+	"github.com/gojuno/minimock/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_CancelExpired_Success(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+	order := &model.Order{
+		ID:    1,
+		Items: []*model.Item{{SKU: 1, Count: 10}},
+	}
+	order.State = model.AWAITING_PAYMENT
+
+	repoMock.GetExpiredOrderIdsMock.Expect(ctx, 15*time.Minute, 100).Return([]int64{order.ID}, nil)
+	repoMock.GetByIdMock.Expect(ctx, order.ID).Return(order, nil)
+	repoMock.GetByIdForUpdateMock.Expect(ctx, nil, order.ID).Return(copyOrder(order), nil)
+	stockServiceMock.ReserveCancelMock.Expect(ctx, nil, order.ID, order.Items).Return(nil)
+	orderForUpdate := copyOrder(order)
+	orderForUpdate.State = model.CANCELLED
+	repoMock.UpdateOrderMock.Expect(ctx, nil, orderForUpdate).Return(nil)
+
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
+
+	cancelled, err := service.CancelExpired(ctx, 15*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cancelled)
+}
+
+func TestService_CancelExpired_PaidConcurrently(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	repoMock := NewRepositoryMock(mc)
+	order := &model.Order{
+		ID:    1,
+		Items: []*model.Item{{SKU: 1, Count: 10}},
+	}
+	// заказ оплатили между выборкой и отменой
+	order.State = model.PAYED
+
+	repoMock.GetExpiredOrderIdsMock.Return([]int64{order.ID}, nil)
+	repoMock.GetByIdMock.Expect(ctx, order.ID).Return(order, nil)
+
+	service := orderservice.NewService(repoMock, NewStockServiceMock(mc), newSingleShardTM(mc))
+
+	cancelled, err := service.CancelExpired(ctx, 15*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cancelled)
+}
+
+func TestService_CancelExpired_GetExpiredError(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	repoMock := NewRepositoryMock(mc)
+	repoMock.GetExpiredOrderIdsMock.Return(nil, errors.New("database error"))
+
+	service := orderservice.NewService(repoMock, NewStockServiceMock(mc), NewTransactionManagerMock(mc))
+
+	cancelled, err := service.CancelExpired(ctx, 15*time.Minute)
+	assert.EqualError(t, err, "database error")
+	assert.Equal(t, 0, cancelled)
+}
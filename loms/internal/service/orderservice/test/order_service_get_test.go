@@ -3,9 +3,11 @@ package test
 import (
 	"context"
 	"errors"
+	appErrors "route256/loms/internal/errors"
 	"route256/loms/internal/model"
 	"route256/loms/internal/service/orderservice"
 	"testing"
+	"time"
 
 	"github.com/gojuno/minimock/v3"
 	"github.com/stretchr/testify/assert"
@@ -21,9 +23,9 @@ func TestService_GetById_Success(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
-	repoMock.GetByIdMock.Expect(ctx, order.ID).Return(order, nil)
+	repoMock.GetByIdFromReplicaMock.Expect(ctx, order.ID).Return(order, nil)
 
-	service := orderservice.NewService(repoMock, NewStockServiceMock(mc))
+	service := orderservice.NewService(repoMock, NewStockServiceMock(mc), NewTransactionManagerMock(mc))
 
 	order, err := service.GetById(ctx, order.ID)
 	assert.NoError(t, err)
@@ -37,12 +39,56 @@ func TestService_GetById_Error(t *testing.T) {
 	orderID := int64(1)
 
 	repoMock := NewRepositoryMock(mc)
-	repoMock.GetByIdMock.Expect(ctx, orderID).Return(nil, errors.New("database error"))
+	repoMock.GetByIdFromReplicaMock.Expect(ctx, orderID).Return(nil, errors.New("database error"))
 
-	service := orderservice.NewService(repoMock, NewStockServiceMock(mc))
+	service := orderservice.NewService(repoMock, NewStockServiceMock(mc), NewTransactionManagerMock(mc))
 
 	order, err := service.GetById(ctx, orderID)
 	assert.Error(t, err)
 	assert.Nil(t, order)
 	assert.EqualError(t, err, "database error")
 }
+
+func TestService_GetHistory_Success(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+	orderID := int64(1)
+	createdAt := time.Date(2024, 12, 1, 12, 0, 0, 0, time.UTC)
+
+	history := &model.OrderHistory{
+		OrderID:   orderID,
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt.Add(time.Minute),
+		Changes: []*model.OrderStateChange{
+			{State: model.NEW, ChangedAt: createdAt},
+			{State: model.AWAITING_PAYMENT, ChangedAt: createdAt},
+			{State: model.PAYED, ChangedAt: createdAt.Add(time.Minute)},
+		},
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	repoMock.GetStateHistoryMock.Expect(ctx, orderID).Return(history, nil)
+
+	service := orderservice.NewService(repoMock, NewStockServiceMock(mc), NewTransactionManagerMock(mc))
+
+	result, err := service.GetHistory(ctx, orderID)
+	assert.NoError(t, err)
+	assert.Equal(t, history, result)
+}
+
+func TestService_GetHistory_NotFound(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+	orderID := int64(1)
+
+	repoMock := NewRepositoryMock(mc)
+	repoMock.GetStateHistoryMock.Expect(ctx, orderID).Return(nil, appErrors.ErrNotFound)
+
+	service := orderservice.NewService(repoMock, NewStockServiceMock(mc), NewTransactionManagerMock(mc))
+
+	result, err := service.GetHistory(ctx, orderID)
+	assert.ErrorIs(t, err, appErrors.ErrNotFound)
+	assert.Nil(t, result)
+}
@@ -0,0 +1,155 @@
+package test
+
+import (
+	"context"
+	"errors"
+	appErr "route256/loms/internal/errors"
+	"route256/loms/internal/model"
+	"route256/loms/internal/repository/orderrepository"
+	"route256/loms/internal/service/orderservice"
+	"testing"
+
+	"github.com/gojuno/minimock/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_ListOrders_HasNextPage(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	repoMock := NewRepositoryMock(mc)
+	orders := []*model.Order{{ID: 30}, {ID: 20}, {ID: 10}}
+
+	repoMock.ListOrdersMock.Expect(ctx, &model.OrdersFilter{UserId: 1, State: model.PAYED, BeforeID: 40, Limit: 3}).
+		Return(orders, nil)
+
+	service := orderservice.NewService(repoMock, NewStockServiceMock(mc), NewTransactionManagerMock(mc))
+
+	page, next, err := service.ListOrders(ctx, model.OrdersFilter{UserId: 1, State: model.PAYED, BeforeID: 40, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, orders[:2], page)
+	assert.Equal(t, int64(20), next)
+}
+
+func TestService_ListOrders_LastPage(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	repoMock := NewRepositoryMock(mc)
+	orders := []*model.Order{{ID: 30}, {ID: 20}}
+
+	repoMock.ListOrdersMock.Expect(ctx, &model.OrdersFilter{Limit: orderservice.DefaultPageSize + 1}).Return(orders, nil)
+
+	service := orderservice.NewService(repoMock, NewStockServiceMock(mc), NewTransactionManagerMock(mc))
+
+	page, next, err := service.ListOrders(ctx, model.OrdersFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, orders, page)
+	assert.Zero(t, next)
+}
+
+func TestService_ListOrders_RepositoryError(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	repoMock := NewRepositoryMock(mc)
+	repoErr := errors.New("shard unavailable")
+
+	repoMock.ListOrdersMock.Return(nil, repoErr)
+
+	service := orderservice.NewService(repoMock, NewStockServiceMock(mc), NewTransactionManagerMock(mc))
+
+	_, _, err := service.ListOrders(ctx, model.OrdersFilter{Limit: 10})
+	assert.ErrorIs(t, err, repoErr)
+}
+
+func TestService_ListUserOrders_Success(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	repoMock := NewRepositoryMock(mc)
+	orders := []*model.Order{{ID: 30, UserId: 7}, {ID: 20, UserId: 7}}
+
+	repoMock.ListUserOrdersMock.Expect(ctx, &model.OrdersFilter{UserId: 7, BeforeID: 40, Limit: 2}).Return(orders, nil)
+
+	service := orderservice.NewService(repoMock, NewStockServiceMock(mc), NewTransactionManagerMock(mc))
+
+	page, next, err := service.ListUserOrders(ctx, model.OrdersFilter{UserId: 7, BeforeID: 40, Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, orders[:1], page)
+	assert.Equal(t, int64(30), next)
+}
+
+func TestService_ListUserOrders_WithoutUser(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	service := orderservice.NewService(NewRepositoryMock(mc), NewStockServiceMock(mc), NewTransactionManagerMock(mc))
+
+	_, _, err := service.ListUserOrders(ctx, model.OrdersFilter{Limit: 10})
+	assert.ErrorIs(t, err, appErr.ErrInvalidInput)
+}
+
+// shardOrderStream поток заказов шарда по убыванию ID с отсечкой по курсору, как в запросе listOrders
+type shardOrderStream struct {
+	ids []int64
+}
+
+func (s *shardOrderStream) Next() (*model.Order, bool, error) {
+	if len(s.ids) == 0 {
+		return nil, false, nil
+	}
+	order := &model.Order{ID: s.ids[0]}
+	s.ids = s.ids[1:]
+	return order, true, nil
+}
+
+func TestService_ListOrders_PageCursorAcrossShards(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	shards := [][]int64{{90, 60, 50, 20}, {}, {80, 70, 40, 30, 10}}
+
+	repoMock := NewRepositoryMock(mc)
+	repoMock.ListOrdersMock.Set(func(_ context.Context, filter *model.OrdersFilter) ([]*model.Order, error) {
+		streams := make([]orderrepository.OrderStream, 0, len(shards))
+		for _, ids := range shards {
+			filtered := make([]int64, 0, len(ids))
+			for _, id := range ids {
+				if filter.BeforeID == 0 || id < filter.BeforeID {
+					filtered = append(filtered, id)
+				}
+			}
+			streams = append(streams, &shardOrderStream{ids: filtered})
+		}
+		return orderrepository.MergeOrderStreams(streams, filter.Limit)
+	})
+
+	service := orderservice.NewService(repoMock, NewStockServiceMock(mc), NewTransactionManagerMock(mc))
+
+	var pages [][]int64
+	beforeID := int64(0)
+	for {
+		page, next, err := service.ListOrders(ctx, model.OrdersFilter{BeforeID: beforeID, Limit: 3})
+		assert.NoError(t, err)
+
+		ids := make([]int64, 0, len(page))
+		for _, order := range page {
+			ids = append(ids, order.ID)
+		}
+		pages = append(pages, ids)
+
+		if next == 0 {
+			break
+		}
+		beforeID = next
+	}
+
+	assert.Equal(t, [][]int64{{90, 80, 70}, {60, 50, 40}, {30, 20, 10}}, pages)
+}
@@ -3,6 +3,7 @@ package test
 import (
 	"context"
 	"errors"
+	appErrors "route256/loms/internal/errors"
 	"route256/loms/internal/model"
 	"route256/loms/internal/service/orderservice"
 	"testing"
@@ -23,18 +24,19 @@ func TestService_OrderPay_Success(t *testing.T) {
 		Items: []*model.Item{{SKU: 1, Count: 10}},
 	}
 
-	_ = order.SetState(model.AWAITING_PAYMENT)
+	order.State = model.AWAITING_PAYMENT
 
 	repoMock.GetByIdMock.Expect(ctx, order.ID).Return(order, nil)
-	stockServiceMock.ReserveRemoveMock.Expect(ctx, order.Items).Return(nil)
+	repoMock.GetByIdForUpdateMock.Expect(ctx, nil, order.ID).Return(copyOrder(order), nil)
+	stockServiceMock.ReserveRemoveMock.Expect(ctx, nil, order.ID, order.Items).Return(nil)
 	orderForUpdate := &model.Order{
 		ID:    order.ID,
 		Items: order.Items,
 	}
-	_ = orderForUpdate.SetState(model.PAYED)
-	repoMock.UpdateOrderMock.Expect(ctx, orderForUpdate).Return(nil)
+	orderForUpdate.State = model.PAYED
+	repoMock.UpdateOrderMock.Expect(ctx, nil, orderForUpdate).Return(nil)
 
-	service := orderservice.NewService(repoMock, stockServiceMock)
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
 
 	err := service.OrderPay(ctx, order.ID)
 	assert.NoError(t, err)
@@ -51,7 +53,7 @@ func TestService_OrderPay_GetByIdError(t *testing.T) {
 
 	repoMock.GetByIdMock.Expect(ctx, orderID).Return(nil, errors.New("database error"))
 
-	service := orderservice.NewService(repoMock, stockServiceMock)
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
 
 	err := service.OrderPay(ctx, orderID)
 	assert.Error(t, err)
@@ -68,12 +70,13 @@ func TestService_OrderPay_ReserveRemoveError(t *testing.T) {
 	order := &model.Order{
 		Items: []*model.Item{{SKU: 1, Count: 10}},
 	}
-	_ = order.SetState(model.AWAITING_PAYMENT)
+	order.State = model.AWAITING_PAYMENT
 
 	repoMock.GetByIdMock.Expect(ctx, order.ID).Return(order, nil)
-	stockServiceMock.ReserveRemoveMock.Expect(ctx, order.Items).Return(errors.New("reserve remove error"))
+	repoMock.GetByIdForUpdateMock.Expect(ctx, nil, order.ID).Return(copyOrder(order), nil)
+	stockServiceMock.ReserveRemoveMock.Expect(ctx, nil, order.ID, order.Items).Return(errors.New("reserve remove error"))
 
-	service := orderservice.NewService(repoMock, stockServiceMock)
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
 
 	err := service.OrderPay(ctx, order.ID)
 	assert.Error(t, err)
@@ -90,20 +93,107 @@ func TestService_OrderPay_UpdateOrderError(t *testing.T) {
 	order := &model.Order{
 		Items: []*model.Item{{SKU: 1, Count: 10}},
 	}
-	_ = order.SetState(model.AWAITING_PAYMENT)
+	order.State = model.AWAITING_PAYMENT
 	repoMock.GetByIdMock.Expect(ctx, order.ID).Return(order, nil)
-	stockServiceMock.ReserveRemoveMock.Expect(ctx, order.Items).Return(nil)
+	repoMock.GetByIdForUpdateMock.Expect(ctx, nil, order.ID).Return(copyOrder(order), nil)
+	stockServiceMock.ReserveRemoveMock.Expect(ctx, nil, order.ID, order.Items).Return(nil)
 	orderForUpdate := &model.Order{
 		ID:    order.ID,
 		Items: order.Items,
 	}
-	_ = orderForUpdate.SetState(model.PAYED)
+	orderForUpdate.State = model.PAYED
 
-	repoMock.UpdateOrderMock.Expect(ctx, orderForUpdate).Return(errors.New("update error"))
+	repoMock.UpdateOrderMock.Expect(ctx, nil, orderForUpdate).Return(errors.New("update error"))
 
-	service := orderservice.NewService(repoMock, stockServiceMock)
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
 
 	err := service.OrderPay(ctx, order.ID)
 	assert.Error(t, err)
 	assert.EqualError(t, err, "update error")
 }
+
+func TestService_OrderPay_StateChangedConcurrently(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+	order := &model.Order{
+		ID:    1,
+		Items: []*model.Item{{SKU: 1, Count: 10}},
+	}
+	order.State = model.AWAITING_PAYMENT
+	cancelledOrder := copyOrder(order)
+	cancelledOrder.State = model.CANCELLED
+
+	repoMock.GetByIdMock.Expect(ctx, order.ID).Return(order, nil)
+	// между чтением и блокировкой заказ успели отменить
+	repoMock.GetByIdForUpdateMock.Expect(ctx, nil, order.ID).Return(cancelledOrder, nil)
+
+	service := orderservice.NewService(repoMock, stockServiceMock, newSingleShardTM(mc))
+
+	err := service.OrderPay(ctx, order.ID)
+	assert.ErrorIs(t, err, appErrors.ErrOrderState)
+	assert.Equal(t, uint64(0), stockServiceMock.ReserveRemoveAfterCounter())
+}
+
+func TestService_OrderPay_Saga(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+	order := &model.Order{
+		ID:     1,
+		UserId: 2,
+		Items:  []*model.Item{{SKU: 1, Count: 10}},
+	}
+	order.State = model.AWAITING_PAYMENT
+	step := &model.SagaStep{
+		OrderID:     order.ID,
+		UserId:      order.UserId,
+		Operation:   model.RESERVE_REMOVE,
+		TargetState: model.PAYED,
+	}
+
+	repoMock.GetByIdMock.Expect(ctx, order.ID).Return(order, nil)
+	repoMock.GetByIdForUpdateMock.Expect(ctx, nil, order.ID).Return(copyOrder(order), nil)
+	repoMock.SaveSagaStepMock.Expect(ctx, nil, step).Return(nil)
+	stockServiceMock.ReserveRemoveMock.Expect(ctx, nil, order.ID, order.Items).Return(nil)
+	repoMock.DeleteSagaStepMock.Expect(ctx, nil, order.ID).Return(true, nil)
+	orderForUpdate := copyOrder(order)
+	orderForUpdate.State = model.PAYED
+	repoMock.UpdateOrderMock.Expect(ctx, nil, orderForUpdate).Return(nil)
+
+	service := orderservice.NewService(repoMock, stockServiceMock, newCrossShardTM(mc))
+
+	err := service.OrderPay(ctx, order.ID)
+	assert.NoError(t, err)
+}
+
+func TestService_OrderPay_SagaAlreadyPending(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+	order := &model.Order{
+		ID:     1,
+		UserId: 2,
+		Items:  []*model.Item{{SKU: 1, Count: 10}},
+	}
+	order.State = model.AWAITING_PAYMENT
+
+	repoMock.GetByIdMock.Expect(ctx, order.ID).Return(order, nil)
+	repoMock.GetByIdForUpdateMock.Expect(ctx, nil, order.ID).Return(copyOrder(order), nil)
+	repoMock.SaveSagaStepMock.Return(appErrors.ErrOrderState)
+
+	service := orderservice.NewService(repoMock, stockServiceMock, newCrossShardTM(mc))
+
+	err := service.OrderPay(ctx, order.ID)
+	assert.ErrorIs(t, err, appErrors.ErrOrderState)
+	assert.Equal(t, uint64(0), stockServiceMock.ReserveRemoveAfterCounter())
+}
@@ -0,0 +1,95 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"route256/loms/internal/model"
+	"route256/loms/internal/service/orderservice"
+	"testing"
+	"time"
+
+	"github.com/gojuno/minimock/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_RecoverSagas_CompletesPendingStep(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+	order := &model.Order{
+		ID:     1,
+		UserId: 2,
+		Items:  []*model.Item{{SKU: 1, Count: 10}},
+	}
+	order.State = model.NEW
+	step := &model.SagaStep{
+		OrderID:     order.ID,
+		UserId:      order.UserId,
+		Operation:   model.RESERVE,
+		TargetState: model.AWAITING_PAYMENT,
+	}
+
+	repoMock.GetPendingSagaStepsMock.Expect(ctx, time.Minute, 100).Return([]*model.SagaStep{step}, nil)
+	repoMock.GetByIdMock.Expect(ctx, order.ID).Return(order, nil)
+	// резерв уже мог быть выполнен до сбоя, повторный вызов идемпотентен
+	stockServiceMock.ReserveMock.Expect(ctx, nil, order.ID, order.Items).Return(nil)
+	repoMock.DeleteSagaStepMock.Expect(ctx, nil, order.ID).Return(true, nil)
+	repoMock.GetByIdForUpdateMock.Expect(ctx, nil, order.ID).Return(copyOrder(order), nil)
+	orderForUpdate := copyOrder(order)
+	orderForUpdate.State = model.AWAITING_PAYMENT
+	repoMock.UpdateOrderMock.Expect(ctx, nil, orderForUpdate).Return(nil)
+
+	service := orderservice.NewService(repoMock, stockServiceMock, newCrossShardTM(mc))
+
+	err := service.RecoverSagas(ctx, time.Minute)
+	assert.NoError(t, err)
+}
+
+func TestService_RecoverSagas_StepAlreadyCompleted(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	repoMock := NewRepositoryMock(mc)
+	stockServiceMock := NewStockServiceMock(mc)
+	order := &model.Order{
+		ID:     1,
+		UserId: 2,
+		Items:  []*model.Item{{SKU: 1, Count: 10}},
+	}
+	step := &model.SagaStep{
+		OrderID:     order.ID,
+		UserId:      order.UserId,
+		Operation:   model.RESERVE_CANCEL,
+		TargetState: model.CANCELLED,
+	}
+
+	repoMock.GetPendingSagaStepsMock.Expect(ctx, time.Minute, 100).Return([]*model.SagaStep{step}, nil)
+	repoMock.GetByIdMock.Expect(ctx, order.ID).Return(order, nil)
+	stockServiceMock.ReserveCancelMock.Expect(ctx, nil, order.ID, order.Items).Return(nil)
+	// шаг успел завершить исходный запрос
+	repoMock.DeleteSagaStepMock.Expect(ctx, nil, order.ID).Return(false, nil)
+
+	service := orderservice.NewService(repoMock, stockServiceMock, newCrossShardTM(mc))
+
+	err := service.RecoverSagas(ctx, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), repoMock.UpdateOrderAfterCounter())
+}
+
+func TestService_RecoverSagas_GetPendingError(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	repoMock := NewRepositoryMock(mc)
+	repoMock.GetPendingSagaStepsMock.Return(nil, errors.New("database error"))
+
+	service := orderservice.NewService(repoMock, NewStockServiceMock(mc), NewTransactionManagerMock(mc))
+
+	err := service.RecoverSagas(ctx, time.Minute)
+	assert.EqualError(t, err, "database error")
+}
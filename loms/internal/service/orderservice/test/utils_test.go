@@ -0,0 +1,40 @@
+package test
+
+import (
+	"context"
+	"route256/loms/internal/model"
+
+	"github.com/gojuno/minimock/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// newSingleShardTM мок менеджера транзакций для пользователя, чьи заказы лежат на шарде стоков заказа
+func newSingleShardTM(mc *minimock.Controller) *TransactionManagerMock {
+	tmMock := NewTransactionManagerMock(mc)
+	tmMock.UserShardHoldsStockMock.Optional().Return(true)
+	tmMock.RunInUserShardTxMock.Optional().Set(func(_ context.Context, _ int64, fn func(tx pgx.Tx) error) error {
+		return fn(nil)
+	})
+	return tmMock
+}
+
+// newCrossShardTM мок менеджера транзакций для пользователя, чьи заказы лежат не на шарде стоков.
+// Все стоки лежат на одном шарде
+func newCrossShardTM(mc *minimock.Controller) *TransactionManagerMock {
+	tmMock := NewTransactionManagerMock(mc)
+	tmMock.StockShardIndexMock.Optional().Return(0, nil)
+	tmMock.UserShardHoldsStockMock.Optional().Return(false)
+	tmMock.RunInUserShardTxMock.Optional().Set(func(_ context.Context, _ int64, fn func(tx pgx.Tx) error) error {
+		return fn(nil)
+	})
+	tmMock.RunInStockShardTxMock.Optional().Set(func(_ context.Context, _ []model.SKUType, fn func(tx pgx.Tx) error) error {
+		return fn(nil)
+	})
+	return tmMock
+}
+
+// copyOrder копия заказа, которую репозиторий вернет при чтении с блокировкой
+func copyOrder(order *model.Order) *model.Order {
+	orderCopy := *order
+	return &orderCopy
+}
@@ -0,0 +1,49 @@
+package expiryprocessor
+
+import (
+	"context"
+	"log"
+	"route256/loms/internal/metrics"
+	"route256/loms/internal/service/orderservice"
+	"time"
+)
+
+var _ ExpiredOrderCanceller = (*orderservice.Service)(nil)
+
+type ExpiredOrderCanceller interface {
+	CancelExpired(ctx context.Context, ttl time.Duration) (int, error)
+}
+
+// ExpiryProcessor периодически отменяет заказы, не оплаченные за ttl, и освобождает их резерв
+type ExpiryProcessor struct {
+	canceller ExpiredOrderCanceller
+	interval  time.Duration
+	ttl       time.Duration
+}
+
+func NewExpiryProcessor(canceller ExpiredOrderCanceller, interval time.Duration, ttl time.Duration) *ExpiryProcessor {
+	return &ExpiryProcessor{
+		canceller: canceller,
+		interval:  interval,
+		ttl:       ttl,
+	}
+}
+
+func (p *ExpiryProcessor) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping ExpiryProcessor")
+			return
+		case <-ticker.C:
+			cancelled, err := p.canceller.CancelExpired(ctx, p.ttl)
+			if err != nil {
+				log.Printf("Failed to cancel expired orders: %v", err)
+			}
+			metrics.RecordExpiryRun(cancelled, err)
+		}
+	}
+}
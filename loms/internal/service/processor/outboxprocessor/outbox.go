@@ -2,12 +2,13 @@ package outboxprocessor
 
 import (
 	"context"
+	"errors"
 	"github.com/IBM/sarama"
-	"github.com/jackc/pgx/v5"
 	"log"
+	"route256/loms/internal/infra/database"
+	"route256/loms/internal/metrics"
 	"route256/loms/internal/model"
 	"route256/loms/internal/repository/outboxrepository"
-	transactionmanager "route256/loms/internal/service/transactionamanger"
 	"strconv"
 	"sync"
 	"time"
@@ -16,36 +17,58 @@ import (
 var _ OutboxRepository = (*outboxrepository.Repository)(nil)
 
 type OutboxRepository interface {
-	GetPendingOutboxEvents(ctx context.Context, tx pgx.Tx, limit int) ([]*model.OutboxEvent, error)
-	MarkOutboxEventProcessed(ctx context.Context, tx pgx.Tx, eventID int32) error
+	ClaimOutboxEvents(ctx context.Context, db outboxrepository.DBTX, lease time.Duration, limit int) ([]*model.OutboxEvent, error)
+	MarkOutboxEventsProcessed(ctx context.Context, db outboxrepository.DBTX, eventIDs []int32) error
+	RecordOutboxFailures(ctx context.Context, db outboxrepository.DBTX, failures []*model.OutboxFailure,
+		policy outboxrepository.RetryPolicy) ([]*model.OutboxEvent, error)
 }
 
-var _ TransactionManager = (*transactionmanager.TransactionManager)(nil)
+var _ ShardPooler = (*database.DBRouter)(nil)
 
-type TransactionManager interface {
-	BeginTransactionsOnAllShards(ctx context.Context) ([]pgx.Tx, error)
+type ShardPooler interface {
+	CountShards() int
+	PickShard(ctx context.Context, shardIndex int, readOnlyOperation bool) (*database.FallbackConnection, error)
 }
 
+type Options struct {
+	// Interval период, с которым обработчик проверяет outbox
+	Interval time.Duration
+	// BatchSize число событий, которые захватываются и отправляются в Kafka за раз
+	BatchSize int
+	// Parallelism число обработчиков, одновременно отправляющих пачки одного шарда
+	Parallelism int
+	// Lease время, на которое захватываются события. Должно превышать время отправки пачки,
+	// иначе событие успеет захватить и отправить еще один обработчик
+	Lease time.Duration
+	// Retry задержки между повторными отправками и число попыток до перевода события в dead letter
+	Retry outboxrepository.RetryPolicy
+}
+
+// OutboxProcessor отправляет события outbox в Kafka хотя бы один раз.
+// События захватываются арендой на время Lease, поэтому обработчик может работать на нескольких репликах LOMS,
+// а транзакции и соединения с базой на время отправки не удерживаются
 type OutboxProcessor struct {
 	repo     OutboxRepository
 	producer sarama.SyncProducer
-	interval time.Duration
-	tm       TransactionManager
-	topic    string
+	pool     ShardPooler
+	// topics топик Kafka для каждого потока событий
+	topics map[model.OutboxStream]string
+	opts   Options
 }
 
-func NewOutboxProcessor(repo OutboxRepository, producer sarama.SyncProducer, interval time.Duration, tm TransactionManager, topicName string) *OutboxProcessor {
+func NewOutboxProcessor(repo OutboxRepository, producer sarama.SyncProducer, pool ShardPooler,
+	topics map[model.OutboxStream]string, opts Options) *OutboxProcessor {
 	return &OutboxProcessor{
 		repo:     repo,
 		producer: producer,
-		interval: interval,
-		tm:       tm,
-		topic:    topicName,
+		pool:     pool,
+		topics:   topics,
+		opts:     opts,
 	}
 }
 
 func (p *OutboxProcessor) Start(ctx context.Context) {
-	ticker := time.NewTicker(p.interval)
+	ticker := time.NewTicker(p.opts.Interval)
 	defer ticker.Stop()
 
 	for {
@@ -59,58 +82,137 @@ func (p *OutboxProcessor) Start(ctx context.Context) {
 	}
 }
 
+// processEvents отправляет накопившиеся события всех шардов, на каждом шарде работает Parallelism обработчиков
 func (p *OutboxProcessor) processEvents(ctx context.Context) {
-	transactions, err := p.tm.BeginTransactionsOnAllShards(ctx)
+	wg := sync.WaitGroup{}
+	for shardIndex := 0; shardIndex < p.pool.CountShards(); shardIndex++ {
+		for range p.opts.Parallelism {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.drainShard(ctx, shardIndex)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// drainShard отправляет пачки шарда, пока они захватываются полностью
+func (p *OutboxProcessor) drainShard(ctx context.Context, shardIndex int) {
+	for ctx.Err() == nil {
+		claimed, err := p.ProcessBatch(ctx, shardIndex)
+		if err != nil {
+			log.Printf("Failed to process outbox of shard %d: %v", shardIndex, err)
+			return
+		}
+		if claimed < p.opts.BatchSize {
+			return
+		}
+	}
+}
+
+// ProcessBatch захватывает пачку событий шарда, отправляет ее в Kafka и помечает отправленные события.
+// Неотправленные события откладываются до следующей попытки и не задерживают остальные.
+// Ошибка возвращается, только если не удалось обратиться к базе: тогда события будут отправлены повторно
+// после истечения аренды
+func (p *OutboxProcessor) ProcessBatch(ctx context.Context, shardIndex int) (int, error) {
+	var events []*model.OutboxEvent
+	err := p.withShard(ctx, shardIndex, func(conn *database.FallbackConnection) (err error) {
+		events, err = p.repo.ClaimOutboxEvents(ctx, conn, p.opts.Lease, p.opts.BatchSize)
+		return err
+	})
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	sent, failures := p.send(events)
+	var deadLettered []*model.OutboxEvent
+	err = p.withShard(ctx, shardIndex, func(conn *database.FallbackConnection) (err error) {
+		if err = p.repo.MarkOutboxEventsProcessed(ctx, conn, sent); err != nil {
+			return err
+		}
+		deadLettered, err = p.repo.RecordOutboxFailures(ctx, conn, failures, p.opts.Retry)
+		return err
+	})
 	if err != nil {
-		log.Printf("Failed to start transactions: %v", err)
-		return
+		return len(events), err
 	}
-	wg := sync.WaitGroup{}
+	for _, event := range deadLettered {
+		log.Printf("Outbox event %d of %s stream moved to dead letter after %d attempts",
+			event.ID, event.Stream, event.Attempts)
+	}
+	metrics.RecordOutboxBatch(len(sent), len(failures)-len(deadLettered), len(deadLettered))
+	return len(events), nil
+}
 
-	for _, tx := range transactions {
-		wg.Add(1)
-		go func(tx pgx.Tx) {
-			defer tx.Rollback(ctx)
-
-			events, err := p.repo.GetPendingOutboxEvents(ctx, tx, 10) // Получаем до 10 событий за раз
-			if err != nil {
-				log.Printf("Error fetching outbox events: %v", err)
-				return
-			}
-
-			for _, event := range events {
-				msg := p.createProducerMessage(event)
-				partition, offset, err := p.producer.SendMessage(msg)
-				if err != nil {
-					log.Printf("Failed to send message: %v", err)
-					return
-				}
-				log.Printf("Sent message to partition %d at offset %d", partition, offset)
-
-				if err = p.repo.MarkOutboxEventProcessed(ctx, tx, event.ID); err != nil {
-					log.Printf("Failed to mark event as processed: %v", err)
-					return
-				}
-			}
-			err = tx.Commit(ctx)
-			if err != nil {
-				log.Printf("Failed to commit transaction: %v", err)
-				return
-			}
-		}(tx)
+// send отправляет события одной пачкой и делит их на отправленные и неотправленные
+func (p *OutboxProcessor) send(events []*model.OutboxEvent) (sent []int32, failures []*model.OutboxFailure) {
+	msgs := make([]*sarama.ProducerMessage, len(events))
+	for i, event := range events {
+		msgs[i] = p.createProducerMessage(event)
+	}
 
+	err := p.producer.SendMessages(msgs)
+	if err == nil {
+		for _, event := range events {
+			sent = append(sent, event.ID)
+		}
+		return sent, nil
 	}
-	wg.Wait()
 
+	var producerErrors sarama.ProducerErrors
+	if !errors.As(err, &producerErrors) {
+		log.Printf("Failed to send outbox batch: %v", err)
+		for _, event := range events {
+			failures = append(failures, &model.OutboxFailure{EventID: event.ID, Err: err})
+		}
+		return nil, failures
+	}
+	failedErrs := make(map[int32]error, len(producerErrors))
+	for _, producerErr := range producerErrors {
+		log.Printf("Failed to send message: %v", producerErr.Err)
+		failedErrs[producerErr.Msg.Metadata.(int32)] = producerErr.Err
+	}
+	for _, event := range events {
+		if sendErr, ok := failedErrs[event.ID]; ok {
+			failures = append(failures, &model.OutboxFailure{EventID: event.ID, Err: sendErr, Permanent: IsPermanent(sendErr)})
+		} else {
+			sent = append(sent, event.ID)
+		}
+	}
+	return sent, failures
+}
+
+// IsPermanent сообщает, что брокер не примет сообщение и при повторе
+func IsPermanent(err error) bool {
+	return errors.Is(err, sarama.ErrMessageSizeTooLarge) ||
+		errors.Is(err, sarama.ErrInvalidMessage) ||
+		errors.Is(err, sarama.ErrInvalidMessageSize)
 }
 
+// withShard выполняет fn на соединении с мастером шарда и сразу возвращает соединение в пул
+func (p *OutboxProcessor) withShard(ctx context.Context, shardIndex int, fn func(conn *database.FallbackConnection) error) error {
+	conn, err := p.pool.PickShard(ctx, shardIndex, false)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	return fn(conn)
+}
+
+// createProducerMessage выбирает топик по потоку события. Ключ сообщения - ID заказа или SKU,
+// поэтому события одного заказа или SKU попадают в одну партицию и читаются по порядку
 func (p *OutboxProcessor) createProducerMessage(event *model.OutboxEvent) *sarama.ProducerMessage {
 	value := sarama.ByteEncoder(event.Payload)
 	key := sarama.StringEncoder(strconv.FormatInt(event.OrderID, 10))
+	if event.Stream == model.StockOutboxStream {
+		key = sarama.StringEncoder(strconv.FormatUint(uint64(event.SKU), 10))
+	}
 	return &sarama.ProducerMessage{
-		Topic:     p.topic,
+		Topic:     p.topics[event.Stream],
 		Key:       key,
 		Value:     value,
 		Timestamp: event.CreatedAt,
+		Metadata:  event.ID,
 	}
 }
@@ -0,0 +1,196 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"route256/loms/internal/infra/database"
+	"route256/loms/internal/model"
+	"route256/loms/internal/repository/outboxrepository"
+	"route256/loms/internal/service/processor/outboxprocessor"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/gojuno/minimock/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var topics = map[model.OutboxStream]string{
+	model.OrderOutboxStream: "loms.order-events",
+	model.StockOutboxStream: "loms.stock-events",
+}
+
+// fakeProducer отправляет пачку, возвращая ошибки для сообщений событий из failed
+type fakeProducer struct {
+	sarama.SyncProducer
+	failed   map[int32]error
+	batchErr error
+	sent     []*sarama.ProducerMessage
+}
+
+func (p *fakeProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	if p.batchErr != nil {
+		return p.batchErr
+	}
+	var producerErrors sarama.ProducerErrors
+	for _, msg := range msgs {
+		if err, ok := p.failed[msg.Metadata.(int32)]; ok {
+			producerErrors = append(producerErrors, &sarama.ProducerError{Msg: msg, Err: err})
+			continue
+		}
+		p.sent = append(p.sent, msg)
+	}
+	if len(producerErrors) > 0 {
+		return producerErrors
+	}
+	return nil
+}
+
+// newShardPooler мок пула с одним шардом. Репозиторий замокан, поэтому соединение пустое
+func newShardPooler(mc *minimock.Controller) *ShardPoolerMock {
+	poolMock := NewShardPoolerMock(mc)
+	poolMock.PickShardMock.Expect(context.Background(), 0, false).Return(&database.FallbackConnection{}, nil)
+	return poolMock
+}
+
+func claimedEvents() []*model.OutboxEvent {
+	return []*model.OutboxEvent{
+		{ID: 1, Stream: model.OrderOutboxStream, OrderID: 10, Payload: []byte("created")},
+		{ID: 2, Stream: model.OrderOutboxStream, OrderID: 11, Payload: []byte("too large")},
+		{ID: 3, Stream: model.StockOutboxStream, SKU: 1076963, Payload: []byte("stock")},
+	}
+}
+
+func newProcessor(repo outboxprocessor.OutboxRepository, producer sarama.SyncProducer, pool outboxprocessor.ShardPooler) *outboxprocessor.OutboxProcessor {
+	return outboxprocessor.NewOutboxProcessor(repo, producer, pool, topics, outboxprocessor.Options{
+		BatchSize: 10,
+		Lease:     time.Minute,
+		Retry:     outboxrepository.RetryPolicy{MaxAttempts: 5, BaseBackoff: time.Second, MaxBackoff: time.Minute},
+	})
+}
+
+func TestOutboxProcessor_ProcessBatch_AllSent(t *testing.T) {
+	mc := minimock.NewController(t)
+	ctx := context.Background()
+
+	repoMock := NewOutboxRepositoryMock(mc)
+	repoMock.ClaimOutboxEventsMock.Return(claimedEvents(), nil)
+	repoMock.MarkOutboxEventsProcessedMock.Set(func(_ context.Context, _ outboxrepository.DBTX, eventIDs []int32) error {
+		assert.Equal(t, []int32{1, 2, 3}, eventIDs)
+		return nil
+	})
+	repoMock.RecordOutboxFailuresMock.Set(func(_ context.Context, _ outboxrepository.DBTX, failures []*model.OutboxFailure,
+		_ outboxrepository.RetryPolicy) ([]*model.OutboxEvent, error) {
+		assert.Empty(t, failures)
+		return nil, nil
+	})
+	producer := &fakeProducer{}
+
+	claimed, err := newProcessor(repoMock, producer, newShardPooler(mc)).ProcessBatch(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, claimed)
+
+	// сообщения заказа идут в топик заказов с ключом заказа, стоки - в топик стоков с ключом SKU
+	require.Len(t, producer.sent, 3)
+	assert.Equal(t, "loms.order-events", producer.sent[0].Topic)
+	assert.Equal(t, sarama.StringEncoder("10"), producer.sent[0].Key)
+	assert.Equal(t, "loms.stock-events", producer.sent[2].Topic)
+	assert.Equal(t, sarama.StringEncoder("1076963"), producer.sent[2].Key)
+}
+
+func TestOutboxProcessor_ProcessBatch_SplitsPartialFailure(t *testing.T) {
+	mc := minimock.NewController(t)
+	ctx := context.Background()
+
+	repoMock := NewOutboxRepositoryMock(mc)
+	repoMock.ClaimOutboxEventsMock.Return(claimedEvents(), nil)
+	repoMock.MarkOutboxEventsProcessedMock.Set(func(_ context.Context, _ outboxrepository.DBTX, eventIDs []int32) error {
+		assert.Equal(t, []int32{1}, eventIDs)
+		return nil
+	})
+	repoMock.RecordOutboxFailuresMock.Set(func(_ context.Context, _ outboxrepository.DBTX, failures []*model.OutboxFailure,
+		policy outboxrepository.RetryPolicy) ([]*model.OutboxEvent, error) {
+		require.Len(t, failures, 2)
+		assert.Equal(t, int32(2), failures[0].EventID)
+		assert.ErrorIs(t, failures[0].Err, sarama.ErrMessageSizeTooLarge)
+		assert.True(t, failures[0].Permanent, "Слишком большое сообщение не будет принято и при повторе")
+		assert.Equal(t, int32(3), failures[1].EventID)
+		assert.ErrorIs(t, failures[1].Err, sarama.ErrOutOfBrokers)
+		assert.False(t, failures[1].Permanent)
+		assert.Equal(t, 5, policy.MaxAttempts)
+		return []*model.OutboxEvent{{ID: 2, Stream: model.OrderOutboxStream, OrderID: 11, DeadLettered: true}}, nil
+	})
+	producer := &fakeProducer{failed: map[int32]error{
+		2: sarama.ErrMessageSizeTooLarge,
+		3: sarama.ErrOutOfBrokers,
+	}}
+
+	claimed, err := newProcessor(repoMock, producer, newShardPooler(mc)).ProcessBatch(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, claimed)
+	require.Len(t, producer.sent, 1)
+	assert.Equal(t, int32(1), producer.sent[0].Metadata)
+}
+
+func TestOutboxProcessor_ProcessBatch_BatchFailure(t *testing.T) {
+	mc := minimock.NewController(t)
+	ctx := context.Background()
+
+	repoMock := NewOutboxRepositoryMock(mc)
+	repoMock.ClaimOutboxEventsMock.Return(claimedEvents(), nil)
+	repoMock.MarkOutboxEventsProcessedMock.Set(func(_ context.Context, _ outboxrepository.DBTX, eventIDs []int32) error {
+		assert.Empty(t, eventIDs)
+		return nil
+	})
+	repoMock.RecordOutboxFailuresMock.Set(func(_ context.Context, _ outboxrepository.DBTX, failures []*model.OutboxFailure,
+		_ outboxrepository.RetryPolicy) ([]*model.OutboxEvent, error) {
+		// ошибка не относится к отдельным сообщениям: вся пачка повторяется позже
+		require.Len(t, failures, 3)
+		for _, failure := range failures {
+			assert.ErrorIs(t, failure.Err, sarama.ErrClosedClient)
+			assert.False(t, failure.Permanent)
+		}
+		return nil, nil
+	})
+	producer := &fakeProducer{batchErr: sarama.ErrClosedClient}
+
+	claimed, err := newProcessor(repoMock, producer, newShardPooler(mc)).ProcessBatch(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, claimed)
+}
+
+func TestOutboxProcessor_ProcessBatch_ClaimError(t *testing.T) {
+	mc := minimock.NewController(t)
+	ctx := context.Background()
+
+	repoMock := NewOutboxRepositoryMock(mc)
+	repoMock.ClaimOutboxEventsMock.Return(nil, errors.New("connection refused"))
+
+	claimed, err := newProcessor(repoMock, &fakeProducer{}, newShardPooler(mc)).ProcessBatch(ctx, 0)
+	assert.EqualError(t, err, "connection refused")
+	assert.Zero(t, claimed)
+}
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "message too large", err: sarama.ErrMessageSizeTooLarge, want: true},
+		{name: "invalid message", err: sarama.ErrInvalidMessage, want: true},
+		{name: "invalid message size", err: sarama.ErrInvalidMessageSize, want: true},
+		{name: "wrapped", err: fmt.Errorf("send: %w", sarama.ErrMessageSizeTooLarge), want: true},
+		{name: "out of brokers", err: sarama.ErrOutOfBrokers, want: false},
+		{name: "not leader", err: sarama.ErrNotLeaderForPartition, want: false},
+		{name: "request timed out", err: sarama.ErrRequestTimedOut, want: false},
+		{name: "plain error", err: errors.New("connection reset"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, outboxprocessor.IsPermanent(tt.err))
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package retentionprocessor
+
+import (
+	"context"
+	"log"
+	"route256/loms/internal/infra/database"
+	"route256/loms/internal/metrics"
+	"route256/loms/internal/repository/outboxrepository"
+	"time"
+)
+
+var _ OutboxPurger = (*outboxrepository.Repository)(nil)
+
+type OutboxPurger interface {
+	DeleteProcessedOutboxEvents(ctx context.Context, db outboxrepository.DBTX, retention time.Duration, limit int) (int64, error)
+}
+
+var _ ShardPooler = (*database.DBRouter)(nil)
+
+type ShardPooler interface {
+	CountShards() int
+	PickShard(ctx context.Context, shardIndex int, readOnlyOperation bool) (*database.FallbackConnection, error)
+}
+
+// deleteBatchSize число событий, удаляемых одним запросом, чтобы не держать долгие блокировки
+const deleteBatchSize = 1000
+
+// RetentionProcessor периодически удаляет из outbox отправленные события старше срока хранения.
+// Недоставленные события остаются в outbox для разбора
+type RetentionProcessor struct {
+	repo      OutboxPurger
+	pool      ShardPooler
+	interval  time.Duration
+	retention time.Duration
+}
+
+func NewRetentionProcessor(repo OutboxPurger, pool ShardPooler, interval time.Duration, retention time.Duration) *RetentionProcessor {
+	return &RetentionProcessor{
+		repo:      repo,
+		pool:      pool,
+		interval:  interval,
+		retention: retention,
+	}
+}
+
+func (p *RetentionProcessor) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping RetentionProcessor")
+			return
+		case <-ticker.C:
+			for shardIndex := 0; shardIndex < p.pool.CountShards(); shardIndex++ {
+				if err := p.purgeShard(ctx, shardIndex); err != nil {
+					log.Printf("Failed to purge outbox of shard %d: %v", shardIndex, err)
+				}
+			}
+		}
+	}
+}
+
+// purgeShard удаляет устаревшие события шарда пачками, пока они не закончатся
+func (p *RetentionProcessor) purgeShard(ctx context.Context, shardIndex int) error {
+	conn, err := p.pool.PickShard(ctx, shardIndex, false)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	for ctx.Err() == nil {
+		deleted, err := p.repo.DeleteProcessedOutboxEvents(ctx, conn, p.retention, deleteBatchSize)
+		if err != nil {
+			return err
+		}
+		metrics.RecordOutboxPurge(deleted)
+		if deleted < deleteBatchSize {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
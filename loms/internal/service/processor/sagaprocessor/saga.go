@@ -0,0 +1,45 @@
+package sagaprocessor
+
+import (
+	"context"
+	"log"
+	"route256/loms/internal/service/orderservice"
+	"time"
+)
+
+var _ SagaRecoverer = (*orderservice.Service)(nil)
+
+type SagaRecoverer interface {
+	RecoverSagas(ctx context.Context, olderThan time.Duration) error
+}
+
+// SagaProcessor периодически завершает шаги саг, зависшие в журнале после сбоя
+type SagaProcessor struct {
+	recoverer SagaRecoverer
+	interval  time.Duration
+}
+
+func NewSagaProcessor(recoverer SagaRecoverer, interval time.Duration) *SagaProcessor {
+	return &SagaProcessor{
+		recoverer: recoverer,
+		interval:  interval,
+	}
+}
+
+func (p *SagaProcessor) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping SagaProcessor")
+			return
+		case <-ticker.C:
+			// шаги моложе интервала скорее всего еще завершаются запросом, который их создал
+			if err := p.recoverer.RecoverSagas(ctx, p.interval); err != nil {
+				log.Printf("Failed to recover sagas: %v", err)
+			}
+		}
+	}
+}
@@ -3,11 +3,14 @@ package stockservice
 import (
 	"context"
 	"fmt"
+	"github.com/jackc/pgx/v5"
 	"log"
+	"math"
 	appErr "route256/loms/internal/errors"
 	"route256/loms/internal/model"
 	"route256/loms/internal/repository/stockrepository"
 	"route256/loms/internal/service/orderservice"
+	"slices"
 )
 
 var _ orderservice.StockService = (*Service)(nil)
@@ -15,7 +18,10 @@ var _ Repository = (*stockrepository.Repository)(nil)
 
 type Repository interface {
 	GetStocks(ctx context.Context, sku []model.SKUType) ([]*model.Stock, error)
-	UpdateStock(ctx context.Context, items map[model.SKUType]*model.Stock) error
+	GetStocksForUpdate(ctx context.Context, tx pgx.Tx, sku []model.SKUType) ([]*model.Stock, error)
+	UpdateStock(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation, items map[model.SKUType]*model.Stock) error
+	IsStockOperationApplied(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation) (bool, error)
+	SaveStockOperation(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation, skus []model.SKUType) error
 }
 
 type Service struct {
@@ -26,8 +32,18 @@ func NewService(repository Repository) *Service {
 	return &Service{repository: repository}
 }
 
-func (s *Service) Reserve(ctx context.Context, items []*model.Item) error {
-	return s.processItems(ctx, items, func(stock *model.Stock, neededCount uint32) error {
+// Reserve резервирует сток под заказ в транзакции tx. Повторный вызов для того же заказа ничего не меняет.
+// Если резерв заказа на этом шарде уже откатили компенсацией, резерв отклоняется
+func (s *Service) Reserve(ctx context.Context, tx pgx.Tx, orderID int64, items []*model.Item) error {
+	compensated, err := s.repository.IsStockOperationApplied(ctx, tx, orderID, model.RESERVE_CANCEL)
+	if err != nil {
+		log.Printf("[stock_service] Error checking stock operation: %v", err)
+		return err
+	}
+	if compensated {
+		return fmt.Errorf("reservation of order %d was rolled back: %w", orderID, appErr.ErrStockInsufficient)
+	}
+	return s.processItems(ctx, tx, orderID, model.RESERVE, items, func(stock *model.Stock, neededCount uint32) error {
 		availableCount := stock.TotalCount - stock.ReservedCount
 		if availableCount < neededCount {
 			return fmt.Errorf("not enough stock for SKU %v: %w", stock.SKU, appErr.ErrStockInsufficient)
@@ -37,8 +53,9 @@ func (s *Service) Reserve(ctx context.Context, items []*model.Item) error {
 	})
 }
 
-func (s *Service) ReserveRemove(ctx context.Context, items []*model.Item) error {
-	return s.processItems(ctx, items, func(stock *model.Stock, neededCount uint32) error {
+// ReserveRemove списывает зарезервированный под заказ сток в транзакции tx
+func (s *Service) ReserveRemove(ctx context.Context, tx pgx.Tx, orderID int64, items []*model.Item) error {
+	return s.processItems(ctx, tx, orderID, model.RESERVE_REMOVE, items, func(stock *model.Stock, neededCount uint32) error {
 		if stock.ReservedCount < neededCount {
 			return appErr.ErrNegativeReserved
 		}
@@ -51,8 +68,9 @@ func (s *Service) ReserveRemove(ctx context.Context, items []*model.Item) error
 	})
 }
 
-func (s *Service) ReserveCancel(ctx context.Context, items []*model.Item) error {
-	return s.processItems(ctx, items, func(stock *model.Stock, neededCount uint32) error {
+// ReserveCancel снимает резерв под заказ в транзакции tx
+func (s *Service) ReserveCancel(ctx context.Context, tx pgx.Tx, orderID int64, items []*model.Item) error {
+	return s.processItems(ctx, tx, orderID, model.RESERVE_CANCEL, items, func(stock *model.Stock, neededCount uint32) error {
 		if stock.ReservedCount < neededCount {
 			return appErr.ErrNegativeReserved
 		}
@@ -61,6 +79,62 @@ func (s *Service) ReserveCancel(ctx context.Context, items []*model.Item) error
 	})
 }
 
+// CompensateReserve откатывает резерв заказа на шарде транзакции tx, если он был выполнен.
+// Откат отмечается и там, где резерва не было, чтобы повтор резерва при восстановлении саги его не выполнил
+func (s *Service) CompensateReserve(ctx context.Context, tx pgx.Tx, orderID int64, items []*model.Item) error {
+	reserved, err := s.repository.IsStockOperationApplied(ctx, tx, orderID, model.RESERVE)
+	if err != nil {
+		log.Printf("[stock_service] Error checking stock operation: %v", err)
+		return err
+	}
+	if reserved {
+		return s.ReserveCancel(ctx, tx, orderID, items)
+	}
+
+	compensated, err := s.repository.IsStockOperationApplied(ctx, tx, orderID, model.RESERVE_CANCEL)
+	if err != nil {
+		log.Printf("[stock_service] Error checking stock operation: %v", err)
+		return err
+	}
+	if compensated {
+		return nil
+	}
+	return s.repository.SaveStockOperation(ctx, tx, orderID, model.RESERVE_CANCEL, getSKUList(makeSkuCountMap(items)))
+}
+
+// Restock пополняет сток поставкой в транзакции tx. Пополнение не связано с заказом и не дедуплицируется,
+// событие stock.changed пишется с нулевым ID заказа
+func (s *Service) Restock(ctx context.Context, tx pgx.Tx, items []*model.Item) error {
+	itemMap := makeSkuCountMap(items)
+	for sku, count := range itemMap {
+		if count == 0 {
+			return fmt.Errorf("restock of SKU %v with zero count: %w", sku, appErr.ErrInvalidInput)
+		}
+	}
+
+	stocks, err := s.repository.GetStocksForUpdate(ctx, tx, getSKUList(itemMap))
+	if err != nil {
+		log.Printf("[stock_service] Error getting stocks: %v", err)
+		return err
+	}
+	updateStocks := make(map[model.SKUType]*model.Stock, len(stocks))
+	for _, stock := range stocks {
+		count := itemMap[stock.SKU]
+		if stock.TotalCount > math.MaxUint32-count {
+			return fmt.Errorf("restock of SKU %v overflows total count %d: %w", stock.SKU, stock.TotalCount, appErr.ErrInvalidInput)
+		}
+		stock.TotalCount += count
+		updateStocks[stock.SKU] = stock
+	}
+
+	err = s.repository.UpdateStock(ctx, tx, 0, model.RESTOCK, updateStocks)
+	if err != nil {
+		log.Printf("[stock_service] Error updating stocks: %v", err)
+		return err
+	}
+	return nil
+}
+
 func (s *Service) GetBySKUAvailableCount(ctx context.Context, sku model.SKUType) (uint64, error) {
 	stocks, err := s.repository.GetStocks(ctx, []model.SKUType{sku})
 	if err != nil {
@@ -74,11 +148,24 @@ func (s *Service) GetBySKUAvailableCount(ctx context.Context, sku model.SKUType)
 	return uint64(stock.TotalCount - stock.ReservedCount), nil
 }
 
-func (s *Service) processItems(ctx context.Context, items []*model.Item, processFunc func(*model.Stock, uint32) error) error {
+// processItems применяет операцию к стокам заказа. Операция выполняется для заказа не более одного раза:
+// факт выполнения сохраняется в той же транзакции, что и новые значения стоков и события об их изменении
+func (s *Service) processItems(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation,
+	items []*model.Item, processFunc func(*model.Stock, uint32) error) error {
+	applied, err := s.repository.IsStockOperationApplied(ctx, tx, orderID, operation)
+	if err != nil {
+		log.Printf("[stock_service] Error checking stock operation: %v", err)
+		return err
+	}
+	if applied {
+		log.Printf("[stock_service] Operation %v for order %d already applied", operation, orderID)
+		return nil
+	}
+
 	itemMap := makeSkuCountMap(items)
 	skus := getSKUList(itemMap)
 
-	stocks, err := s.repository.GetStocks(ctx, skus)
+	stocks, err := s.repository.GetStocksForUpdate(ctx, tx, skus)
 	if err != nil {
 		log.Printf("[stock_service] Error getting stocks: %v", err)
 		return err
@@ -95,7 +182,12 @@ func (s *Service) processItems(ctx context.Context, items []*model.Item, process
 		updateStocks[stock.SKU] = stock
 	}
 
-	return s.repository.UpdateStock(ctx, updateStocks)
+	err = s.repository.UpdateStock(ctx, tx, orderID, operation, updateStocks)
+	if err != nil {
+		log.Printf("[stock_service] Error updating stocks: %v", err)
+		return err
+	}
+	return s.repository.SaveStockOperation(ctx, tx, orderID, operation, skus)
 }
 
 func getSKUList(itemMap map[model.SKUType]uint32) []model.SKUType {
@@ -103,6 +195,7 @@ func getSKUList(itemMap map[model.SKUType]uint32) []model.SKUType {
 	for sku := range itemMap {
 		skus = append(skus, sku)
 	}
+	slices.Sort(skus)
 	return skus
 }
 
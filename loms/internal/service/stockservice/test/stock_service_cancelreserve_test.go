@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/gojuno/minimock/v3"
+	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,8 +24,9 @@ func TestService_ReserveCancel_Success(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
 
-	repoMock.GetStocksMock.Set(func(ctx context.Context, skus []model.SKUType) ([]*model.Stock, error) {
+	repoMock.GetStocksForUpdateMock.Set(func(ctx context.Context, tx pgx.Tx, skus []model.SKUType) ([]*model.Stock, error) {
 		assert.ElementsMatch(t, []model.SKUType{1, 2}, skus)
 		return []*model.Stock{{SKU: 1, TotalCount: 20, ReservedCount: 10}, {SKU: 2, TotalCount: 15, ReservedCount: 5}}, nil
 	})
@@ -32,14 +34,16 @@ func TestService_ReserveCancel_Success(t *testing.T) {
 		1: {SKU: 1, TotalCount: 20, ReservedCount: 5}, // 10 - 5
 		2: {SKU: 2, TotalCount: 15, ReservedCount: 2}, // 5 - 3
 	}
-	repoMock.UpdateStockMock.Set(func(ctx context.Context, stocks map[model.SKUType]*model.Stock) error {
+	repoMock.UpdateStockMock.Set(func(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation, stocks map[model.SKUType]*model.Stock) error {
 		assert.True(t, compareStocks(expectedUpdateStocks, stocks))
 		return nil
 	})
 
+	repoMock.SaveStockOperationMock.Return(nil)
+
 	service := stockservice.NewService(repoMock)
 
-	err := service.ReserveCancel(ctx, items)
+	err := service.ReserveCancel(ctx, nil, 1, items)
 	assert.NoError(t, err)
 }
 
@@ -53,14 +57,15 @@ func TestService_ReserveCancel_GetStockError(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
 
-	repoMock.GetStocksMock.
-		When(ctx, []model.SKUType{1}).
+	repoMock.GetStocksForUpdateMock.
+		When(ctx, nil, []model.SKUType{1}).
 		Then(nil, errors.New("database error"))
 
 	service := stockservice.NewService(repoMock)
 
-	err := service.ReserveCancel(ctx, items)
+	err := service.ReserveCancel(ctx, nil, 1, items)
 	assert.Error(t, err)
 	assert.EqualError(t, err, "database error")
 }
@@ -75,14 +80,15 @@ func TestService_ReserveCancel_NegativeReservedCount(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
 
-	repoMock.GetStocksMock.
-		When(ctx, []model.SKUType{1}).
+	repoMock.GetStocksForUpdateMock.
+		When(ctx, nil, []model.SKUType{1}).
 		Then([]*model.Stock{{SKU: 1, TotalCount: 20, ReservedCount: 10}}, nil)
 
 	service := stockservice.NewService(repoMock)
 
-	err := service.ReserveCancel(ctx, items)
+	err := service.ReserveCancel(ctx, nil, 1, items)
 	assert.Error(t, err)
 	assert.IsType(t, appErrors.ErrStockInsufficient, err)
 }
@@ -97,22 +103,23 @@ func TestService_ReserveCancel_UpdateStockError(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
 
-	repoMock.GetStocksMock.
-		When(ctx, []model.SKUType{1}).
+	repoMock.GetStocksForUpdateMock.
+		When(ctx, nil, []model.SKUType{1}).
 		Then([]*model.Stock{{SKU: 1, TotalCount: 20, ReservedCount: 10}}, nil)
 
 	expectedUpdateStocks := map[model.SKUType]*model.Stock{
 		1: {SKU: 1, TotalCount: 20, ReservedCount: 5}, // 10 - 5
 	}
-	repoMock.UpdateStockMock.Set(func(ctx context.Context, stocks map[model.SKUType]*model.Stock) error {
+	repoMock.UpdateStockMock.Set(func(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation, stocks map[model.SKUType]*model.Stock) error {
 		assert.True(t, compareStocks(expectedUpdateStocks, stocks))
 		return errors.New("update error")
 	})
 
 	service := stockservice.NewService(repoMock)
 
-	err := service.ReserveCancel(ctx, items)
+	err := service.ReserveCancel(ctx, nil, 1, items)
 	assert.Error(t, err)
 	assert.EqualError(t, err, "update error")
 }
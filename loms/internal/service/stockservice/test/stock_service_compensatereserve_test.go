@@ -0,0 +1,81 @@
+package test
+
+import (
+	"context"
+	"route256/loms/internal/model"
+	"route256/loms/internal/service/stockservice"
+	"testing"
+
+	"github.com/gojuno/minimock/v3"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_CompensateReserve_Reserved(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	items := []*model.Item{
+		{SKU: 1, Count: 5},
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.When(ctx, nil, 1, model.RESERVE).Then(true, nil)
+	repoMock.IsStockOperationAppliedMock.When(ctx, nil, 1, model.RESERVE_CANCEL).Then(false, nil)
+	repoMock.GetStocksForUpdateMock.Return([]*model.Stock{{SKU: 1, TotalCount: 20, ReservedCount: 10}}, nil)
+	repoMock.UpdateStockMock.Set(func(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation, stocks map[model.SKUType]*model.Stock) error {
+		assert.Equal(t, uint32(5), stocks[1].ReservedCount)
+		return nil
+	})
+	repoMock.SaveStockOperationMock.Expect(ctx, nil, 1, model.RESERVE_CANCEL, []model.SKUType{1}).Return(nil)
+
+	service := stockservice.NewService(repoMock)
+
+	err := service.CompensateReserve(ctx, nil, 1, items)
+	assert.NoError(t, err)
+}
+
+func TestService_CompensateReserve_NotReserved(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	items := []*model.Item{
+		{SKU: 2, Count: 3},
+		{SKU: 1, Count: 5},
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
+	repoMock.SaveStockOperationMock.Expect(ctx, nil, 1, model.RESERVE_CANCEL, []model.SKUType{1, 2}).Return(nil)
+
+	service := stockservice.NewService(repoMock)
+
+	err := service.CompensateReserve(ctx, nil, 1, items)
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint64(0), repoMock.GetStocksForUpdateAfterCounter())
+	assert.Equal(t, uint64(0), repoMock.UpdateStockAfterCounter())
+}
+
+func TestService_CompensateReserve_AlreadyCompensated(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	items := []*model.Item{
+		{SKU: 1, Count: 5},
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.When(ctx, nil, 1, model.RESERVE).Then(false, nil)
+	repoMock.IsStockOperationAppliedMock.When(ctx, nil, 1, model.RESERVE_CANCEL).Then(true, nil)
+
+	service := stockservice.NewService(repoMock)
+
+	err := service.CompensateReserve(ctx, nil, 1, items)
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint64(0), repoMock.SaveStockOperationAfterCounter())
+}
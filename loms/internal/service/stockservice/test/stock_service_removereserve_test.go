@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/gojuno/minimock/v3"
+	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,8 +24,9 @@ func TestService_ReserveRemove_Success(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
 
-	repoMock.GetStocksMock.Set(func(ctx context.Context, skus []model.SKUType) ([]*model.Stock, error) {
+	repoMock.GetStocksForUpdateMock.Set(func(ctx context.Context, tx pgx.Tx, skus []model.SKUType) ([]*model.Stock, error) {
 		assert.ElementsMatch(t, []model.SKUType{1, 2}, skus)
 		return []*model.Stock{{SKU: 1, TotalCount: 20, ReservedCount: 10}, {SKU: 2, TotalCount: 15, ReservedCount: 5}}, nil
 	})
@@ -34,14 +36,16 @@ func TestService_ReserveRemove_Success(t *testing.T) {
 		2: {SKU: 2, TotalCount: 12, ReservedCount: 2}, // 15 - 3
 	}
 
-	repoMock.UpdateStockMock.Set(func(ctx context.Context, stocks map[model.SKUType]*model.Stock) error {
+	repoMock.UpdateStockMock.Set(func(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation, stocks map[model.SKUType]*model.Stock) error {
 		assert.True(t, compareStocks(expectedUpdateStocks, stocks))
 		return nil
 	})
 
+	repoMock.SaveStockOperationMock.Return(nil)
+
 	service := stockservice.NewService(repoMock)
 
-	err := service.ReserveRemove(ctx, items)
+	err := service.ReserveRemove(ctx, nil, 1, items)
 	assert.NoError(t, err)
 }
 
@@ -55,14 +59,15 @@ func TestService_ReserveRemove_GetStockError(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
 
-	repoMock.GetStocksMock.
-		When(ctx, []model.SKUType{1}).
+	repoMock.GetStocksForUpdateMock.
+		When(ctx, nil, []model.SKUType{1}).
 		Then(nil, errors.New("database error"))
 
 	service := stockservice.NewService(repoMock)
 
-	err := service.ReserveRemove(ctx, items)
+	err := service.ReserveRemove(ctx, nil, 1, items)
 	assert.Error(t, err)
 	assert.EqualError(t, err, "database error")
 }
@@ -77,14 +82,15 @@ func TestService_ReserveRemove_NegativeReservedCount(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
 
-	repoMock.GetStocksMock.
-		When(ctx, []model.SKUType{1}).
+	repoMock.GetStocksForUpdateMock.
+		When(ctx, nil, []model.SKUType{1}).
 		Then([]*model.Stock{{SKU: 1, TotalCount: 20, ReservedCount: 10}}, nil)
 
 	service := stockservice.NewService(repoMock)
 
-	err := service.ReserveRemove(ctx, items)
+	err := service.ReserveRemove(ctx, nil, 1, items)
 	assert.Error(t, err)
 	assert.IsType(t, appErrors.ErrStockInsufficient, err)
 }
@@ -99,14 +105,15 @@ func TestService_ReserveRemove_NegativeTotalCount(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
 
-	repoMock.GetStocksMock.
-		When(ctx, []model.SKUType{1}).
+	repoMock.GetStocksForUpdateMock.
+		When(ctx, nil, []model.SKUType{1}).
 		Then([]*model.Stock{{SKU: 1, TotalCount: 20, ReservedCount: 10}}, nil)
 
 	service := stockservice.NewService(repoMock)
 
-	err := service.ReserveRemove(ctx, items)
+	err := service.ReserveRemove(ctx, nil, 1, items)
 	assert.Error(t, err)
 	assert.IsType(t, appErrors.ErrStockInsufficient, err)
 }
@@ -121,22 +128,23 @@ func TestService_ReserveRemove_UpdateStockError(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
 
-	repoMock.GetStocksMock.
-		When(ctx, []model.SKUType{1}).
+	repoMock.GetStocksForUpdateMock.
+		When(ctx, nil, []model.SKUType{1}).
 		Then([]*model.Stock{{SKU: 1, TotalCount: 20, ReservedCount: 10}}, nil)
 
 	expectedUpdateStocks := map[model.SKUType]*model.Stock{
 		1: {SKU: 1, TotalCount: 15, ReservedCount: 5}, // 10 - 5
 	}
-	repoMock.UpdateStockMock.Set(func(ctx context.Context, stocks map[model.SKUType]*model.Stock) error {
+	repoMock.UpdateStockMock.Set(func(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation, stocks map[model.SKUType]*model.Stock) error {
 		assert.True(t, compareStocks(expectedUpdateStocks, stocks))
 		return errors.New("update error")
 	})
 
 	service := stockservice.NewService(repoMock)
 
-	err := service.ReserveRemove(ctx, items)
+	err := service.ReserveRemove(ctx, nil, 1, items)
 	assert.Error(t, err)
 	assert.EqualError(t, err, "update error")
 }
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/gojuno/minimock/v3"
+	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/assert"
 
 	"route256/loms/internal/model"
@@ -24,26 +25,29 @@ func TestService_Reserve_Success(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
 
-	repoMock.GetStocksMock.
-		When(ctx, []model.SKUType{1, 2}).
+	repoMock.GetStocksForUpdateMock.
+		When(ctx, nil, []model.SKUType{1, 2}).
 		Then([]*model.Stock{{SKU: 1, TotalCount: 20, ReservedCount: 10}, {SKU: 2, TotalCount: 15, ReservedCount: 5}}, nil)
 
 	expectedUpdateStocks := map[model.SKUType]*model.Stock{
 		1: {SKU: 1, TotalCount: 20, ReservedCount: 15}, // 5 + 10
 		2: {SKU: 2, TotalCount: 15, ReservedCount: 7},  // 2 + 5
 	}
-	repoMock.UpdateStockMock.Set(func(ctx context.Context, stocks map[model.SKUType]*model.Stock) error {
+	repoMock.UpdateStockMock.Set(func(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation, stocks map[model.SKUType]*model.Stock) error {
 		assert.True(t, compareStocks(expectedUpdateStocks, stocks))
 		return nil
 	})
 
+	repoMock.SaveStockOperationMock.Return(nil)
+
 	service := stockservice.NewService(repoMock)
 
-	err := service.Reserve(ctx, items)
+	err := service.Reserve(ctx, nil, 1, items)
 	assert.NoError(t, err)
 
-	assert.Equal(t, uint64(1), repoMock.GetStocksAfterCounter())
+	assert.Equal(t, uint64(1), repoMock.GetStocksForUpdateAfterCounter())
 	assert.Equal(t, uint64(1), repoMock.UpdateStockAfterCounter())
 }
 
@@ -57,18 +61,19 @@ func TestService_Reserve_NotEnoughStock(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
 
-	repoMock.GetStocksMock.
-		When(ctx, []model.SKUType{1}).
+	repoMock.GetStocksForUpdateMock.
+		When(ctx, nil, []model.SKUType{1}).
 		Then([]*model.Stock{{SKU: 1, TotalCount: 10, ReservedCount: 0}}, nil)
 
 	service := stockservice.NewService(repoMock)
 
-	err := service.Reserve(ctx, items)
+	err := service.Reserve(ctx, nil, 1, items)
 	assert.Error(t, err)
 	assert.ErrorIs(t, err, appErrors.ErrStockInsufficient)
 
-	assert.Equal(t, uint64(1), repoMock.GetStocksAfterCounter())
+	assert.Equal(t, uint64(1), repoMock.GetStocksForUpdateAfterCounter())
 	assert.Equal(t, uint64(0), repoMock.UpdateStockAfterCounter())
 }
 
@@ -82,18 +87,19 @@ func TestService_Reserve_GetStockError(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
 
-	repoMock.GetStocksMock.
-		When(ctx, []model.SKUType{1}).
+	repoMock.GetStocksForUpdateMock.
+		When(ctx, nil, []model.SKUType{1}).
 		Then(nil, errors.New("database error"))
 
 	service := stockservice.NewService(repoMock)
 
-	err := service.Reserve(ctx, items)
+	err := service.Reserve(ctx, nil, 1, items)
 	assert.Error(t, err)
 	assert.EqualError(t, err, "database error")
 
-	assert.Equal(t, uint64(1), repoMock.GetStocksAfterCounter())
+	assert.Equal(t, uint64(1), repoMock.GetStocksForUpdateAfterCounter())
 	assert.Equal(t, uint64(0), repoMock.UpdateStockAfterCounter())
 }
 
@@ -107,26 +113,27 @@ func TestService_Reserve_UpdateStockError(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
 
-	repoMock.GetStocksMock.
-		When(ctx, []model.SKUType{1}).
+	repoMock.GetStocksForUpdateMock.
+		When(ctx, nil, []model.SKUType{1}).
 		Then([]*model.Stock{{SKU: 1, TotalCount: 10, ReservedCount: 2}}, nil)
 
 	expectedUpdateStocks := map[model.SKUType]*model.Stock{
 		1: {SKU: 1, TotalCount: 10, ReservedCount: 7}, // 2 + 5
 	}
 
-	repoMock.UpdateStockMock.Set(func(ctx context.Context, stocks map[model.SKUType]*model.Stock) error {
+	repoMock.UpdateStockMock.Set(func(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation, stocks map[model.SKUType]*model.Stock) error {
 		assert.True(t, compareStocks(expectedUpdateStocks, stocks))
 		return errors.New("update error")
 	})
 	service := stockservice.NewService(repoMock)
 
-	err := service.Reserve(ctx, items)
+	err := service.Reserve(ctx, nil, 1, items)
 	assert.Error(t, err)
 	assert.EqualError(t, err, "update error")
 
-	assert.Equal(t, uint64(1), repoMock.GetStocksAfterCounter())
+	assert.Equal(t, uint64(1), repoMock.GetStocksForUpdateAfterCounter())
 	assert.Equal(t, uint64(1), repoMock.UpdateStockAfterCounter())
 }
 
@@ -141,24 +148,70 @@ func TestService_Reserve_DuplicateSKUs(t *testing.T) {
 	}
 
 	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Return(false, nil)
 
-	repoMock.GetStocksMock.
-		When(ctx, []model.SKUType{1}).
+	repoMock.GetStocksForUpdateMock.
+		When(ctx, nil, []model.SKUType{1}).
 		Then([]*model.Stock{{SKU: 1, TotalCount: 10, ReservedCount: 2}}, nil)
 
 	expectedUpdateStocks := map[model.SKUType]*model.Stock{
 		1: {SKU: 1, TotalCount: 10, ReservedCount: 10}, // 2 + (5 + 3)
 	}
-	repoMock.UpdateStockMock.Set(func(ctx context.Context, stocks map[model.SKUType]*model.Stock) error {
+	repoMock.UpdateStockMock.Set(func(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation, stocks map[model.SKUType]*model.Stock) error {
 		assert.True(t, compareStocks(expectedUpdateStocks, stocks))
 		return nil
 	})
 
+	repoMock.SaveStockOperationMock.Return(nil)
+
 	service := stockservice.NewService(repoMock)
 
-	err := service.Reserve(ctx, items)
+	err := service.Reserve(ctx, nil, 1, items)
 	assert.NoError(t, err)
 
-	assert.Equal(t, uint64(1), repoMock.GetStocksAfterCounter())
+	assert.Equal(t, uint64(1), repoMock.GetStocksForUpdateAfterCounter())
 	assert.Equal(t, uint64(1), repoMock.UpdateStockAfterCounter())
 }
+
+func TestService_Reserve_AlreadyApplied(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	items := []*model.Item{
+		{SKU: 1, Count: 5},
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.When(ctx, nil, 1, model.RESERVE_CANCEL).Then(false, nil)
+	repoMock.IsStockOperationAppliedMock.When(ctx, nil, 1, model.RESERVE).Then(true, nil)
+
+	service := stockservice.NewService(repoMock)
+
+	err := service.Reserve(ctx, nil, 1, items)
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint64(0), repoMock.GetStocksForUpdateAfterCounter())
+	assert.Equal(t, uint64(0), repoMock.UpdateStockAfterCounter())
+}
+
+func TestService_Reserve_AfterCompensation(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	items := []*model.Item{
+		{SKU: 1, Count: 5},
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	repoMock.IsStockOperationAppliedMock.Expect(ctx, nil, 1, model.RESERVE_CANCEL).Return(true, nil)
+
+	service := stockservice.NewService(repoMock)
+
+	err := service.Reserve(ctx, nil, 1, items)
+	assert.ErrorIs(t, err, appErrors.ErrStockInsufficient)
+
+	assert.Equal(t, uint64(0), repoMock.GetStocksForUpdateAfterCounter())
+	assert.Equal(t, uint64(0), repoMock.UpdateStockAfterCounter())
+}
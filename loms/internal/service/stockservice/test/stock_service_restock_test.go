@@ -0,0 +1,102 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"math"
+	appErrors "route256/loms/internal/errors"
+	"route256/loms/internal/model"
+	"route256/loms/internal/service/stockservice"
+	"testing"
+
+	"github.com/gojuno/minimock/v3"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_Restock_Success(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	items := []*model.Item{
+		{SKU: 2, Count: 3},
+		{SKU: 1, Count: 5},
+		{SKU: 2, Count: 1},
+	}
+
+	repoMock := NewRepositoryMock(mc)
+	repoMock.GetStocksForUpdateMock.Expect(ctx, nil, []model.SKUType{1, 2}).
+		Return([]*model.Stock{{SKU: 1, TotalCount: 20, ReservedCount: 10}, {SKU: 2, TotalCount: 15, ReservedCount: 5}}, nil)
+	expectedUpdateStocks := map[model.SKUType]*model.Stock{
+		1: {SKU: 1, TotalCount: 25, ReservedCount: 10}, // 20 + 5
+		2: {SKU: 2, TotalCount: 19, ReservedCount: 5},  // 15 + 3 + 1
+	}
+	// пополнение не связано с заказом, событие stock.changed пишется репозиторием вместе с новыми стоками
+	repoMock.UpdateStockMock.Set(func(ctx context.Context, tx pgx.Tx, orderID int64, operation model.StockOperation, stocks map[model.SKUType]*model.Stock) error {
+		assert.Equal(t, int64(0), orderID)
+		assert.Equal(t, model.RESTOCK, operation)
+		assert.True(t, compareStocks(expectedUpdateStocks, stocks))
+		return nil
+	})
+
+	service := stockservice.NewService(repoMock)
+
+	err := service.Restock(ctx, nil, items)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), repoMock.IsStockOperationAppliedAfterCounter())
+	assert.Equal(t, uint64(0), repoMock.SaveStockOperationAfterCounter())
+}
+
+func TestService_Restock_InvalidCount(t *testing.T) {
+	tests := []struct {
+		name  string
+		stock *model.Stock
+		items []*model.Item
+	}{
+		{
+			name:  "zero count",
+			items: []*model.Item{{SKU: 1, Count: 0}},
+		},
+		{
+			name:  "total count overflow",
+			stock: &model.Stock{SKU: 1, TotalCount: math.MaxUint32 - 1, ReservedCount: 0},
+			items: []*model.Item{{SKU: 1, Count: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := minimock.NewController(t)
+
+			ctx := context.Background()
+
+			repoMock := NewRepositoryMock(mc)
+			if tt.stock != nil {
+				repoMock.GetStocksForUpdateMock.Return([]*model.Stock{tt.stock}, nil)
+			}
+
+			service := stockservice.NewService(repoMock)
+
+			err := service.Restock(ctx, nil, tt.items)
+			assert.ErrorIs(t, err, appErrors.ErrInvalidInput)
+			assert.Equal(t, uint64(0), repoMock.UpdateStockAfterCounter())
+		})
+	}
+}
+
+func TestService_Restock_UpdateStockError(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	ctx := context.Background()
+
+	updateErr := errors.New("update error")
+
+	repoMock := NewRepositoryMock(mc)
+	repoMock.GetStocksForUpdateMock.Return([]*model.Stock{{SKU: 1, TotalCount: 20, ReservedCount: 10}}, nil)
+	repoMock.UpdateStockMock.Return(updateErr)
+
+	service := stockservice.NewService(repoMock)
+
+	err := service.Restock(ctx, nil, []*model.Item{{SKU: 1, Count: 5}})
+	assert.ErrorIs(t, err, updateErr)
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"github.com/jackc/pgx/v5"
 	"route256/loms/internal/infra/database"
+	"route256/loms/internal/model"
 )
 
 type ConnectionPooler interface {
 	PickDefaultShard(ctx context.Context, readOnlyOperation bool) (*database.FallbackConnection, error)
-	PickAllShards(ctx context.Context, readOnlyOperation bool) ([]*database.FallbackConnection, error)
+	PickConnFromUserId(ctx context.Context, userId int64, readOnlyOperation bool) (*database.FallbackConnection, error)
+	ShardIndexFromUserId(userId int64) (int, error)
+	PickConnFromSkus(ctx context.Context, skus []int64, readOnlyOperation bool) (*database.FallbackConnection, error)
+	ShardIndexFromSku(sku int64, readOnlyOperation bool) (int, error)
 }
 
 type TransactionManager struct {
@@ -37,21 +41,60 @@ func (tm *TransactionManager) Begin(ctx context.Context) (pgx.Tx, error) {
 	return tx, nil
 }
 
-// BeginWithShard - начинает транзакцию и возвращает транзакцию и функцию закрытия на определенном шарде
-func (tm *TransactionManager) BeginTransactionsOnAllShards(ctx context.Context) ([]pgx.Tx, error) {
-	connections, err := tm.pool.PickAllShards(ctx, false)
+// RunInUserShardTx выполняет fn в транзакции на шарде заказов пользователя.
+// После коммита позиция WAL шарда запоминается в трекере коммитов ctx, если он есть
+func (tm *TransactionManager) RunInUserShardTx(ctx context.Context, userId int64, fn func(tx pgx.Tx) error) error {
+	conn, err := tm.pool.PickConnFromUserId(ctx, userId, false)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer conn.Release()
+
+	if err = pgx.BeginFunc(ctx, conn, fn); err != nil {
+		return err
+	}
+	conn.TrackCommit(ctx)
+	return nil
+}
+
+// RunInStockShardTx выполняет fn в транзакции на шарде стоков skus. Все SKU должны лежать на одном шарде
+func (tm *TransactionManager) RunInStockShardTx(ctx context.Context, skus []model.SKUType, fn func(tx pgx.Tx) error) error {
+	conn, err := tm.pool.PickConnFromSkus(ctx, toInt64Skus(skus), false)
+	if err != nil {
+		return err
 	}
-	var transcations []pgx.Tx
+	defer conn.Release()
+
+	return pgx.BeginFunc(ctx, conn, fn)
+}
+
+// StockShardIndex возвращает индекс шарда, на котором лежит сток SKU
+func (tm *TransactionManager) StockShardIndex(sku model.SKUType) (int, error) {
+	return tm.pool.ShardIndexFromSku(int64(sku), true)
+}
 
-	for _, conn := range connections {
-		tx, err := conn.Begin(ctx)
-		if err != nil {
-			conn.Release()
-			return nil, err
+// UserShardHoldsStock сообщает, лежат ли заказы пользователя на одном шарде со стоками всех skus.
+// Только в этом случае заказ и сток можно изменить в одной транзакции.
+// Если бакет пользователя или SKU не закреплен за шардом либо переносится, возвращается false,
+// а ошибку вернет выбор шарда при выполнении операции
+func (tm *TransactionManager) UserShardHoldsStock(userId int64, skus []model.SKUType) bool {
+	userShardIndex, err := tm.pool.ShardIndexFromUserId(userId)
+	if err != nil {
+		return false
+	}
+	for _, sku := range skus {
+		stockShardIndex, err := tm.pool.ShardIndexFromSku(int64(sku), false)
+		if err != nil || stockShardIndex != userShardIndex {
+			return false
 		}
-		transcations = append(transcations, tx)
 	}
-	return transcations, nil
+	return true
+}
+
+func toInt64Skus(skus []model.SKUType) []int64 {
+	intSkus := make([]int64, 0, len(skus))
+	for _, sku := range skus {
+		intSkus = append(intSkus, int64(sku))
+	}
+	return intSkus
 }
@@ -4,7 +4,10 @@
 DELETE FROM stock WHERE sku IN (773297411, 1002, 1003, 1004, 1005);
 
 -- Drop tables
-DROP TABLE IF EXISTS items, orders, stock CASCADE;
+DROP TABLE IF EXISTS order_state_history, order_saga, stock_operations, idempotency_keys, items, orders, stock, outbox CASCADE;
+
+DROP SEQUENCE IF EXISTS order_number_seq;
 
 -- Drop custom type
+DROP TYPE IF EXISTS STOCK_OPERATION_TYPE;
 DROP TYPE IF EXISTS ORDER_STATUS;
@@ -17,6 +17,9 @@ import (
 
 var db *pgx.Conn
 
+// dsn строка подключения к тестовой базе, нужна тестам, которым требуется пул соединений
+var dsn string
+
 func TestMain(m *testing.M) {
 	ctx := context.Background()
 
@@ -57,7 +60,7 @@ func TestMain(m *testing.M) {
 		log.Fatalf("failed to get container port: %v", err)
 	}
 
-	dsn := fmt.Sprintf("host=%s port=%s user=testuser password=testpassword dbname=testdb sslmode=disable", host, port.Port())
+	dsn = fmt.Sprintf("host=%s port=%s user=testuser password=testpassword dbname=testdb sslmode=disable", host, port.Port())
 
 	db, err = pgx.Connect(ctx, dsn)
 	if err != nil {
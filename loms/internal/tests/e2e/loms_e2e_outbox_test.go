@@ -0,0 +1,238 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"route256/loms/internal/model"
+	"route256/loms/internal/repository/outboxrepository"
+)
+
+// insertOrderEvent сохраняет неотправленное событие заказа и возвращает его ID
+func insertOrderEvent(t *testing.T, orderID int64) int32 {
+	var id int32
+	err := db.QueryRow(context.Background(),
+		"INSERT INTO outbox (order_id, payload) VALUES ($1, 'order') RETURNING id", orderID).Scan(&id)
+	require.NoError(t, err, "Не удалось сохранить событие заказа")
+	return id
+}
+
+// insertStockEvent сохраняет неотправленное событие стока и возвращает его ID
+func insertStockEvent(t *testing.T, sku int64) int32 {
+	var id int32
+	err := db.QueryRow(context.Background(),
+		"INSERT INTO outbox (sku, payload) VALUES ($1, 'stock') RETURNING id", sku).Scan(&id)
+	require.NoError(t, err, "Не удалось сохранить событие стока")
+	return id
+}
+
+func eventIDs(events []*model.OutboxEvent) []int32 {
+	ids := make([]int32, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestE2E_ClaimOutboxEventsLease(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	repo := outboxrepository.NewRepository()
+
+	id := insertOrderEvent(t, 1)
+	lease := 200 * time.Millisecond
+
+	events, err := repo.ClaimOutboxEvents(ctx, db, lease, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int32{id}, eventIDs(events))
+	assert.Equal(t, model.OrderOutboxStream, events[0].Stream)
+	assert.Equal(t, int64(1), events[0].OrderID)
+
+	// пока аренда не истекла, событие не захватывает другой обработчик
+	events, err = repo.ClaimOutboxEvents(ctx, db, lease, 10)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	// обработчик не отметил событие до истечения аренды: оно захватывается повторно
+	time.Sleep(2 * lease)
+	events, err = repo.ClaimOutboxEvents(ctx, db, lease, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int32{id}, eventIDs(events))
+}
+
+func TestE2E_ClaimOutboxEventsSkipsLocked(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	repo := outboxrepository.NewRepository()
+
+	lockedID := insertOrderEvent(t, 1)
+	freeID := insertOrderEvent(t, 2)
+
+	pool, err := pgxpool.New(ctx, dsn)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	// другой обработчик держит строку события в своей транзакции захвата
+	tx, err := pool.Begin(ctx)
+	require.NoError(t, err)
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	_, err = tx.Exec(ctx, "SELECT id FROM outbox WHERE id = $1 FOR UPDATE", lockedID)
+	require.NoError(t, err)
+
+	claimCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	events, err := repo.ClaimOutboxEvents(claimCtx, db, time.Minute, 10)
+	require.NoError(t, err, "Захват ждал заблокированную строку вместо того, чтобы ее пропустить")
+	assert.Equal(t, []int32{freeID}, eventIDs(events))
+
+	require.NoError(t, tx.Rollback(ctx))
+	events, err = repo.ClaimOutboxEvents(ctx, db, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int32{lockedID}, eventIDs(events))
+}
+
+func TestE2E_ClaimOutboxEventsWaitsForEarlierEvents(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	repo := outboxrepository.NewRepository()
+
+	orderFirst := insertOrderEvent(t, 1)
+	stockFirst := insertStockEvent(t, 100)
+	orderSecond := insertOrderEvent(t, 1)
+	stockSecond := insertStockEvent(t, 100)
+	otherOrder := insertOrderEvent(t, 2)
+
+	// следующие события заказа и SKU ждут отправки предыдущих, остальные захватываются сразу
+	events, err := repo.ClaimOutboxEvents(ctx, db, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int32{orderFirst, stockFirst, otherOrder}, eventIDs(events))
+	assert.Equal(t, model.StockOutboxStream, events[1].Stream)
+	assert.Equal(t, model.SKUType(100), events[1].SKU)
+
+	events, err = repo.ClaimOutboxEvents(ctx, db, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	require.NoError(t, repo.MarkOutboxEventsProcessed(ctx, db, []int32{orderFirst}))
+	events, err = repo.ClaimOutboxEvents(ctx, db, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int32{orderSecond}, eventIDs(events))
+
+	// событие в dead letter больше не задерживает следующие
+	deadLettered, err := repo.RecordOutboxFailures(ctx, db, []*model.OutboxFailure{
+		{EventID: stockFirst, Err: errors.New("message too large"), Permanent: true},
+	}, outboxrepository.RetryPolicy{MaxAttempts: 5, BaseBackoff: time.Second, MaxBackoff: time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, []int32{stockFirst}, eventIDs(deadLettered))
+	events, err = repo.ClaimOutboxEvents(ctx, db, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int32{stockSecond}, eventIDs(events))
+}
+
+// recordFailure учитывает временную ошибку отправки события и в той же транзакции читает задержку до следующей попытки.
+// now() в транзакции не меняется, поэтому задержка сравнивается точно
+func recordFailure(t *testing.T, id int32, policy outboxrepository.RetryPolicy) (int32, time.Duration, bool) {
+	ctx := context.Background()
+	repo := outboxrepository.NewRepository()
+
+	var attempts int32
+	var backoff float64
+	var deadLettered bool
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		_, err := repo.RecordOutboxFailures(ctx, tx, []*model.OutboxFailure{
+			{EventID: id, Err: errors.New("kafka: client has run out of available brokers")},
+		}, policy)
+		if err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, `SELECT attempts, EXTRACT(EPOCH FROM next_attempt_at - now())::float8, dead_lettered_at IS NOT NULL
+			FROM outbox WHERE id = $1`, id).Scan(&attempts, &backoff, &deadLettered)
+	})
+	require.NoError(t, err)
+	return attempts, time.Duration(backoff * float64(time.Second)), deadLettered
+}
+
+func TestE2E_RecordOutboxFailuresBackoff(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	repo := outboxrepository.NewRepository()
+	policy := outboxrepository.RetryPolicy{MaxAttempts: 5, BaseBackoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	id := insertOrderEvent(t, 1)
+
+	// задержка удваивается после каждой неудачи, пока не упрется в MaxBackoff
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		attempts, backoff, deadLettered := recordFailure(t, id, policy)
+		assert.Equal(t, want, backoff, "Задержка после попытки %d", attempts)
+		assert.False(t, deadLettered, "Событие переведено в dead letter после попытки %d", attempts)
+	}
+
+	// до следующей попытки событие не захватывается
+	events, err := repo.ClaimOutboxEvents(ctx, db, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	// последняя попытка из MaxAttempts переводит событие в dead letter
+	attempts, _, deadLettered := recordFailure(t, id, policy)
+	assert.Equal(t, int32(5), attempts)
+	assert.True(t, deadLettered)
+}
+
+func TestE2E_RecordOutboxFailuresDeadLetter(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	repo := outboxrepository.NewRepository()
+	policy := outboxrepository.RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	permanentID := insertOrderEvent(t, 1)
+	retriedID := insertStockEvent(t, 100)
+	processedID := insertOrderEvent(t, 2)
+	require.NoError(t, repo.MarkOutboxEventsProcessed(ctx, db, []int32{processedID}))
+
+	failures := []*model.OutboxFailure{
+		{EventID: permanentID, Err: errors.New("message too large"), Permanent: true},
+		{EventID: retriedID, Err: errors.New("out of brokers")},
+		{EventID: processedID, Err: errors.New("out of brokers")},
+	}
+
+	// неисправимая ошибка переводит событие в dead letter сразу, отправленное событие не меняется
+	deadLettered, err := repo.RecordOutboxFailures(ctx, db, failures, policy)
+	require.NoError(t, err)
+	require.Len(t, deadLettered, 1)
+	assert.Equal(t, permanentID, deadLettered[0].ID)
+	assert.Equal(t, int32(1), deadLettered[0].Attempts)
+	assert.Equal(t, model.OrderOutboxStream, deadLettered[0].Stream)
+	assert.Equal(t, int64(1), deadLettered[0].OrderID)
+
+	deadLettered, err = repo.RecordOutboxFailures(ctx, db, failures[1:], policy)
+	require.NoError(t, err)
+	require.Len(t, deadLettered, 1)
+	assert.Equal(t, retriedID, deadLettered[0].ID)
+	assert.Equal(t, int32(2), deadLettered[0].Attempts)
+	assert.Equal(t, model.StockOutboxStream, deadLettered[0].Stream)
+	assert.Equal(t, model.SKUType(100), deadLettered[0].SKU)
+
+	// события в dead letter больше не захватываются
+	time.Sleep(10 * time.Millisecond)
+	events, err := repo.ClaimOutboxEvents(ctx, db, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	var lastError *string
+	var attempts int32
+	err = db.QueryRow(ctx, "SELECT last_error, attempts FROM outbox WHERE id = $1", processedID).Scan(&lastError, &attempts)
+	require.NoError(t, err)
+	assert.Nil(t, lastError)
+	assert.Zero(t, attempts)
+}
@@ -0,0 +1,355 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"route256/loms/internal/app/reshard"
+	"route256/loms/internal/infra/database"
+	"route256/loms/internal/repository/orderrepository"
+	"route256/loms/internal/repository/outboxrepository"
+)
+
+const secondShardDB = "testdb_shard_1"
+
+// setupSecondShard создает вторую базу со схемой up.sql и возвращает пулы обоих шардов
+func setupSecondShard(t *testing.T) (*pgxpool.Pool, *pgxpool.Pool) {
+	ctx := context.Background()
+
+	_, err := db.Exec(ctx, "CREATE DATABASE "+secondShardDB)
+	require.NoError(t, err, "Не удалось создать базу второго шарда")
+
+	shard0, err := pgxpool.New(ctx, dsn)
+	require.NoError(t, err, "Не удалось создать пул первого шарда")
+	shard1, err := pgxpool.New(ctx, strings.Replace(dsn, "dbname=testdb", "dbname="+secondShardDB, 1))
+	require.NoError(t, err, "Не удалось создать пул второго шарда")
+
+	t.Cleanup(func() {
+		shard0.Close()
+		shard1.Close()
+		_, err := db.Exec(context.Background(), "DROP DATABASE IF EXISTS "+secondShardDB)
+		assert.NoError(t, err, "Не удалось удалить базу второго шарда")
+	})
+
+	conn, err := shard1.Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+	require.NoError(t, executeSQLFile(conn.Conn(), "up.sql"), "Не удалось создать схему второго шарда")
+
+	return shard0, shard1
+}
+
+// userInBucket подбирает пользователя, бакет которого не меньше minBucket и лежит на шарде 0 при двух шардах
+func userInBucket(t *testing.T, minBucket int, except int) int64 {
+	for userId := int64(1); userId < 100000; userId++ {
+		bucket := database.BucketFromUserId(userId)
+		if bucket >= minBucket && bucket%2 == 0 && bucket != except {
+			return userId
+		}
+	}
+	t.Fatal("Не удалось подобрать пользователя")
+	return 0
+}
+
+// insertOrder сохраняет заказ со всеми дочерними строками, как их пишет сервис
+func insertOrder(t *testing.T, q *pgxpool.Pool, orderID, userId int64, state string) {
+	ctx := context.Background()
+	err := pgx.BeginFunc(ctx, q, func(tx pgx.Tx) error {
+		queries := []struct {
+			sql  string
+			args []any
+		}{
+			{"INSERT INTO orders (id, state, user_id) VALUES ($1, $2, $3)", []any{orderID, state, userId}},
+			{"INSERT INTO items (sku, order_id, count) VALUES (1, $1, 2)", []any{orderID}},
+			{"INSERT INTO order_state_history (order_id, state) VALUES ($1, $2)", []any{orderID, state}},
+			{"INSERT INTO idempotency_keys (user_id, idempotency_key, order_id) VALUES ($1, $2, $3)",
+				[]any{userId, "key-" + strconv.FormatInt(orderID, 10), orderID}},
+			{"INSERT INTO outbox (order_id, payload, processed) VALUES ($1, 'event', TRUE)", []any{orderID}},
+		}
+		for _, query := range queries {
+			if _, err := tx.Exec(ctx, query.sql, query.args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err, "Не удалось сохранить заказ")
+}
+
+func countOrderRows(t *testing.T, q *pgxpool.Pool, orderID int64) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, query := range []struct{ table, column string }{
+		{"orders", "id"}, {"items", "order_id"}, {"order_state_history", "order_id"},
+		{"idempotency_keys", "order_id"}, {"outbox", "order_id"},
+	} {
+		var count int64
+		err := q.QueryRow(context.Background(), "SELECT count(*) FROM "+query.table+" WHERE "+query.column+" = $1", orderID).
+			Scan(&count)
+		require.NoError(t, err, "Не удалось посчитать строки %s", query.table)
+		counts[query.table] = count
+	}
+	return counts
+}
+
+func countStock(t *testing.T, q *pgxpool.Pool, sku int64) int64 {
+	var count int64
+	err := q.QueryRow(context.Background(), "SELECT count(*) FROM stock WHERE sku = $1", sku).Scan(&count)
+	require.NoError(t, err, "Не удалось посчитать стоки")
+	return count
+}
+
+func waitBucketMap(t *testing.T, path string, condition func(bucketMap *database.BucketMap) bool) *database.BucketMap {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		bucketMap, err := database.LoadBucketMap(path, 2)
+		if err == nil && condition(bucketMap) {
+			return bucketMap
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Карта бакетов не пришла в ожидаемое состояние")
+	return nil
+}
+
+func TestE2E_ReshardMovesBucketWithUser(t *testing.T) {
+	setupTest(t)
+	shard0, shard1 := setupSecondShard(t)
+	ctx := context.Background()
+
+	userId := userInBucket(t, 2, -1)
+	bucket := database.BucketFromUserId(userId)
+	otherUserId := userInBucket(t, 2, bucket)
+
+	// заказ из последовательности шарда 0 до перехода на бакеты лежит в бакете 0, а не в бакете пользователя
+	const legacyOrderID, otherLegacyOrderID = 3000, 4000
+	newOrderID := int64(5*database.BucketsCount + bucket)
+	insertOrder(t, shard0, legacyOrderID, userId, "PAYED")
+	insertOrder(t, shard0, newOrderID, userId, "AWAITING_PAYMENT")
+	insertOrder(t, shard0, otherLegacyOrderID, otherUserId, "NEW")
+	// копия, оставшаяся на новом шарде от прерванного запуска, заменяется
+	insertOrder(t, shard1, legacyOrderID, userId, "NEW")
+
+	sku := int64(7*database.BucketsCount + bucket)
+	_, err := shard0.Exec(ctx, "INSERT INTO stock (sku, total_count, reserved_count) VALUES ($1, 10, 1)", sku)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "bucket-map.json")
+	current := database.NewModuloBucketMap(2)
+	require.NoError(t, current.Save(path))
+	target := current.Clone()
+	target.SetShard(bucket, 1)
+
+	resharder := reshard.NewResharder([]*pgxpool.Pool{shard0, shard1}, reshard.Options{
+		BucketMapPath: path,
+		Target:        target,
+		BatchSize:     1,
+		Grace:         300 * time.Millisecond,
+		DrainTimeout:  time.Second,
+		StockFrom:     database.UnassignedShard,
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- resharder.Run(ctx)
+	}()
+
+	// пока бакет read-only, он остается на старом шарде и запись в него запрещена
+	readOnly := waitBucketMap(t, path, func(bucketMap *database.BucketMap) bool { return bucketMap.IsReadOnly(bucket) })
+	shardIndex, err := readOnly.ShardIndex(bucket)
+	require.NoError(t, err)
+	assert.Equal(t, 0, shardIndex, "Бакет переключен до копирования")
+	router := database.NewDBRouter([]*database.MasterAndReplica{{shard0, nil}, {shard1, nil}}, readOnly)
+	_, err = router.PickConnFromUserId(ctx, userId, false)
+	assert.ErrorIs(t, err, database.ErrBucketReadOnly)
+
+	// карта переключается только после того, как строки бакета скопированы
+	waitBucketMap(t, path, func(bucketMap *database.BucketMap) bool {
+		shardIndex, err := bucketMap.ShardIndex(bucket)
+		return err == nil && shardIndex == 1 && !bucketMap.IsReadOnly(bucket)
+	})
+	assert.Equal(t, int64(1), countOrderRows(t, shard1, newOrderID)["orders"], "Карта переключена до копирования")
+
+	require.NoError(t, <-done, "Решардинг завершился ошибкой")
+
+	moved := map[string]int64{"orders": 1, "items": 1, "order_state_history": 1, "idempotency_keys": 1, "outbox": 1}
+	empty := map[string]int64{"orders": 0, "items": 0, "order_state_history": 0, "idempotency_keys": 0, "outbox": 0}
+	for _, orderID := range []int64{legacyOrderID, newOrderID} {
+		assert.Equal(t, moved, countOrderRows(t, shard1, orderID), "Заказ %d не перенесен", orderID)
+		assert.Equal(t, empty, countOrderRows(t, shard0, orderID), "Заказ %d не удален со старого шарда", orderID)
+	}
+	assert.Equal(t, moved, countOrderRows(t, shard0, otherLegacyOrderID), "Заказ другого пользователя перенесен")
+	assert.Equal(t, empty, countOrderRows(t, shard1, otherLegacyOrderID))
+
+	// карта стоков не менялась, сток остается на шарде 0
+	assert.Equal(t, int64(1), countStock(t, shard0, sku), "Сток перенесен вместе с бакетом заказов")
+	assert.Equal(t, int64(0), countStock(t, shard1, sku))
+
+	// заказ со старым ID находится по ID, хотя бакет из ID остался на шарде 0
+	final, err := database.LoadBucketMap(path, 2)
+	require.NoError(t, err)
+	router.SetBucketMap(final)
+	order, err := orderrepository.NewRepository(router, outboxrepository.NewRepository()).GetById(ctx, legacyOrderID)
+	require.NoError(t, err)
+	assert.Equal(t, userId, order.UserId)
+	assert.Equal(t, "PAYED", string(order.State))
+}
+
+func TestE2E_ReshardPendingOutboxKeepsBucket(t *testing.T) {
+	setupTest(t)
+	shard0, shard1 := setupSecondShard(t)
+	ctx := context.Background()
+
+	userId := userInBucket(t, 2, -1)
+	bucket := database.BucketFromUserId(userId)
+	orderID := int64(5*database.BucketsCount + bucket)
+	insertOrder(t, shard0, orderID, userId, "NEW")
+	_, err := shard0.Exec(ctx, "INSERT INTO outbox (order_id, payload) VALUES ($1, 'pending')", orderID)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "bucket-map.json")
+	current := database.NewModuloBucketMap(2)
+	require.NoError(t, current.Save(path))
+	target := current.Clone()
+	target.SetShard(bucket, 1)
+
+	err = reshard.NewResharder([]*pgxpool.Pool{shard0, shard1}, reshard.Options{
+		BucketMapPath: path,
+		Target:        target,
+		BatchSize:     1,
+		Grace:         10 * time.Millisecond,
+		DrainTimeout:  10 * time.Millisecond,
+		StockFrom:     database.UnassignedShard,
+	}).Run(ctx)
+	require.Error(t, err, "Бакет перенесен с неотправленными событиями")
+
+	// бакет остается на старом шарде и снова доступен на запись
+	bucketMap, err := database.LoadBucketMap(path, 2)
+	require.NoError(t, err)
+	shardIndex, err := bucketMap.ShardIndex(bucket)
+	require.NoError(t, err)
+	assert.Equal(t, 0, shardIndex)
+	assert.False(t, bucketMap.IsReadOnly(bucket))
+	assert.Equal(t, int64(1), countOrderRows(t, shard0, orderID)["orders"])
+	assert.Equal(t, int64(0), countOrderRows(t, shard1, orderID)["orders"])
+}
+
+func TestE2E_ReshardStockFrom(t *testing.T) {
+	setupTest(t)
+	shard0, shard1 := setupSecondShard(t)
+	ctx := context.Background()
+
+	// бакет 3 по карте заказов на шарде 1, бакет 4 на шарде 0
+	const movedSku, keptSku = 7003, 7004
+	for _, sku := range []int64{movedSku, keptSku} {
+		_, err := shard0.Exec(ctx, "INSERT INTO stock (sku, total_count, reserved_count) VALUES ($1, 10, 1)", sku)
+		require.NoError(t, err)
+	}
+	bucket := database.BucketFromSku(movedSku)
+
+	path := filepath.Join(t.TempDir(), "bucket-map.json")
+	require.NoError(t, database.NewModuloBucketMap(2).Save(path))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- reshard.NewResharder([]*pgxpool.Pool{shard0, shard1}, reshard.Options{
+			BucketMapPath: path,
+			BatchSize:     database.BucketsCount,
+			Grace:         300 * time.Millisecond,
+			DrainTimeout:  time.Second,
+			StockFrom:     0,
+		}).Run(ctx)
+	}()
+
+	// пока бакет стоков read-only, сток читается со старого шарда, а запись в него запрещена
+	readOnly := waitBucketMap(t, path, func(bucketMap *database.BucketMap) bool { return bucketMap.IsStockReadOnly(bucket) })
+	router := database.NewDBRouter([]*database.MasterAndReplica{{shard0, nil}, {shard1, nil}}, readOnly)
+	_, err := router.ShardIndexFromSku(movedSku, false)
+	assert.ErrorIs(t, err, database.ErrBucketReadOnly)
+	shardIndex, err := router.ShardIndexFromSku(movedSku, true)
+	require.NoError(t, err)
+	assert.Equal(t, 0, shardIndex, "Бакет стоков переключен до копирования")
+
+	// карта стоков переключается только после того, как сток скопирован
+	waitBucketMap(t, path, func(bucketMap *database.BucketMap) bool {
+		shardIndex, err := bucketMap.StockShardIndex(bucket)
+		return err == nil && shardIndex == 1 && !bucketMap.IsStockReadOnly(bucket)
+	})
+	assert.Equal(t, int64(1), countStock(t, shard1, movedSku), "Карта стоков переключена до копирования")
+
+	require.NoError(t, <-done, "Решардинг стоков завершился ошибкой")
+
+	assert.Equal(t, int64(0), countStock(t, shard0, movedSku), "Сток не удален со старого шарда")
+	assert.Equal(t, int64(1), countStock(t, shard0, keptSku))
+	assert.Equal(t, int64(0), countStock(t, shard1, keptSku))
+
+	final, err := database.LoadBucketMap(path, 2)
+	require.NoError(t, err)
+	router.SetBucketMap(final)
+	shardIndex, err = router.ShardIndexFromSku(movedSku, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, shardIndex)
+	shardIndex, err = router.ShardIndexFromSku(keptSku, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, shardIndex)
+}
+
+func TestE2E_ReshardCleansUpInterruptedMove(t *testing.T) {
+	setupTest(t)
+	shard0, shard1 := setupSecondShard(t)
+	ctx := context.Background()
+
+	userId := userInBucket(t, 2, -1)
+	bucket := database.BucketFromUserId(userId)
+	otherUserId := userInBucket(t, 2, bucket)
+
+	// прерванный запуск переключил бакет на шард 1, но не удалил его строки с шарда 0
+	const legacyOrderID, otherLegacyOrderID = 3000, 4000
+	newOrderID := int64(5*database.BucketsCount + bucket)
+	for _, shard := range []*pgxpool.Pool{shard0, shard1} {
+		insertOrder(t, shard, legacyOrderID, userId, "PAYED")
+		insertOrder(t, shard, newOrderID, userId, "AWAITING_PAYMENT")
+	}
+	insertOrder(t, shard0, otherLegacyOrderID, otherUserId, "NEW")
+	// сток скопирован на шард 1, но карта стоков не переключена
+	const sku = 7004
+	for _, shard := range []*pgxpool.Pool{shard0, shard1} {
+		_, err := shard.Exec(ctx, "INSERT INTO stock (sku, total_count, reserved_count) VALUES ($1, 10, 1)", sku)
+		require.NoError(t, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "bucket-map.json")
+	current := database.NewModuloBucketMap(2)
+	current.SetShard(bucket, 1)
+	require.NoError(t, current.Save(path))
+
+	err := reshard.NewResharder([]*pgxpool.Pool{shard0, shard1}, reshard.Options{
+		BucketMapPath: path,
+		Target:        current,
+		BatchSize:     1,
+		Grace:         10 * time.Millisecond,
+		DrainTimeout:  time.Second,
+		StockFrom:     database.UnassignedShard,
+	}).Run(ctx)
+	require.NoError(t, err, "Решардинг завершился ошибкой")
+
+	moved := map[string]int64{"orders": 1, "items": 1, "order_state_history": 1, "idempotency_keys": 1, "outbox": 1}
+	empty := map[string]int64{"orders": 0, "items": 0, "order_state_history": 0, "idempotency_keys": 0, "outbox": 0}
+	for _, orderID := range []int64{legacyOrderID, newOrderID} {
+		assert.Equal(t, moved, countOrderRows(t, shard1, orderID), "Заказ %d удален с шарда бакета", orderID)
+		assert.Equal(t, empty, countOrderRows(t, shard0, orderID), "Заказ %d не удален со старого шарда", orderID)
+	}
+	assert.Equal(t, moved, countOrderRows(t, shard0, otherLegacyOrderID), "Удален заказ другого пользователя")
+
+	assert.Equal(t, int64(1), countStock(t, shard0, sku), "Удален сток с шарда бакета")
+	assert.Equal(t, int64(0), countStock(t, shard1, sku), "Копия стока не удалена")
+}
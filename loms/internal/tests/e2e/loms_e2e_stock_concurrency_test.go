@@ -0,0 +1,75 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	appErr "route256/loms/internal/errors"
+	"route256/loms/internal/infra/database"
+	"route256/loms/internal/model"
+	"route256/loms/internal/repository/outboxrepository"
+	"route256/loms/internal/repository/stockrepository"
+	"route256/loms/internal/service/stockservice"
+	transactionmanager "route256/loms/internal/service/transactionamanger"
+)
+
+func TestE2E_ConcurrentReserveDoesNotOversell(t *testing.T) {
+	setupTest(t)
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	require.NoError(t, err, "Не удалось создать пул соединений")
+	defer pool.Close()
+
+	router := database.NewDBRouter([]*database.MasterAndReplica{{pool, nil}}, database.NewModuloBucketMap(1))
+	tm := transactionmanager.NewTransactionManager(router)
+	stockService := stockservice.NewService(stockrepository.NewRepository(router, outboxrepository.NewRepository()))
+
+	// SKU 1: всего 150, зарезервировано 10, доступно 140 - хватит только на 28 заказов по 5 штук
+	const (
+		sku            = 1
+		orders         = 50
+		countPerOrder  = 5
+		expectedOrders = 28
+	)
+
+	var wg sync.WaitGroup
+	var reservedOrders atomic.Int32
+	for i := 0; i < orders; i++ {
+		wg.Add(1)
+		go func(orderID int64) {
+			defer wg.Done()
+			err := tm.RunInStockShardTx(ctx, []model.SKUType{sku}, func(tx pgx.Tx) error {
+				return stockService.Reserve(ctx, tx, orderID, []*model.Item{{SKU: sku, Count: countPerOrder}})
+			})
+			if err != nil {
+				assert.ErrorIs(t, err, appErr.ErrStockInsufficient, "Резерв завершился неожиданной ошибкой")
+				return
+			}
+			reservedOrders.Add(1)
+		}(int64(i + 1))
+	}
+	wg.Wait()
+
+	var totalCount, reservedCount int64
+	err = db.QueryRow(ctx, "SELECT total_count, reserved_count FROM stock WHERE sku = $1", sku).Scan(&totalCount, &reservedCount)
+	require.NoError(t, err, "Не удалось прочитать сток")
+
+	assert.LessOrEqual(t, reservedCount, totalCount, "Резерв превысил остаток")
+	assert.Equal(t, int32(expectedOrders), reservedOrders.Load(), "Число успешных резервов не соответствует остатку")
+	assert.Equal(t, int64(10+expectedOrders*countPerOrder), reservedCount, "Резерв не соответствует числу успешных заказов")
+
+	var stockEvents int64
+	err = db.QueryRow(ctx, "SELECT count(*) FROM outbox WHERE sku = $1", sku).Scan(&stockEvents)
+	require.NoError(t, err, "Не удалось прочитать outbox")
+	assert.Equal(t, int64(expectedOrders), stockEvents, "Каждый успешный резерв должен записать событие изменения стока")
+}
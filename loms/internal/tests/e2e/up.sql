@@ -2,12 +2,25 @@
 
 CREATE TYPE ORDER_STATUS AS ENUM ('NEW', 'AWAITING_PAYMENT', 'FAILED', 'PAYED', 'CANCELLED');
 
+CREATE SEQUENCE order_number_seq;
+
 -- Create tables
 CREATE TABLE orders
 (
-  id      BIGSERIAL PRIMARY KEY,
-  state   ORDER_STATUS NOT NULL,
-  user_id BIGINT       NOT NULL
+  id         BIGSERIAL PRIMARY KEY,
+  state      ORDER_STATUS NOT NULL,
+  user_id    BIGINT       NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE order_state_history
+(
+  id         BIGSERIAL PRIMARY KEY,
+  order_id   BIGINT       NOT NULL,
+  state      ORDER_STATUS NOT NULL,
+  changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  FOREIGN KEY (order_id) REFERENCES orders (id)
 );
 
 CREATE TABLE items
@@ -19,11 +32,61 @@ CREATE TABLE items
   FOREIGN KEY (order_id) REFERENCES orders (id)
 );
 
+CREATE TABLE idempotency_keys
+(
+  user_id         BIGINT NOT NULL,
+  idempotency_key TEXT   NOT NULL,
+  order_id        BIGINT NOT NULL,
+  created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  request_hash    TEXT   NOT NULL DEFAULT '',
+  PRIMARY KEY (user_id, idempotency_key),
+  FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+
+CREATE TYPE STOCK_OPERATION_TYPE AS ENUM ('RESERVE', 'RESERVE_REMOVE', 'RESERVE_CANCEL');
+
+CREATE TABLE order_saga
+(
+  order_id     BIGINT PRIMARY KEY,
+  user_id      BIGINT               NOT NULL,
+  operation    STOCK_OPERATION_TYPE NOT NULL,
+  target_state ORDER_STATUS         NOT NULL,
+  created_at   TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+
+CREATE TABLE stock_operations
+(
+  order_id   BIGINT               NOT NULL,
+  operation  STOCK_OPERATION_TYPE NOT NULL,
+  sku        BIGINT               NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  PRIMARY KEY (order_id, operation, sku)
+);
+
+CREATE TABLE outbox
+(
+  id               SERIAL PRIMARY KEY,
+  order_id         BIGINT,
+  sku              BIGINT,
+  payload          BYTEA                    NOT NULL,
+  created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  processed        BOOLEAN                  DEFAULT FALSE,
+  processed_at     TIMESTAMP WITH TIME ZONE,
+  locked_until     TIMESTAMP WITH TIME ZONE,
+  attempts         INT                      NOT NULL DEFAULT 0,
+  last_error       TEXT,
+  next_attempt_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  dead_lettered_at TIMESTAMP WITH TIME ZONE,
+  CONSTRAINT outbox_order_id_or_sku_check CHECK ((order_id IS NULL) <> (sku IS NULL))
+);
+
 CREATE TABLE stock
 (
   sku            BIGINT PRIMARY KEY,
   total_count    BIGINT NOT NULL,
-  reserved_count BIGINT NOT NULL
+  reserved_count BIGINT NOT NULL,
+  CONSTRAINT stock_reserved_not_exceed_total CHECK (reserved_count >= 0 AND reserved_count <= total_count)
 );
 
 INSERT INTO stock (sku, total_count, reserved_count)
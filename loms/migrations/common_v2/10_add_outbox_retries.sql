@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Неудачные отправки события: число попыток, последняя ошибка и время, раньше которого событие не захватывается снова.
+-- Событие, исчерпавшее попытки или с неисправимой ошибкой, получает dead_lettered_at и больше не отправляется.
+-- processed_at нужен для удаления отправленных событий старше срока хранения
+ALTER TABLE outbox
+  ADD COLUMN attempts         INT                      NOT NULL DEFAULT 0,
+  ADD COLUMN last_error       TEXT,
+  ADD COLUMN next_attempt_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  ADD COLUMN dead_lettered_at TIMESTAMP WITH TIME ZONE,
+  ADD COLUMN processed_at     TIMESTAMP WITH TIME ZONE;
+
+UPDATE outbox SET processed_at = created_at WHERE processed;
+
+-- Недоставленные события не мешают отправке следующих событий заказа и не попадают в захват
+DROP INDEX IF EXISTS outbox_pending_idx;
+CREATE INDEX outbox_pending_idx ON outbox (order_id, id) WHERE processed = FALSE AND dead_lettered_at IS NULL;
+
+CREATE INDEX outbox_processed_at_idx ON outbox (processed_at) WHERE processed;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS outbox_processed_at_idx;
+DROP INDEX IF EXISTS outbox_pending_idx;
+CREATE INDEX outbox_pending_idx ON outbox (order_id, id) WHERE processed = FALSE;
+ALTER TABLE outbox
+  DROP COLUMN IF EXISTS attempts,
+  DROP COLUMN IF EXISTS last_error,
+  DROP COLUMN IF EXISTS next_attempt_at,
+  DROP COLUMN IF EXISTS dead_lettered_at,
+  DROP COLUMN IF EXISTS processed_at;
+-- +goose StatementEnd
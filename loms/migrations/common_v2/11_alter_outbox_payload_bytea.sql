@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Событие хранится конвертом EventEnvelope в формате protobuf.
+-- Уже записанные события остаются JSON версии 0, потребители различают их по первому байту
+ALTER TABLE outbox ALTER COLUMN payload TYPE BYTEA USING convert_to(payload, 'UTF8');
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- События в формате protobuf не переводятся в текст, поэтому откат удаляет их
+DELETE FROM outbox WHERE get_byte(payload, 0) <> ascii('{');
+ALTER TABLE outbox ALTER COLUMN payload TYPE TEXT USING convert_from(payload, 'UTF8');
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- События изменения стока лежат в outbox шарда стока и привязаны к SKU, а не к заказу.
+-- У события заказа задан order_id, у события стока - sku
+ALTER TABLE outbox
+  ALTER COLUMN order_id DROP NOT NULL,
+  ADD COLUMN sku BIGINT,
+  ADD CONSTRAINT outbox_order_id_or_sku_check CHECK ((order_id IS NULL) <> (sku IS NULL));
+
+-- События одного SKU, как и события одного заказа, отправляются по порядку
+CREATE INDEX outbox_pending_sku_idx ON outbox (sku, id) WHERE processed = FALSE AND dead_lettered_at IS NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM outbox WHERE sku IS NOT NULL;
+DROP INDEX IF EXISTS outbox_pending_sku_idx;
+ALTER TABLE outbox
+  DROP CONSTRAINT IF EXISTS outbox_order_id_or_sku_check,
+  DROP COLUMN IF EXISTS sku,
+  ALTER COLUMN order_id SET NOT NULL;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Хеш тела запроса, с которым создан заказ. Пустой у ключей, сохраненных до появления колонки,
+-- такие ключи повторяют заказ без проверки тела
+ALTER TABLE idempotency_keys
+  ADD COLUMN request_hash TEXT NOT NULL DEFAULT '';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE idempotency_keys
+  DROP COLUMN IF EXISTS request_hash;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE idempotency_keys
+(
+  user_id         BIGINT NOT NULL,
+  idempotency_key TEXT   NOT NULL,
+  order_id        BIGINT NOT NULL,
+  created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  PRIMARY KEY (user_id, idempotency_key),
+  FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS idempotency_keys CASCADE;
+-- +goose StatementEnd
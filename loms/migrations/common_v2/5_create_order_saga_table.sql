@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TYPE STOCK_OPERATION_TYPE AS ENUM ('RESERVE', 'RESERVE_REMOVE', 'RESERVE_CANCEL');
+
+-- Журнал незавершенных межшардовых операций над заказами. Строка живет, пока изменение стока
+-- и смена статуса заказа не зафиксированы оба; по оставшимся строкам операция восстанавливается
+CREATE TABLE order_saga
+(
+  order_id     BIGINT PRIMARY KEY,
+  user_id      BIGINT               NOT NULL,
+  operation    STOCK_OPERATION_TYPE NOT NULL,
+  target_state ORDER_STATUS         NOT NULL,
+  created_at   TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS order_saga CASCADE;
+DROP TYPE STOCK_OPERATION_TYPE;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Время последней смены статуса заказа, по нему истекает резерв неоплаченных заказов
+ALTER TABLE orders
+  ADD COLUMN updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+CREATE INDEX orders_state_updated_at_idx ON orders (state, updated_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS orders_state_updated_at_idx;
+ALTER TABLE orders DROP COLUMN IF EXISTS updated_at;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE orders
+  ADD COLUMN created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+-- История смены статусов заказа, пишется в одной транзакции со сменой статуса
+CREATE TABLE order_state_history
+(
+  id         BIGSERIAL PRIMARY KEY,
+  order_id   BIGINT       NOT NULL,
+  state      ORDER_STATUS NOT NULL,
+  changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+
+CREATE INDEX order_state_history_order_id_idx ON order_state_history (order_id, changed_at);
+
+-- для существующих заказов известен только текущий статус
+INSERT INTO order_state_history (order_id, state, changed_at)
+SELECT id, state, updated_at
+FROM orders;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS order_state_history CASCADE;
+ALTER TABLE orders DROP COLUMN IF EXISTS created_at;
+-- +goose StatementEnd
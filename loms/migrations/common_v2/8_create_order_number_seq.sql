@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Порядковый номер заказа на шарде. ID заказа = номер * 1000 + бакет пользователя,
+-- поэтому по ID заказа всегда находится тот же шард, что и по пользователю
+CREATE SEQUENCE order_number_seq;
+
+-- Номера продолжают уже выданные shard-специфичными последовательностями ID
+SELECT setval('order_number_seq', COALESCE(max(id) / 1000, 0) + 1, false) FROM orders;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP SEQUENCE IF EXISTS order_number_seq;
+-- +goose StatementEnd
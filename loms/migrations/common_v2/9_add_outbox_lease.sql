@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Аренда события обработчиком outbox: пока locked_until в будущем, событие отправляет захватившая его реплика LOMS.
+-- Если реплика упала, не успев пометить событие, после истечения аренды его захватит другая
+ALTER TABLE outbox ADD COLUMN locked_until TIMESTAMP WITH TIME ZONE;
+
+-- Захват выбирает неотправленные события по порядку и проверяет, нет ли перед ними неотправленных событий того же заказа
+CREATE INDEX outbox_pending_idx ON outbox (order_id, id) WHERE processed = FALSE;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS outbox_pending_idx;
+ALTER TABLE outbox DROP COLUMN IF EXISTS locked_until;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Примененные к стоку операции по заказам, делает повтор операции при восстановлении саги безопасным
+CREATE TABLE stock_operations
+(
+  order_id   BIGINT               NOT NULL,
+  operation  STOCK_OPERATION_TYPE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  PRIMARY KEY (order_id, operation)
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS stock_operations CASCADE;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Последний рубеж против перепродажи: резерв никогда не может превысить остаток
+ALTER TABLE stock
+  ADD CONSTRAINT stock_reserved_not_exceed_total CHECK (reserved_count >= 0 AND reserved_count <= total_count);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE stock DROP CONSTRAINT IF EXISTS stock_reserved_not_exceed_total;
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Стоки шардируются по SKU, поэтому отметка о примененной операции хранится по каждому SKU
+-- и переезжает на другой шард вместе со стоком. Операции, записанные до шардирования стоков,
+-- относятся к sku 0 и покрывают заказ только на этом шарде
+ALTER TABLE stock_operations
+  ADD COLUMN sku BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE stock_operations
+  DROP CONSTRAINT stock_operations_pkey,
+  ADD PRIMARY KEY (order_id, operation, sku);
+
+ALTER TABLE stock_operations
+  ALTER COLUMN sku DROP DEFAULT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM stock_operations a
+  USING stock_operations b
+WHERE a.order_id = b.order_id
+  AND a.operation = b.operation
+  AND a.sku > b.sku;
+
+ALTER TABLE stock_operations
+  DROP CONSTRAINT stock_operations_pkey,
+  ADD PRIMARY KEY (order_id, operation);
+
+ALTER TABLE stock_operations DROP COLUMN IF EXISTS sku;
+-- +goose StatementEnd
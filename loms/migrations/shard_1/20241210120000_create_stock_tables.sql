@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Стоки шардируются по SKU. Строки SKU, чьи бакеты закреплены за этим шардом,
+-- переносятся с шарда 0 командой `loms reshard -stock-from 0`
+CREATE TABLE stock
+(
+  sku            BIGINT PRIMARY KEY,
+  total_count    BIGINT NOT NULL,
+  reserved_count BIGINT NOT NULL,
+  CONSTRAINT stock_reserved_not_exceed_total CHECK (reserved_count >= 0 AND reserved_count <= total_count)
+);
+
+CREATE TABLE stock_operations
+(
+  order_id   BIGINT               NOT NULL,
+  operation  STOCK_OPERATION_TYPE NOT NULL,
+  sku        BIGINT               NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  PRIMARY KEY (order_id, operation, sku)
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS stock_operations, stock CASCADE;
+-- +goose StatementEnd
@@ -3,11 +3,13 @@ package main
 import (
 	"context"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"route256/notifier/internal/app/initialization"
 	"sync"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -25,6 +27,13 @@ func main() {
 		log.Fatalf("[main] Failed to load configuration: %v", err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "replay-dlq" {
+		if err = initialization.RunReplayDLQ(config, os.Args[2:]); err != nil {
+			log.Fatalf("[main] Replaying DLQ failed: %v", err)
+		}
+		return
+	}
+
 	application, err := initialization.New(config)
 	if err != nil {
 		log.Fatalf("[main] Failed to initialize application: %v", err)
@@ -45,10 +54,34 @@ func main() {
 		application.ConsumerGroup.Run(ctx, wg)
 	}()
 
+	// Отправка уведомлений из очереди
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		application.DeliveryProcessor.Start(ctx)
+	}()
+
+	// HTTP сервер с /healthz и /readyz
+	go func() {
+		log.Printf("[main] HTTP server listening at %v", application.HttpServer.Addr)
+		if err := application.HttpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("[main] HTTP server error: %v", err)
+		}
+	}()
+
 	// Ожидаем завершения работы по сигналу
 	<-ctx.Done()
 	log.Println("[main] Shutting down Consumer...")
 
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := application.HttpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[main] HTTP server forced to shutdown: %v", err)
+	}
+	if err := application.KafkaProbe.Close(); err != nil {
+		log.Printf("[main] Error closing kafka health probe: %v", err)
+	}
+
 	// Закрываем consumer group
 	if err := application.ConsumerGroup.Close(); err != nil {
 		log.Printf("[main] Error closing consumer group: %v", err)
@@ -56,6 +89,13 @@ func main() {
 
 	// Ждем завершения всех горутин
 	wg.Wait()
+	if err := application.DLQProducer.Close(); err != nil {
+		log.Printf("[main] Error closing dlq producer: %v", err)
+	}
+	application.DBPool.Close()
+	if err := application.FileNotifier.Close(); err != nil {
+		log.Printf("[main] Error closing notification file: %v", err)
+	}
 
 	log.Println("[main] Consumer gracefully stopped")
 }
@@ -1,25 +1,171 @@
 package initialization
 
 import (
+	"context"
+	"fmt"
 	"github.com/IBM/sarama"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"log"
+	"net/http"
+	"route256/libs/health"
+	"route256/libs/kafka"
 	"route256/notifier/internal/infra/consumer_group"
+	"route256/notifier/internal/infra/dlq"
+	"route256/notifier/internal/infra/notifiers"
+	"route256/notifier/internal/pkg/repository/deliveryrepository"
+	"route256/notifier/internal/pkg/repository/inboxrepository"
+	"route256/notifier/internal/pkg/routing"
+	"route256/notifier/internal/pkg/service/processors/deliveryprocessor"
 	"route256/notifier/internal/pkg/service/processors/eventsprocessor"
+	"time"
 )
 
 type App struct {
 	ConsumerGroup *consumer_group.ConsumerGroup
+	HttpServer    *http.Server
+	KafkaProbe    *kafka.BrokersProbe
+	DBPool        *pgxpool.Pool
+	// DeliveryProcessor отправляет уведомления, поставленные в очередь при чтении событий
+	DeliveryProcessor *deliveryprocessor.DeliveryProcessor
+	FileNotifier      *notifiers.FileNotifier
+	DLQProducer       sarama.SyncProducer
 }
 
+// deliveryLeaseMargin запас аренды уведомления сверх времени его отправки
+const deliveryLeaseMargin = 20 * time.Second
+
 func New(config *Config) (*App, error) {
 	log.Println("[cart] Starting application initialization")
-	eventService := eventsprocessor.New()
+	pool, err := initDbPool(&config.DBConfig)
+	if err != nil {
+		return nil, err
+	}
+	inboxRepository := inboxrepository.NewRepository(pool)
+	deliveryRepository := deliveryrepository.NewRepository(pool)
+
+	rules, err := routing.LoadRules(config.NotifyConfig.RulesPath)
+	if err != nil {
+		return nil, err
+	}
+	fileNotifier, channels, err := initNotifiers(&config.NotifyConfig, rules)
+	if err != nil {
+		return nil, err
+	}
+	channelNames := make([]string, 0, len(channels))
+	for name := range channels {
+		channelNames = append(channelNames, name)
+	}
+	if err = rules.Validate(channelNames); err != nil {
+		return nil, fmt.Errorf("invalid routing rules %s: %w", config.NotifyConfig.RulesPath, err)
+	}
+	deliveryProcessor := deliveryprocessor.NewDeliveryProcessor(deliveryRepository, channels, deliveryprocessor.Options{
+		Interval:    config.NotifyConfig.Interval,
+		BatchSize:   config.NotifyConfig.BatchSize,
+		Lease:       config.NotifyConfig.SendTimeout + deliveryLeaseMargin,
+		SendTimeout: config.NotifyConfig.SendTimeout,
+		Retry: deliveryrepository.RetryPolicy{
+			MaxAttempts: config.NotifyConfig.MaxAttempts,
+			BaseBackoff: config.NotifyConfig.RetryBackoff,
+			MaxBackoff:  config.NotifyConfig.MaxRetryBackoff,
+		},
+	})
+
+	dlqProducer, err := initKafkaSyncProducer(config.KafkaConfig.Brokers)
+	if err != nil {
+		return nil, err
+	}
+	deadLetters := dlq.NewPublisher(dlqProducer, config.KafkaConfig.DLQTopic)
+
+	eventService := eventsprocessor.New(rules, deliveryRepository)
+	handler := consumer_group.NewConsumerGroupHandler(eventService, inboxRepository, deadLetters,
+		consumer_group.WithWorkers(config.KafkaConfig.Workers),
+		consumer_group.WithMaxInFlight(config.KafkaConfig.MaxInFlight))
 	group, err := consumer_group.NewConsumerGroup(config.KafkaConfig.Brokers, config.KafkaConfig.GroupId, []string{config.KafkaConfig.Topic},
-		consumer_group.NewConsumerGroupHandler(eventService), eventService, consumer_group.WithOffsetsInitial(sarama.OffsetNewest))
+		handler, eventService, consumer_group.WithOffsetsInitial(sarama.OffsetNewest))
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	kafkaProbe := kafka.NewBrokersProbe(config.KafkaConfig.Brokers)
+	checker := NewHealthChecker(kafkaProbe.Check, handler.CheckSession, inboxRepository.Ping)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", health.LiveHandler)
+	mux.HandleFunc("GET /readyz", checker.ReadyHandler)
+	mux.Handle("GET /metrics", promhttp.Handler())
+
 	return &App{
 		ConsumerGroup: group,
+		HttpServer: &http.Server{
+			Addr:    fmt.Sprintf(":%d", config.HttpPort),
+			Handler: mux,
+		},
+		KafkaProbe:        kafkaProbe,
+		DBPool:            pool,
+		DeliveryProcessor: deliveryProcessor,
+		FileNotifier:      fileNotifier,
+		DLQProducer:       dlqProducer,
 	}, nil
 }
+
+// initKafkaSyncProducer создает продюсера, который ждет подтверждения записи от всех реплик
+func initKafkaSyncProducer(brokers []string) (sarama.SyncProducer, error) {
+	config := sarama.NewConfig()
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 5
+	config.Producer.Retry.Backoff = 500 * time.Millisecond
+	config.Producer.Return.Successes = true
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create kafka producer: %w", err)
+	}
+	return producer, nil
+}
+
+// initNotifiers создает каналы уведомлений: file есть всегда, email и webhook - если заданы их адреса
+func initNotifiers(config *NotifyConfig, rules *routing.Rules) (*notifiers.FileNotifier, map[string]deliveryprocessor.Notifier, error) {
+	fileNotifier, err := notifiers.NewFileNotifier(config.FilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	channels := map[string]deliveryprocessor.Notifier{
+		notifiers.FileChannel: fileNotifier,
+	}
+	if config.SMTPAddr != "" {
+		smtpNotifier, err := notifiers.NewSMTPNotifier(config.SMTPAddr, config.SMTPFrom,
+			config.SMTPUsername, config.SMTPPassword, rules)
+		if err != nil {
+			return nil, nil, err
+		}
+		channels[notifiers.EmailChannel] = smtpNotifier
+	}
+	if config.WebhookURL != "" {
+		channels[notifiers.WebhookChannel] = notifiers.NewWebhookNotifier(config.WebhookURL, config.WebhookSecret, config.SendTimeout)
+	}
+	return fileNotifier, channels, nil
+}
+
+func initDbPool(dbConfig *DBConfig) (*pgxpool.Pool, error) {
+	dsn := fmt.Sprintf("postgres://%s:%s@%s/%s",
+		dbConfig.DBUser, dbConfig.DBPassword, dbConfig.DBHostPort, dbConfig.DBName)
+	poolConfig, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse configuration for db (host:port %s): %w", dbConfig.DBHostPort, err)
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create pool with host:port %s: %w", dbConfig.DBHostPort, err)
+	}
+	return pool, nil
+}
+
+// NewHealthChecker проверяет брокеры Kafka, сессию группы консьюмеров и базу уведомлений.
+// Без любой из них сервис не читает события, поэтому все проверки обязательные
+func NewHealthChecker(kafkaCheck, consumerGroupCheck, postgresCheck health.Check) *health.Checker {
+	checker := health.NewChecker(health.DefaultTimeout)
+	checker.Add("kafka", kafkaCheck)
+	checker.Add("consumer_group", consumerGroupCheck)
+	checker.Add("postgres", postgresCheck)
+	return checker
+}
@@ -3,19 +3,71 @@ package initialization
 import (
 	"fmt"
 	"github.com/joho/godotenv"
+	"log"
+	"math"
 	"os"
+	"strconv"
+	"time"
 )
 
 type KafkaConfig struct {
 	Brokers []string
 	Topic   string
 	GroupId string
+	// DLQTopic топик для сообщений, которые не разбираются, не проходят проверку или не обработаются и при повторе
+	DLQTopic string
+	// Workers число обработчиков партиции, MaxInFlight предел необработанных сообщений партиции
+	Workers     int
+	MaxInFlight int
+}
+
+// DBConfig база с inbox обработанных событий
+type DBConfig struct {
+	DBUser     string
+	DBPassword string
+	DBHostPort string
+	DBName     string
+}
+
+// NotifyConfig каналы уведомлений. Почта и вебхук включаются, если заданы их адреса
+type NotifyConfig struct {
+	RulesPath string
+	// FilePath файл для канала file, пустой - stdout
+	FilePath        string
+	SMTPAddr        string
+	SMTPFrom        string
+	SMTPUsername    string
+	SMTPPassword    string
+	WebhookURL      string
+	WebhookSecret   string
+	SendTimeout     time.Duration
+	Interval        time.Duration
+	BatchSize       int
+	MaxAttempts     int
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
 }
 
 type Config struct {
-	KafkaConfig KafkaConfig
+	KafkaConfig  KafkaConfig
+	DBConfig     DBConfig
+	NotifyConfig NotifyConfig
+	HttpPort     int
 }
 
+const (
+	defaultHttpPort             = 8083
+	defaultWorkers              = 4
+	defaultMaxInFlight          = 64
+	defaultRulesPath            = "./rules.json"
+	defaultSendTimeout          = 10 * time.Second
+	defaultIntervalDelivery     = time.Second
+	defaultDeliveryBatchSize    = 100
+	defaultDeliveryMaxAttempts  = 10
+	defaultDeliveryRetryBackoff = time.Second
+	defaultDeliveryMaxBackoff   = 5 * time.Minute
+)
+
 func LoadDefaultConfig() (*Config, error) {
 	return LoadConfig("./.env")
 }
@@ -24,15 +76,93 @@ func LoadConfig(pathToEnv string) (*Config, error) {
 		return nil, fmt.Errorf("failed to load environment variables: %w", err)
 	}
 
+	httpPort := defaultHttpPort
+	if httpPortStr := os.Getenv("HTTP_PORT"); httpPortStr != "" {
+		var err error
+		if httpPort, err = strconv.Atoi(httpPortStr); err != nil {
+			return nil, fmt.Errorf("failed to parse HTTP_PORT: %w", err)
+		}
+	}
+
+	dbConfig := DBConfig{
+		DBUser:     os.Getenv("DATABASE_USER"),
+		DBPassword: os.Getenv("DATABASE_PASSWORD"),
+		DBHostPort: os.Getenv("DATABASE_HOST_PORT"),
+		DBName:     os.Getenv("DATABASE_NAME"),
+	}
+	if dbConfig.DBHostPort == "" || dbConfig.DBName == "" {
+		return nil, fmt.Errorf("DATABASE_HOST_PORT and DATABASE_NAME must be set")
+	}
+
+	notifyConfig := NotifyConfig{
+		RulesPath:       os.Getenv("NOTIFY_RULES_PATH"),
+		FilePath:        os.Getenv("NOTIFY_FILE_PATH"),
+		SMTPAddr:        os.Getenv("SMTP_ADDR"),
+		SMTPFrom:        os.Getenv("SMTP_FROM"),
+		SMTPUsername:    os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:    os.Getenv("SMTP_PASSWORD"),
+		WebhookURL:      os.Getenv("WEBHOOK_URL"),
+		WebhookSecret:   os.Getenv("WEBHOOK_SECRET"),
+		SendTimeout:     loadDurationEnv("NOTIFY_SEND_TIMEOUT", defaultSendTimeout),
+		Interval:        loadDurationEnv("INTERVAL_DELIVERY", defaultIntervalDelivery),
+		BatchSize:       loadIntEnv("DELIVERY_BATCH_SIZE", defaultDeliveryBatchSize),
+		MaxAttempts:     loadIntEnv("DELIVERY_MAX_ATTEMPTS", defaultDeliveryMaxAttempts),
+		RetryBackoff:    loadDurationEnv("DELIVERY_RETRY_BACKOFF", defaultDeliveryRetryBackoff),
+		MaxRetryBackoff: loadDurationEnv("DELIVERY_MAX_RETRY_BACKOFF", defaultDeliveryMaxBackoff),
+	}
+	if notifyConfig.RulesPath == "" {
+		notifyConfig.RulesPath = defaultRulesPath
+	}
+	if notifyConfig.SMTPAddr != "" && notifyConfig.SMTPFrom == "" {
+		return nil, fmt.Errorf("SMTP_FROM must be set with SMTP_ADDR")
+	}
+	if notifyConfig.WebhookURL != "" && notifyConfig.WebhookSecret == "" {
+		return nil, fmt.Errorf("WEBHOOK_SECRET must be set with WEBHOOK_URL")
+	}
+
+	dlqTopic := os.Getenv("KAFKA_DLQ_TOPIC")
+	if dlqTopic == "" {
+		return nil, fmt.Errorf("KAFKA_DLQ_TOPIC must be set")
+	}
+
 	return &Config{
 		KafkaConfig: KafkaConfig{
-			Brokers: []string{os.Getenv("KAFKA_BROKER")},
-			Topic:   os.Getenv("KAFKA_TOPIC"),
-			GroupId: os.Getenv("KAFKA_GROUP_ID"),
+			Brokers:     []string{os.Getenv("KAFKA_BROKER")},
+			Topic:       os.Getenv("KAFKA_TOPIC"),
+			GroupId:     os.Getenv("KAFKA_GROUP_ID"),
+			DLQTopic:    dlqTopic,
+			Workers:     loadIntEnv("KAFKA_WORKERS", defaultWorkers),
+			MaxInFlight: loadIntEnv("KAFKA_MAX_IN_FLIGHT", defaultMaxInFlight),
 		},
+		DBConfig:     dbConfig,
+		NotifyConfig: notifyConfig,
+		HttpPort:     httpPort,
 	}, nil
 }
 
+// loadDurationEnv читает длительность в наносекундах из переменной окружения envName,
+// при отсутствии или некорректном значении возвращает defaultValue
+func loadDurationEnv(envName string, defaultValue time.Duration) time.Duration {
+	valueStr := os.Getenv(envName)
+	value, err := strconv.ParseUint(valueStr, 10, 64)
+	if err != nil || value > math.MaxInt64 || value == 0 {
+		log.Printf("[config] failed to parse %s: %v, will be using default: %v", envName, err, defaultValue)
+		return defaultValue
+	}
+	return time.Duration(value)
+}
+
+// loadIntEnv читает положительное целое из переменной окружения envName,
+// при отсутствии или некорректном значении возвращает defaultValue
+func loadIntEnv(envName string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(envName))
+	if err != nil || value <= 0 {
+		log.Printf("[config] failed to parse %s: %v, will be using default: %d", envName, err, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
 func loadEnv(pathToEnv string) error {
 	if err := godotenv.Load(pathToEnv); err != nil {
 		return fmt.Errorf("failed to load %s: %w", pathToEnv, err)
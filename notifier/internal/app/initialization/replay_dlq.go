@@ -0,0 +1,57 @@
+package initialization
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os/signal"
+	"route256/notifier/internal/infra/dlq"
+	"syscall"
+
+	"github.com/IBM/sarama"
+)
+
+// replayGroupSuffix группа, в которую коммитится прочитанный offset DLQ, отдельная от группы обработки событий
+const replayGroupSuffix = "-dlq-replay"
+
+// RunReplayDLQ выполняет команду replay-dlq: возвращает сообщения из DLQ в исходные топики,
+// например после исправления ошибки, из-за которой они не обработались
+func RunReplayDLQ(config *Config, args []string) error {
+	flags := flag.NewFlagSet("replay-dlq", flag.ContinueOnError)
+	limit := flags.Int("limit", 0, "max messages to replay, 0 replays all")
+	dryRun := flags.Bool("dry-run", false, "print messages without replaying them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *limit < 0 {
+		return fmt.Errorf("-limit must not be negative, got %d", *limit)
+	}
+
+	clientConfig := sarama.NewConfig()
+	clientConfig.Consumer.Return.Errors = true
+	clientConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	client, err := sarama.NewClient(config.KafkaConfig.Brokers, clientConfig)
+	if err != nil {
+		return fmt.Errorf("unable to create kafka client: %w", err)
+	}
+	defer client.Close()
+	producer, err := initKafkaSyncProducer(config.KafkaConfig.Brokers)
+	if err != nil {
+		return err
+	}
+	defer producer.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	replayer := dlq.NewReplayer(client, producer, config.KafkaConfig.DLQTopic, config.KafkaConfig.GroupId+replayGroupSuffix,
+		dlq.ReplayOptions{
+			DefaultTopic: config.KafkaConfig.Topic,
+			Limit:        *limit,
+			DryRun:       *dryRun,
+		})
+	replayed, err := replayer.Replay(ctx)
+	log.Printf("[replay-dlq] %d messages replayed from %s", replayed, config.KafkaConfig.DLQTopic)
+	return err
+}
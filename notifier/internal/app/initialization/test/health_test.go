@@ -0,0 +1,68 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"route256/libs/health"
+	"route256/notifier/internal/app/initialization"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ok(context.Context) error { return nil }
+
+func TestNewHealthChecker(t *testing.T) {
+	down := func(context.Context) error { return errors.New("no active session") }
+
+	tests := []struct {
+		name           string
+		kafka          health.Check
+		consumerGroup  health.Check
+		postgres       health.Check
+		wantStatusCode int
+		wantChecks     map[string]string
+	}{
+		{
+			name:           "all dependencies are up",
+			kafka:          ok,
+			consumerGroup:  ok,
+			postgres:       ok,
+			wantStatusCode: http.StatusOK,
+			wantChecks:     map[string]string{"kafka": "ok", "consumer_group": "ok", "postgres": "ok"},
+		},
+		{
+			name:           "consumer group has no session",
+			kafka:          ok,
+			consumerGroup:  down,
+			postgres:       ok,
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantChecks:     map[string]string{"kafka": "ok", "consumer_group": "no active session", "postgres": "ok"},
+		},
+		{
+			name:           "postgres is down",
+			kafka:          ok,
+			consumerGroup:  ok,
+			postgres:       func(context.Context) error { return errors.New("connection refused") },
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantChecks:     map[string]string{"kafka": "ok", "consumer_group": "ok", "postgres": "connection refused"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := initialization.NewHealthChecker(tt.kafka, tt.consumerGroup, tt.postgres)
+
+			recorder := httptest.NewRecorder()
+			checker.ReadyHandler(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			assert.Equal(t, tt.wantStatusCode, recorder.Code)
+			var body health.Result
+			require.NoError(t, json.NewDecoder(recorder.Body).Decode(&body))
+			assert.Equal(t, tt.wantChecks, body.Checks)
+		})
+	}
+}
@@ -71,9 +71,8 @@ func NewConsumerGroup(brokers []string, groupID string, topics []string, consume
 	//
 	config.Consumer.Return.Errors = true
 
-	//config.Consumer.Offsets.AutoCommit.Enable = false
-	config.Consumer.Offsets.AutoCommit.Enable = true
-	config.Consumer.Offsets.AutoCommit.Interval = 1 * time.Second
+	// Offset коммитится вручную после обработки события, см. ConsumerGroupHandler.ConsumeClaim
+	config.Consumer.Offsets.AutoCommit.Enable = false
 
 	// Применяем свои конфигурации
 	for _, opt := range opts {
@@ -0,0 +1,114 @@
+package consumer_group
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	events "route256/libs/generated/api/events/v1"
+	"route256/notifier/internal/pkg/model"
+	"strings"
+)
+
+// SupportedEventVersion последняя версия схемы событий LOMS, которую понимает notifier
+const SupportedEventVersion = 1
+
+// orderEventTypePrefix общий префикс типов событий заказа
+const orderEventTypePrefix = "order."
+
+// legacyEventTypes тип события версии 0 по статусу заказа, как его назначает LOMS в конверте
+var legacyEventTypes = map[model.StateType]string{
+	model.NEW:              "order.created",
+	model.AWAITING_PAYMENT: "order.awaiting_payment",
+	model.FAILED:           "order.failed",
+	model.PAYED:            "order.paid",
+	model.CANCELLED:        "order.cancelled",
+}
+
+var ErrUnsupportedEventVersion = errors.New("unsupported event version")
+
+// ErrInvalidEvent событие не разбирается или обработать его нельзя, например в нем нет заказа
+var ErrInvalidEvent = errors.New("invalid event")
+
+// legacyOrderEvent событие версии 0: заказ LOMS, сериализованный в JSON без конверта
+type legacyOrderEvent struct {
+	ID     int64
+	State  model.StateType
+	Items  []*model.Item
+	UserId int64
+}
+
+// DecodeOrderEvent разбирает и проверяет конверт события заказа. События версии 0 в JSON,
+// которые LOMS писал до введения конверта, отличаются по первому байту и разбираются отдельно
+func DecodeOrderEvent(value []byte) (model.OrderEvent, error) {
+	var event model.OrderEvent
+	var err error
+	if trimmed := bytes.TrimLeft(value, " \t\r\n"); len(trimmed) > 0 && trimmed[0] == '{' {
+		event, err = decodeLegacyOrderEvent(trimmed)
+	} else {
+		event, err = decodeEnvelope(value)
+	}
+	if err != nil {
+		return model.OrderEvent{}, err
+	}
+	if err = validateOrderEvent(&event); err != nil {
+		return model.OrderEvent{}, err
+	}
+	return event, nil
+}
+
+func decodeEnvelope(value []byte) (model.OrderEvent, error) {
+	var envelope events.EventEnvelope
+	if err := proto.Unmarshal(value, &envelope); err != nil {
+		return model.OrderEvent{}, fmt.Errorf("unable to unmarshal event envelope: %w: %w", ErrInvalidEvent, err)
+	}
+	if envelope.GetVersion() == 0 || envelope.GetVersion() > SupportedEventVersion {
+		return model.OrderEvent{}, fmt.Errorf("event %s of type %s: %w %d, supported up to %d",
+			envelope.GetEventId(), envelope.GetType(), ErrUnsupportedEventVersion, envelope.GetVersion(), SupportedEventVersion)
+	}
+	order := envelope.GetOrder()
+	if !strings.HasPrefix(envelope.GetType(), orderEventTypePrefix) || order == nil {
+		return model.OrderEvent{}, fmt.Errorf("event %s of type %s: %w: no order", envelope.GetEventId(), envelope.GetType(), ErrInvalidEvent)
+	}
+
+	event := model.OrderEvent{
+		EventID:    envelope.GetEventId(),
+		Type:       envelope.GetType(),
+		Version:    envelope.GetVersion(),
+		OccurredAt: envelope.GetOccurredAt().AsTime(),
+		ID:         order.GetOrderId(),
+		State:      model.StateType(order.GetState()),
+		Items:      make([]*model.Item, 0, len(order.GetItems())),
+		UserId:     order.GetUserId(),
+	}
+	for _, item := range order.GetItems() {
+		event.Items = append(event.Items, &model.Item{SKU: model.SKUType(item.GetSku()), Count: item.GetCount()})
+	}
+	return event, nil
+}
+
+func decodeLegacyOrderEvent(value []byte) (model.OrderEvent, error) {
+	var legacy legacyOrderEvent
+	if err := json.Unmarshal(value, &legacy); err != nil {
+		return model.OrderEvent{}, fmt.Errorf("unable to unmarshal legacy order event: %w: %w", ErrInvalidEvent, err)
+	}
+	return model.OrderEvent{
+		Type:   legacyEventTypes[legacy.State],
+		ID:     legacy.ID,
+		State:  legacy.State,
+		Items:  legacy.Items,
+		UserId: legacy.UserId,
+	}, nil
+}
+
+// validateOrderEvent проверяет, что у события есть заказ и известный статус, иначе уведомление не составить
+func validateOrderEvent(event *model.OrderEvent) error {
+	switch {
+	case event.ID <= 0:
+		return fmt.Errorf("event %s: %w: order id %d", event.DedupKey(), ErrInvalidEvent, event.ID)
+	case !event.State.IsKnown():
+		return fmt.Errorf("event %s: %w: unknown order state %q", event.DedupKey(), ErrInvalidEvent, event.State)
+	}
+	return nil
+}
@@ -1,71 +1,244 @@
 package consumer_group
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"hash/fnv"
 	"log"
+	"route256/notifier/internal/infra/dlq"
+	"route256/notifier/internal/infra/notifiers"
+	"route256/notifier/internal/metrics"
 	"route256/notifier/internal/pkg/model"
+	"route256/notifier/internal/pkg/repository/inboxrepository"
 	"route256/notifier/internal/pkg/service/processors/eventsprocessor"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/IBM/sarama"
 )
 
 var _ sarama.ConsumerGroupHandler = (*ConsumerGroupHandler)(nil)
 
+var _ Inbox = (*inboxrepository.Repository)(nil)
+
+// Inbox выполняет handle для события, если событие с таким ключом еще не обработано, и сообщает о дубликате
+type Inbox interface {
+	Handle(ctx context.Context, event *model.OrderEvent, handle func(ctx context.Context, tx pgx.Tx) error) (bool, error)
+}
+
+var _ EventsProcessor = (*eventsprocessor.EventService)(nil)
+
+// EventsProcessor составляет уведомления по событию в транзакции inbox и учитывает ошибки обработки
+type EventsProcessor interface {
+	Process(ctx context.Context, tx pgx.Tx, event *model.OrderEvent) error
+	ProcessError(err error)
+}
+
+var _ DeadLetters = (*dlq.Publisher)(nil)
+
+// DeadLetters топик, в который переносятся сообщения, которые не обработаются и при повторе
+type DeadLetters interface {
+	Publish(message *sarama.ConsumerMessage, cause error, attempts int) error
+	Topic() string
+}
+
 type ConsumerGroupHandler struct {
 	ready           chan bool
-	eventsProcessor *eventsprocessor.EventService
+	eventsProcessor EventsProcessor
+	inbox           Inbox
+	deadLetters     DeadLetters
+	opts            HandlerOptions
+	// active между Setup и Cleanup: группа получила партиции и читает сообщения
+	active atomic.Bool
 }
 
-func NewConsumerGroupHandler(service *eventsprocessor.EventService) *ConsumerGroupHandler {
-	return &ConsumerGroupHandler{
+func NewConsumerGroupHandler(service EventsProcessor, inbox Inbox, deadLetters DeadLetters, opts ...HandlerOption) *ConsumerGroupHandler {
+	h := &ConsumerGroupHandler{
 		eventsProcessor: service,
+		inbox:           inbox,
+		deadLetters:     deadLetters,
+		opts:            defaultHandlerOptions(),
 	}
+	for _, opt := range opts {
+		opt.ApplyHandler(&h.opts)
+	}
+	return h
 }
 
 // Setup Начинаем новую сессию, до ConsumeClaim
 func (h *ConsumerGroupHandler) Setup(_ sarama.ConsumerGroupSession) error {
+	h.active.Store(true)
 	return nil
 }
 
 // Cleanup завершает сессию, после того, как все ConsumeClaim завершатся
 func (h *ConsumerGroupHandler) Cleanup(_ sarama.ConsumerGroupSession) error {
+	h.active.Store(false)
+	return nil
+}
+
+// CheckSession для readiness: ошибка, если у группы нет активной сессии, например во время ребалансировки
+func (h *ConsumerGroupHandler) CheckSession(_ context.Context) error {
+	if !h.active.Load() {
+		return errors.New("no active consumer group session")
+	}
 	return nil
 }
 
-// ConsumeClaim читаем до тех пор, пока сессия не завершилась
+// ConsumeClaim читаем до тех пор, пока сессия не завершилась.
+// Сообщения партиции обрабатывают Workers обработчиков: сообщения с одним ключом, то есть одного заказа,
+// попадают к одному обработчику и обрабатываются по порядку, а разные заказы - параллельно.
+// Offset коммитится вручную только до первого необработанного сообщения: событие должно быть записано в inbox
+// или перенесено в DLQ, поэтому после падения событие доставится повторно, а inbox отбросит его, если оно уже обработано
 func (h *ConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	tracker := NewOffsetTracker()
+	// inFlight ограничивает число прочитанных, но еще не обработанных сообщений партиции
+	inFlight := make(chan struct{}, h.opts.MaxInFlight)
+	queues := make([]chan *sarama.ConsumerMessage, h.opts.Workers)
+	wg := sync.WaitGroup{}
+	for i := range queues {
+		queues[i] = make(chan *sarama.ConsumerMessage, h.opts.MaxInFlight)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for message := range queues[i] {
+				if h.processMessage(ctx, message) {
+					if next, advanced := tracker.Complete(message.Offset); advanced {
+						session.MarkOffset(message.Topic, message.Partition, next, "")
+						session.Commit()
+					}
+				}
+				<-inFlight
+			}
+		}()
+	}
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
 	for {
 		select {
 		case message, ok := <-claim.Messages():
 			if !ok {
 				return nil
 			}
-
-			msg, err := convertMsg(message)
-			if err != nil {
-				h.eventsProcessor.ProcessError(err)
+			select {
+			case inFlight <- struct{}{}:
+			case <-ctx.Done():
+				return nil
 			}
-			h.eventsProcessor.Process(&msg.payload)
-			data, _ := json.Marshal(msg)
-			log.Printf("Message claimed: %s", data)
-
-			// mark message as successfully handled and ready to commit offset
-			// autocommit may commit message offset sometime
-			session.MarkMessage(message, "")
-
-			// commit offset manually right now
-			// works when autocommit disabled
-			// session.Commit()
-
-			// autocommit not to work if cg not gracefully shut downed
-			// but manual commit does
-			// panic("emulate not commit")
-		case <-session.Context().Done():
+			tracker.Add(message.Offset)
+			queues[queueIndex(message.Key, len(queues))] <- message
+		case <-ctx.Done():
 			return nil
 		}
 	}
 }
 
+// processMessage обрабатывает сообщение, а сообщение, которое не разбирается или не проходит проверку, сразу переносит в DLQ.
+// Возвращает false, если сессия завершилась раньше, чем сообщение удалось обработать: его offset не коммитится
+func (h *ConsumerGroupHandler) processMessage(ctx context.Context, message *sarama.ConsumerMessage) bool {
+	msg, err := convertMsg(message)
+	handled := false
+	if err != nil {
+		h.eventsProcessor.ProcessError(err)
+		handled = h.deadLetter(ctx, message, err, 1)
+	} else {
+		handled = h.handle(ctx, message, &msg)
+	}
+	if handled {
+		data, _ := json.Marshal(msg)
+		log.Printf("Message claimed: %s", data)
+	}
+	return handled
+}
+
+// queueIndex выбирает обработчика по ключу сообщения
+func queueIndex(key []byte, queues int) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write(key)
+	return int(hash.Sum32() % uint32(queues))
+}
+
+// handle обрабатывает событие через inbox. Ошибка инфраструктуры, например недоступность базы, повторяется
+// с растущей паузой без ограничения числа попыток: иначе сбой базы перенес бы в DLQ все события подряд.
+// В DLQ переносится только событие, которое не обработается и при повторе. Возвращает false, если сессия завершилась
+func (h *ConsumerGroupHandler) handle(ctx context.Context, message *sarama.ConsumerMessage, msg *Msg) bool {
+	retryInterval := h.opts.RetryInterval
+	for attempt := 1; ; attempt++ {
+		duplicate, err := h.inbox.Handle(ctx, &msg.payload, func(ctx context.Context, tx pgx.Tx) error {
+			return h.eventsProcessor.Process(ctx, tx, &msg.payload)
+		})
+		if err == nil {
+			if duplicate {
+				log.Printf("[consumer-group] Skipping duplicate event %s", msg.payload.DedupKey())
+				metrics.RecordDuplicateEvent()
+			} else {
+				metrics.RecordProcessedEvent()
+			}
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		h.eventsProcessor.ProcessError(err)
+		if IsPoison(err) {
+			return h.deadLetter(ctx, message, err, attempt)
+		}
+
+		if !sleep(ctx, retryInterval) {
+			return false
+		}
+		retryInterval = min(2*retryInterval, h.opts.MaxRetryInterval)
+	}
+}
+
+// IsPoison сообщает, что событие не обработается и при повторе: оно не разбирается, не проходит проверку
+// или обработка вернула неисправимую ошибку
+func IsPoison(err error) bool {
+	return errors.Is(err, ErrInvalidEvent) ||
+		errors.Is(err, ErrUnsupportedEventVersion) ||
+		errors.Is(err, notifiers.ErrPermanent)
+}
+
+// deadLetter переносит сообщение в DLQ, повторяя отправку, пока она не удастся или не завершится сессия.
+// Возвращает false, если сессия завершилась
+func (h *ConsumerGroupHandler) deadLetter(ctx context.Context, message *sarama.ConsumerMessage, cause error, attempts int) bool {
+	retryInterval := h.opts.RetryInterval
+	for {
+		err := h.deadLetters.Publish(message, cause, attempts)
+		if err == nil {
+			log.Printf("[consumer-group] Message %s/%d/%d moved to %s: %v",
+				message.Topic, message.Partition, message.Offset, h.deadLetters.Topic(), cause)
+			metrics.RecordDeadLetteredEvent()
+			return true
+		}
+		h.eventsProcessor.ProcessError(err)
+
+		if !sleep(ctx, retryInterval) {
+			return false
+		}
+		retryInterval = min(2*retryInterval, h.opts.MaxRetryInterval)
+	}
+}
+
+// sleep ждет d и возвращает false, если ctx завершился раньше
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
 type Msg struct {
 	Topic     string `json:"topic"`
 	Partition int32  `json:"partition"`
@@ -75,8 +248,7 @@ type Msg struct {
 }
 
 func convertMsg(in *sarama.ConsumerMessage) (Msg, error) {
-	var payload model.OrderEvent
-	err := json.Unmarshal(in.Value, &payload)
+	payload, err := DecodeOrderEvent(in.Value)
 	if err != nil {
 		return Msg{
 			Topic:     in.Topic,
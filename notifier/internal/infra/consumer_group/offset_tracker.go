@@ -0,0 +1,41 @@
+package consumer_group
+
+import "sync"
+
+// OffsetTracker отслеживает сообщения партиции, которые обрабатываются параллельно и завершаются в любом порядке,
+// и находит offset, до которого все сообщения обработаны
+type OffsetTracker struct {
+	mu sync.Mutex
+	// pending offset прочитанных сообщений в порядке чтения, начиная с первого необработанного
+	pending []int64
+	done    map[int64]bool
+}
+
+func NewOffsetTracker() *OffsetTracker {
+	return &OffsetTracker{done: make(map[int64]bool)}
+}
+
+// Add запоминает прочитанное сообщение, offset сообщений партиции возрастают
+func (t *OffsetTracker) Add(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, offset)
+}
+
+// Complete отмечает сообщение обработанным. Если обработаны все сообщения до первого необработанного,
+// возвращает offset для коммита - следующий за последним обработанным подряд - и true
+func (t *OffsetTracker) Complete(offset int64) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done[offset] = true
+
+	advanced := false
+	var next int64
+	for len(t.pending) > 0 && t.done[t.pending[0]] {
+		delete(t.done, t.pending[0])
+		next = t.pending[0] + 1
+		t.pending = t.pending[1:]
+		advanced = true
+	}
+	return next, advanced
+}
@@ -1,6 +1,8 @@
 package consumer_group
 
 import (
+	"time"
+
 	"github.com/IBM/sarama"
 )
 
@@ -21,3 +23,72 @@ func WithOffsetsInitial(v int64) Option {
 		return nil
 	})
 }
+
+// HandlerOptions параметры обработки сообщений партиции
+type HandlerOptions struct {
+	// Workers число обработчиков партиции. Сообщения с одним ключом обрабатывает один обработчик по порядку
+	Workers int
+	// MaxInFlight наибольшее число прочитанных, но еще не обработанных сообщений партиции
+	MaxInFlight int
+	// RetryInterval пауза перед повторной обработкой события или отправкой в DLQ после ошибки.
+	// Каждая следующая пауза удваивается до MaxRetryInterval
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+}
+
+const (
+	defaultWorkers          = 4
+	defaultMaxInFlight      = 64
+	defaultRetryInterval    = time.Second
+	defaultMaxRetryInterval = 30 * time.Second
+)
+
+func defaultHandlerOptions() HandlerOptions {
+	return HandlerOptions{
+		Workers:          defaultWorkers,
+		MaxInFlight:      defaultMaxInFlight,
+		RetryInterval:    defaultRetryInterval,
+		MaxRetryInterval: defaultMaxRetryInterval,
+	}
+}
+
+// HandlerOption is a handler configuration callback
+type HandlerOption interface {
+	ApplyHandler(*HandlerOptions)
+}
+
+type handlerOptionFn func(*HandlerOptions)
+
+func (fn handlerOptionFn) ApplyHandler(o *HandlerOptions) {
+	fn(o)
+}
+
+// WithWorkers задает число обработчиков партиции, неположительное значение игнорируется
+func WithWorkers(v int) HandlerOption {
+	return handlerOptionFn(func(o *HandlerOptions) {
+		if v > 0 {
+			o.Workers = v
+		}
+	})
+}
+
+// WithMaxInFlight задает предел необработанных сообщений партиции, неположительное значение игнорируется
+func WithMaxInFlight(v int) HandlerOption {
+	return handlerOptionFn(func(o *HandlerOptions) {
+		if v > 0 {
+			o.MaxInFlight = v
+		}
+	})
+}
+
+// WithRetryInterval задает первую и наибольшую паузу между повторами, неположительные значения игнорируются
+func WithRetryInterval(interval, maxInterval time.Duration) HandlerOption {
+	return handlerOptionFn(func(o *HandlerOptions) {
+		if interval > 0 {
+			o.RetryInterval = interval
+		}
+		if maxInterval > 0 {
+			o.MaxRetryInterval = maxInterval
+		}
+	})
+}
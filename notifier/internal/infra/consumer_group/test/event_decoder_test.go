@@ -0,0 +1,107 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	events "route256/libs/generated/api/events/v1"
+	"route256/notifier/internal/infra/consumer_group"
+	"route256/notifier/internal/pkg/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fixturesDir эталонные события, которые пишет LOMS, их проверяют тесты loms/internal/repository
+const fixturesDir = "../../../../../libs/api/events/v1/testdata"
+
+var occurredAt = time.Date(2024, 10, 1, 12, 30, 0, 0, time.UTC)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	payload, err := os.ReadFile(filepath.Join(fixturesDir, name))
+	require.NoError(t, err)
+	return payload
+}
+
+func marshalEnvelope(t *testing.T, version uint32) []byte {
+	t.Helper()
+	return marshalOrder(t, version, &events.OrderEvent{OrderId: 1005, UserId: 42, State: "PAYED"})
+}
+
+func marshalOrder(t *testing.T, version uint32, order *events.OrderEvent) []byte {
+	t.Helper()
+	payload, err := proto.Marshal(&events.EventEnvelope{
+		EventId:    "b7f6d1c2-5a3e-4f0b-9c1d-2e8a7f6b5c4d",
+		Type:       "order.paid",
+		OccurredAt: timestamppb.New(occurredAt),
+		Version:    version,
+		Data:       &events.EventEnvelope_Order{Order: order},
+	})
+	require.NoError(t, err)
+	return payload
+}
+
+func TestDecodeOrderEvent_FromLOMS(t *testing.T) {
+	event, err := consumer_group.DecodeOrderEvent(readFixture(t, "order_paid.v1.pb"))
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, event.EventID)
+	assert.Equal(t, "order.paid", event.Type)
+	assert.Equal(t, uint32(1), event.Version)
+	assert.Equal(t, occurredAt, event.OccurredAt)
+	assert.Equal(t, int64(1005), event.ID)
+	assert.Equal(t, model.StateType(model.PAYED), event.State)
+	assert.Equal(t, int64(42), event.UserId)
+	assert.Equal(t, []*model.Item{{SKU: 1076963, Count: 2}, {SKU: 1148162, Count: 1}}, event.Items)
+}
+
+func TestDecodeOrderEvent_Legacy(t *testing.T) {
+	event, err := consumer_group.DecodeOrderEvent(readFixture(t, "order_paid.v0.json"))
+	require.NoError(t, err)
+
+	// у событий до введения конверта нет идентификатора, тип восстанавливается по статусу
+	assert.Empty(t, event.EventID)
+	assert.Equal(t, "order.paid", event.Type)
+	assert.Zero(t, event.Version)
+	assert.Equal(t, int64(1005), event.ID)
+	assert.Equal(t, model.StateType(model.PAYED), event.State)
+	assert.Equal(t, int64(42), event.UserId)
+	assert.Equal(t, []*model.Item{{SKU: 1076963, Count: 2}, {SKU: 1148162, Count: 1}}, event.Items)
+}
+
+func TestDecodeOrderEvent_Rejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		wantErr error
+	}{
+		{name: "envelope version 0", payload: marshalEnvelope(t, 0), wantErr: consumer_group.ErrUnsupportedEventVersion},
+		{name: "newer version", payload: marshalEnvelope(t, consumer_group.SupportedEventVersion+1), wantErr: consumer_group.ErrUnsupportedEventVersion},
+		{name: "stock event", payload: readFixture(t, "stock_changed.v1.pb"), wantErr: consumer_group.ErrInvalidEvent},
+		{name: "broken legacy json", payload: []byte(`{"ID": "1005"`), wantErr: consumer_group.ErrInvalidEvent},
+		{name: "not an envelope", payload: []byte{0xff, 0xff, 0xff}, wantErr: consumer_group.ErrInvalidEvent},
+		{
+			name:    "zero order id",
+			payload: marshalOrder(t, consumer_group.SupportedEventVersion, &events.OrderEvent{UserId: 42, State: "PAYED"}),
+			wantErr: consumer_group.ErrInvalidEvent,
+		},
+		{
+			name:    "unknown state",
+			payload: marshalOrder(t, consumer_group.SupportedEventVersion, &events.OrderEvent{OrderId: 1005, UserId: 42, State: "SHIPPED"}),
+			wantErr: consumer_group.ErrInvalidEvent,
+		},
+		{name: "legacy unknown state", payload: []byte(`{"ID":1005,"State":"SHIPPED","UserId":42}`), wantErr: consumer_group.ErrInvalidEvent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := consumer_group.DecodeOrderEvent(tt.payload)
+			require.ErrorIs(t, err, tt.wantErr)
+			// такое событие не обработается и при повторе
+			assert.True(t, consumer_group.IsPoison(err))
+		})
+	}
+}
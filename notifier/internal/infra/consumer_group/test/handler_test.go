@@ -0,0 +1,346 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"route256/notifier/internal/infra/consumer_group"
+	"route256/notifier/internal/infra/notifiers"
+	"route256/notifier/internal/pkg/model"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/gojuno/minimock/v3"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	topic    = "loms.order-events"
+	dlqTopic = "loms.order-events.dlq"
+)
+
+// fakeSession сессия группы, которая запоминает закоммиченные offset партиции
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx context.Context
+
+	mu      sync.Mutex
+	marked  []int64
+	commits int
+}
+
+func (s *fakeSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeSession) MarkOffset(_ string, _ int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = append(s.marked, offset)
+}
+
+func (s *fakeSession) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commits++
+}
+
+func (s *fakeSession) Marked() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int64(nil), s.marked...)
+}
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// orderMessage сообщение заказа orderID. SKU товара совпадает с offset, по нему тесты находят сообщение
+func orderMessage(t *testing.T, offset, orderID int64) *sarama.ConsumerMessage {
+	t.Helper()
+	return &sarama.ConsumerMessage{
+		Topic:     topic,
+		Partition: 0,
+		Offset:    offset,
+		Key:       []byte(fmt.Sprint(orderID)),
+		Value:     []byte(fmt.Sprintf(`{"ID":%d,"State":"PAYED","Items":[{"SKU":%d,"Count":1}],"UserId":42}`, orderID, offset)),
+	}
+}
+
+func messageOffset(event *model.OrderEvent) int64 {
+	return int64(event.Items[0].SKU)
+}
+
+// consume передает сообщения обработчику и ждет, пока все они будут обработаны
+func consume(t *testing.T, handler *consumer_group.ConsumerGroupHandler, messages ...*sarama.ConsumerMessage) *fakeSession {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, len(messages))}
+	for _, message := range messages {
+		claim.messages <- message
+	}
+	close(claim.messages)
+
+	session := &fakeSession{ctx: ctx}
+	require.NoError(t, handler.ConsumeClaim(session, claim))
+	require.NoError(t, ctx.Err(), "Сообщения не обработаны до завершения сессии")
+	return session
+}
+
+func newEventsProcessorMock(mc *minimock.Controller) *EventsProcessorMock {
+	processorMock := NewEventsProcessorMock(mc)
+	processorMock.ProcessMock.Optional().Return(nil)
+	processorMock.ProcessErrorMock.Optional().Return()
+	return processorMock
+}
+
+func newDeadLettersMock(mc *minimock.Controller) *DeadLettersMock {
+	deadLettersMock := NewDeadLettersMock(mc)
+	deadLettersMock.TopicMock.Optional().Return(dlqTopic)
+	return deadLettersMock
+}
+
+var retryFast = consumer_group.WithRetryInterval(time.Millisecond, 2*time.Millisecond)
+
+func TestConsumerGroupHandler_PoisonMessageDeadLettered(t *testing.T) {
+	tests := []struct {
+		name         string
+		value        []byte
+		processErr   error
+		failuresLeft int
+		wantErr      error
+		wantAttempts int
+	}{
+		{name: "not decoded", value: []byte{0xff, 0xff, 0xff}, wantErr: consumer_group.ErrInvalidEvent, wantAttempts: 1},
+		{name: "unknown state", value: []byte(`{"ID":1005,"State":"SHIPPED","UserId":42}`), wantErr: consumer_group.ErrInvalidEvent, wantAttempts: 1},
+		{name: "unsupported version", value: marshalEnvelope(t, consumer_group.SupportedEventVersion+1),
+			wantErr: consumer_group.ErrUnsupportedEventVersion, wantAttempts: 1},
+		{
+			// ошибки базы перед неисправимой ошибкой повторяются, а число попыток попадает в DLQ
+			name:         "permanent after infrastructure errors",
+			value:        []byte(`{"ID":1005,"State":"PAYED","UserId":42}`),
+			processErr:   fmt.Errorf("%w: user 42 has no email", notifiers.ErrPermanent),
+			failuresLeft: 2,
+			wantErr:      notifiers.ErrPermanent,
+			wantAttempts: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := minimock.NewController(t)
+			message := &sarama.ConsumerMessage{Topic: topic, Partition: 0, Offset: 10, Key: []byte("1005"), Value: tt.value}
+
+			processorMock := newEventsProcessorMock(mc)
+			inboxMock := NewInboxMock(mc)
+			failuresLeft := tt.failuresLeft
+			inboxMock.HandleMock.Optional().Set(func(ctx context.Context, _ *model.OrderEvent,
+				handle func(ctx context.Context, tx pgx.Tx) error) (bool, error) {
+				if failuresLeft > 0 {
+					failuresLeft--
+					return false, errors.New("connection refused")
+				}
+				return false, tt.processErr
+			})
+			deadLettersMock := newDeadLettersMock(mc)
+			deadLettersMock.PublishMock.Set(func(dead *sarama.ConsumerMessage, cause error, attempts int) error {
+				assert.Same(t, message, dead)
+				assert.ErrorIs(t, cause, tt.wantErr)
+				assert.Equal(t, tt.wantAttempts, attempts)
+				return nil
+			})
+
+			handler := consumer_group.NewConsumerGroupHandler(processorMock, inboxMock, deadLettersMock, retryFast)
+			session := consume(t, handler, message)
+			assert.Equal(t, uint64(1), deadLettersMock.PublishAfterCounter())
+			// перенесенное в DLQ сообщение больше не читается из партиции
+			assert.Equal(t, []int64{11}, session.Marked())
+		})
+	}
+}
+
+func TestConsumerGroupHandler_InfrastructureErrorRetried(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	processorMock := newEventsProcessorMock(mc)
+	inboxMock := NewInboxMock(mc)
+	calls := 0
+	inboxMock.HandleMock.Set(func(ctx context.Context, event *model.OrderEvent,
+		handle func(ctx context.Context, tx pgx.Tx) error) (bool, error) {
+		calls++
+		// база недоступна дольше, чем прежний предел попыток
+		if calls <= 10 {
+			return false, errors.New("connection refused")
+		}
+		return false, handle(ctx, nil)
+	})
+	// DLQ не вызывается: сообщение без ожиданий завершит тест ошибкой
+	deadLettersMock := newDeadLettersMock(mc)
+
+	handler := consumer_group.NewConsumerGroupHandler(processorMock, inboxMock, deadLettersMock, retryFast)
+	session := consume(t, handler, orderMessage(t, 10, 1005))
+	assert.Equal(t, 11, calls)
+	assert.Equal(t, uint64(1), processorMock.ProcessAfterCounter())
+	assert.Equal(t, uint64(10), processorMock.ProcessErrorAfterCounter())
+	assert.Equal(t, []int64{11}, session.Marked())
+}
+
+func TestConsumerGroupHandler_DeadLetterPublishRetried(t *testing.T) {
+	mc := minimock.NewController(t)
+
+	deadLettersMock := newDeadLettersMock(mc)
+	publishes := 0
+	deadLettersMock.PublishMock.Set(func(_ *sarama.ConsumerMessage, _ error, attempts int) error {
+		publishes++
+		assert.Equal(t, 1, attempts, "Повтор отправки в DLQ не повторяет обработку")
+		if publishes < 3 {
+			return errors.New("kafka: client has run out of available brokers")
+		}
+		return nil
+	})
+
+	handler := consumer_group.NewConsumerGroupHandler(newEventsProcessorMock(mc), NewInboxMock(mc), deadLettersMock, retryFast)
+	session := consume(t, handler, &sarama.ConsumerMessage{Topic: topic, Offset: 10, Value: []byte{0xff}})
+	assert.Equal(t, 3, publishes)
+	assert.Equal(t, []int64{11}, session.Marked())
+}
+
+func TestConsumerGroupHandler_SessionEndsDuringRetry(t *testing.T) {
+	mc := minimock.NewController(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inboxMock := NewInboxMock(mc)
+	calls := 0
+	inboxMock.HandleMock.Set(func(context.Context, *model.OrderEvent, func(ctx context.Context, tx pgx.Tx) error) (bool, error) {
+		calls++
+		if calls == 3 {
+			// ребалансировка: партиция уходит другому участнику группы
+			cancel()
+		}
+		return false, errors.New("connection refused")
+	})
+
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 1)}
+	claim.messages <- orderMessage(t, 10, 1005)
+	session := &fakeSession{ctx: ctx}
+	handler := consumer_group.NewConsumerGroupHandler(newEventsProcessorMock(mc), inboxMock, newDeadLettersMock(mc), retryFast)
+	require.NoError(t, handler.ConsumeClaim(session, claim))
+
+	// необработанное сообщение прочитает следующий владелец партиции
+	assert.Empty(t, session.Marked())
+}
+
+func TestConsumerGroupHandler_SameKeyInOrder(t *testing.T) {
+	mc := minimock.NewController(t)
+	const orders, perOrder = 8, 25
+
+	mu := sync.Mutex{}
+	processed := make(map[int64][]int64, orders)
+	inboxMock := NewInboxMock(mc)
+	inboxMock.HandleMock.Set(func(_ context.Context, event *model.OrderEvent,
+		_ func(ctx context.Context, tx pgx.Tx) error) (bool, error) {
+		// разное время обработки перемешивает завершение сообщений разных обработчиков
+		time.Sleep(time.Duration(rand.IntN(500)) * time.Microsecond)
+		mu.Lock()
+		defer mu.Unlock()
+		processed[event.ID] = append(processed[event.ID], messageOffset(event))
+		return false, nil
+	})
+
+	messages := make([]*sarama.ConsumerMessage, 0, orders*perOrder)
+	want := make(map[int64][]int64, orders)
+	for offset := int64(0); offset < orders*perOrder; offset++ {
+		orderID := 1000 + offset%orders
+		messages = append(messages, orderMessage(t, offset, orderID))
+		want[orderID] = append(want[orderID], offset)
+	}
+
+	handler := consumer_group.NewConsumerGroupHandler(newEventsProcessorMock(mc), inboxMock, newDeadLettersMock(mc),
+		consumer_group.WithWorkers(4), consumer_group.WithMaxInFlight(16))
+	session := consume(t, handler, messages...)
+
+	// события одного заказа обработаны в порядке offset, хотя заказы обрабатываются параллельно
+	assert.Equal(t, want, processed)
+	marked := session.Marked()
+	require.NotEmpty(t, marked)
+	assert.IsNonDecreasing(t, marked)
+	assert.Equal(t, int64(orders*perOrder), marked[len(marked)-1])
+}
+
+func TestConsumerGroupHandler_CommitWaitsForGap(t *testing.T) {
+	mc := minimock.NewController(t)
+	const slowOffset = 10
+
+	release := make(chan struct{})
+	othersDone := make(chan struct{}, 8)
+	inboxMock := NewInboxMock(mc)
+	inboxMock.HandleMock.Set(func(_ context.Context, event *model.OrderEvent,
+		_ func(ctx context.Context, tx pgx.Tx) error) (bool, error) {
+		if messageOffset(event) == slowOffset {
+			<-release
+		} else {
+			othersDone <- struct{}{}
+		}
+		return false, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 8)}
+	for offset := int64(slowOffset); offset < slowOffset+8; offset++ {
+		claim.messages <- orderMessage(t, offset, 1000+offset)
+	}
+	close(claim.messages)
+	session := &fakeSession{ctx: ctx}
+
+	go func() {
+		// сообщения других заказов обрабатываются, пока первое сообщение партиции ждет
+		select {
+		case <-othersDone:
+		case <-ctx.Done():
+		}
+		assert.Empty(t, session.Marked(), "Offset закоммичен раньше необработанного сообщения")
+		close(release)
+	}()
+
+	handler := consumer_group.NewConsumerGroupHandler(newEventsProcessorMock(mc), inboxMock, newDeadLettersMock(mc),
+		consumer_group.WithWorkers(4))
+	require.NoError(t, handler.ConsumeClaim(session, claim))
+	require.NoError(t, ctx.Err())
+
+	marked := session.Marked()
+	require.NotEmpty(t, marked)
+	// после обработки первого сообщения offset сдвигается сразу за все обработанные
+	assert.Equal(t, int64(slowOffset+8), marked[len(marked)-1])
+}
+
+func TestIsPoison(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "invalid event", err: fmt.Errorf("event 1005: %w: order id 0", consumer_group.ErrInvalidEvent), want: true},
+		{name: "unsupported version", err: fmt.Errorf("%w 2", consumer_group.ErrUnsupportedEventVersion), want: true},
+		{name: "permanent", err: fmt.Errorf("%w: user 42 has no email", notifiers.ErrPermanent), want: true},
+		{name: "database unavailable", err: errors.New("connection refused")},
+		{name: "timeout", err: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, consumer_group.IsPoison(tt.err))
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"route256/notifier/internal/infra/consumer_group"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type completion struct {
+	offset   int64
+	next     int64
+	advanced bool
+}
+
+func TestOffsetTracker_Complete(t *testing.T) {
+	tests := []struct {
+		name        string
+		read        []int64
+		completions []completion
+	}{
+		{
+			name: "in order",
+			read: []int64{10, 11, 12},
+			completions: []completion{
+				{offset: 10, next: 11, advanced: true},
+				{offset: 11, next: 12, advanced: true},
+				{offset: 12, next: 13, advanced: true},
+			},
+		},
+		{
+			name: "first completes last",
+			read: []int64{10, 11, 12},
+			completions: []completion{
+				{offset: 11},
+				{offset: 12},
+				{offset: 10, next: 13, advanced: true},
+			},
+		},
+		{
+			name: "gap in the middle",
+			read: []int64{10, 11, 12, 13},
+			completions: []completion{
+				{offset: 10, next: 11, advanced: true},
+				{offset: 12},
+				{offset: 13},
+				{offset: 11, next: 14, advanced: true},
+			},
+		},
+		{
+			name: "gap fills partially",
+			read: []int64{10, 11, 12, 13},
+			completions: []completion{
+				{offset: 13},
+				{offset: 11},
+				{offset: 10, next: 12, advanced: true},
+				{offset: 12, next: 14, advanced: true},
+			},
+		},
+		{
+			// в сжатом топике и после транзакций offset партиции идут не подряд
+			name: "sparse offsets",
+			read: []int64{10, 15, 20},
+			completions: []completion{
+				{offset: 15},
+				{offset: 10, next: 16, advanced: true},
+				{offset: 20, next: 21, advanced: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := consumer_group.NewOffsetTracker()
+			for _, offset := range tt.read {
+				tracker.Add(offset)
+			}
+			for _, c := range tt.completions {
+				next, advanced := tracker.Complete(c.offset)
+				assert.Equal(t, c.advanced, advanced, "offset %d", c.offset)
+				if c.advanced {
+					assert.Equal(t, c.next, next, "offset %d", c.offset)
+				}
+			}
+		})
+	}
+}
+
+func TestOffsetTracker_ReadWhileProcessing(t *testing.T) {
+	tracker := consumer_group.NewOffsetTracker()
+	tracker.Add(10)
+	tracker.Add(11)
+
+	_, advanced := tracker.Complete(11)
+	assert.False(t, advanced)
+	// сообщение, прочитанное после обработанных, не сдвигает offset дальше первого необработанного
+	tracker.Add(12)
+	_, advanced = tracker.Complete(12)
+	assert.False(t, advanced)
+
+	next, advanced := tracker.Complete(10)
+	assert.True(t, advanced)
+	assert.Equal(t, int64(13), next)
+
+	tracker.Add(13)
+	next, advanced = tracker.Complete(13)
+	assert.True(t, advanced)
+	assert.Equal(t, int64(14), next)
+}
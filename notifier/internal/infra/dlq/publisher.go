@@ -0,0 +1,77 @@
+package dlq
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Заголовки, которые добавляются к исходным заголовкам сообщения при переносе в DLQ
+const (
+	ErrorHeader           = "dlq-error"
+	SourceTopicHeader     = "dlq-source-topic"
+	SourcePartitionHeader = "dlq-source-partition"
+	SourceOffsetHeader    = "dlq-source-offset"
+	AttemptsHeader        = "dlq-attempts"
+	FailedAtHeader        = "dlq-failed-at"
+)
+
+// Publisher переносит сообщения, которые не удалось обработать, в топик DLQ.
+// Ключ, значение и заголовки сообщения сохраняются как есть, чтобы его можно было вернуть командой replay-dlq
+type Publisher struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+func NewPublisher(producer sarama.SyncProducer, topic string) *Publisher {
+	return &Publisher{producer: producer, topic: topic}
+}
+
+// Publish отправляет сообщение в DLQ с ошибкой обработки и его местом в исходном топике
+func (p *Publisher) Publish(message *sarama.ConsumerMessage, cause error, attempts int) error {
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers)+6)
+	for _, header := range message.Headers {
+		if header != nil {
+			headers = append(headers, *header)
+		}
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(ErrorHeader), Value: []byte(cause.Error())},
+		sarama.RecordHeader{Key: []byte(SourceTopicHeader), Value: []byte(message.Topic)},
+		sarama.RecordHeader{Key: []byte(SourcePartitionHeader), Value: []byte(strconv.FormatInt(int64(message.Partition), 10))},
+		sarama.RecordHeader{Key: []byte(SourceOffsetHeader), Value: []byte(strconv.FormatInt(message.Offset, 10))},
+		sarama.RecordHeader{Key: []byte(AttemptsHeader), Value: []byte(strconv.Itoa(attempts))},
+		sarama.RecordHeader{Key: []byte(FailedAtHeader), Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+	)
+
+	msg := &sarama.ProducerMessage{
+		Topic:     p.topic,
+		Value:     sarama.ByteEncoder(message.Value),
+		Headers:   headers,
+		Timestamp: message.Timestamp,
+	}
+	if message.Key != nil {
+		msg.Key = sarama.ByteEncoder(message.Key)
+	}
+	if _, _, err := p.producer.SendMessage(msg); err != nil {
+		return fmt.Errorf("unable to publish message %s/%d/%d to dlq %s: %w",
+			message.Topic, message.Partition, message.Offset, p.topic, err)
+	}
+	return nil
+}
+
+// Topic топик DLQ
+func (p *Publisher) Topic() string {
+	return p.topic
+}
+
+// isDLQHeader сообщает, что заголовок добавлен при переносе в DLQ
+func isDLQHeader(key []byte) bool {
+	switch string(key) {
+	case ErrorHeader, SourceTopicHeader, SourcePartitionHeader, SourceOffsetHeader, AttemptsHeader, FailedAtHeader:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package dlq
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/IBM/sarama"
+)
+
+// ReplayOptions параметры возврата сообщений из DLQ
+type ReplayOptions struct {
+	// DefaultTopic топик, в который возвращается сообщение без заголовка исходного топика
+	DefaultTopic string
+	// Limit наибольшее число возвращаемых сообщений, 0 - без ограничения
+	Limit int
+	// DryRun только выводит сообщения, не возвращая их и не сдвигая offset
+	DryRun bool
+}
+
+// Replayer возвращает сообщения из DLQ в исходные топики.
+// Прочитанный offset DLQ коммитится в группу groupID, поэтому повторный запуск не возвращает сообщения дважды,
+// а сообщения, попавшие в DLQ после запуска, остаются до следующего
+type Replayer struct {
+	client   sarama.Client
+	producer sarama.SyncProducer
+	topic    string
+	groupID  string
+	opts     ReplayOptions
+}
+
+func NewReplayer(client sarama.Client, producer sarama.SyncProducer, topic, groupID string, opts ReplayOptions) *Replayer {
+	return &Replayer{
+		client:   client,
+		producer: producer,
+		topic:    topic,
+		groupID:  groupID,
+		opts:     opts,
+	}
+}
+
+// Replay возвращает сообщения всех партиций DLQ и возвращает их число
+func (r *Replayer) Replay(ctx context.Context) (int, error) {
+	partitions, err := r.client.Partitions(r.topic)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get partitions of %s: %w", r.topic, err)
+	}
+	offsetManager, err := sarama.NewOffsetManagerFromClient(r.groupID, r.client)
+	if err != nil {
+		return 0, fmt.Errorf("unable to create offset manager: %w", err)
+	}
+	defer offsetManager.Close()
+	consumer, err := sarama.NewConsumerFromClient(r.client)
+	if err != nil {
+		return 0, fmt.Errorf("unable to create consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	replayed := 0
+	for _, partition := range partitions {
+		if r.opts.Limit > 0 && replayed >= r.opts.Limit {
+			break
+		}
+		count, err := r.replayPartition(ctx, consumer, offsetManager, partition, replayed)
+		replayed += count
+		if err != nil {
+			return replayed, fmt.Errorf("partition %d: %w", partition, err)
+		}
+	}
+	return replayed, nil
+}
+
+// replayPartition возвращает сообщения партиции, записанные до начала возврата
+func (r *Replayer) replayPartition(ctx context.Context, consumer sarama.Consumer, offsetManager sarama.OffsetManager,
+	partition int32, replayedBefore int) (int, error) {
+	partitionManager, err := offsetManager.ManagePartition(r.topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	defer partitionManager.Close()
+
+	oldest, err := r.client.GetOffset(r.topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	end, err := r.client.GetOffset(r.topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+	next, _ := partitionManager.NextOffset()
+	if next < oldest {
+		next = oldest
+	}
+	if next >= end {
+		return 0, nil
+	}
+
+	partitionConsumer, err := consumer.ConsumePartition(r.topic, partition, next)
+	if err != nil {
+		return 0, err
+	}
+	defer partitionConsumer.Close()
+
+	count := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return count, ctx.Err()
+		case consumerErr := <-partitionConsumer.Errors():
+			return count, consumerErr
+		case message := <-partitionConsumer.Messages():
+			if err = r.replayMessage(message); err != nil {
+				return count, err
+			}
+			count++
+			if !r.opts.DryRun {
+				partitionManager.MarkOffset(message.Offset+1, "")
+				offsetManager.Commit()
+			}
+			if message.Offset+1 >= end || (r.opts.Limit > 0 && replayedBefore+count >= r.opts.Limit) {
+				return count, nil
+			}
+		}
+	}
+}
+
+// replayMessage отправляет сообщение в исходный топик без заголовков DLQ
+func (r *Replayer) replayMessage(message *sarama.ConsumerMessage) error {
+	topic := r.opts.DefaultTopic
+	var cause string
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers))
+	for _, header := range message.Headers {
+		if header == nil {
+			continue
+		}
+		switch string(header.Key) {
+		case SourceTopicHeader:
+			topic = string(header.Value)
+		case ErrorHeader:
+			cause = string(header.Value)
+		}
+		if !isDLQHeader(header.Key) {
+			headers = append(headers, *header)
+		}
+	}
+	if topic == "" {
+		return fmt.Errorf("message %d has no source topic", message.Offset)
+	}
+
+	if r.opts.DryRun {
+		log.Printf("[replay-dlq] Would replay message %d/%d to %s, failed with: %s",
+			message.Partition, message.Offset, topic, cause)
+		return nil
+	}
+	msg := &sarama.ProducerMessage{
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	}
+	if message.Key != nil {
+		msg.Key = sarama.ByteEncoder(message.Key)
+	}
+	if _, _, err := r.producer.SendMessage(msg); err != nil {
+		return fmt.Errorf("unable to replay message %d/%d to %s: %w", message.Partition, message.Offset, topic, err)
+	}
+	log.Printf("[replay-dlq] Replayed message %d/%d to %s, failed with: %s", message.Partition, message.Offset, topic, cause)
+	return nil
+}
@@ -0,0 +1,118 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"route256/notifier/internal/infra/dlq"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const dlqTopic = "loms.order-events.dlq"
+
+// fakeProducer запоминает отправленные сообщения, sendErr возвращается вместо отправки
+type fakeProducer struct {
+	sarama.SyncProducer
+	sendErr error
+
+	mu   sync.Mutex
+	sent []*sarama.ProducerMessage
+}
+
+func (p *fakeProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	if p.sendErr != nil {
+		return 0, 0, p.sendErr
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent = append(p.sent, msg)
+	return 0, int64(len(p.sent) - 1), nil
+}
+
+func (p *fakeProducer) Sent() []*sarama.ProducerMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*sarama.ProducerMessage(nil), p.sent...)
+}
+
+// headers заголовки сообщения по ключу
+func headers(recordHeaders []sarama.RecordHeader) map[string]string {
+	result := make(map[string]string, len(recordHeaders))
+	for _, header := range recordHeaders {
+		result[string(header.Key)] = string(header.Value)
+	}
+	return result
+}
+
+func encoded(t *testing.T, encoder sarama.Encoder) []byte {
+	t.Helper()
+	data, err := encoder.Encode()
+	require.NoError(t, err)
+	return data
+}
+
+func TestPublisher_Publish(t *testing.T) {
+	producer := &fakeProducer{}
+	timestamp := time.Date(2024, 10, 1, 12, 30, 0, 0, time.UTC)
+	message := &sarama.ConsumerMessage{
+		Topic:     "loms.order-events",
+		Partition: 2,
+		Offset:    1042,
+		Key:       []byte("1005"),
+		Value:     []byte{0xff, 0xff},
+		Timestamp: timestamp,
+		Headers:   []*sarama.RecordHeader{{Key: []byte("trace-id"), Value: []byte("4bf92f35")}, nil},
+	}
+	cause := fmt.Errorf("event 1005: %w", errors.New("invalid event"))
+
+	require.NoError(t, dlq.NewPublisher(producer, dlqTopic).Publish(message, cause, 3))
+	sent := producer.Sent()
+	require.Len(t, sent, 1)
+
+	msg := sent[0]
+	assert.Equal(t, dlqTopic, msg.Topic)
+	assert.Equal(t, []byte("1005"), encoded(t, msg.Key))
+	assert.Equal(t, []byte{0xff, 0xff}, encoded(t, msg.Value))
+	assert.Equal(t, timestamp, msg.Timestamp)
+
+	got := headers(msg.Headers)
+	failedAt, err := time.Parse(time.RFC3339, got[dlq.FailedAtHeader])
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), failedAt, time.Minute)
+	delete(got, dlq.FailedAtHeader)
+	assert.Equal(t, map[string]string{
+		"trace-id":                "4bf92f35",
+		dlq.ErrorHeader:           cause.Error(),
+		dlq.SourceTopicHeader:     "loms.order-events",
+		dlq.SourcePartitionHeader: "2",
+		dlq.SourceOffsetHeader:    strconv.Itoa(1042),
+		dlq.AttemptsHeader:        "3",
+	}, got)
+	// исходные заголовки идут первыми, как в исходном сообщении
+	assert.Equal(t, "trace-id", string(msg.Headers[0].Key))
+}
+
+func TestPublisher_PublishWithoutKey(t *testing.T) {
+	producer := &fakeProducer{}
+	message := &sarama.ConsumerMessage{Topic: "loms.order-events", Value: []byte("{}")}
+
+	require.NoError(t, dlq.NewPublisher(producer, dlqTopic).Publish(message, errors.New("invalid event"), 1))
+	require.Len(t, producer.Sent(), 1)
+	assert.Nil(t, producer.Sent()[0].Key)
+}
+
+func TestPublisher_PublishError(t *testing.T) {
+	sendErr := errors.New("kafka: client has run out of available brokers")
+	producer := &fakeProducer{sendErr: sendErr}
+	message := &sarama.ConsumerMessage{Topic: "loms.order-events", Partition: 1, Offset: 7}
+
+	err := dlq.NewPublisher(producer, dlqTopic).Publish(message, errors.New("invalid event"), 1)
+	assert.ErrorIs(t, err, sendErr)
+	assert.ErrorContains(t, err, "loms.order-events/1/7")
+}
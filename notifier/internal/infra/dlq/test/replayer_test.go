@@ -0,0 +1,176 @@
+package test
+
+import (
+	"context"
+	"route256/notifier/internal/infra/dlq"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sourceTopic  = "loms.order-events"
+	defaultTopic = "loms.order-events.replayed"
+	groupID      = "notifier-dlq-replay"
+)
+
+type dlqRecord struct {
+	key     string
+	value   string
+	headers map[string]string
+}
+
+// newDLQBroker брокер с одной партицией DLQ, в которой лежат records начиная с offset 0.
+// Он же координатор группы, offset которой еще не коммитился
+func newDLQBroker(t *testing.T, records []dlqRecord) *sarama.MockBroker {
+	t.Helper()
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+
+	// MockFetchResponse не умеет заголовки, поэтому ответ на fetch собирается вручную
+	fetch := &sarama.FetchResponse{Version: 10}
+	for offset, record := range records {
+		fetch.AddRecord(dlqTopic, 0, sarama.StringEncoder(record.key), sarama.StringEncoder(record.value), int64(offset))
+	}
+	block := fetch.GetBlock(dlqTopic, 0)
+	block.HighWaterMarkOffset = int64(len(records))
+	for i, record := range block.RecordsSet[0].RecordBatch.Records {
+		for key, value := range records[i].headers {
+			record.Headers = append(record.Headers, &sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+		}
+	}
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(dlqTopic, 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(dlqTopic, 0, sarama.OffsetOldest, 0).
+			SetOffset(dlqTopic, 0, sarama.OffsetNewest, int64(len(records))),
+		"FetchRequest": sarama.NewMockWrapper(fetch),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, groupID, broker),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset(groupID, dlqTopic, 0, -1, "", sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+	})
+	return broker
+}
+
+func newClient(t *testing.T, broker *sarama.MockBroker) sarama.Client {
+	t.Helper()
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_1_0_0
+	config.Metadata.Retry.Max = 0
+	// offset manager при закрытии ждет очередного автокоммита, а без автокоммита не закрывается
+	config.Consumer.Offsets.AutoCommit.Interval = 10 * time.Millisecond
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+// committedOffsets offset DLQ, которые replay закоммитил в группу, по порядку
+func committedOffsets(broker *sarama.MockBroker) []int64 {
+	var offsets []int64
+	for _, rr := range broker.History() {
+		request, ok := rr.Request.(*sarama.OffsetCommitRequest)
+		if !ok {
+			continue
+		}
+		offset, _, err := request.Offset(dlqTopic, 0)
+		// автокоммит может повторить коммит replay, если успеет до сброса отметки
+		if err == nil && (len(offsets) == 0 || offsets[len(offsets)-1] != offset) {
+			offsets = append(offsets, offset)
+		}
+	}
+	return offsets
+}
+
+func deadLetter(key, value string, extra map[string]string) dlqRecord {
+	headers := map[string]string{
+		"trace-id":                "4bf92f35",
+		dlq.ErrorHeader:           "event 1005: invalid event: unknown order state",
+		dlq.SourcePartitionHeader: "0",
+		dlq.SourceOffsetHeader:    "1042",
+		dlq.AttemptsHeader:        "1",
+		dlq.FailedAtHeader:        "2024-10-01T12:30:00Z",
+	}
+	for k, v := range extra {
+		headers[k] = v
+	}
+	return dlqRecord{key: key, value: value, headers: headers}
+}
+
+var records = []dlqRecord{
+	deadLetter("1005", "first", map[string]string{dlq.SourceTopicHeader: sourceTopic}),
+	// сообщение без исходного топика возвращается в топик по умолчанию
+	deadLetter("1006", "second", nil),
+}
+
+func replay(t *testing.T, broker *sarama.MockBroker, producer sarama.SyncProducer, opts dlq.ReplayOptions) (int, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return dlq.NewReplayer(newClient(t, broker), producer, dlqTopic, groupID, opts).Replay(ctx)
+}
+
+func TestReplayer_Replay(t *testing.T) {
+	broker := newDLQBroker(t, records)
+	producer := &fakeProducer{}
+
+	replayed, err := replay(t, broker, producer, dlq.ReplayOptions{DefaultTopic: defaultTopic})
+	require.NoError(t, err)
+	assert.Equal(t, 2, replayed)
+
+	sent := producer.Sent()
+	require.Len(t, sent, 2)
+	assert.Equal(t, sourceTopic, sent[0].Topic)
+	assert.Equal(t, defaultTopic, sent[1].Topic)
+	for i, msg := range sent {
+		assert.Equal(t, []byte(records[i].key), encoded(t, msg.Key))
+		assert.Equal(t, []byte(records[i].value), encoded(t, msg.Value))
+		// заголовки DLQ снимаются, остальные возвращаются как были
+		assert.Equal(t, map[string]string{"trace-id": "4bf92f35"}, headers(msg.Headers))
+	}
+	// offset сдвигается после каждого сообщения, поэтому повторный запуск их не вернет
+	assert.Equal(t, []int64{1, 2}, committedOffsets(broker))
+}
+
+func TestReplayer_ReplayLimit(t *testing.T) {
+	broker := newDLQBroker(t, records)
+	producer := &fakeProducer{}
+
+	replayed, err := replay(t, broker, producer, dlq.ReplayOptions{DefaultTopic: defaultTopic, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+	require.Len(t, producer.Sent(), 1)
+	assert.Equal(t, sourceTopic, producer.Sent()[0].Topic)
+	assert.Equal(t, []int64{1}, committedOffsets(broker))
+}
+
+func TestReplayer_DryRun(t *testing.T) {
+	broker := newDLQBroker(t, records)
+	producer := &fakeProducer{}
+
+	replayed, err := replay(t, broker, producer, dlq.ReplayOptions{DefaultTopic: defaultTopic, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, replayed)
+	assert.Empty(t, producer.Sent())
+	assert.Empty(t, committedOffsets(broker))
+}
+
+func TestReplayer_NoSourceTopic(t *testing.T) {
+	broker := newDLQBroker(t, records[1:])
+	producer := &fakeProducer{}
+
+	replayed, err := replay(t, broker, producer, dlq.ReplayOptions{})
+	require.ErrorContains(t, err, "has no source topic")
+	assert.Zero(t, replayed)
+	assert.Empty(t, producer.Sent())
+	// сообщение остается в DLQ до запуска с топиком по умолчанию
+	assert.Empty(t, committedOffsets(broker))
+}
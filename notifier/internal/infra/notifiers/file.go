@@ -0,0 +1,68 @@
+package notifiers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"route256/notifier/internal/pkg/model"
+	"sync"
+	"time"
+)
+
+// FileNotifier пишет уведомления строками JSON в файл или в stdout. Канал file есть всегда, им удобно проверять
+// маршрутизацию при локальном запуске
+type FileNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+	// file открытый файл, nil для stdout
+	file *os.File
+}
+
+type fileRecord struct {
+	Time    time.Time       `json:"time"`
+	UserID  int64           `json:"user_id"`
+	OrderID int64           `json:"order_id"`
+	State   model.StateType `json:"state"`
+	Message string          `json:"message"`
+}
+
+// NewFileNotifier дописывает уведомления в файл path, при пустом path пишет в stdout
+func NewFileNotifier(path string) (*FileNotifier, error) {
+	if path == "" {
+		return &FileNotifier{w: os.Stdout}, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open notification file: %w", err)
+	}
+	return &FileNotifier{w: file, file: file}, nil
+}
+
+func (n *FileNotifier) Notify(_ context.Context, event *model.OrderEvent) error {
+	data, err := json.Marshal(fileRecord{
+		Time:    time.Now(),
+		UserID:  event.UserId,
+		OrderID: event.ID,
+		State:   event.State,
+		Message: event.Message(),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPermanent, err)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, err = n.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("unable to write notification: %w", err)
+	}
+	return nil
+}
+
+// Close закрывает файл уведомлений
+func (n *FileNotifier) Close() error {
+	if n.file == nil {
+		return nil
+	}
+	return n.file.Close()
+}
@@ -0,0 +1,13 @@
+package notifiers
+
+import "errors"
+
+// Каналы уведомлений, на которые ссылаются правила маршрутизации
+const (
+	EmailChannel   = "email"
+	WebhookChannel = "webhook"
+	FileChannel    = "file"
+)
+
+// ErrPermanent ошибка, которая не исправится при повторной отправке
+var ErrPermanent = errors.New("permanent notification failure")
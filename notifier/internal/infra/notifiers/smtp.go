@@ -0,0 +1,102 @@
+package notifiers
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"route256/notifier/internal/pkg/model"
+	"strings"
+)
+
+// Recipients адреса почты пользователей
+type Recipients interface {
+	Email(userID int64) (string, bool)
+}
+
+// SMTPNotifier отправляет уведомления письмом через SMTP сервер.
+// Если сервер поддерживает STARTTLS, соединение шифруется, авторизация выполняется при заданном логине
+type SMTPNotifier struct {
+	addr       string
+	host       string
+	from       string
+	auth       smtp.Auth
+	recipients Recipients
+}
+
+func NewSMTPNotifier(addr, from, username, password string, recipients Recipients) (*SMTPNotifier, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp address %s: %w", addr, err)
+	}
+	n := &SMTPNotifier{addr: addr, host: host, from: from, recipients: recipients}
+	if username != "" {
+		n.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return n, nil
+}
+
+// Notify отправляет письмо. Уведомление пользователю без адреса не отправляется
+func (n *SMTPNotifier) Notify(ctx context.Context, event *model.OrderEvent) error {
+	to, ok := n.recipients.Email(event.UserId)
+	if !ok {
+		return fmt.Errorf("%w: user %d has no email", ErrPermanent, event.UserId)
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return fmt.Errorf("smtp dial: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("smtp handshake: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: n.host}); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+	if n.auth != nil {
+		if err = client.Auth(n.auth); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err = client.Mail(n.from); err != nil {
+		return fmt.Errorf("smtp mail from: %w", err)
+	}
+	if err = client.Rcpt(to); err != nil {
+		return fmt.Errorf("smtp rcpt %s: %w", to, err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	if _, err = w.Write(n.message(to, event)); err != nil {
+		return fmt.Errorf("smtp write: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("smtp send: %w", err)
+	}
+	return client.Quit()
+}
+
+func (n *SMTPNotifier) message(to string, event *model.OrderEvent) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", fmt.Sprintf("Заказ %d", event.ID)))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(event.Message())
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
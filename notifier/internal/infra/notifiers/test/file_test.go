@@ -0,0 +1,52 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"route256/notifier/internal/infra/notifiers"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileNotifier_AppendsRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"order_id\":1}\n"), 0o644))
+
+	notifier, err := notifiers.NewFileNotifier(path)
+	require.NoError(t, err)
+	require.NoError(t, notifier.Notify(context.Background(), event))
+	require.NoError(t, notifier.Notify(context.Background(), event))
+	require.NoError(t, notifier.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var records []map[string]any
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+
+	// файл дописывается, уведомление - одна строка JSON
+	require.Len(t, records, 3)
+	assert.Equal(t, float64(1005), records[2]["order_id"])
+	assert.Equal(t, float64(42), records[2]["user_id"])
+	assert.Equal(t, "PAYED", records[2]["state"])
+	assert.Equal(t, event.Message(), records[2]["message"])
+}
+
+func TestFileNotifier_Stdout(t *testing.T) {
+	notifier, err := notifiers.NewFileNotifier("")
+	require.NoError(t, err)
+	// stdout процесса не закрывается
+	assert.NoError(t, notifier.Close())
+}
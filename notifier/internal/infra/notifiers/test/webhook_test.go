@@ -0,0 +1,141 @@
+package test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"route256/notifier/internal/infra/notifiers"
+	"route256/notifier/internal/pkg/model"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const secret = "webhook-secret"
+
+var event = &model.OrderEvent{
+	EventID:    "b7f6d1c2-5a3e-4f0b-9c1d-2e8a7f6b5c4d",
+	Type:       "order.paid",
+	Version:    1,
+	OccurredAt: time.Date(2024, 10, 1, 12, 30, 0, 0, time.UTC),
+	ID:         1005,
+	State:      model.PAYED,
+	Items:      []*model.Item{{SKU: 1076963, Count: 2}},
+	UserId:     42,
+}
+
+// verifySignature проверяет подпись так же, как получатель вебхука. Вызывается из обработчика сервера,
+// поэтому использует assert, а не require
+func verifySignature(t *testing.T, r *http.Request, body []byte) {
+	t.Helper()
+	timestamp := r.Header.Get(notifiers.TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	assert.NoError(t, err, "Время подписи не в секундах Unix")
+	assert.WithinDuration(t, time.Now(), time.Unix(unix, 0), time.Minute)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	assert.Equal(t, want, r.Header.Get(notifiers.SignatureHeader))
+}
+
+func TestWebhookNotifier_SignsRequest(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		verifySignature(t, r, body)
+
+		var payload map[string]any
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		assert.Equal(t, event.EventID, payload["event_id"])
+		assert.Equal(t, "order.paid", payload["type"])
+		assert.Equal(t, float64(1005), payload["order_id"])
+		assert.Equal(t, float64(42), payload["user_id"])
+		assert.Equal(t, "PAYED", payload["state"])
+		assert.Equal(t, event.Message(), payload["message"])
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	err := notifiers.NewWebhookNotifier(server.URL, secret, time.Second).Notify(context.Background(), event)
+	require.NoError(t, err)
+	assert.Equal(t, 1, requests)
+}
+
+func TestWebhookNotifier_WrongSecretDoesNotVerify(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(r.Header.Get(notifiers.TimestampHeader) + "." + string(body)))
+		if !hmac.Equal([]byte("sha256="+hex.EncodeToString(mac.Sum(nil))), []byte(r.Header.Get(notifiers.SignatureHeader))) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	err := notifiers.NewWebhookNotifier(server.URL, "other-secret", time.Second).Notify(context.Background(), event)
+	assert.ErrorIs(t, err, notifiers.ErrPermanent)
+}
+
+func TestWebhookNotifier_ResponseStatus(t *testing.T) {
+	tests := []struct {
+		status    int
+		wantErr   bool
+		permanent bool
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusAccepted},
+		{status: http.StatusBadRequest, wantErr: true, permanent: true},
+		{status: http.StatusUnauthorized, wantErr: true, permanent: true},
+		{status: http.StatusNotFound, wantErr: true, permanent: true},
+		{status: http.StatusGone, wantErr: true, permanent: true},
+		// получатель не успел или просит подождать: повтор может пройти
+		{status: http.StatusRequestTimeout, wantErr: true},
+		{status: http.StatusTooManyRequests, wantErr: true},
+		{status: http.StatusInternalServerError, wantErr: true},
+		{status: http.StatusServiceUnavailable, wantErr: true},
+		// перенаправления клиент не выполняет без Location, такой ответ повторяется
+		{status: http.StatusMultipleChoices, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			err := notifiers.NewWebhookNotifier(server.URL, secret, time.Second).Notify(context.Background(), event)
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, tt.permanent, errors.Is(err, notifiers.ErrPermanent))
+		})
+	}
+}
+
+func TestWebhookNotifier_Unreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	err := notifiers.NewWebhookNotifier(url, secret, time.Second).Notify(context.Background(), event)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, notifiers.ErrPermanent, "Недоступный получатель должен повторяться")
+}
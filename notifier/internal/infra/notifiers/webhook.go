@@ -0,0 +1,99 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"route256/notifier/internal/pkg/model"
+	"strconv"
+	"time"
+)
+
+const (
+	// SignatureHeader подпись HMAC-SHA256 строки "<timestamp>.<тело запроса>" секретом вебхука
+	SignatureHeader = "X-Notifier-Signature"
+	// TimestampHeader время подписи в секундах Unix, получатель может отклонять старые запросы
+	TimestampHeader = "X-Notifier-Timestamp"
+)
+
+// WebhookNotifier отправляет уведомления POST запросом с JSON телом, подписанным HMAC
+type WebhookNotifier struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+type webhookPayload struct {
+	EventID    string          `json:"event_id,omitempty"`
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	OrderID    int64           `json:"order_id"`
+	UserID     int64           `json:"user_id"`
+	State      model.StateType `json:"state"`
+	Items      []*model.Item   `json:"items"`
+	Message    string          `json:"message"`
+}
+
+func NewWebhookNotifier(url, secret string, timeout time.Duration) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Notify отправляет уведомление. Ответ 4xx, кроме 408 и 429, повтором не исправится
+func (n *WebhookNotifier) Notify(ctx context.Context, event *model.OrderEvent) error {
+	body, err := json.Marshal(webhookPayload{
+		EventID:    event.EventID,
+		Type:       event.Type,
+		OccurredAt: event.OccurredAt,
+		OrderID:    event.ID,
+		UserID:     event.UserId,
+		State:      event.State,
+		Items:      event.Items,
+		Message:    event.Message(),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPermanent, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPermanent, err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+n.sign(timestamp, body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: webhook responded with %s", ErrPermanent, resp.Status)
+	default:
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+}
+
+func (n *WebhookNotifier) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ConsumedEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "notifier_events_total",
+			Help: "Количество прочитанных событий по результату обработки (processed, duplicate, dead_lettered).",
+		},
+		[]string{"status"},
+	)
+	Notifications = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "notifier_notifications_total",
+			Help: "Количество попыток отправки уведомлений по каналу и результату (sent, retried, failed).",
+		},
+		[]string{"channel", "status"},
+	)
+)
+
+// RecordProcessedEvent учитывает событие, обработанное впервые
+func RecordProcessedEvent() {
+	ConsumedEvents.WithLabelValues("processed").Inc()
+}
+
+// RecordDuplicateEvent учитывает повторно доставленное событие, которое уже есть в inbox
+func RecordDuplicateEvent() {
+	ConsumedEvents.WithLabelValues("duplicate").Inc()
+}
+
+// RecordDeadLetteredEvent учитывает сообщение, перенесенное в DLQ
+func RecordDeadLetteredEvent() {
+	ConsumedEvents.WithLabelValues("dead_lettered").Inc()
+}
+
+// RecordNotification учитывает результат отправки уведомления в канал
+func RecordNotification(channel, status string) {
+	Notifications.WithLabelValues(channel, status).Inc()
+}
@@ -0,0 +1,17 @@
+package model
+
+// Delivery отправка уведомления о событии в один канал
+type Delivery struct {
+	ID       int64       // Идентификатор отправки в таблице deliveries
+	Channel  string      // Канал уведомления, например email
+	Event    *OrderEvent // Событие, о котором уведомляется пользователь
+	Attempts int32       // Число неудачных попыток отправки
+	Failed   bool        // Попытки исчерпаны или отправка невозможна, уведомление больше не отправляется
+}
+
+// DeliveryFailure неудачная попытка отправить уведомление
+type DeliveryFailure struct {
+	DeliveryID int64 // Идентификатор отправки в таблице deliveries
+	Err        error // Ошибка отправки
+	Permanent  bool  // Ошибка не исправится при повторе, например у пользователя нет адреса
+}
@@ -1,5 +1,10 @@
 package model
 
+import (
+	"fmt"
+	"time"
+)
+
 type StateType string
 type SKUType uint32
 
@@ -12,10 +17,49 @@ const (
 )
 
 type OrderEvent struct {
-	ID     int64
-	State  StateType
-	Items  []*Item
-	UserId int64
+	// EventID идентификатор события, у событий версии 0 пустой
+	EventID string
+	// Type тип события, например order.created
+	Type string
+	// Version версия схемы события, 0 - JSON до введения конверта
+	Version    uint32
+	OccurredAt time.Time
+	ID         int64
+	State      StateType
+	Items      []*Item
+	UserId     int64
+}
+
+// DedupKey ключ события в inbox. У событий версии 0 нет идентификатора,
+// их различают заказ и тип: каждый статус заказ проходит один раз
+func (e *OrderEvent) DedupKey() string {
+	if e.EventID != "" {
+		return e.EventID
+	}
+	return fmt.Sprintf("order:%d:v%d:%s", e.ID, e.Version, e.Type)
+}
+
+// stateMessages текст уведомления по статусу заказа
+var stateMessages = map[StateType]string{
+	NEW:              "Заказ создан",
+	AWAITING_PAYMENT: "Заказ ожидает оплаты",
+	FAILED:           "Заказ не оплачен",
+	PAYED:            "Заказ оплачен",
+	CANCELLED:        "Заказ отменен",
+}
+
+// IsKnown сообщает, что статус заказа есть в LOMS
+func (s StateType) IsKnown() bool {
+	_, ok := stateMessages[s]
+	return ok
+}
+
+// Message текст уведомления пользователя о событии
+func (e *OrderEvent) Message() string {
+	if message, ok := stateMessages[e.State]; ok {
+		return fmt.Sprintf("%s: заказ %d", message, e.ID)
+	}
+	return fmt.Sprintf("Статус заказа %d изменен на %s", e.ID, e.State)
 }
 
 type Item struct {
@@ -0,0 +1,132 @@
+package deliveryrepository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"route256/notifier/internal/pkg/model"
+	"time"
+)
+
+// RetryPolicy ограничивает повторные отправки уведомления
+type RetryPolicy struct {
+	// MaxAttempts число попыток, после которого уведомление больше не отправляется
+	MaxAttempts int
+	// BaseBackoff задержка после первой неудачи, каждая следующая удваивается
+	BaseBackoff time.Duration
+	// MaxBackoff предел задержки между попытками
+	MaxBackoff time.Duration
+}
+
+// Repository очередь уведомлений: уведомления сохраняются вместе с записью события в inbox
+// и отправляются отдельно, поэтому недоступный канал не задерживает чтение партиции
+type Repository struct {
+	pool *pgxpool.Pool
+}
+
+func NewRepository(pool *pgxpool.Pool) *Repository {
+	return &Repository{pool: pool}
+}
+
+// SaveDeliveries сохраняет по уведомлению о событии на каждый канал в рамках транзакции inbox
+func (r *Repository) SaveDeliveries(ctx context.Context, tx pgx.Tx, event *model.OrderEvent, channels []string) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("unable to marshal event %s: %w", event.DedupKey(), err)
+	}
+	queries := New(tx)
+	for _, channel := range channels {
+		err = queries.SaveDelivery(ctx, &SaveDeliveryParams{
+			EventKey: event.DedupKey(),
+			Channel:  channel,
+			UserID:   event.UserId,
+			OrderID:  event.ID,
+			Payload:  payload,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to save %s delivery of event %s: %w", channel, event.DedupKey(), err)
+		}
+	}
+	return nil
+}
+
+// ClaimDeliveries захватывает до limit неотправленных уведомлений на время lease и возвращает их.
+// Уведомление о заказе не захватывается, пока в тот же канал не отправлены предыдущие уведомления о нем
+func (r *Repository) ClaimDeliveries(ctx context.Context, lease time.Duration, limit int) ([]*model.Delivery, error) {
+	rows, err := New(r.pool).ClaimDeliveries(ctx, &ClaimDeliveriesParams{
+		Lease:    pgtype.Interval{Microseconds: lease.Microseconds(), Valid: true},
+		MaxCount: int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to claim deliveries: %w", err)
+	}
+
+	deliveries := make([]*model.Delivery, 0, len(rows))
+	for _, row := range rows {
+		var event model.OrderEvent
+		if err = json.Unmarshal(row.Payload, &event); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal event of delivery %d: %w", row.ID, err)
+		}
+		deliveries = append(deliveries, &model.Delivery{
+			ID:       row.ID,
+			Channel:  row.Channel,
+			Event:    &event,
+			Attempts: row.Attempts,
+		})
+	}
+	return deliveries, nil
+}
+
+// MarkDeliveriesDelivered помечает уведомления отправленными
+func (r *Repository) MarkDeliveriesDelivered(ctx context.Context, deliveryIDs []int64) error {
+	if len(deliveryIDs) == 0 {
+		return nil
+	}
+	if err := New(r.pool).MarkDeliveriesDelivered(ctx, deliveryIDs); err != nil {
+		return fmt.Errorf("unable to mark deliveries delivered: %w", err)
+	}
+	return nil
+}
+
+// RecordDeliveryFailures откладывает неотправленные уведомления по policy и возвращает те из них,
+// которые больше не будут отправлены
+func (r *Repository) RecordDeliveryFailures(ctx context.Context, failures []*model.DeliveryFailure,
+	policy RetryPolicy) ([]*model.Delivery, error) {
+	if len(failures) == 0 {
+		return nil, nil
+	}
+	params := &RecordDeliveryFailuresParams{
+		BaseBackoff: pgtype.Interval{Microseconds: policy.BaseBackoff.Microseconds(), Valid: true},
+		MaxBackoff:  pgtype.Interval{Microseconds: policy.MaxBackoff.Microseconds(), Valid: true},
+		MaxAttempts: int32(policy.MaxAttempts),
+		Ids:         make([]int64, len(failures)),
+		Errors:      make([]string, len(failures)),
+		Permanent:   make([]bool, len(failures)),
+	}
+	for i, failure := range failures {
+		params.Ids[i] = failure.DeliveryID
+		params.Errors[i] = failure.Err.Error()
+		params.Permanent[i] = failure.Permanent
+	}
+
+	rows, err := New(r.pool).RecordDeliveryFailures(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("unable to record delivery failures: %w", err)
+	}
+	var failed []*model.Delivery
+	for _, row := range rows {
+		if row.Failed {
+			failed = append(failed, &model.Delivery{
+				ID:       row.ID,
+				Channel:  row.Channel,
+				Event:    &model.OrderEvent{ID: row.OrderID},
+				Attempts: row.Attempts,
+				Failed:   true,
+			})
+		}
+	}
+	return failed, nil
+}
@@ -0,0 +1,44 @@
+-- name: SaveDelivery :exec
+INSERT INTO deliveries (event_key, channel, user_id, order_id, payload)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: ClaimDeliveries :many
+UPDATE deliveries
+SET locked_until = now() + sqlc.arg(lease)::interval
+WHERE deliveries.id IN (SELECT pending.id
+                        FROM deliveries pending
+                        WHERE pending.delivered_at IS NULL
+                          AND pending.failed_at IS NULL
+                          AND pending.next_attempt_at <= now()
+                          AND (pending.locked_until IS NULL OR pending.locked_until < now())
+                          AND NOT EXISTS (SELECT 1
+                                          FROM deliveries earlier
+                                          WHERE earlier.order_id = pending.order_id
+                                            AND earlier.channel = pending.channel
+                                            AND earlier.delivered_at IS NULL
+                                            AND earlier.failed_at IS NULL
+                                            AND earlier.id < pending.id)
+                        ORDER BY pending.id
+                        LIMIT @max_count FOR UPDATE SKIP LOCKED)
+RETURNING deliveries.id, deliveries.channel, deliveries.payload, deliveries.attempts;
+
+-- name: MarkDeliveriesDelivered :exec
+UPDATE deliveries
+SET delivered_at = now(),
+    locked_until = NULL
+WHERE id = ANY (@ids::bigint[]);
+
+-- name: RecordDeliveryFailures :many
+UPDATE deliveries
+SET attempts        = deliveries.attempts + 1,
+    last_error      = failures.error,
+    locked_until    = NULL,
+    next_attempt_at = now() + LEAST(sqlc.arg(base_backoff)::interval * power(2, LEAST(deliveries.attempts, 30)),
+                                    sqlc.arg(max_backoff)::interval),
+    failed_at       = CASE
+                          WHEN failures.permanent OR deliveries.attempts + 1 >= sqlc.arg(max_attempts)::int THEN now()
+                          END
+FROM unnest(@ids::bigint[], @errors::text[], @permanent::boolean[]) AS failures(id, error, permanent)
+WHERE deliveries.id = failures.id
+  AND deliveries.delivered_at IS NULL
+RETURNING deliveries.id, deliveries.channel, deliveries.order_id, deliveries.attempts, deliveries.failed_at IS NOT NULL AS failed;
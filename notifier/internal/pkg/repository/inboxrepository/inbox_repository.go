@@ -0,0 +1,56 @@
+package inboxrepository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"route256/notifier/internal/pkg/model"
+)
+
+// Repository inbox обработанных событий: событие с уже записанным ключом повторно не обрабатывается
+type Repository struct {
+	pool *pgxpool.Pool
+}
+
+func NewRepository(pool *pgxpool.Pool) *Repository {
+	return &Repository{pool: pool}
+}
+
+// Handle выполняет handle для события, если событие с таким ключом еще не обработано, и возвращает true для дубликата.
+// Ключ записывается в транзакции tx, в которой handle сохраняет результат обработки, и фиксируется только после
+// успешного handle: при ошибке или падении событие обработается повторно,
+// а параллельная обработка того же события ждет завершения транзакции
+func (r *Repository) Handle(ctx context.Context, event *model.OrderEvent, handle func(ctx context.Context, tx pgx.Tx) error) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("unable to begin inbox transaction: %w", err)
+	}
+	// после Commit откат ничего не делает
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	saved, err := New(tx).SaveInboxEvent(ctx, &SaveInboxEventParams{
+		EventKey: event.DedupKey(),
+		OrderID:  event.ID,
+		Version:  int32(event.Version),
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to save inbox event %s: %w", event.DedupKey(), err)
+	}
+	if saved == 0 {
+		return true, nil
+	}
+
+	if err = handle(ctx, tx); err != nil {
+		return false, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("unable to commit inbox event %s: %w", event.DedupKey(), err)
+	}
+	return false, nil
+}
+
+// Ping для readiness
+func (r *Repository) Ping(ctx context.Context) error {
+	return r.pool.Ping(ctx)
+}
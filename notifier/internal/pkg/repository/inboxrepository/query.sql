@@ -0,0 +1,4 @@
+-- name: SaveInboxEvent :execrows
+INSERT INTO inbox (event_key, order_id, version)
+VALUES (@event_key, @order_id, @version)
+ON CONFLICT (event_key) DO NOTHING;
@@ -0,0 +1,78 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"route256/notifier/internal/pkg/model"
+	"slices"
+)
+
+// Rule направляет события заказов в статусах States в каналы Channels.
+// Правило с пустым Users относится ко всем пользователям
+type Rule struct {
+	Users    []int64           `json:"users"`
+	States   []model.StateType `json:"states"`
+	Channels []string          `json:"channels"`
+}
+
+// Rules правила маршрутизации уведомлений и адреса пользователей.
+// Событие отправляется во все каналы правил, под которые оно подходит
+type Rules struct {
+	Rules []Rule `json:"rules"`
+	// Emails адрес почты по ID пользователя
+	Emails map[int64]string `json:"emails"`
+}
+
+// LoadRules читает правила из JSON файла
+func LoadRules(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read routing rules: %w", err)
+	}
+	var rules Rules
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("unable to parse routing rules %s: %w", path, err)
+	}
+	return &rules, nil
+}
+
+// Validate проверяет, что правила ссылаются только на настроенные каналы
+func (r *Rules) Validate(channels []string) error {
+	for i, rule := range r.Rules {
+		if len(rule.States) == 0 || len(rule.Channels) == 0 {
+			return fmt.Errorf("rule %d: states and channels must not be empty", i)
+		}
+		for _, channel := range rule.Channels {
+			if !slices.Contains(channels, channel) {
+				return fmt.Errorf("rule %d: channel %q is not configured", i, channel)
+			}
+		}
+	}
+	return nil
+}
+
+// Channels возвращает каналы, в которые нужно отправить уведомление о событии, без повторов
+func (r *Rules) Channels(event *model.OrderEvent) []string {
+	var channels []string
+	for _, rule := range r.Rules {
+		if !slices.Contains(rule.States, event.State) {
+			continue
+		}
+		if len(rule.Users) > 0 && !slices.Contains(rule.Users, event.UserId) {
+			continue
+		}
+		for _, channel := range rule.Channels {
+			if !slices.Contains(channels, channel) {
+				channels = append(channels, channel)
+			}
+		}
+	}
+	return channels
+}
+
+// Email возвращает адрес почты пользователя
+func (r *Rules) Email(userID int64) (string, bool) {
+	email, ok := r.Emails[userID]
+	return email, ok
+}
@@ -0,0 +1,129 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"route256/notifier/internal/infra/notifiers"
+	"route256/notifier/internal/pkg/model"
+	"route256/notifier/internal/pkg/routing"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var configured = []string{notifiers.FileChannel, notifiers.EmailChannel, notifiers.WebhookChannel}
+
+func TestRules_Channels(t *testing.T) {
+	rules := &routing.Rules{Rules: []routing.Rule{
+		{States: []model.StateType{model.NEW, model.CANCELLED}, Channels: []string{notifiers.FileChannel}},
+		{Users: []int64{42}, States: []model.StateType{model.PAYED, model.CANCELLED}, Channels: []string{notifiers.EmailChannel, notifiers.FileChannel}},
+		{Users: []int64{7, 42}, States: []model.StateType{model.FAILED}, Channels: []string{notifiers.WebhookChannel}},
+	}}
+
+	tests := []struct {
+		name   string
+		state  model.StateType
+		userID int64
+		want   []string
+	}{
+		{name: "rule for all users", state: model.NEW, userID: 1, want: []string{notifiers.FileChannel}},
+		{name: "user rule", state: model.PAYED, userID: 42, want: []string{notifiers.EmailChannel, notifiers.FileChannel}},
+		{name: "user rule for other user", state: model.PAYED, userID: 1, want: nil},
+		{name: "channels without repeats", state: model.CANCELLED, userID: 42, want: []string{notifiers.FileChannel, notifiers.EmailChannel}},
+		{name: "one of rule users", state: model.FAILED, userID: 7, want: []string{notifiers.WebhookChannel}},
+		{name: "no rule for state", state: model.AWAITING_PAYMENT, userID: 42, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channels := rules.Channels(&model.OrderEvent{ID: 1005, State: tt.state, UserId: tt.userID})
+			assert.Equal(t, tt.want, channels)
+		})
+	}
+}
+
+func TestRules_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    routing.Rule
+		wantErr string
+	}{
+		{
+			name: "valid",
+			rule: routing.Rule{States: []model.StateType{model.PAYED}, Channels: []string{notifiers.EmailChannel}},
+		},
+		{
+			name:    "no states",
+			rule:    routing.Rule{Channels: []string{notifiers.EmailChannel}},
+			wantErr: "rule 1: states and channels must not be empty",
+		},
+		{
+			name:    "no channels",
+			rule:    routing.Rule{States: []model.StateType{model.PAYED}},
+			wantErr: "rule 1: states and channels must not be empty",
+		},
+		{
+			name:    "unknown channel",
+			rule:    routing.Rule{States: []model.StateType{model.PAYED}, Channels: []string{notifiers.FileChannel, "sms"}},
+			wantErr: `rule 1: channel "sms" is not configured`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := &routing.Rules{Rules: []routing.Rule{
+				{States: []model.StateType{model.NEW}, Channels: []string{notifiers.FileChannel}},
+				tt.rule,
+			}}
+			err := rules.Validate(configured)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRules_ValidateWithoutConfiguredChannel(t *testing.T) {
+	rules := &routing.Rules{Rules: []routing.Rule{
+		{States: []model.StateType{model.PAYED}, Channels: []string{notifiers.WebhookChannel}},
+	}}
+	// вебхук не настроен, и правило на него ссылается
+	assert.Error(t, rules.Validate([]string{notifiers.FileChannel}))
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"rules": [{"users": [42], "states": ["PAYED"], "channels": ["email"]}],
+		"emails": {"42": "user@example.com"}
+	}`), 0o644))
+
+	rules, err := routing.LoadRules(path)
+	require.NoError(t, err)
+	require.NoError(t, rules.Validate(configured))
+	assert.Equal(t, []string{notifiers.EmailChannel}, rules.Channels(&model.OrderEvent{State: model.PAYED, UserId: 42}))
+
+	email, ok := rules.Email(42)
+	assert.True(t, ok)
+	assert.Equal(t, "user@example.com", email)
+	_, ok = rules.Email(7)
+	assert.False(t, ok)
+}
+
+func TestLoadRules_ServiceConfig(t *testing.T) {
+	// правила, с которыми notifier запускается в docker-compose
+	rules, err := routing.LoadRules("../../../../rules.json")
+	require.NoError(t, err)
+	assert.NoError(t, rules.Validate([]string{notifiers.FileChannel}))
+}
+
+func TestLoadRules_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"rules": [`), 0o644))
+
+	_, err := routing.LoadRules(path)
+	assert.Error(t, err)
+	_, err = routing.LoadRules(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,162 @@
+package deliveryprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"route256/notifier/internal/infra/notifiers"
+	"route256/notifier/internal/metrics"
+	"route256/notifier/internal/pkg/model"
+	"route256/notifier/internal/pkg/repository/deliveryrepository"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	_ Notifier = (*notifiers.FileNotifier)(nil)
+	_ Notifier = (*notifiers.WebhookNotifier)(nil)
+	_ Notifier = (*notifiers.SMTPNotifier)(nil)
+)
+
+// Notifier канал уведомлений. Ошибка, обернутая в notifiers.ErrPermanent, повторно не отправляется
+type Notifier interface {
+	Notify(ctx context.Context, event *model.OrderEvent) error
+}
+
+var _ DeliveryRepository = (*deliveryrepository.Repository)(nil)
+
+type DeliveryRepository interface {
+	ClaimDeliveries(ctx context.Context, lease time.Duration, limit int) ([]*model.Delivery, error)
+	MarkDeliveriesDelivered(ctx context.Context, deliveryIDs []int64) error
+	RecordDeliveryFailures(ctx context.Context, failures []*model.DeliveryFailure,
+		policy deliveryrepository.RetryPolicy) ([]*model.Delivery, error)
+}
+
+type Options struct {
+	// Interval период, с которым обработчик проверяет очередь уведомлений
+	Interval time.Duration
+	// BatchSize число уведомлений, которые захватываются и отправляются одновременно
+	BatchSize int
+	// Lease время, на которое захватываются уведомления. Должно превышать SendTimeout
+	Lease time.Duration
+	// SendTimeout предел времени отправки одного уведомления
+	SendTimeout time.Duration
+	// Retry задержки между повторными отправками и число попыток
+	Retry deliveryrepository.RetryPolicy
+}
+
+// DeliveryProcessor отправляет уведомления из очереди в каналы.
+// Неотправленное уведомление откладывается с растущей задержкой и не задерживает остальные
+type DeliveryProcessor struct {
+	repo      DeliveryRepository
+	notifiers map[string]Notifier
+	opts      Options
+}
+
+func NewDeliveryProcessor(repo DeliveryRepository, notifiers map[string]Notifier, opts Options) *DeliveryProcessor {
+	return &DeliveryProcessor{
+		repo:      repo,
+		notifiers: notifiers,
+		opts:      opts,
+	}
+}
+
+func (p *DeliveryProcessor) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping DeliveryProcessor")
+			return
+		case <-ticker.C:
+			p.processDeliveries(ctx)
+		}
+	}
+}
+
+// processDeliveries отправляет пачки уведомлений, пока они захватываются полностью
+func (p *DeliveryProcessor) processDeliveries(ctx context.Context) {
+	for ctx.Err() == nil {
+		claimed, err := p.ProcessBatch(ctx)
+		if err != nil {
+			log.Printf("Failed to process deliveries: %v", err)
+			return
+		}
+		if claimed < p.opts.BatchSize {
+			return
+		}
+	}
+}
+
+// ProcessBatch захватывает пачку уведомлений, отправляет их параллельно и записывает результат.
+// Ошибка возвращается, только если не удалось обратиться к базе: тогда уведомления будут отправлены повторно
+// после истечения аренды
+func (p *DeliveryProcessor) ProcessBatch(ctx context.Context) (int, error) {
+	deliveries, err := p.repo.ClaimDeliveries(ctx, p.opts.Lease, p.opts.BatchSize)
+	if err != nil || len(deliveries) == 0 {
+		return 0, err
+	}
+
+	var (
+		mu        sync.Mutex
+		delivered []int64
+		failures  []*model.DeliveryFailure
+		wg        sync.WaitGroup
+	)
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.send(ctx, delivery)
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				delivered = append(delivered, delivery.ID)
+				metrics.RecordNotification(delivery.Channel, "sent")
+				return
+			}
+			log.Printf("Failed to send %s notification %d: %v", delivery.Channel, delivery.ID, err)
+			failures = append(failures, &model.DeliveryFailure{
+				DeliveryID: delivery.ID,
+				Err:        err,
+				Permanent:  errors.Is(err, notifiers.ErrPermanent),
+			})
+		}()
+	}
+	wg.Wait()
+
+	if err = p.repo.MarkDeliveriesDelivered(ctx, delivered); err != nil {
+		return len(deliveries), err
+	}
+	failed, err := p.repo.RecordDeliveryFailures(ctx, failures, p.opts.Retry)
+	if err != nil {
+		return len(deliveries), err
+	}
+	failedIDs := make(map[int64]bool, len(failed))
+	for _, delivery := range failed {
+		log.Printf("Giving up %s notification %d about order %d after %d attempts",
+			delivery.Channel, delivery.ID, delivery.Event.ID, delivery.Attempts)
+		metrics.RecordNotification(delivery.Channel, "failed")
+		failedIDs[delivery.ID] = true
+	}
+	for _, delivery := range deliveries {
+		if !slices.Contains(delivered, delivery.ID) && !failedIDs[delivery.ID] {
+			metrics.RecordNotification(delivery.Channel, "retried")
+		}
+	}
+	return len(deliveries), nil
+}
+
+func (p *DeliveryProcessor) send(ctx context.Context, delivery *model.Delivery) error {
+	notifier, ok := p.notifiers[delivery.Channel]
+	if !ok {
+		return fmt.Errorf("%w: channel %q is not configured", notifiers.ErrPermanent, delivery.Channel)
+	}
+	sendCtx, cancel := context.WithTimeout(ctx, p.opts.SendTimeout)
+	defer cancel()
+	return notifier.Notify(sendCtx, delivery.Event)
+}
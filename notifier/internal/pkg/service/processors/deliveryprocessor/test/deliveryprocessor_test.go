@@ -0,0 +1,143 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"route256/notifier/internal/infra/notifiers"
+	"route256/notifier/internal/metrics"
+	"route256/notifier/internal/pkg/model"
+	"route256/notifier/internal/pkg/repository/deliveryrepository"
+	"route256/notifier/internal/pkg/service/processors/deliveryprocessor"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gojuno/minimock/v3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var options = deliveryprocessor.Options{
+	BatchSize:   10,
+	Lease:       time.Minute,
+	SendTimeout: time.Second,
+	Retry:       deliveryrepository.RetryPolicy{MaxAttempts: 5, BaseBackoff: time.Second, MaxBackoff: time.Minute},
+}
+
+func delivery(id int64, channel string) *model.Delivery {
+	return &model.Delivery{
+		ID:      id,
+		Channel: channel,
+		Event:   &model.OrderEvent{Type: "order.paid", ID: 1000 + id, State: model.PAYED, UserId: 42},
+	}
+}
+
+// notificationsCount число отправок канала с результатом status, учтенных в метриках
+func notificationsCount(channel, status string) float64 {
+	return testutil.ToFloat64(metrics.Notifications.WithLabelValues(channel, status))
+}
+
+func TestDeliveryProcessor_ProcessBatch(t *testing.T) {
+	mc := minimock.NewController(t)
+	ctx := context.Background()
+
+	filePath := filepath.Join(t.TempDir(), "notifications.jsonl")
+	fileNotifier, err := notifiers.NewFileNotifier(filePath)
+	require.NoError(t, err)
+	defer fileNotifier.Close()
+
+	webhookMock := NewNotifierMock(mc)
+	webhookMock.NotifyMock.Set(func(_ context.Context, event *model.OrderEvent) error {
+		if event.ID == 1002 {
+			return errors.New("webhook responded with 503 Service Unavailable")
+		}
+		return nil
+	})
+	emailMock := NewNotifierMock(mc)
+	emailMock.NotifyMock.Return(fmt.Errorf("%w: user 42 has no email", notifiers.ErrPermanent))
+
+	repoMock := NewDeliveryRepositoryMock(mc)
+	repoMock.ClaimDeliveriesMock.Expect(ctx, options.Lease, options.BatchSize).Return([]*model.Delivery{
+		delivery(1, notifiers.WebhookChannel),
+		delivery(2, notifiers.WebhookChannel),
+		delivery(3, notifiers.EmailChannel),
+		delivery(4, "sms"),
+		delivery(5, notifiers.FileChannel),
+	}, nil)
+	repoMock.MarkDeliveriesDeliveredMock.Set(func(_ context.Context, deliveryIDs []int64) error {
+		assert.ElementsMatch(t, []int64{1, 5}, deliveryIDs)
+		return nil
+	})
+	repoMock.RecordDeliveryFailuresMock.Set(func(_ context.Context, failures []*model.DeliveryFailure,
+		policy deliveryrepository.RetryPolicy) ([]*model.Delivery, error) {
+		permanent := make(map[int64]bool, len(failures))
+		for _, failure := range failures {
+			permanent[failure.DeliveryID] = failure.Permanent
+		}
+		// временная ошибка вебхука повторяется, отсутствие адреса и ненастроенный канал - нет
+		assert.Equal(t, map[int64]bool{2: false, 3: true, 4: true}, permanent)
+		assert.Equal(t, options.Retry, policy)
+		failed := []*model.Delivery{delivery(3, notifiers.EmailChannel), delivery(4, "sms")}
+		for _, d := range failed {
+			d.Failed = true
+		}
+		return failed, nil
+	})
+
+	sentBefore := notificationsCount(notifiers.WebhookChannel, "sent")
+	retriedBefore := notificationsCount(notifiers.WebhookChannel, "retried")
+	failedBefore := notificationsCount(notifiers.EmailChannel, "failed")
+
+	processor := deliveryprocessor.NewDeliveryProcessor(repoMock, map[string]deliveryprocessor.Notifier{
+		notifiers.WebhookChannel: webhookMock,
+		notifiers.EmailChannel:   emailMock,
+		notifiers.FileChannel:    fileNotifier,
+	}, options)
+	claimed, err := processor.ProcessBatch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 5, claimed)
+
+	assert.Equal(t, sentBefore+1, notificationsCount(notifiers.WebhookChannel, "sent"))
+	assert.Equal(t, retriedBefore+1, notificationsCount(notifiers.WebhookChannel, "retried"))
+	assert.Equal(t, failedBefore+1, notificationsCount(notifiers.EmailChannel, "failed"))
+
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+	assert.Contains(t, string(data), `"order_id":1005`)
+}
+
+func TestDeliveryProcessor_ProcessBatch_NothingClaimed(t *testing.T) {
+	mc := minimock.NewController(t)
+	ctx := context.Background()
+
+	repoMock := NewDeliveryRepositoryMock(mc)
+	repoMock.ClaimDeliveriesMock.Return(nil, nil)
+
+	claimed, err := deliveryprocessor.NewDeliveryProcessor(repoMock, nil, options).ProcessBatch(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, claimed)
+}
+
+func TestDeliveryProcessor_ProcessBatch_MarkError(t *testing.T) {
+	mc := minimock.NewController(t)
+	ctx := context.Background()
+
+	notifierMock := NewNotifierMock(mc)
+	notifierMock.NotifyMock.Return(nil)
+	repoMock := NewDeliveryRepositoryMock(mc)
+	repoMock.ClaimDeliveriesMock.Return([]*model.Delivery{delivery(1, notifiers.WebhookChannel)}, nil)
+	repoMock.MarkDeliveriesDeliveredMock.Return(errors.New("connection refused"))
+
+	processor := deliveryprocessor.NewDeliveryProcessor(repoMock, map[string]deliveryprocessor.Notifier{
+		notifiers.WebhookChannel: notifierMock,
+	}, options)
+	claimed, err := processor.ProcessBatch(ctx)
+	// уведомление отправится еще раз после истечения аренды
+	assert.EqualError(t, err, "connection refused")
+	assert.Equal(t, 1, claimed)
+}
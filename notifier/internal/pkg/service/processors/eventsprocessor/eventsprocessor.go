@@ -1,34 +1,50 @@
 package eventsprocessor
 
 import (
+	"context"
+	"github.com/jackc/pgx/v5"
 	"log"
 	"route256/notifier/internal/pkg/model"
+	"route256/notifier/internal/pkg/repository/deliveryrepository"
+	"route256/notifier/internal/pkg/routing"
 )
 
-type EventService struct {
-}
+var _ Router = (*routing.Rules)(nil)
 
-func New() *EventService {
-	return &EventService{}
+// Router выбирает каналы уведомления о событии
+type Router interface {
+	Channels(event *model.OrderEvent) []string
 }
 
-func (ep *EventService) Process(event *model.OrderEvent) {
-	switch event.State {
-	case model.NEW:
-		log.Printf("Заказ создан: %+v\n", event)
+var _ DeliveryRepository = (*deliveryrepository.Repository)(nil)
 
-	case model.AWAITING_PAYMENT:
-		log.Printf("Заказ ожидает оплаты: %+v\n", event)
+type DeliveryRepository interface {
+	SaveDeliveries(ctx context.Context, tx pgx.Tx, event *model.OrderEvent, channels []string) error
+}
 
-	case model.FAILED:
-		log.Printf("Заказ не оплачен: %+v\n", event)
+// EventService ставит уведомления о событиях заказов в очередь отправки по правилам маршрутизации.
+// Сами уведомления отправляет deliveryprocessor
+type EventService struct {
+	router     Router
+	deliveries DeliveryRepository
+}
 
-	case model.PAYED:
-		log.Printf("Заказ оплачен: %+v\n", event)
+func New(router Router, deliveries DeliveryRepository) *EventService {
+	return &EventService{
+		router:     router,
+		deliveries: deliveries,
+	}
+}
 
-	case model.CANCELLED:
-		log.Printf("Заказ отменен: %+v\n", event)
+// Process сохраняет уведомления о событии в транзакции inbox
+func (ep *EventService) Process(ctx context.Context, tx pgx.Tx, event *model.OrderEvent) error {
+	channels := ep.router.Channels(event)
+	if len(channels) == 0 {
+		log.Printf("Нет каналов для уведомления: %s\n", event.Message())
+		return nil
 	}
+	log.Printf("%s, каналы: %v\n", event.Message(), channels)
+	return ep.deliveries.SaveDeliveries(ctx, tx, event, channels)
 }
 
 func (ep *EventService) ProcessError(err error) {
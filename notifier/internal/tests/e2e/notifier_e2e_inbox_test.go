@@ -0,0 +1,136 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"route256/notifier/internal/pkg/model"
+	"route256/notifier/internal/pkg/repository/inboxrepository"
+)
+
+// saveDelivery результат обработки события, который handle пишет в транзакции inbox
+func saveDelivery(ctx context.Context, tx pgx.Tx, event *model.OrderEvent) error {
+	_, err := tx.Exec(ctx, `INSERT INTO deliveries (event_key, channel, user_id, order_id, payload)
+		VALUES ($1, 'log', $2, $3, '{}')`, event.DedupKey(), event.UserId, event.ID)
+	return err
+}
+
+func countRows(t *testing.T, table string) int64 {
+	var count int64
+	require.NoError(t, pool.QueryRow(context.Background(), "SELECT count(*) FROM "+table).Scan(&count))
+	return count
+}
+
+func TestE2E_InboxSkipsDuplicateEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		event *model.OrderEvent
+	}{
+		{
+			name:  "envelope",
+			event: &model.OrderEvent{EventID: "b7f6d1c2-5a3e-4f0b-9c1d-2e8a7f6b5c4d", Type: "order.paid", Version: 1, ID: 1005, UserId: 42},
+		},
+		{
+			// у событий версии 0 нет идентификатора, ключом служат заказ и тип
+			name:  "legacy",
+			event: &model.OrderEvent{Type: "order.paid", ID: 1005, UserId: 42},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			ctx := context.Background()
+			repo := inboxrepository.NewRepository(pool)
+
+			handled := 0
+			handle := func(ctx context.Context, tx pgx.Tx) error {
+				handled++
+				return saveDelivery(ctx, tx, tt.event)
+			}
+
+			duplicate, err := repo.Handle(ctx, tt.event, handle)
+			require.NoError(t, err)
+			assert.False(t, duplicate)
+
+			// повтор того же события не обрабатывается
+			duplicate, err = repo.Handle(ctx, tt.event, handle)
+			require.NoError(t, err)
+			assert.True(t, duplicate)
+			assert.Equal(t, 1, handled)
+			assert.Equal(t, int64(1), countRows(t, "inbox"))
+			assert.Equal(t, int64(1), countRows(t, "deliveries"))
+		})
+	}
+}
+
+func TestE2E_InboxRollsBackFailedHandle(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	repo := inboxrepository.NewRepository(pool)
+	event := &model.OrderEvent{EventID: "3c9e1f0a-8d2b-4e6f-a1c3-5b7d9e0f2a4c", Type: "order.created", Version: 1, ID: 1005, UserId: 42}
+	handleErr := errors.New("rules are not loaded")
+
+	duplicate, err := repo.Handle(ctx, event, func(ctx context.Context, tx pgx.Tx) error {
+		require.NoError(t, saveDelivery(ctx, tx, event))
+		return handleErr
+	})
+	require.ErrorIs(t, err, handleErr)
+	assert.False(t, duplicate)
+
+	// ключ откатывается вместе с результатом обработки, поэтому событие обработается повторно
+	assert.Zero(t, countRows(t, "inbox"))
+	assert.Zero(t, countRows(t, "deliveries"))
+
+	handled := false
+	duplicate, err = repo.Handle(ctx, event, func(ctx context.Context, tx pgx.Tx) error {
+		handled = true
+		return saveDelivery(ctx, tx, event)
+	})
+	require.NoError(t, err)
+	assert.False(t, duplicate)
+	assert.True(t, handled, "Событие после неудачной обработки считается дубликатом")
+	assert.Equal(t, int64(1), countRows(t, "inbox"))
+	assert.Equal(t, int64(1), countRows(t, "deliveries"))
+}
+
+func TestE2E_InboxConcurrentDuplicateWaits(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	repo := inboxrepository.NewRepository(pool)
+	event := &model.OrderEvent{EventID: "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d", Type: "order.paid", Version: 1, ID: 1005, UserId: 42}
+
+	var handled atomic.Int32
+	var duplicates atomic.Int32
+	wg := sync.WaitGroup{}
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			duplicate, err := repo.Handle(ctx, event, func(ctx context.Context, tx pgx.Tx) error {
+				handled.Add(1)
+				// остальные обработчики ждут завершения транзакции на ключе inbox
+				time.Sleep(50 * time.Millisecond)
+				return saveDelivery(ctx, tx, event)
+			})
+			assert.NoError(t, err)
+			if duplicate {
+				duplicates.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), handled.Load())
+	assert.Equal(t, int32(4), duplicates.Load())
+	assert.Equal(t, int64(1), countRows(t, "deliveries"))
+}
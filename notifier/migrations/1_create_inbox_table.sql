@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE inbox
+(
+  event_key    TEXT PRIMARY KEY,
+  order_id     BIGINT  NOT NULL,
+  version      INTEGER NOT NULL,
+  processed_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS inbox;
+-- +goose StatementEnd
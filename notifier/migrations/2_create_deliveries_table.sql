@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE deliveries
+(
+  id              BIGSERIAL PRIMARY KEY,
+  event_key       TEXT    NOT NULL REFERENCES inbox (event_key),
+  channel         TEXT    NOT NULL,
+  user_id         BIGINT  NOT NULL,
+  order_id        BIGINT  NOT NULL,
+  payload         JSONB   NOT NULL,
+  attempts        INTEGER NOT NULL DEFAULT 0,
+  last_error      TEXT,
+  next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  locked_until    TIMESTAMP WITH TIME ZONE,
+  delivered_at    TIMESTAMP WITH TIME ZONE,
+  failed_at       TIMESTAMP WITH TIME ZONE,
+  created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX deliveries_pending_idx ON deliveries (order_id, channel, id)
+  WHERE delivered_at IS NULL AND failed_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS deliveries;
+-- +goose StatementEnd